@@ -62,8 +62,7 @@ func Main() error {
 	}
 
 	if config.CleanupConfig {
-		cmd.CleanupConfigAndExit()
-		return nil
+		return cmd.CleanupConfigAndExit(config)
 	}
 
 	kubeRouter, err := cmd.NewKubeRouterDefault(config)
@@ -0,0 +1,125 @@
+// Package v1alpha1 contains the custom resources kube-router uses to describe its BGP configuration.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// GroupName is the API group of the kube-router custom resources
+	GroupName = "kube-router.io"
+	// Version is the API version of the kube-router custom resources
+	Version = "v1alpha1"
+
+	// DefaultBGPConfigurationName is the name of the cluster-wide BGPConfiguration resource kube-router reads
+	DefaultBGPConfigurationName = "default"
+)
+
+var (
+	// BGPPeerResource is the resource of the BGPPeer custom resource
+	BGPPeerResource = schema.GroupVersionResource{Group: GroupName, Version: Version, Resource: "bgppeers"}
+	// BGPConfigurationResource is the resource of the BGPConfiguration custom resource
+	BGPConfigurationResource = schema.GroupVersionResource{Group: GroupName, Version: Version,
+		Resource: "bgpconfigurations"}
+)
+
+// BGPPeer is a cluster scoped resource describing an external BGP peer that the nodes selected by its node selector
+// peer with
+type BGPPeer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BGPPeerSpec `json:"spec"`
+}
+
+// BGPPeerSpec is the specification of a BGPPeer
+type BGPPeerSpec struct {
+	// PeerAddress is the IP address of the peer
+	PeerAddress string `json:"peerAddress"`
+	// PeerASN is the AS number of the peer
+	PeerASN uint32 `json:"peerASN"`
+	// PeerPort is the TCP port of the peer, defaults to the standard BGP port
+	PeerPort uint32 `json:"peerPort,omitempty"`
+	// LocalAddress is the address the node peers from, defaults to the node IP of the peer address' IP family
+	LocalAddress string `json:"localAddress,omitempty"`
+	// PasswordSecretRef references the key of a Secret holding the TCP MD5 password of the session
+	PasswordSecretRef *SecretKeyReference `json:"passwordSecretRef,omitempty"`
+	// EBGPMultihopTTL enables eBGP multihop with the given TTL when greater than 1, defaults to --peer-router-multihop-ttl
+	EBGPMultihopTTL uint32 `json:"ebgpMultihopTTL,omitempty"`
+	// HoldTime is the hold time of the session, defaults to the BGPConfiguration's or else to --bgp-holdtime
+	HoldTime *metav1.Duration `json:"holdTime,omitempty"`
+	// KeepaliveTime is the keepalive interval of the session, defaults to a third of the hold time
+	KeepaliveTime *metav1.Duration `json:"keepaliveTime,omitempty"`
+	// MaxPrefixes is the maximum number of prefixes per address family accepted from the peer, the session is torn
+	// down when the peer sends more. The peer is not limited when it is not set.
+	MaxPrefixes uint32 `json:"maxPrefixes,omitempty"`
+	// NodeSelector selects the nodes that peer with the peer, all nodes do when it is not set
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// BFD configures the BFD session with the peer, defaults to the --enable-bfd and --bfd-* flags
+	BFD *BFDSpec `json:"bfd,omitempty"`
+	// Import selects the routes learned from the peer that are installed in the routing table of the nodes when
+	// --install-external-routes is set, the other routes learned from the peer are rejected
+	Import *BGPImportSpec `json:"import,omitempty"`
+}
+
+// BGPImportSpec selects routes learned from a BGP peer, a route is selected when it matches one of the prefixes and,
+// if communities are given, carries one of them
+type BGPImportSpec struct {
+	// Prefixes are the prefixes of the selected routes, 0.0.0.0/0 and ::/0 select the default routes
+	Prefixes []BGPPrefixMatch `json:"prefixes"`
+	// Communities are the BGP communities of the selected routes, in the same forms as the
+	// kube-router.io/node.bgp.communities annotation
+	Communities []string `json:"communities,omitempty"`
+}
+
+// BGPPrefixMatch matches the routes to a prefix, or to the prefixes it contains when a mask length range is given
+type BGPPrefixMatch struct {
+	// CIDR is the prefix
+	CIDR string `json:"cidr"`
+	// MaskLengthMin is the shortest mask length matched, defaults to the mask length of the prefix
+	MaskLengthMin uint32 `json:"maskLengthMin,omitempty"`
+	// MaskLengthMax is the longest mask length matched, defaults to the mask length of the prefix
+	MaskLengthMax uint32 `json:"maskLengthMax,omitempty"`
+}
+
+// BFDSpec configures the BFD session with a BGP peer
+type BFDSpec struct {
+	// Enabled enables or disables BFD with the peer, defaults to --enable-bfd
+	Enabled *bool `json:"enabled,omitempty"`
+	// MinTxInterval is the desired minimum interval between transmitted control packets, defaults to
+	// --bfd-min-tx-interval
+	MinTxInterval *metav1.Duration `json:"minTxInterval,omitempty"`
+	// MinRxInterval is the required minimum interval between received control packets, defaults to
+	// --bfd-min-rx-interval
+	MinRxInterval *metav1.Duration `json:"minRxInterval,omitempty"`
+	// DetectMultiplier is the number of missed control packets after which the session is down, defaults to
+	// --bfd-detect-multiplier
+	DetectMultiplier uint32 `json:"detectMultiplier,omitempty"`
+}
+
+// SecretKeyReference references a key of a Secret in a given namespace
+type SecretKeyReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Key       string `json:"key"`
+}
+
+// BGPConfiguration is a cluster scoped resource holding cluster-wide BGP settings. Only the one named "default" is
+// used by kube-router.
+type BGPConfiguration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BGPConfigurationSpec `json:"spec"`
+}
+
+// BGPConfigurationSpec is the specification of a BGPConfiguration
+type BGPConfigurationSpec struct {
+	// ASN is the AS number of the nodes when node to node mesh is enabled, it overrides --cluster-asn
+	ASN uint32 `json:"asn,omitempty"`
+	// NodeToNodeMesh enables the full mesh of iBGP sessions between all nodes, it overrides --nodes-full-mesh
+	NodeToNodeMesh *bool `json:"nodeToNodeMesh,omitempty"`
+	// HoldTime is the default hold time of the sessions with BGPPeers
+	HoldTime *metav1.Duration `json:"holdTime,omitempty"`
+}
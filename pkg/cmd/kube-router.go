@@ -2,12 +2,15 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
 	"github.com/cloudnativelabs/kube-router/pkg/controllers/netpol"
 	"github.com/cloudnativelabs/kube-router/pkg/controllers/proxy"
 	"github.com/cloudnativelabs/kube-router/pkg/controllers/routing"
@@ -17,9 +20,12 @@ import (
 	"github.com/cloudnativelabs/kube-router/pkg/version"
 	"k8s.io/klog/v2"
 
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
@@ -27,8 +33,9 @@ const healthControllerChannelLength = 10
 
 // KubeRouter holds the information needed to run server
 type KubeRouter struct {
-	Client kubernetes.Interface
-	Config *options.KubeRouterConfig
+	Client        kubernetes.Interface
+	DynamicClient dynamic.Interface
+	Config        *options.KubeRouterConfig
 }
 
 // NewKubeRouterDefault returns a KubeRouter object
@@ -55,19 +62,44 @@ func NewKubeRouterDefault(config *options.KubeRouterConfig) (*KubeRouter, error)
 		return nil, errors.New("Failed to create Kubernetes client: " + err.Error())
 	}
 
-	return &KubeRouter{Client: clientset, Config: config}, nil
+	dynamicClient, err := dynamic.NewForConfig(clientconfig)
+	if err != nil {
+		return nil, errors.New("Failed to create Kubernetes dynamic client: " + err.Error())
+	}
+
+	return &KubeRouter{Client: clientset, DynamicClient: dynamicClient, Config: config}, nil
 }
 
-// CleanupConfigAndExit performs Cleanup on all three controllers
-func CleanupConfigAndExit() {
-	npc := netpol.NetworkPolicyController{}
-	npc.Cleanup()
+// CleanupConfigAndExit performs Cleanup on the controllers selected with --cleanup-controllers. With --cleanup-dry-run
+// the controllers only list their configuration.
+func CleanupConfigAndExit(config *options.KubeRouterConfig) error {
+	selected := make(map[string]bool)
+	for _, controller := range config.CleanupControllers {
+		switch controller {
+		case "firewall", "router", "service-proxy":
+			selected[controller] = true
+		default:
+			return fmt.Errorf("invalid --cleanup-controllers value %q, valid values are firewall, router and "+
+				"service-proxy", controller)
+		}
+	}
+
+	if selected["firewall"] {
+		npc := netpol.NetworkPolicyController{}
+		npc.Cleanup(config.CleanupDryRun)
+	}
+
+	if selected["service-proxy"] {
+		nsc := proxy.NetworkServicesController{}
+		nsc.Cleanup(config.CleanupDryRun)
+	}
 
-	nsc := proxy.NetworkServicesController{}
-	nsc.Cleanup()
+	if selected["router"] {
+		nrc := routing.NetworkRoutingController{}
+		nrc.Cleanup(config.CleanupDryRun)
+	}
 
-	nrc := routing.NetworkRoutingController{}
-	nrc.Cleanup()
+	return nil
 }
 
 // Run starts the controllers and waits forever till we get SIGINT or SIGTERM
@@ -106,6 +138,35 @@ func (kr *KubeRouter) Run() error {
 		return errors.New("Failed to synchronize cache: " + err.Error())
 	}
 
+	var secretInformer cache.SharedIndexInformer
+	if kr.Config.RunRouter && kr.Config.EnablePeerPasswordSecrets {
+		// only the Secrets of a single namespace are cached, rather than every Secret of the cluster
+		secretInformerFactory := informers.NewSharedInformerFactoryWithOptions(kr.Client, 0,
+			informers.WithNamespace(kr.Config.PeerPasswordSecretsNamespace))
+		secretInformer = secretInformerFactory.Core().V1().Secrets().Informer()
+		secretInformerFactory.Start(stopCh)
+
+		err = kr.CacheSyncOrTimeout(secretInformerFactory, stopCh)
+		if err != nil {
+			return errors.New("Failed to synchronize secrets cache, check that kube-router is allowed to list " +
+				"and watch secrets in namespace " + kr.Config.PeerPasswordSecretsNamespace + ": " + err.Error())
+		}
+	}
+
+	var bgpPeerInformer, bgpConfigInformer cache.SharedIndexInformer
+	if kr.Config.RunRouter && kr.Config.EnableBGPCRDs {
+		dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(kr.DynamicClient, 0)
+		bgpPeerInformer = dynamicInformerFactory.ForResource(v1alpha1.BGPPeerResource).Informer()
+		bgpConfigInformer = dynamicInformerFactory.ForResource(v1alpha1.BGPConfigurationResource).Informer()
+		dynamicInformerFactory.Start(stopCh)
+
+		err = kr.syncOrTimeout(func() { dynamicInformerFactory.WaitForCacheSync(stopCh) })
+		if err != nil {
+			return errors.New("Failed to synchronize BGP custom resources cache, check that their definitions " +
+				"are installed: " + err.Error())
+		}
+	}
+
 	hc.SetAlive()
 	wg.Add(1)
 	go hc.RunCheck(healthChan, stopCh, &wg)
@@ -142,7 +203,7 @@ func (kr *KubeRouter) Run() error {
 
 	if kr.Config.RunRouter {
 		nrc, err := routing.NewNetworkRoutingController(kr.Client, kr.Config,
-			nodeInformer, svcInformer, epInformer, &ipsetMutex)
+			nodeInformer, svcInformer, epInformer, bgpPeerInformer, bgpConfigInformer, secretInformer, &ipsetMutex)
 		if err != nil {
 			return errors.New("Failed to create network routing controller: " + err.Error())
 		}
@@ -150,6 +211,21 @@ func (kr *KubeRouter) Run() error {
 		nodeInformer.AddEventHandler(nrc.NodeEventHandler)
 		svcInformer.AddEventHandler(nrc.ServiceEventHandler)
 		epInformer.AddEventHandler(nrc.EndpointsEventHandler)
+		if kr.Config.EnableBGPCRDs {
+			bgpPeerInformer.AddEventHandler(nrc.BGPPeerEventHandler)
+			bgpConfigInformer.AddEventHandler(nrc.BGPConfigurationEventHandler)
+		}
+		if kr.Config.EnablePeerPasswordSecrets {
+			secretInformer.AddEventHandler(nrc.SecretEventHandler)
+		}
+
+		if kr.Config.EnableRoutingIntrospection {
+			http.HandleFunc(routing.IntrospectionPath, nrc.IntrospectionHandler)
+			if kr.Config.HealthPort == 0 && !kr.Config.MetricsEnabled {
+				klog.Warningf("The routing state is not served on %s as neither the health nor the metrics port "+
+					"is set", routing.IntrospectionPath)
+			}
+		}
 
 		wg.Add(1)
 		go nrc.Run(healthChan, stopCh, &wg)
@@ -171,6 +247,9 @@ func (kr *KubeRouter) Run() error {
 
 		svcInformer.AddEventHandler(nsc.ServiceEventHandler)
 		epInformer.AddEventHandler(nsc.EndpointsEventHandler)
+		if kr.Config.EnableHostPort {
+			podInformer.AddEventHandler(nsc.PodEventHandler)
+		}
 
 		wg.Add(1)
 		go nsc.Run(healthChan, stopCh, &wg)
@@ -213,9 +292,14 @@ func (kr *KubeRouter) Run() error {
 // CacheSyncOrTimeout performs cache synchronization under timeout limit
 func (kr *KubeRouter) CacheSyncOrTimeout(informerFactory informers.SharedInformerFactory,
 	stopCh <-chan struct{}) error {
+	return kr.syncOrTimeout(func() { informerFactory.WaitForCacheSync(stopCh) })
+}
+
+// syncOrTimeout waits for the given cache synchronization to be over under timeout limit
+func (kr *KubeRouter) syncOrTimeout(waitForCacheSync func()) error {
 	syncOverCh := make(chan struct{})
 	go func() {
-		informerFactory.WaitForCacheSync(stopCh)
+		waitForCacheSync()
 		close(syncOverCh)
 	}()
 
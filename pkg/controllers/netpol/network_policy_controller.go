@@ -278,7 +278,7 @@ func (npc *NetworkPolicyController) fullPolicySync() {
 		return
 	}
 
-	err = npc.cleanupStaleIPSets(activePolicyIPSets)
+	err = npc.cleanupStaleIPSets(activePolicyIPSets, false)
 	if err != nil {
 		klog.Errorf("Failed to cleanup stale ipsets: %v", err.Error())
 		return
@@ -541,7 +541,8 @@ func (npc *NetworkPolicyController) cleanupStaleRules(activePolicyChains, active
 	return nil
 }
 
-func (npc *NetworkPolicyController) cleanupStaleIPSets(activePolicyIPSets map[string]bool) error {
+// cleanupStaleIPSets destroys the network policy ipsets that are not active, in dry run mode they are only logged
+func (npc *NetworkPolicyController) cleanupStaleIPSets(activePolicyIPSets map[string]bool, dryRun bool) error {
 	cleanupPolicyIPSets := make([]*utils.Set, 0)
 
 	// There are certain actions like Cleanup() actions that aren't working with full instantiations of the controller
@@ -575,6 +576,10 @@ func (npc *NetworkPolicyController) cleanupStaleIPSets(activePolicyIPSets map[st
 	}
 	// cleanup network policy ipsets
 	for _, set := range cleanupPolicyIPSets {
+		if dryRun {
+			klog.Infof("Dry run: would remove ipset %s", set.Name)
+			continue
+		}
 		err = set.Destroy()
 		if err != nil {
 			return fmt.Errorf("failed to delete ipset %s due to %s", set.Name, err)
@@ -583,8 +588,8 @@ func (npc *NetworkPolicyController) cleanupStaleIPSets(activePolicyIPSets map[st
 	return nil
 }
 
-// Cleanup cleanup configurations done
-func (npc *NetworkPolicyController) Cleanup() {
+// Cleanup cleanup configurations done, in dry run mode the iptables chains, rules and ipsets are only logged
+func (npc *NetworkPolicyController) Cleanup(dryRun bool) {
 	klog.Info("Cleaning up NetworkPolicyController configurations...")
 
 	var emptySet map[string]bool
@@ -593,6 +598,7 @@ func (npc *NetworkPolicyController) Cleanup() {
 		klog.Errorf("error encountered attempting to list iptables rules for cleanup: %v", err)
 		return
 	}
+	currentFilterTable := npc.filterTableRules.String()
 	// Run cleanupStaleRules() to get rid of most of the kube-router rules (this is the same logic that runs as
 	// part NPC's runtime loop). Setting the last parameter to true causes even the default chains are removed.
 	err := npc.cleanupStaleRules(emptySet, emptySet, true)
@@ -600,20 +606,28 @@ func (npc *NetworkPolicyController) Cleanup() {
 		klog.Errorf("error encountered attempting to cleanup iptables rules: %v", err)
 		return
 	}
-	// Restore (iptables-restore) npc's cleaned up version of the iptables filter chain
-	if err = utils.Restore("filter", npc.filterTableRules.Bytes()); err != nil {
+	if dryRun {
+		for _, entry := range removedFilterTableEntries(currentFilterTable, npc.filterTableRules.String()) {
+			klog.Infof("Dry run: would remove %s", entry)
+		}
+	} else if err = utils.Restore("filter", npc.filterTableRules.Bytes()); err != nil {
+		// Restore (iptables-restore) npc's cleaned up version of the iptables filter chain
 		klog.Errorf(
 			"error encountered while loading running iptables-restore: %v\n%s", err,
 			npc.filterTableRules.String())
 	}
 
 	// Cleanup ipsets
-	err = npc.cleanupStaleIPSets(emptySet)
+	err = npc.cleanupStaleIPSets(emptySet, dryRun)
 	if err != nil {
 		klog.Errorf("error encountered while cleaning ipsets: %v", err)
 		return
 	}
 
+	if dryRun {
+		klog.Infof("Dry run: listed the NetworkPolicyController configuration done by kube-router")
+		return
+	}
 	klog.Infof("Successfully cleaned the NetworkPolicyController configurations done by kube-router")
 }
 
@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	api "k8s.io/api/core/v1"
@@ -69,6 +70,36 @@ func validateNodePortRange(nodePortOption string) (string, error) {
 	return fmt.Sprintf("%d:%d", port1, port2), nil
 }
 
+// removedFilterTableEntries compares two iptables-save dumps of the filter table and returns the chains and rules of
+// the current one that are missing from the desired one
+func removedFilterTableEntries(current, desired string) []string {
+	desiredChains := make(map[string]bool)
+	desiredRules := make(map[string]bool)
+	for _, line := range strings.Split(desired, "\n") {
+		switch {
+		case strings.HasPrefix(line, ":"):
+			desiredChains[strings.Fields(line)[0]] = true
+		case strings.HasPrefix(line, "-"):
+			desiredRules[line] = true
+		}
+	}
+
+	var removed []string
+	for _, line := range strings.Split(current, "\n") {
+		switch {
+		case strings.HasPrefix(line, ":"):
+			if chain := strings.Fields(line)[0]; !desiredChains[chain] {
+				removed = append(removed, "iptables chain -t filter "+strings.TrimPrefix(chain, ":"))
+			}
+		case strings.HasPrefix(line, "-"):
+			if !desiredRules[line] {
+				removed = append(removed, "iptables rule -t filter "+line)
+			}
+		}
+	}
+	return removed
+}
+
 func getIPsFromPods(pods []podInfo) []string {
 	ips := make([]string, len(pods))
 	for idx, pod := range pods {
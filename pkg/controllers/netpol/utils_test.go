@@ -160,3 +160,29 @@ func Test_NewNetworkPolicyController(t *testing.T) {
 		assert.Empty(t, portRange)
 	})
 }
+
+func Test_removedFilterTableEntries(t *testing.T) {
+	current := `# Generated by iptables-save
+*filter
+:INPUT ACCEPT [0:0]
+:FORWARD ACCEPT [0:0]
+:KUBE-ROUTER-INPUT - [0:0]
+:KUBE-POD-FW-ABC - [0:0]
+-A INPUT -m comment --comment "kube-router netpol" -j KUBE-ROUTER-INPUT
+-A FORWARD -j ACCEPT
+-A KUBE-ROUTER-INPUT -j KUBE-POD-FW-ABC
+COMMIT
+`
+	desired := `*filter
+:INPUT ACCEPT [0:0] - [0:0]
+:FORWARD ACCEPT [0:0] - [0:0]
+-A FORWARD -j ACCEPT
+COMMIT
+`
+	assert.Equal(t, []string{
+		"iptables chain -t filter KUBE-ROUTER-INPUT",
+		"iptables chain -t filter KUBE-POD-FW-ABC",
+		"iptables rule -t filter -A INPUT -m comment --comment \"kube-router netpol\" -j KUBE-ROUTER-INPUT",
+		"iptables rule -t filter -A KUBE-ROUTER-INPUT -j KUBE-POD-FW-ABC",
+	}, removedFilterTableEntries(current, desired))
+}
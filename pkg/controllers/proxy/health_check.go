@@ -0,0 +1,386 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+const (
+	healthCheckTCP   = "tcp"
+	healthCheckHTTP  = "http"
+	healthCheckLocal = "local"
+	healthCheckAll   = "all"
+
+	// number of consecutive probe results that are needed before the health of a destination flips
+	healthCheckFailureThreshold = 3
+	healthCheckSuccessThreshold = 2
+)
+
+// active health check settings of a service as configured by the kube-router.io/service.healthcheck annotations
+type healthCheckOpt struct {
+	protocol     string
+	path         string
+	port         int
+	allEndpoints bool
+}
+
+// endpoint that is actively probed on behalf of a service
+type healthCheckTarget struct {
+	svcNamespace string
+	svcName      string
+	ip           string
+	port         int
+	protocol     string
+	path         string
+	udp          bool
+}
+
+// state of a probed endpoint, new endpoints are considered healthy until they have failed enough probes
+type healthCheckState struct {
+	target    healthCheckTarget
+	healthy   bool
+	failures  int
+	successes int
+}
+
+// endpointHealthChecker periodically probes the endpoints of services that have active health checking enabled and
+// keeps track of their health, so that unhealthy endpoints can be set to weight 0 in their IPVS services
+type endpointHealthChecker struct {
+	mu             sync.Mutex
+	interval       time.Duration
+	timeout        time.Duration
+	metricsEnabled bool
+	states         map[string]*healthCheckState
+	probe          func(target healthCheckTarget, timeout time.Duration) error
+	onChange       func(target healthCheckTarget, healthy bool)
+}
+
+func newEndpointHealthChecker(interval, timeout time.Duration,
+	onChange func(target healthCheckTarget, healthy bool)) *endpointHealthChecker {
+	return &endpointHealthChecker{
+		interval: interval,
+		timeout:  timeout,
+		states:   make(map[string]*healthCheckState),
+		probe:    probeEndpoint,
+		onChange: onChange,
+	}
+}
+
+// unique identifier for a probed endpoint (namespace + service name + check + endpoint)
+func generateHealthCheckTargetID(namespace, svcName string, check *healthCheckOpt, ip string, port int) string {
+	return namespace + "-" + svcName + "-" + check.protocol + "-" + generateEndpointID(ip, strconv.Itoa(port)) +
+		check.path
+}
+
+// parseHealthCheck returns the active health check settings from the annotations of a service or nil if active health
+// checking is not enabled for the service
+func parseHealthCheck(annotations map[string]string) (*healthCheckOpt, error) {
+	protocol, ok := annotations[svcHealthCheckAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	check := healthCheckOpt{protocol: strings.ToLower(strings.TrimSpace(protocol))}
+	switch check.protocol {
+	case healthCheckTCP:
+	case healthCheckHTTP:
+		check.path = "/"
+		if path, ok := annotations[svcHealthCheckPathAnnotation]; ok && path != "" {
+			if !strings.HasPrefix(path, "/") {
+				return nil, fmt.Errorf("health check path %q must start with a /", path)
+			}
+			check.path = path
+		}
+	default:
+		return nil, fmt.Errorf("unsupported health check type %q, must be one of: %s, %s", protocol,
+			healthCheckTCP, healthCheckHTTP)
+	}
+
+	if portStr, ok := annotations[svcHealthCheckPortAnnotation]; ok {
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid health check port %q", portStr)
+		}
+		check.port = port
+	}
+
+	switch scope := annotations[svcHealthCheckScopeAnnotation]; scope {
+	case "", healthCheckLocal:
+	case healthCheckAll:
+		check.allEndpoints = true
+	default:
+		return nil, fmt.Errorf("unsupported health check scope %q, must be one of: %s, %s", scope,
+			healthCheckLocal, healthCheckAll)
+	}
+
+	return &check, nil
+}
+
+// probeEndpoint probes the given target once, returns an error if the target is not healthy
+func probeEndpoint(target healthCheckTarget, timeout time.Duration) error {
+	addr := net.JoinHostPort(target.ip, strconv.Itoa(target.port))
+	if target.protocol == healthCheckHTTP {
+		client := http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{DisableKeepAlives: true},
+			// like kubelet HTTP probes, any 2xx or 3xx response is considered a success
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		resp, err := client.Get("http://" + addr + target.path)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("HTTP probe to %s%s returned status %d", addr, target.path, resp.StatusCode)
+		}
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// setTargets updates the endpoints that are probed, state of endpoints which are still probed is retained
+func (hc *endpointHealthChecker) setTargets(targets map[string]healthCheckTarget) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	for id, state := range hc.states {
+		if _, ok := targets[id]; ok {
+			continue
+		}
+		if hc.metricsEnabled {
+			metrics.ServiceEndpointHealthy.DeleteLabelValues(state.target.metricLabels()...)
+			metrics.ServiceEndpointHealthCheckFailures.DeleteLabelValues(state.target.metricLabels()...)
+		}
+		delete(hc.states, id)
+	}
+
+	for id, target := range targets {
+		if state, ok := hc.states[id]; ok {
+			state.target = target
+			continue
+		}
+		klog.V(2).Infof("Starting active health checking of endpoint %s:%d for service %s/%s",
+			target.ip, target.port, target.svcNamespace, target.svcName)
+		hc.states[id] = &healthCheckState{target: target, healthy: true}
+		if hc.metricsEnabled {
+			metrics.ServiceEndpointHealthy.WithLabelValues(target.metricLabels()...).Set(1)
+		}
+	}
+}
+
+// isHealthy returns false only if the endpoint is probed and has been found to be unhealthy
+func (hc *endpointHealthChecker) isHealthy(id string) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	state, ok := hc.states[id]
+	return !ok || state.healthy
+}
+
+// run probes all targets every interval until notified to stop on stopCh
+func (hc *endpointHealthChecker) run(stopCh <-chan struct{}) {
+	t := time.NewTicker(hc.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stopCh:
+			klog.V(1).Info("Stopping active health checking of IPVS destinations")
+			return
+		case <-t.C:
+			hc.probeAll()
+		}
+	}
+}
+
+// probeAll probes all targets in parallel and updates their state once all probes finished
+func (hc *endpointHealthChecker) probeAll() {
+	hc.mu.Lock()
+	targets := make(map[string]healthCheckTarget, len(hc.states))
+	for id, state := range hc.states {
+		targets[id] = state.target
+	}
+	hc.mu.Unlock()
+
+	if len(targets) == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	var resultsMu sync.Mutex
+	results := make(map[string]error, len(targets))
+	for id, target := range targets {
+		wg.Add(1)
+		go func(id string, target healthCheckTarget) {
+			defer wg.Done()
+			err := hc.probe(target, hc.timeout)
+			resultsMu.Lock()
+			results[id] = err
+			resultsMu.Unlock()
+		}(id, target)
+	}
+	wg.Wait()
+
+	changed := make(map[healthCheckTarget]bool)
+	hc.mu.Lock()
+	for id, err := range results {
+		// target may have been removed while it was being probed
+		state, ok := hc.states[id]
+		if !ok {
+			continue
+		}
+		if hc.updateState(state, err) {
+			changed[state.target] = state.healthy
+		}
+	}
+	hc.mu.Unlock()
+
+	for target, healthy := range changed {
+		if hc.onChange != nil {
+			hc.onChange(target, healthy)
+		}
+	}
+}
+
+// updateState records a probe result for the given state and returns true if the health of the endpoint flipped
+func (hc *endpointHealthChecker) updateState(state *healthCheckState, probeErr error) bool {
+	target := state.target
+	if probeErr != nil {
+		state.successes = 0
+		state.failures++
+		klog.V(2).Infof("Health check of endpoint %s:%d for service %s/%s failed (%d/%d): %v", target.ip,
+			target.port, target.svcNamespace, target.svcName, state.failures, healthCheckFailureThreshold, probeErr)
+		if hc.metricsEnabled {
+			metrics.ServiceEndpointHealthCheckFailures.WithLabelValues(target.metricLabels()...).Inc()
+		}
+		if !state.healthy || state.failures < healthCheckFailureThreshold {
+			return false
+		}
+		klog.Warningf("Endpoint %s:%d of service %s/%s failed %d consecutive health checks, marking it unhealthy",
+			target.ip, target.port, target.svcNamespace, target.svcName, state.failures)
+		state.healthy = false
+	} else {
+		state.failures = 0
+		state.successes++
+		if state.healthy || state.successes < healthCheckSuccessThreshold {
+			return false
+		}
+		klog.Infof("Endpoint %s:%d of service %s/%s passed %d consecutive health checks, marking it healthy",
+			target.ip, target.port, target.svcNamespace, target.svcName, state.successes)
+		state.healthy = true
+	}
+
+	if hc.metricsEnabled {
+		healthy := 0.0
+		if state.healthy {
+			healthy = 1
+		}
+		metrics.ServiceEndpointHealthy.WithLabelValues(target.metricLabels()...).Set(healthy)
+	}
+	return true
+}
+
+func (t healthCheckTarget) metricLabels() []string {
+	return []string{t.svcNamespace, t.svcName, t.ip, strconv.Itoa(t.port), t.protocol}
+}
+
+// buildHealthCheckTargets returns the endpoints that need to be probed for the services with active health checking
+// enabled. Only endpoints local to the node are probed unless the service asks for all endpoints to be probed.
+func buildHealthCheckTargets(serviceInfoMap serviceInfoMap,
+	endpointsInfoMap endpointsInfoMap) map[string]healthCheckTarget {
+	targets := make(map[string]healthCheckTarget)
+	for k, svc := range serviceInfoMap {
+		if svc.healthCheck == nil {
+			continue
+		}
+		for _, endpoint := range endpointsInfoMap[k] {
+			if !endpoint.isLocal && !svc.healthCheck.allEndpoints {
+				continue
+			}
+			port := endpoint.port
+			if svc.healthCheck.port != 0 {
+				port = svc.healthCheck.port
+			}
+			id := generateHealthCheckTargetID(svc.namespace, svc.name, svc.healthCheck, endpoint.ip, port)
+			// a target can be shared by several ports of the service, UDP flows need to be flushed if any of them
+			// load balances UDP without one-packet scheduling, which reschedules every datagram anyway
+			udp := !svc.flags.onePacket &&
+				(svc.protocol == udpProtocol || (svc.portRange != nil && svc.portRange.protocol == udpProtocol))
+			targets[id] = healthCheckTarget{
+				svcNamespace: svc.namespace,
+				svcName:      svc.name,
+				ip:           endpoint.ip,
+				port:         port,
+				protocol:     svc.healthCheck.protocol,
+				path:         svc.healthCheck.path,
+				udp:          udp || targets[id].udp,
+			}
+		}
+	}
+	return targets
+}
+
+// endpointWeight returns the weight the IPVS destination for the given endpoint of the service should have. Endpoints
+// that failed their active health check get weight 0 so that IPVS no longer schedules new connections to them, in the
+// same way as graceful termination does.
+func (nsc *NetworkServicesController) endpointWeight(svc *serviceInfo, endpoint endpointsInfo) int {
+	if nsc.healthChecker == nil || svc.healthCheck == nil {
+		return 1
+	}
+	port := endpoint.port
+	if svc.healthCheck.port != 0 {
+		port = svc.healthCheck.port
+	}
+	if nsc.healthChecker.isHealthy(
+		generateHealthCheckTargetID(svc.namespace, svc.name, svc.healthCheck, endpoint.ip, port)) {
+		return 1
+	}
+	return 0
+}
+
+// onEndpointHealthChange requests a sync of the IPVS services so that the weight of the destination reflects the new
+// health of the endpoint. Existing UDP flows to an unhealthy endpoint are flushed as they would otherwise stick to it.
+func (nsc *NetworkServicesController) onEndpointHealthChange(target healthCheckTarget, healthy bool) {
+	klog.V(1).Infof("Syncing IPVS services as health of endpoint %s:%d for service %s/%s changed to healthy=%t",
+		target.ip, target.port, target.svcNamespace, target.svcName, healthy)
+	if !healthy && target.udp {
+		if err := flushConntrackUDPForDestination(target.ip); err != nil {
+			klog.Errorf("Failed to flush conntrack: %s", err.Error())
+		}
+	}
+	nsc.sync(synctypeIpvs)
+}
+
+// flushConntrackUDPForDestination flushes UDP conntrack records of flows that were load balanced to the given endpoint
+func flushConntrackUDPForDestination(endpointIP string) error {
+	// Conntrack exits with non zero exit code when exiting if 0 flow entries have been deleted, use regex to
+	// check output and don't Error when matching
+	re := regexp.MustCompile("([[:space:]]0 flow entries have been deleted.)")
+
+	//nolint:gosec // this exec should be safe from command injection given the parameter's context
+	out, err := exec.Command("conntrack", "-D", "--reply-src", endpointIP, "-p", udpProtocol).CombinedOutput()
+	if err != nil {
+		if matched := re.MatchString(string(out)); !matched {
+			return fmt.Errorf("failed to delete conntrack entries for endpoint: %s due to %s",
+				endpointIP, err.Error())
+		}
+	}
+	klog.V(1).Infof("Deleted UDP conntrack entries for endpoint: %s", endpointIP)
+	return nil
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHealthCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    *healthCheckOpt
+		expectErr   bool
+	}{
+		{"no annotation", map[string]string{}, nil, false},
+		{"tcp", map[string]string{svcHealthCheckAnnotation: "tcp"},
+			&healthCheckOpt{protocol: healthCheckTCP}, false},
+		{"http default path", map[string]string{svcHealthCheckAnnotation: "HTTP"},
+			&healthCheckOpt{protocol: healthCheckHTTP, path: "/"}, false},
+		{"http with path port and scope", map[string]string{
+			svcHealthCheckAnnotation:      "http",
+			svcHealthCheckPathAnnotation:  "/healthz",
+			svcHealthCheckPortAnnotation:  "8080",
+			svcHealthCheckScopeAnnotation: "all",
+		}, &healthCheckOpt{protocol: healthCheckHTTP, path: "/healthz", port: 8080, allEndpoints: true}, false},
+		{"unsupported type", map[string]string{svcHealthCheckAnnotation: "icmp"}, nil, true},
+		{"relative path", map[string]string{
+			svcHealthCheckAnnotation:     "http",
+			svcHealthCheckPathAnnotation: "healthz",
+		}, nil, true},
+		{"invalid port", map[string]string{
+			svcHealthCheckAnnotation:     "tcp",
+			svcHealthCheckPortAnnotation: "70000",
+		}, nil, true},
+		{"invalid scope", map[string]string{
+			svcHealthCheckAnnotation:      "tcp",
+			svcHealthCheckScopeAnnotation: "remote",
+		}, nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			check, err := parseHealthCheck(tc.annotations)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, check)
+		})
+	}
+}
+
+func TestBuildHealthCheckTargets(t *testing.T) {
+	svcs := serviceInfoMap{
+		"default-web-tcp-http": &serviceInfo{
+			name:        "web",
+			namespace:   "default",
+			protocol:    tcpProtocol,
+			healthCheck: &healthCheckOpt{protocol: healthCheckTCP},
+		},
+		"default-dns-udp-dns": &serviceInfo{
+			name:        "dns",
+			namespace:   "default",
+			protocol:    udpProtocol,
+			healthCheck: &healthCheckOpt{protocol: healthCheckHTTP, path: "/ready", port: 8181, allEndpoints: true},
+		},
+		"default-plain-tcp-http": &serviceInfo{
+			name:      "plain",
+			namespace: "default",
+			protocol:  tcpProtocol,
+		},
+	}
+	endpoints := endpointsInfoMap{
+		"default-web-tcp-http": {
+			{ip: "172.20.1.1", port: 80, isLocal: true},
+			{ip: "172.20.2.1", port: 80, isLocal: false},
+		},
+		"default-dns-udp-dns": {
+			{ip: "172.20.1.2", port: 53, isLocal: true},
+			{ip: "172.20.2.2", port: 53, isLocal: false},
+		},
+		"default-plain-tcp-http": {
+			{ip: "172.20.1.3", port: 80, isLocal: true},
+		},
+	}
+
+	targets := buildHealthCheckTargets(svcs, endpoints)
+	assert.Len(t, targets, 3)
+
+	webCheck := svcs["default-web-tcp-http"].healthCheck
+	assert.Contains(t, targets, generateHealthCheckTargetID("default", "web", webCheck, "172.20.1.1", 80))
+	assert.NotContains(t, targets, generateHealthCheckTargetID("default", "web", webCheck, "172.20.2.1", 80),
+		"remote endpoints should only be probed when the scope is all")
+
+	dnsCheck := svcs["default-dns-udp-dns"].healthCheck
+	for _, ip := range []string{"172.20.1.2", "172.20.2.2"} {
+		target, ok := targets[generateHealthCheckTargetID("default", "dns", dnsCheck, ip, 8181)]
+		assert.True(t, ok, "endpoint %s should be probed on the health check port", ip)
+		assert.True(t, target.udp)
+		assert.Equal(t, "/ready", target.path)
+	}
+}
+
+func TestEndpointHealthChecker_probeAll(t *testing.T) {
+	target := healthCheckTarget{svcNamespace: "default", svcName: "web", ip: "172.20.1.1", port: 80,
+		protocol: healthCheckTCP}
+	var probeErr error
+	var changes []bool
+	hc := newEndpointHealthChecker(time.Second, time.Second, func(_ healthCheckTarget, healthy bool) {
+		changes = append(changes, healthy)
+	})
+	hc.probe = func(healthCheckTarget, time.Duration) error { return probeErr }
+	hc.setTargets(map[string]healthCheckTarget{"web": target})
+
+	assert.True(t, hc.isHealthy("web"), "new targets should be healthy")
+	assert.True(t, hc.isHealthy("unknown"), "targets that are not probed should be healthy")
+
+	probeErr = errors.New("connection refused")
+	for i := 1; i < healthCheckFailureThreshold; i++ {
+		hc.probeAll()
+		assert.True(t, hc.isHealthy("web"), "target should stay healthy after %d failed probes", i)
+	}
+	hc.probeAll()
+	assert.False(t, hc.isHealthy("web"))
+	assert.Equal(t, []bool{false}, changes)
+
+	probeErr = nil
+	for i := 1; i < healthCheckSuccessThreshold; i++ {
+		hc.probeAll()
+		assert.False(t, hc.isHealthy("web"), "target should stay unhealthy after %d successful probes", i)
+	}
+	hc.probeAll()
+	assert.True(t, hc.isHealthy("web"))
+	assert.Equal(t, []bool{false, true}, changes)
+
+	hc.setTargets(map[string]healthCheckTarget{})
+	assert.Empty(t, hc.states)
+}
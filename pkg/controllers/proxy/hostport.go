@@ -0,0 +1,394 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	api "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	hostPortChainName     = "KUBE-ROUTER-HOSTPORTS"
+	hostPortMasqChainName = "KUBE-ROUTER-HOSTPORTS-MASQ"
+)
+
+// hostPortMapping is a hostPort of a container of a pod running on this node
+type hostPortMapping struct {
+	podNamespace  string
+	podName       string
+	hostIP        string
+	hostPort      int
+	podIP         string
+	containerPort int
+	protocol      string
+}
+
+// static rules that send traffic to local addresses through the hostPort chain, traffic to the loopback addresses is
+// excluded as it can not be DNAT'ed to a pod without enabling route_localnet
+func getHostPortJumpRules() map[string][]string {
+	return map[string][]string{
+		"PREROUTING": {"-m", "comment", "--comment", "kube-router hostports",
+			"-m", "addrtype", "--dst-type", "LOCAL", "-j", hostPortChainName},
+		"OUTPUT": {"!", "-d", "127.0.0.0/8", "-m", "comment", "--comment", "kube-router hostports",
+			"-m", "addrtype", "--dst-type", "LOCAL", "-j", hostPortChainName},
+	}
+}
+
+// static rule that masquerades hairpin traffic of pods to their own hostPorts
+func getHostPortMasqJumpRule() []string {
+	return []string{"-m", "comment", "--comment", "kube-router hostport hairpin", "-j", hostPortMasqChainName}
+}
+
+// buildHostPortMappings returns the hostPorts of the containers of the running pods on the given node. Pods in the host
+// network namespace are skipped as their containers bind the hostPort directly.
+func buildHostPortMappings(pods []interface{}, nodeName string) []hostPortMapping {
+	mappings := make([]hostPortMapping, 0)
+	for _, obj := range pods {
+		pod, ok := obj.(*api.Pod)
+		if !ok || !podHasHostPorts(pod, nodeName) {
+			continue
+		}
+		if pod.Status.PodIP == "" || pod.Status.Phase == api.PodSucceeded || pod.Status.Phase == api.PodFailed {
+			continue
+		}
+		if net.ParseIP(pod.Status.PodIP).To4() == nil {
+			klog.V(2).Infof("Skipping hostPorts of pod %s/%s as IPv6 is not supported", pod.Namespace, pod.Name)
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			for _, port := range container.Ports {
+				if port.HostPort == 0 {
+					continue
+				}
+				hostIP := port.HostIP
+				if hostIP == "0.0.0.0" {
+					hostIP = ""
+				}
+				if hostIP != "" && net.ParseIP(hostIP).To4() == nil {
+					klog.Warningf("Skipping hostPort %d of pod %s/%s as host IP %s is not a valid IPv4 address",
+						port.HostPort, pod.Namespace, pod.Name, hostIP)
+					continue
+				}
+				protocol := strings.ToLower(string(port.Protocol))
+				if protocol == "" {
+					protocol = tcpProtocol
+				}
+				mappings = append(mappings, hostPortMapping{
+					podNamespace:  pod.Namespace,
+					podName:       pod.Name,
+					hostIP:        hostIP,
+					hostPort:      int(port.HostPort),
+					podIP:         pod.Status.PodIP,
+					containerPort: int(port.ContainerPort),
+					protocol:      protocol,
+				})
+			}
+		}
+	}
+
+	// the order in which the informer lists pods is random, keep the mappings comparable between syncs
+	sort.Slice(mappings, func(i, j int) bool {
+		a, b := mappings[i], mappings[j]
+		if a.podNamespace+"/"+a.podName != b.podNamespace+"/"+b.podName {
+			return a.podNamespace+"/"+a.podName < b.podNamespace+"/"+b.podName
+		}
+		if a.hostPort != b.hostPort {
+			return a.hostPort < b.hostPort
+		}
+		return a.protocol+a.hostIP < b.protocol+b.hostIP
+	})
+	return mappings
+}
+
+// podHasHostPorts returns true if the pod runs on the given node in its own network namespace and exposes hostPorts
+func podHasHostPorts(pod *api.Pod, nodeName string) bool {
+	if pod.Spec.NodeName != nodeName || pod.Spec.HostNetwork {
+		return false
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.HostPort != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hostPortDNATRuleFrom(mapping hostPortMapping) (string, []string) {
+	ruleArgs := make([]string, 0)
+	ruleString := "-A " + hostPortChainName
+	if mapping.hostIP != "" {
+		ruleArgs = append(ruleArgs, "-d", mapping.hostIP+"/32")
+		ruleString += " -d " + mapping.hostIP + "/32"
+	}
+	destination := net.JoinHostPort(mapping.podIP, strconv.Itoa(mapping.containerPort))
+	ruleArgs = append(ruleArgs, "-p", mapping.protocol, "-m", mapping.protocol, "--dport",
+		strconv.Itoa(mapping.hostPort), "-j", "DNAT", "--to-destination", destination)
+
+	// Trying to ensure this matches iptables.List()
+	ruleString += " -p " + mapping.protocol + " -m " + mapping.protocol + " --dport " +
+		strconv.Itoa(mapping.hostPort) + " -j DNAT" + " --to-destination " + destination
+
+	return ruleString, ruleArgs
+}
+
+func hostPortMasqRuleFrom(mapping hostPortMapping) (string, []string) {
+	ruleArgs := []string{"-s", mapping.podIP + "/32", "-d", mapping.podIP + "/32",
+		"-p", mapping.protocol, "-m", mapping.protocol, "--dport", strconv.Itoa(mapping.containerPort),
+		"-j", "MASQUERADE"}
+
+	// Trying to ensure this matches iptables.List()
+	ruleString := "-A " + hostPortMasqChainName + " -s " + mapping.podIP + "/32" + " -d " + mapping.podIP + "/32" +
+		" -p " + mapping.protocol + " -m " + mapping.protocol + " --dport " + strconv.Itoa(mapping.containerPort) +
+		" -j MASQUERADE"
+
+	return ruleString, ruleArgs
+}
+
+// syncHostPortIptablesRules sets up the nat rules that DNAT traffic to the hostPorts of the pods running on this node.
+// The rules are kept in kube-router's own chains so that they are cleaned up together with the pods, traffic that has
+// been DNAT'ed to a pod is still subject to the network policy firewall chains in the filter table.
+func (nsc *NetworkServicesController) syncHostPortIptablesRules() error {
+	dnatRulesNeeded := make(map[string][]string)
+	masqRulesNeeded := make(map[string][]string)
+	for _, mapping := range nsc.hostPortMappings {
+		rule, ruleArgs := hostPortDNATRuleFrom(mapping)
+		dnatRulesNeeded[rule] = ruleArgs
+		rule, ruleArgs = hostPortMasqRuleFrom(mapping)
+		masqRulesNeeded[rule] = ruleArgs
+	}
+
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return errors.New("Failed to initialize iptables executor" + err.Error())
+	}
+
+	err = syncNatChainRules(iptablesCmdHandler, hostPortChainName, dnatRulesNeeded)
+	if err != nil {
+		return err
+	}
+	err = syncNatChainRules(iptablesCmdHandler, hostPortMasqChainName, masqRulesNeeded)
+	if err != nil {
+		return err
+	}
+
+	for chain, jumpArgs := range getHostPortJumpRules() {
+		err = iptablesCmdHandler.AppendUnique("nat", chain, jumpArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to add hostport iptables jump rule to chain \"%s\": %v", chain, err)
+		}
+	}
+	err = iptablesCmdHandler.AppendUnique("nat", "POSTROUTING", getHostPortMasqJumpRule()...)
+	if err != nil {
+		return fmt.Errorf("failed to add hostport iptables jump rule to chain \"POSTROUTING\": %v", err)
+	}
+
+	klog.V(1).Infof("Synced iptables rules for %d hostPorts", len(nsc.hostPortMappings))
+	return nil
+}
+
+// syncNatChainRules ensures that the given chain exists in the nat table and holds exactly the rules needed, the keys
+// of rulesNeeded have to match the rules as returned by iptables.List()
+func syncNatChainRules(iptablesCmdHandler *iptables.IPTables, chain string, rulesNeeded map[string][]string) error {
+	exists, err := iptablesCmdHandler.ChainExists("nat", chain)
+	if err != nil {
+		return fmt.Errorf("failed to check for iptables chain \"%s\": %v", chain, err)
+	}
+	if !exists {
+		err = iptablesCmdHandler.NewChain("nat", chain)
+		if err != nil {
+			return fmt.Errorf("failed to create iptables chain \"%s\": %v", chain, err)
+		}
+	}
+
+	rulesFromNode, err := iptablesCmdHandler.List("nat", chain)
+	if err != nil {
+		return fmt.Errorf("failed to get rules from iptables chain \"%s\": %v", chain, err)
+	}
+
+	existingRules := make(map[string]bool, len(rulesFromNode))
+	for _, ruleFromNode := range rulesFromNode {
+		existingRules[ruleFromNode] = true
+	}
+	for rule, ruleArgs := range rulesNeeded {
+		if existingRules[rule] {
+			continue
+		}
+		err = iptablesCmdHandler.AppendUnique("nat", chain, ruleArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to apply iptables rule to chain \"%s\": %v", chain, err)
+		}
+	}
+
+	// Delete outdated rules, e.g. of pods that have been deleted
+	for _, ruleFromNode := range rulesFromNode {
+		if _, ruleIsNeeded := rulesNeeded[ruleFromNode]; ruleIsNeeded || ruleFromNode == "-N "+chain {
+			continue
+		}
+		args := strings.Fields(ruleFromNode)
+		if len(args) <= 2 {
+			continue
+		}
+		// Strip "-A CHAIN_NAME"
+		err = iptablesCmdHandler.Delete("nat", chain, args[2:]...)
+		if err != nil {
+			klog.Errorf("Unable to delete outdated rule \"%s\" from chain %s: %v", ruleFromNode, chain, err)
+		} else {
+			klog.V(1).Infof("Deleted outdated rule \"%s\" from chain %s", ruleFromNode, chain)
+		}
+	}
+	return nil
+}
+
+func deleteHostPortIptablesRules(dryRun bool) error {
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return errors.New("Failed to initialize iptables executor" + err.Error())
+	}
+
+	jumpRules := getHostPortJumpRules()
+	jumpRules["POSTROUTING"] = getHostPortMasqJumpRule()
+	for chain, jumpArgs := range jumpRules {
+		exists, err := iptablesCmdHandler.Exists("nat", chain, jumpArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to search %s iptables rules: %v", chain, err)
+		}
+		if !exists {
+			continue
+		}
+		chain, jumpArgs := chain, jumpArgs
+		err = cleanupResource(dryRun, "iptables rule -t nat -A "+chain+" "+strings.Join(jumpArgs, " "),
+			func() error {
+				return iptablesCmdHandler.Delete("nat", chain, jumpArgs...)
+			})
+		if err != nil {
+			klog.Errorf("unable to delete hostport jump rule from chain \"%s\": %v", chain, err)
+		} else if !dryRun {
+			klog.V(1).Infof("Deleted hostport jump rule from chain \"%s\"", chain)
+		}
+	}
+
+	for _, chain := range []string{hostPortChainName, hostPortMasqChainName} {
+		exists, err := iptablesCmdHandler.ChainExists("nat", chain)
+		if err != nil {
+			return fmt.Errorf("failed to check for iptables chain \"%s\": %v", chain, err)
+		}
+		if !exists {
+			continue
+		}
+		chain := chain
+		err = cleanupResource(dryRun, "iptables chain -t nat "+chain, func() error {
+			err := iptablesCmdHandler.ClearChain("nat", chain)
+			if err != nil {
+				return fmt.Errorf("failed to flush iptables chain \"%s\": %v", chain, err)
+			}
+			err = iptablesCmdHandler.DeleteChain("nat", chain)
+			if err != nil {
+				return fmt.Errorf("failed to delete iptables chain \"%s\": %v", chain, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnPodUpdate handle change in pods from the API server, only pods on this node that expose hostPorts are relevant
+func (nsc *NetworkServicesController) OnPodUpdate(pod *api.Pod) {
+	if pod.Spec.NodeName != nsc.nodeHostName {
+		return
+	}
+
+	nsc.mu.Lock()
+	defer nsc.mu.Unlock()
+
+	if !nsc.readyForUpdates {
+		klog.V(3).Infof("Skipping update to pod: %s/%s as controller is not ready to process pod updates",
+			pod.Namespace, pod.Name)
+		return
+	}
+
+	newHostPortMappings := buildHostPortMappings(nsc.podLister.List(), nsc.nodeHostName)
+	if !reflect.DeepEqual(newHostPortMappings, nsc.hostPortMappings) {
+		nsc.hostPortMappings = newHostPortMappings
+		klog.V(1).Infof("Syncing hostPorts for update to pod: %s/%s", pod.Namespace, pod.Name)
+		nsc.sync(synctypeHostPorts)
+	}
+}
+
+func (nsc *NetworkServicesController) newPodEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nsc.handlePodAdd(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			nsc.handlePodUpdate(oldObj, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			nsc.handlePodDelete(obj)
+		},
+	}
+}
+
+func (nsc *NetworkServicesController) handlePodAdd(obj interface{}) {
+	pod, ok := obj.(*api.Pod)
+	if !ok {
+		klog.Errorf("unexpected object type: %v", obj)
+		return
+	}
+	if !podHasHostPorts(pod, nsc.nodeHostName) {
+		return
+	}
+	nsc.OnPodUpdate(pod)
+}
+
+func (nsc *NetworkServicesController) handlePodUpdate(oldObj, newObj interface{}) {
+	oldPod, ok := oldObj.(*api.Pod)
+	if !ok {
+		klog.Errorf("unexpected object type: %v", oldObj)
+		return
+	}
+	newPod, ok := newObj.(*api.Pod)
+	if !ok {
+		klog.Errorf("unexpected object type: %v", newObj)
+		return
+	}
+	if !podHasHostPorts(newPod, nsc.nodeHostName) && !podHasHostPorts(oldPod, nsc.nodeHostName) {
+		return
+	}
+	// only the pod IP and phase of a pod with hostPorts are relevant, the containers of a pod can not be changed
+	if newPod.Status.PodIP == oldPod.Status.PodIP && newPod.Status.Phase == oldPod.Status.Phase {
+		return
+	}
+	nsc.OnPodUpdate(newPod)
+}
+
+func (nsc *NetworkServicesController) handlePodDelete(obj interface{}) {
+	pod, ok := obj.(*api.Pod)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			klog.Errorf("unexpected object type: %v", obj)
+			return
+		}
+		if pod, ok = tombstone.Obj.(*api.Pod); !ok {
+			klog.Errorf("unexpected object type: %v", obj)
+			return
+		}
+	}
+	if !podHasHostPorts(pod, nsc.nodeHostName) {
+		return
+	}
+	nsc.OnPodUpdate(pod)
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newHostPortPod(name, nodeName, podIP string, hostNetwork bool, ports ...api.ContainerPort) *api.Pod {
+	return &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec: api.PodSpec{
+			NodeName:    nodeName,
+			HostNetwork: hostNetwork,
+			Containers:  []api.Container{{Name: "app", Ports: ports}},
+		},
+		Status: api.PodStatus{PodIP: podIP, Phase: api.PodRunning},
+	}
+}
+
+func TestBuildHostPortMappings(t *testing.T) {
+	completed := newHostPortPod("completed", "node-1", "172.20.1.5", false,
+		api.ContainerPort{ContainerPort: 80, HostPort: 8082})
+	completed.Status.Phase = api.PodSucceeded
+
+	pods := []interface{}{
+		newHostPortPod("web", "node-1", "172.20.1.2", false,
+			api.ContainerPort{ContainerPort: 80, HostPort: 8080, Protocol: api.ProtocolTCP},
+			api.ContainerPort{ContainerPort: 53, HostPort: 5353, Protocol: api.ProtocolUDP, HostIP: "10.0.0.1"},
+			api.ContainerPort{ContainerPort: 9090}),
+		newHostPortPod("remote", "node-2", "172.20.2.2", false,
+			api.ContainerPort{ContainerPort: 80, HostPort: 8080}),
+		newHostPortPod("host-network", "node-1", "10.0.0.1", true,
+			api.ContainerPort{ContainerPort: 8081, HostPort: 8081}),
+		newHostPortPod("pending", "node-1", "", false,
+			api.ContainerPort{ContainerPort: 80, HostPort: 8083}),
+		completed,
+	}
+
+	mappings := buildHostPortMappings(pods, "node-1")
+	assert.Equal(t, []hostPortMapping{
+		{podNamespace: "default", podName: "web", hostIP: "10.0.0.1", hostPort: 5353, podIP: "172.20.1.2",
+			containerPort: 53, protocol: udpProtocol},
+		{podNamespace: "default", podName: "web", hostPort: 8080, podIP: "172.20.1.2", containerPort: 80,
+			protocol: tcpProtocol},
+	}, mappings)
+}
+
+func TestHostPortRuleFrom(t *testing.T) {
+	mapping := hostPortMapping{podNamespace: "default", podName: "web", hostPort: 8080, podIP: "172.20.1.2",
+		containerPort: 80, protocol: tcpProtocol}
+
+	rule, args := hostPortDNATRuleFrom(mapping)
+	assert.Equal(t, "-A KUBE-ROUTER-HOSTPORTS -p tcp -m tcp --dport 8080 -j DNAT --to-destination 172.20.1.2:80",
+		rule)
+	assert.Equal(t, []string{"-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "DNAT", "--to-destination",
+		"172.20.1.2:80"}, args)
+
+	mapping.hostIP = "10.0.0.1"
+	rule, args = hostPortDNATRuleFrom(mapping)
+	assert.Equal(t, "-A KUBE-ROUTER-HOSTPORTS -d 10.0.0.1/32 -p tcp -m tcp --dport 8080 -j DNAT "+
+		"--to-destination 172.20.1.2:80", rule)
+	assert.Equal(t, []string{"-d", "10.0.0.1/32", "-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "DNAT",
+		"--to-destination", "172.20.1.2:80"}, args)
+
+	rule, args = hostPortMasqRuleFrom(mapping)
+	assert.Equal(t, "-A KUBE-ROUTER-HOSTPORTS-MASQ -s 172.20.1.2/32 -d 172.20.1.2/32 -p tcp -m tcp --dport 80 "+
+		"-j MASQUERADE", rule)
+	assert.Equal(t, []string{"-s", "172.20.1.2/32", "-d", "172.20.1.2/32", "-p", "tcp", "-m", "tcp", "--dport",
+		"80", "-j", "MASQUERADE"}, args)
+}
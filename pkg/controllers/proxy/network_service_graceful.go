@@ -46,8 +46,9 @@ func (nsc *NetworkServicesController) ipvsDeleteDestination(svc *ipvs.Service, d
 			return err
 		}
 	}
-	// flush conntrack when Destination for a UDP service changes
-	if svc.Protocol == syscall.IPPROTO_UDP {
+	// flush conntrack when Destination for a UDP service changes, services with one-packet scheduling reschedule
+	// every datagram so no flows can be stuck on the destination
+	if svc.Protocol == syscall.IPPROTO_UDP && svc.Flags&ipvsOnePacketFlagHex == 0 {
 		if err := nsc.flushConntrackUDP(svc); err != nil {
 			klog.Errorf("Failed to flush conntrack: %s", err.Error())
 		}
@@ -52,6 +52,12 @@ const (
 	externalIPRouteTableID   = "79"
 	externalIPRouteTableName = "external_ip"
 
+	customDSRRouteTableNumber  = 78
+	externalIPRouteTableNumber = 79
+	dsrFWMarkRulePriority      = 32764
+	externalIPRulePriority     = 32765
+	kubeBridgeIf               = "kube-bridge"
+
 	// Taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/ip_vs.h#L21
 	ipvsPersistentFlagHex = 0x0001
 	ipvsHashedFlagHex     = 0x0002
@@ -77,14 +83,22 @@ const (
 	svcLocalAnnotation              = "kube-router.io/service.local"
 	svcSkipLbIpsAnnotation          = "kube-router.io/service.skiplbips"
 	svcSchedFlagsAnnotation         = "kube-router.io/service.schedflags"
-
-	localIPsIPSetName     = "kube-router-local-ips"
-	ipvsServicesIPSetName = "kube-router-ipvs-services"
-	serviceIPsIPSetName   = "kube-router-service-ips"
-	ipvsFirewallChainName = "KUBE-ROUTER-SERVICES"
-	ipvsHairpinChainName  = "KUBE-ROUTER-HAIRPIN"
-	synctypeAll           = iota
+	svcOnePacketAnnotation          = "kube-router.io/service.one-packet"
+	svcPortRangeAnnotation          = "kube-router.io/service.port-range"
+	svcHealthCheckAnnotation        = "kube-router.io/service.healthcheck"
+	svcHealthCheckPathAnnotation    = "kube-router.io/service.healthcheck.path"
+	svcHealthCheckPortAnnotation    = "kube-router.io/service.healthcheck.port"
+	svcHealthCheckScopeAnnotation   = "kube-router.io/service.healthcheck.scope"
+
+	localIPsIPSetName              = "kube-router-local-ips"
+	ipvsServicesIPSetName          = "kube-router-ipvs-services"
+	ipvsPortRangeServicesIPSetName = "kube-router-ipvs-port-ranges"
+	serviceIPsIPSetName            = "kube-router-service-ips"
+	ipvsFirewallChainName          = "KUBE-ROUTER-SERVICES"
+	ipvsHairpinChainName           = "KUBE-ROUTER-HAIRPIN"
+	synctypeAll                    = iota
 	synctypeIpvs
+	synctypeHostPorts
 
 	tcpProtocol         = "tcp"
 	udpProtocol         = "udp"
@@ -279,10 +293,15 @@ type NetworkServicesController struct {
 
 	EndpointsEventHandler cache.ResourceEventHandler
 	ServiceEventHandler   cache.ResourceEventHandler
+	PodEventHandler       cache.ResourceEventHandler
 
 	gracefulPeriod      time.Duration
 	gracefulQueue       gracefulQueue
 	gracefulTermination bool
+	healthChecker       *endpointHealthChecker
+	hostPortEnabled     bool
+	hostPortMappings    []hostPortMapping
+	socketLB            *socketLB
 	syncChan            chan int
 	dsr                 *dsrOpt
 	dsrTCPMSS           int
@@ -314,6 +333,15 @@ type serviceInfo struct {
 	loadBalancerIPs               []string
 	local                         bool
 	flags                         schedFlags
+	portRange                     *portRange
+	healthCheck                   *healthCheckOpt
+}
+
+// range of ports that is load balanced through a FWMARK based IPVS service
+type portRange struct {
+	start    int
+	end      int
+	protocol string
 }
 
 // IPVS scheduler flags
@@ -321,6 +349,8 @@ type schedFlags struct {
 	flag1 bool /* ipvs scheduler flag-1 */
 	flag2 bool /* ipvs scheduler flag-2 */
 	flag3 bool /* ipvs scheduler flag-3 */
+	// ipvs one-packet scheduling, only applied to UDP services as IPVS ignores it for other protocols
+	onePacket bool
 }
 
 // map of all services, with unique service id(namespace name, service name, port) as key
@@ -407,9 +437,32 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 	}
 	nsc.ProxyFirewallSetup.Broadcast()
 
+	if nsc.socketLB != nil {
+		err = nsc.socketLB.start()
+		if err != nil {
+			klog.Errorf("Failed to set up socket load balancing, all connections to services will be load "+
+				"balanced by IPVS: %s", err.Error())
+			nsc.socketLB = nil
+		} else {
+			// connections are load balanced by IPVS again once the programs are detached
+			defer nsc.socketLB.stop()
+		}
+	}
+
+	if !nsc.hostPortEnabled {
+		// remove hostPort rules left behind by a previous run that had native hostPort support enabled
+		err = deleteHostPortIptablesRules(false)
+		if err != nil {
+			klog.Errorf("Error cleaning up hostport iptables rules: %s", err.Error())
+		}
+	}
+
 	gracefulTicker := time.NewTicker(gracefulTermServiceTickTime)
 	defer gracefulTicker.Stop()
 
+	// active health checks are run in their own goroutine so that slow probes do not hold up syncing
+	go nsc.healthChecker.run(stopCh)
+
 	select {
 	case <-stopCh:
 		klog.Info("Shutting down network services controller")
@@ -462,6 +515,14 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 					klog.Errorf("Error syncing hairpin iptables rules: %s", err.Error())
 				}
 				nsc.mu.Unlock()
+			case synctypeHostPorts:
+				klog.V(1).Info("Performing requested sync of hostports")
+				nsc.mu.Lock()
+				err = nsc.syncHostPortIptablesRules()
+				if err != nil {
+					klog.Errorf("Error syncing hostport iptables rules: %s", err.Error())
+				}
+				nsc.mu.Unlock()
 			}
 			if err == nil {
 				healthcheck.SendHeartBeat(healthChan, "NSC")
@@ -507,6 +568,14 @@ func (nsc *NetworkServicesController) doSync() error {
 		klog.Errorf("Error syncing hairpin iptables rules: %s", err.Error())
 	}
 
+	if nsc.hostPortEnabled {
+		nsc.hostPortMappings = buildHostPortMappings(nsc.podLister.List(), nsc.nodeHostName)
+		err = nsc.syncHostPortIptablesRules()
+		if err != nil {
+			klog.Errorf("Error syncing hostport iptables rules: %s", err.Error())
+		}
+	}
+
 	err = nsc.syncIpvsServices(nsc.serviceMap, nsc.endpointsMap)
 	if err != nil {
 		klog.Errorf("Error syncing IPVS services: %s", err.Error())
@@ -568,6 +637,14 @@ func (nsc *NetworkServicesController) setupIpvsFirewall() error {
 	}
 	nsc.ipsetMap[ipvsServicesIPSetName] = ipset
 
+	// Services load balancing a port range are matched on 'ip,mark' as the port range is only known to the mangle
+	// rule that marks the traffic
+	ipset, err = ipSetHandler.Create(ipvsPortRangeServicesIPSetName, utils.TypeHashIPMark, utils.OptionTimeout, "0")
+	if err != nil {
+		return fmt.Errorf("failed to create ipset: %s", err.Error())
+	}
+	nsc.ipsetMap[ipvsPortRangeServicesIPSetName] = ipset
+
 	// Setup a custom iptables chain to explicitly allow input traffic to
 	// ipvs services only.
 	iptablesCmdHandler, err := iptables.New()
@@ -605,6 +682,21 @@ func (nsc *NetworkServicesController) setupIpvsFirewall() error {
 		}
 	}
 
+	comment = "allow input traffic to ipvs port range services"
+	args = []string{"-m", "comment", "--comment", comment,
+		"-m", "set", "--match-set", ipvsPortRangeServicesIPSetName, "dst",
+		"-j", "ACCEPT"}
+	exists, err = iptablesCmdHandler.Exists("filter", ipvsFirewallChainName, args...)
+	if err != nil {
+		return fmt.Errorf("failed to run iptables command: %s", err.Error())
+	}
+	if !exists {
+		err := iptablesCmdHandler.Insert("filter", ipvsFirewallChainName, 1, args...)
+		if err != nil {
+			return fmt.Errorf("failed to run iptables command: %s", err.Error())
+		}
+	}
+
 	comment = "allow icmp echo requests to service IPs"
 	args = []string{"-m", "comment", "--comment", comment,
 		"-p", "icmp", "--icmp-type", "echo-request",
@@ -659,7 +751,7 @@ func (nsc *NetworkServicesController) setupIpvsFirewall() error {
 	return nil
 }
 
-func (nsc *NetworkServicesController) cleanupIpvsFirewall() {
+func (nsc *NetworkServicesController) cleanupIpvsFirewall(dryRun bool) {
 	// Clear iptables rules
 	iptablesCmdHandler, err := iptables.New()
 	if err != nil {
@@ -671,7 +763,10 @@ func (nsc *NetworkServicesController) cleanupIpvsFirewall() {
 			// Changed to level 1 as errors occur when ipsets have already been cleaned and needlessly worries users
 			klog.V(1).Infof("failed to check if iptables rules exists: %v", err)
 		} else if exists {
-			err = iptablesCmdHandler.Delete("filter", "INPUT", ipvsFirewallInputChainRule...)
+			err = cleanupResource(dryRun, "iptables rule -t filter -A INPUT "+
+				strings.Join(ipvsFirewallInputChainRule, " "), func() error {
+				return iptablesCmdHandler.Delete("filter", "INPUT", ipvsFirewallInputChainRule...)
+			})
 			if err != nil {
 				klog.Errorf("failed to run iptables command: %v", err)
 			}
@@ -681,12 +776,12 @@ func (nsc *NetworkServicesController) cleanupIpvsFirewall() {
 		if err != nil {
 			klog.Errorf("failed to check if chain exists for deletion: %v", err)
 		} else if exists {
-			err = iptablesCmdHandler.ClearChain("filter", ipvsFirewallChainName)
-			if err != nil {
-				klog.Errorf("Failed to run iptables command: %s", err.Error())
-			}
-
-			err = iptablesCmdHandler.DeleteChain("filter", ipvsFirewallChainName)
+			err = cleanupResource(dryRun, "iptables chain -t filter "+ipvsFirewallChainName, func() error {
+				if err := iptablesCmdHandler.ClearChain("filter", ipvsFirewallChainName); err != nil {
+					return err
+				}
+				return iptablesCmdHandler.DeleteChain("filter", ipvsFirewallChainName)
+			})
 			if err != nil {
 				klog.Errorf("Failed to run iptables command: %s", err.Error())
 			}
@@ -695,7 +790,9 @@ func (nsc *NetworkServicesController) cleanupIpvsFirewall() {
 
 	// For some reason, if we go too fast into the ipset logic below it causes the system to think that the above
 	// iptables rules are still referencing the ipsets below, and we get errors
-	time.Sleep(1 * time.Second)
+	if !dryRun {
+		time.Sleep(1 * time.Second)
+	}
 
 	// Clear ipsets
 	// There are certain actions like Cleanup() actions that aren't working with full instantiations of the controller
@@ -721,22 +818,13 @@ func (nsc *NetworkServicesController) cleanupIpvsFirewall() {
 		return
 	}
 
-	if _, ok := ipSetHandler.Sets[localIPsIPSetName]; ok {
-		err = ipSetHandler.Destroy(localIPsIPSetName)
-		if err != nil {
-			klog.Errorf("failed to destroy ipset: %s", err.Error())
-		}
-	}
-
-	if _, ok := ipSetHandler.Sets[serviceIPsIPSetName]; ok {
-		err = ipSetHandler.Destroy(serviceIPsIPSetName)
-		if err != nil {
-			klog.Errorf("failed to destroy ipset: %s", err.Error())
+	for _, name := range []string{localIPsIPSetName, serviceIPsIPSetName, ipvsServicesIPSetName,
+		ipvsPortRangeServicesIPSetName} {
+		if _, ok := ipSetHandler.Sets[name]; !ok {
+			continue
 		}
-	}
-
-	if _, ok := ipSetHandler.Sets[ipvsServicesIPSetName]; ok {
-		err = ipSetHandler.Destroy(ipvsServicesIPSetName)
+		name := name
+		err = cleanupResource(dryRun, "ipset "+name, func() error { return ipSetHandler.Destroy(name) })
 		if err != nil {
 			klog.Errorf("failed to destroy ipset: %s", err.Error())
 		}
@@ -780,10 +868,10 @@ func (nsc *NetworkServicesController) syncIpvsFirewall() error {
 
 	serviceIPsSets := make([]string, 0, len(ipvsServices))
 	ipvsServicesSets := make([]string, 0, len(ipvsServices))
+	ipvsPortRangeServicesSets := make([]string, 0)
 
 	for _, ipvsService := range ipvsServices {
-		var address, protocol string
-		var port int
+		var address, protocol, port string
 		if ipvsService.Address != nil {
 			address = ipvsService.Address.String()
 			protocol = convertSysCallProtoToSvcProto(ipvsService.Protocol)
@@ -792,9 +880,9 @@ func (nsc *NetworkServicesController) syncIpvsFirewall() error {
 					ipvsService.Protocol, ipvsService.Address.String())
 				continue
 			}
-			port = int(ipvsService.Port)
+			port = strconv.Itoa(int(ipvsService.Port))
 		} else if ipvsService.FWMark != 0 {
-			address, protocol, port, err = nsc.lookupServiceByFWMark(ipvsService.FWMark)
+			address, protocol, port, err = nsc.lookupServiceKeyByFWMark(ipvsService.FWMark)
 			if err != nil {
 				klog.Warningf("failed to lookup %d by FWMark: %s - this may not be a kube-router controlled service, "+
 					"but if it is, then something's gone wrong", ipvsService.FWMark, err)
@@ -805,7 +893,14 @@ func (nsc *NetworkServicesController) syncIpvsFirewall() error {
 		serviceIPsSet := address
 		serviceIPsSets = append(serviceIPsSets, serviceIPsSet)
 
-		ipvsServicesSet := fmt.Sprintf("%s,%s:%d", address, protocol, port)
+		// port range services are keyed by their FW mark as ipset can not hold the port range itself
+		if isPortRangeServiceKeyPort(port) {
+			ipvsPortRangeServicesSet := fmt.Sprintf("%s,0x%x", address, ipvsService.FWMark)
+			ipvsPortRangeServicesSets = append(ipvsPortRangeServicesSets, ipvsPortRangeServicesSet)
+			continue
+		}
+
+		ipvsServicesSet := fmt.Sprintf("%s,%s:%s", address, protocol, port)
 		ipvsServicesSets = append(ipvsServicesSets, ipvsServicesSet)
 
 	}
@@ -822,6 +917,12 @@ func (nsc *NetworkServicesController) syncIpvsFirewall() error {
 		return fmt.Errorf("failed to sync ipset: %s", err.Error())
 	}
 
+	ipvsPortRangeServicesIPSet := nsc.ipsetMap[ipvsPortRangeServicesIPSetName]
+	err = ipvsPortRangeServicesIPSet.Refresh(ipvsPortRangeServicesSets)
+	if err != nil {
+		return fmt.Errorf("failed to sync ipset: %s", err.Error())
+	}
+
 	return nil
 }
 
@@ -1098,6 +1199,7 @@ func (ln *linuxNetworking) prepareEndpointForDsrWithCRI(runtimeEndpoint, contain
 }
 
 func (nsc *NetworkServicesController) buildServicesInfo() serviceInfoMap {
+	var err error
 	serviceMap := make(serviceInfoMap)
 	for _, obj := range nsc.svcLister.List() {
 		svc := obj.(*api.Service)
@@ -1153,6 +1255,24 @@ func (nsc *NetworkServicesController) buildServicesInfo() serviceInfoMap {
 				svcInfo.flags = parseSchedFlags(flags)
 			}
 
+			onePacket, ok := svc.ObjectMeta.Annotations[svcOnePacketAnnotation]
+			svcInfo.flags.onePacket = ok && strings.EqualFold(onePacket, "true")
+
+			portRangeSpec, ok := svc.ObjectMeta.Annotations[svcPortRangeAnnotation]
+			if ok {
+				svcInfo.portRange, err = parsePortRange(portRangeSpec)
+				if err != nil {
+					klog.Errorf("Skipping port range for service %s/%s due to invalid %s annotation: %s",
+						svc.Namespace, svc.Name, svcPortRangeAnnotation, err.Error())
+				}
+			}
+
+			svcInfo.healthCheck, err = parseHealthCheck(svc.ObjectMeta.Annotations)
+			if err != nil {
+				klog.Errorf("Skipping active health checking for service %s/%s due to invalid %s annotations: %s",
+					svc.Namespace, svc.Name, svcHealthCheckAnnotation, err.Error())
+			}
+
 			copy(svcInfo.externalIPs, svc.Spec.ExternalIPs)
 			for _, lbIngress := range svc.Status.LoadBalancer.Ingress {
 				if len(lbIngress.IP) > 0 {
@@ -1202,7 +1322,7 @@ func parseSchedFlags(value string) schedFlags {
 		}
 	}
 
-	return schedFlags{flag1, flag2, flag3}
+	return schedFlags{flag1: flag1, flag2: flag2, flag3: flag3}
 }
 
 func shuffle(endPoints []endpointsInfo) []endpointsInfo {
@@ -1377,6 +1497,19 @@ func (nsc *NetworkServicesController) syncHairpinIptablesRules() error {
 					rule, ruleArgs := hairpinRuleFrom(nsc.nodeIP.String(), ep.ip, svcInfo.nodePort)
 					rulesNeeded[rule] = ruleArgs
 				}
+
+				// Handle port range of the Service
+				if svcInfo.portRange != nil {
+					rule, ruleArgs := hairpinPortRangeRuleFrom(svcInfo.clusterIP.String(), ep.ip, svcInfo.portRange)
+					rulesNeeded[rule] = ruleArgs
+
+					if svcInfo.hairpinExternalIPs {
+						for _, extIP := range svcInfo.externalIPs {
+							rule, ruleArgs := hairpinPortRangeRuleFrom(extIP, ep.ip, svcInfo.portRange)
+							rulesNeeded[rule] = ruleArgs
+						}
+					}
+				}
 			}
 		}
 	}
@@ -1384,7 +1517,7 @@ func (nsc *NetworkServicesController) syncHairpinIptablesRules() error {
 	// Cleanup (if needed) and return if there's no hairpin-mode Services
 	if len(rulesNeeded) == 0 {
 		klog.V(1).Info("No hairpin-mode enabled services found -- no hairpin rules created")
-		err := deleteHairpinIptablesRules()
+		err := deleteHairpinIptablesRules(false)
 		if err != nil {
 			return errors.New("Error deleting hairpin rules: " + err.Error())
 		}
@@ -1488,7 +1621,23 @@ func hairpinRuleFrom(serviceIP string, endpointIP string, servicePort int) (stri
 	return ruleString, ruleArgs
 }
 
-func deleteHairpinIptablesRules() error {
+// hairpinPortRangeRuleFrom is the port range equivalent of hairpinRuleFrom. The ipvs match can only match a single
+// virtual port so the port range is matched on the destination port instead, which IPVS leaves untouched for port
+// range services as their destinations use port 0.
+func hairpinPortRangeRuleFrom(serviceIP string, endpointIP string, pr *portRange) (string, []string) {
+	ruleArgs := []string{"-s", endpointIP + "/32", "-d", endpointIP + "/32", "-p", pr.protocol,
+		"-m", "ipvs", "--vaddr", serviceIP, "-m", pr.protocol, "--dport", pr.String(),
+		"-j", "SNAT", "--to-source", serviceIP}
+
+	// Trying to ensure this matches iptables.List()
+	ruleString := "-A " + ipvsHairpinChainName + " -s " + endpointIP + "/32" + " -d " +
+		endpointIP + "/32" + " -p " + pr.protocol + " -m ipvs" + " --vaddr " + serviceIP + " -m " + pr.protocol +
+		" --dport " + pr.String() + " -j SNAT" + " --to-source " + serviceIP
+
+	return ruleString, ruleArgs
+}
+
+func deleteHairpinIptablesRules(dryRun bool) error {
 	iptablesCmdHandler, err := iptables.New()
 	if err != nil {
 		return errors.New("Failed to initialize iptables executor" + err.Error())
@@ -1525,27 +1674,32 @@ func deleteHairpinIptablesRules() error {
 
 	// Delete the jump rule to the hairpin chain
 	if hasHairpinJumpRule {
-		err = iptablesCmdHandler.Delete("nat", "POSTROUTING", jumpArgs...)
+		err = cleanupResource(dryRun, "iptables rule -t nat -A POSTROUTING "+strings.Join(jumpArgs, " "),
+			func() error {
+				return iptablesCmdHandler.Delete("nat", "POSTROUTING", jumpArgs...)
+			})
 		if err != nil {
 			klog.Errorf("unable to delete hairpin jump rule from chain \"POSTROUTING\": %v", err)
-		} else {
+		} else if !dryRun {
 			klog.V(1).Info("Deleted hairpin jump rule from chain \"POSTROUTING\"")
 		}
 	}
 
 	// Flush and delete the chain for hairpin rules
-	err = iptablesCmdHandler.ClearChain("nat", ipvsHairpinChainName)
-	if err != nil {
-		return fmt.Errorf("failed to flush iptables chain \"%s\": %v", ipvsHairpinChainName, err)
-	}
-	err = iptablesCmdHandler.DeleteChain("nat", ipvsHairpinChainName)
-	if err != nil {
-		return fmt.Errorf("failed to delete iptables chain \"%s\": %v", ipvsHairpinChainName, err)
-	}
-	return nil
+	return cleanupResource(dryRun, "iptables chain -t nat "+ipvsHairpinChainName, func() error {
+		err := iptablesCmdHandler.ClearChain("nat", ipvsHairpinChainName)
+		if err != nil {
+			return fmt.Errorf("failed to flush iptables chain \"%s\": %v", ipvsHairpinChainName, err)
+		}
+		err = iptablesCmdHandler.DeleteChain("nat", ipvsHairpinChainName)
+		if err != nil {
+			return fmt.Errorf("failed to delete iptables chain \"%s\": %v", ipvsHairpinChainName, err)
+		}
+		return nil
+	})
 }
 
-func deleteMasqueradeIptablesRule() error {
+func deleteMasqueradeIptablesRule(dryRun bool) error {
 	iptablesCmdHandler, err := iptables.New()
 	if err != nil {
 		return errors.New("Failed to initialize iptables executor" + err.Error())
@@ -1556,11 +1710,16 @@ func deleteMasqueradeIptablesRule() error {
 	}
 	for i, rule := range postRoutingChainRules {
 		if strings.Contains(rule, "ipvs") && strings.Contains(rule, "SNAT") {
-			err = iptablesCmdHandler.Delete("nat", "POSTROUTING", strconv.Itoa(i))
+			i := i
+			err = cleanupResource(dryRun, "iptables rule -t nat "+rule, func() error {
+				return iptablesCmdHandler.Delete("nat", "POSTROUTING", strconv.Itoa(i))
+			})
 			if err != nil {
 				return errors.New("Failed to run iptables command" + err.Error())
 			}
-			klog.V(2).Infof("Deleted iptables masquerade rule: %s", rule)
+			if !dryRun {
+				klog.V(2).Infof("Deleted iptables masquerade rule: %s", rule)
+			}
 			break
 		}
 	}
@@ -1621,7 +1780,7 @@ func ipvsSetPersistence(svc *ipvs.Service, p bool, timeout int32) {
 	}
 }
 
-func ipvsSetSchedFlags(svc *ipvs.Service, s schedFlags) {
+func ipvsSetSchedFlags(svc *ipvs.Service, protocol uint16, s schedFlags) {
 	if s.flag1 {
 		svc.Flags |= ipvsSched1FlagHex
 	} else {
@@ -1640,6 +1799,12 @@ func ipvsSetSchedFlags(svc *ipvs.Service, s schedFlags) {
 		svc.Flags &^= ipvsSched3FlagHex
 	}
 
+	if hasOnePacketFlag(protocol, s) {
+		svc.Flags |= ipvsOnePacketFlagHex
+	} else {
+		svc.Flags &^= ipvsOnePacketFlagHex
+	}
+
 	/* Keep netmask which is set by ipvsSetPersistence() before */
 	if (svc.Netmask&0xFFFFFFFF != 0) || (s.flag1 || s.flag2 || s.flag3) {
 		svc.Netmask |= 0xFFFFFFFF
@@ -1649,7 +1814,7 @@ func ipvsSetSchedFlags(svc *ipvs.Service, s schedFlags) {
 }
 
 /* Compare service scheduler flags with ipvs service */
-func changedIpvsSchedFlags(svc *ipvs.Service, s schedFlags) bool {
+func changedIpvsSchedFlags(svc *ipvs.Service, protocol uint16, s schedFlags) bool {
 	if (s.flag1 && (svc.Flags&ipvsSched1FlagHex) == 0) || (!s.flag1 && (svc.Flags&ipvsSched1FlagHex) != 0) {
 		return true
 	}
@@ -1662,9 +1827,20 @@ func changedIpvsSchedFlags(svc *ipvs.Service, s schedFlags) bool {
 		return true
 	}
 
+	if hasOnePacketFlag(protocol, s) != (svc.Flags&ipvsOnePacketFlagHex != 0) {
+		return true
+	}
+
 	return false
 }
 
+// hasOnePacketFlag returns true if one-packet scheduling is requested for the service and the service load balances
+// UDP, the flag is never set on other services so that their flags do not differ on every sync. The protocol is the
+// one of the kubernetes service as FWMARK ipvs services are listed without any protocol.
+func hasOnePacketFlag(protocol uint16, s schedFlags) bool {
+	return s.onePacket && protocol == syscall.IPPROTO_UDP
+}
+
 func (ln *linuxNetworking) ipvsAddService(svcs []*ipvs.Service, vip net.IP, protocol, port uint16,
 	persistent bool, persistentTimeout int32, scheduler string, flags schedFlags) (*ipvs.Service, error) {
 
@@ -1684,8 +1860,8 @@ func (ln *linuxNetworking) ipvsAddService(svcs []*ipvs.Service, vip net.IP, prot
 					ipvsServiceString(svc))
 			}
 
-			if changedIpvsSchedFlags(svc, flags) {
-				ipvsSetSchedFlags(svc, flags)
+			if changedIpvsSchedFlags(svc, protocol, flags) {
+				ipvsSetSchedFlags(svc, protocol, flags)
 
 				err = ln.ipvsUpdateService(svc)
 				if err != nil {
@@ -1717,7 +1893,7 @@ func (ln *linuxNetworking) ipvsAddService(svcs []*ipvs.Service, vip net.IP, prot
 	}
 
 	ipvsSetPersistence(&svc, persistent, persistentTimeout)
-	ipvsSetSchedFlags(&svc, flags)
+	ipvsSetSchedFlags(&svc, protocol, flags)
 
 	err = ln.ipvsNewService(&svc)
 	if err != nil {
@@ -1736,8 +1912,8 @@ func (ln *linuxNetworking) ipvsAddFWMarkService(svcs []*ipvs.Service, fwMark uin
 				(!persistent && (svc.Flags&ipvsPersistentFlagHex) != 0) {
 				ipvsSetPersistence(svc, persistent, persistentTimeout)
 
-				if changedIpvsSchedFlags(svc, flags) {
-					ipvsSetSchedFlags(svc, flags)
+				if changedIpvsSchedFlags(svc, protocol, flags) {
+					ipvsSetSchedFlags(svc, protocol, flags)
 				}
 
 				err := ln.ipvsUpdateService(svc)
@@ -1748,8 +1924,8 @@ func (ln *linuxNetworking) ipvsAddFWMarkService(svcs []*ipvs.Service, fwMark uin
 					ipvsServiceString(svc))
 			}
 
-			if changedIpvsSchedFlags(svc, flags) {
-				ipvsSetSchedFlags(svc, flags)
+			if changedIpvsSchedFlags(svc, protocol, flags) {
+				ipvsSetSchedFlags(svc, protocol, flags)
 
 				err := ln.ipvsUpdateService(svc)
 				if err != nil {
@@ -1781,7 +1957,7 @@ func (ln *linuxNetworking) ipvsAddFWMarkService(svcs []*ipvs.Service, fwMark uin
 	}
 
 	ipvsSetPersistence(&svc, persistent, persistentTimeout)
-	ipvsSetSchedFlags(&svc, flags)
+	ipvsSetSchedFlags(&svc, protocol, flags)
 
 	err := ln.ipvsNewService(&svc)
 	if err != nil {
@@ -1814,20 +1990,16 @@ func (ln *linuxNetworking) ipvsAddServer(service *ipvs.Service, dest *ipvs.Desti
 	return nil
 }
 
-// setupMangleTableRule: sets up iptables rule to FWMARK the traffic to external IP vip
+// setupMangleTableRule: sets up iptables rule to FWMARK the traffic to external IP vip, along with the TCPMSS rules
+// needed by DSR mode
 func setupMangleTableRule(ip string, protocol string, port string, fwmark string, tcpMSS int) error {
-	iptablesCmdHandler, err := iptables.New()
-	if err != nil {
-		return errors.New("Failed to initialize iptables executor" + err.Error())
-	}
-	args := []string{"-d", ip, "-m", protocol, "-p", protocol, "--dport", port, "-j", "MARK", "--set-mark", fwmark}
-	err = iptablesCmdHandler.AppendUnique("mangle", "PREROUTING", args...)
+	err := setupMangleTableMarkRule(ip, protocol, port, fwmark)
 	if err != nil {
-		return errors.New("Failed to run iptables command to set up FWMARK due to " + err.Error())
+		return err
 	}
-	err = iptablesCmdHandler.AppendUnique("mangle", "OUTPUT", args...)
+	iptablesCmdHandler, err := iptables.New()
 	if err != nil {
-		return errors.New("Failed to run iptables command to set up FWMARK due to " + err.Error())
+		return errors.New("Failed to initialize iptables executor" + err.Error())
 	}
 
 	// setup iptables rule TCPMSS for DSR mode to fix mtu problem
@@ -1845,6 +2017,25 @@ func setupMangleTableRule(ip string, protocol string, port string, fwmark string
 	return nil
 }
 
+// setupMangleTableMarkRule: sets up iptables rule to FWMARK the traffic to the given ip and port, without the TCPMSS
+// rules of DSR mode
+func setupMangleTableMarkRule(ip string, protocol string, port string, fwmark string) error {
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return errors.New("Failed to initialize iptables executor" + err.Error())
+	}
+	args := []string{"-d", ip, "-m", protocol, "-p", protocol, "--dport", port, "-j", "MARK", "--set-mark", fwmark}
+	err = iptablesCmdHandler.AppendUnique("mangle", "PREROUTING", args...)
+	if err != nil {
+		return errors.New("Failed to run iptables command to set up FWMARK due to " + err.Error())
+	}
+	err = iptablesCmdHandler.AppendUnique("mangle", "OUTPUT", args...)
+	if err != nil {
+		return errors.New("Failed to run iptables command to set up FWMARK due to " + err.Error())
+	}
+	return nil
+}
+
 func (ln *linuxNetworking) cleanupMangleTableRule(ip string, protocol string, port string,
 	fwmark string, tcpMSS int) error {
 	iptablesCmdHandler, err := iptables.New()
@@ -1908,19 +2099,26 @@ func (ln *linuxNetworking) cleanupMangleTableRule(ip string, protocol string, po
 // For DSR it is required that we dont assign the VIP to any interface to avoid martian packets
 // http://www.austintek.com/LVS/LVS-HOWTO/HOWTO/LVS-HOWTO.routing_to_VIP-less_director.html
 // routeVIPTrafficToDirector: setups policy routing so that FWMARKed packets are delivered locally
-func routeVIPTrafficToDirector(fwmark string) error {
-	out, err := exec.Command("ip", "rule", "list").Output()
+func routeVIPTrafficToDirector(fwmark uint32) error {
+	rules, err := netlink.RuleList(netlink.FAMILY_V4)
 	if err != nil {
 		return errors.New("Failed to verify if `ip rule` exists due to: " + err.Error())
 	}
-	if !strings.Contains(string(out), fwmark+" ") {
-		err = exec.Command("ip", "rule", "add", "prio", "32764", "fwmark", fwmark, "table",
-			customDSRRouteTableID).Run()
-		if err != nil {
-			return errors.New("Failed to add policy rule to lookup traffic to VIP through the custom " +
-				" routing table due to " + err.Error())
+	for _, rule := range rules {
+		if rule.Mark == int(fwmark) && rule.Table == customDSRRouteTableNumber {
+			return nil
 		}
 	}
+
+	rule := netlink.NewRule()
+	rule.Family = netlink.FAMILY_V4
+	rule.Priority = dsrFWMarkRulePriority
+	rule.Mark = int(fwmark)
+	rule.Table = customDSRRouteTableNumber
+	if err = netlink.RuleAdd(rule); err != nil {
+		return errors.New("Failed to add policy rule to lookup traffic to VIP through the custom " +
+			" routing table due to " + err.Error())
+	}
 	return nil
 }
 
@@ -1943,12 +2141,21 @@ func (ln *linuxNetworking) setupPolicyRoutingForDSR() error {
 			return errors.New("Failed to setup policy routing required for DSR due to " + err.Error())
 		}
 	}
-	out, err := exec.Command("ip", "route", "list", "table", customDSRRouteTableID).Output()
-	if err != nil || !strings.Contains(string(out), " lo ") {
-		if err = exec.Command("ip", "route", "add", "local", "default", "dev", "lo", "table",
-			customDSRRouteTableID).Run(); err != nil {
-			return errors.New("Failed to add route in custom route table due to: " + err.Error())
-		}
+
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		return errors.New("Failed to get the loopback interface due to: " + err.Error())
+	}
+	// replacing the route is idempotent, it is the equivalent of `ip route replace local default dev lo table 78`
+	err = netlink.RouteReplace(&netlink.Route{
+		LinkIndex: lo.Attrs().Index,
+		Dst:       &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, net.IPv4len*8)},
+		Type:      syscall.RTN_LOCAL,
+		Scope:     netlink.SCOPE_HOST,
+		Table:     customDSRRouteTableNumber,
+	})
+	if err != nil {
+		return errors.New("Failed to add route in custom route table due to: " + err.Error())
 	}
 	return nil
 }
@@ -1975,17 +2182,24 @@ func (ln *linuxNetworking) setupRoutesForExternalIPForDSR(serviceInfoMap service
 		}
 	}
 
-	out, err := exec.Command("ip", "rule", "list").Output()
+	rules, err := netlink.RuleList(netlink.FAMILY_V4)
 	if err != nil {
 		return fmt.Errorf("failed to verify if `ip rule add prio 32765 from all lookup external_ip` exists due to: %v",
 			err)
 	}
-
-	if !(strings.Contains(string(out), externalIPRouteTableName) ||
-		strings.Contains(string(out), externalIPRouteTableID)) {
-		err = exec.Command("ip", "rule", "add", "prio", "32765", "from", "all", "lookup",
-			externalIPRouteTableID).Run()
-		if err != nil {
+	hasRule := false
+	for _, rule := range rules {
+		if rule.Table == externalIPRouteTableNumber {
+			hasRule = true
+			break
+		}
+	}
+	if !hasRule {
+		rule := netlink.NewRule()
+		rule.Family = netlink.FAMILY_V4
+		rule.Priority = externalIPRulePriority
+		rule.Table = externalIPRouteTableNumber
+		if err = netlink.RuleAdd(rule); err != nil {
 			klog.Infof("Failed to add policy rule `ip rule add prio 32765 from all lookup external_ip` due to %v",
 				err.Error())
 			return fmt.Errorf("failed to add policy rule `ip rule add prio 32765 from all lookup external_ip` "+
@@ -1993,8 +2207,22 @@ func (ln *linuxNetworking) setupRoutesForExternalIPForDSR(serviceInfoMap service
 		}
 	}
 
-	out, _ = exec.Command("ip", "route", "list", "table", externalIPRouteTableID).Output()
-	outStr := string(out)
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: externalIPRouteTableNumber},
+		netlink.RT_FILTER_TABLE)
+	if err != nil {
+		klog.Errorf("Failed to list the routes in custom route table for external IP's due to: %v", err)
+	}
+	existingRoutes := make(map[string]bool)
+	for _, route := range routes {
+		if route.Dst != nil {
+			existingRoutes[route.Dst.IP.String()] = true
+		}
+	}
+
+	bridge, err := netlink.LinkByName(kubeBridgeIf)
+	if err != nil {
+		klog.Errorf("Failed to get the %s interface to route external IP's through due to: %v", kubeBridgeIf, err)
+	}
 	activeExternalIPs := make(map[string]bool)
 	for _, svc := range serviceInfoMap {
 		for _, externalIP := range svc.externalIPs {
@@ -2005,11 +2233,20 @@ func (ln *linuxNetworking) setupRoutesForExternalIPForDSR(serviceInfoMap service
 				continue
 			}
 
-			activeExternalIPs[externalIP] = true
-
-			if !strings.Contains(outStr, externalIP) {
-				if err = exec.Command("ip", "route", "add", externalIP, "dev", "kube-bridge", "table",
-					externalIPRouteTableID).Run(); err != nil {
+			ip := net.ParseIP(externalIP)
+			if ip == nil {
+				klog.Errorf("Failed to parse external IP %s of service %s/%s", externalIP, svc.namespace, svc.name)
+				continue
+			}
+			activeExternalIPs[ip.String()] = true
+
+			if !existingRoutes[ip.String()] && bridge != nil {
+				if err = netlink.RouteReplace(&netlink.Route{
+					LinkIndex: bridge.Attrs().Index,
+					Dst:       getHostIPNet(ip),
+					Scope:     netlink.SCOPE_LINK,
+					Table:     externalIPRouteTableNumber,
+				}); err != nil {
 					klog.Errorf("Failed to add route for %s in custom route table for external IP's due to: %v",
 						externalIP, err)
 					continue
@@ -2018,27 +2255,29 @@ func (ln *linuxNetworking) setupRoutesForExternalIPForDSR(serviceInfoMap service
 		}
 	}
 
-	// check if there are any pbr in externalIPRouteTableID for external IP's
-	if len(outStr) > 0 {
-		// clean up stale external IPs
-		for _, line := range strings.Split(strings.Trim(outStr, "\n"), "\n") {
-			route := strings.Split(strings.Trim(line, " "), " ")
-			ip := route[0]
-			if !activeExternalIPs[ip] {
-				args := []string{"route", "del", "table", externalIPRouteTableID}
-				args = append(args, route...)
-				if err = exec.Command("ip", args...).Run(); err != nil {
-					klog.Errorf("Failed to del route for %v in custom route table for external IP's due to: %s",
-						ip, err)
-					continue
-				}
-			}
+	// clean up stale external IPs
+	for i, route := range routes {
+		if route.Dst == nil || activeExternalIPs[route.Dst.IP.String()] {
+			continue
+		}
+		if err = netlink.RouteDel(&routes[i]); err != nil {
+			klog.Errorf("Failed to del route for %v in custom route table for external IP's due to: %s",
+				route.Dst.IP, err)
+			continue
 		}
 	}
 
 	return nil
 }
 
+// getHostIPNet returns the host prefix of the IP
+func getHostIPNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(net.IPv4len*8, net.IPv4len*8)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(net.IPv6len*8, net.IPv6len*8)}
+}
+
 func isEndpointsForLeaderElection(ep *api.Endpoints) bool {
 	_, isLeaderElection := ep.Annotations[resourcelock.LeaderElectionRecordAnnotationKey]
 	return isLeaderElection
@@ -2110,8 +2349,17 @@ func (ln *linuxNetworking) getKubeDummyInterface() (netlink.Link, error) {
 	return dummyVipInterface, nil
 }
 
-// Cleanup cleans all the configurations (IPVS, iptables, links) done
-func (nsc *NetworkServicesController) Cleanup() {
+// cleanupResource removes a resource set up by the service proxy, or only logs it in dry run mode
+func cleanupResource(dryRun bool, resource string, remove func() error) error {
+	if dryRun {
+		klog.Infof("Dry run: would remove %s", resource)
+		return nil
+	}
+	return remove()
+}
+
+// Cleanup cleans all the configurations (IPVS, iptables, links) done, in dry run mode they are only logged
+func (nsc *NetworkServicesController) Cleanup(dryRun bool) {
 	klog.Infof("Cleaning up NetworkServiceController configurations...")
 
 	// cleanup ipvs rules by flush
@@ -2121,28 +2369,45 @@ func (nsc *NetworkServicesController) Cleanup() {
 	} else {
 		klog.Infof("ipvs definitions don't have names associated with them for checking, during cleanup " +
 			"we assume that we own all of them and delete all ipvs definitions")
-		err = handle.Flush()
-		if err != nil {
-			klog.Errorf("unable to flush ipvs tables: %v", err)
+		if dryRun {
+			svcs, err := handle.GetServices()
+			if err != nil {
+				klog.Errorf("unable to list ipvs services: %v", err)
+			}
+			for _, svc := range svcs {
+				klog.Infof("Dry run: would remove ipvs service %s", ipvsServiceString(svc))
+			}
+		} else {
+			err = handle.Flush()
+			if err != nil {
+				klog.Errorf("unable to flush ipvs tables: %v", err)
+			}
 		}
 		handle.Close()
 	}
 
 	// cleanup iptables masquerade rule
-	err = deleteMasqueradeIptablesRule()
+	err = deleteMasqueradeIptablesRule(dryRun)
 	if err != nil {
 		klog.Errorf("Failed to cleanup iptablesmasquerade rule due to: %s", err.Error())
 		return
 	}
 
 	// cleanup iptables hairpin rules
-	err = deleteHairpinIptablesRules()
+	err = deleteHairpinIptablesRules(dryRun)
 	if err != nil {
 		klog.Errorf("Failed to cleanup iptables hairpin rules: %s", err.Error())
 		return
 	}
 
-	nsc.cleanupIpvsFirewall()
+	// cleanup iptables hostport rules
+	err = deleteHostPortIptablesRules(dryRun)
+	if err != nil {
+		klog.Errorf("Failed to cleanup iptables hostport rules: %s", err.Error())
+		return
+	}
+
+	nsc.cleanupIpvsFirewall(dryRun)
 
 	// delete dummy interface used to assign cluster IP's
 	dummyVipInterface, err := netlink.LinkByName(KubeDummyIf)
@@ -2151,13 +2416,19 @@ func (nsc *NetworkServicesController) Cleanup() {
 			klog.Infof("Dummy interface: " + KubeDummyIf + " does not exist")
 		}
 	} else {
-		err = netlink.LinkDel(dummyVipInterface)
+		err = cleanupResource(dryRun, "interface "+KubeDummyIf, func() error {
+			return netlink.LinkDel(dummyVipInterface)
+		})
 		if err != nil {
 			klog.Errorf("Could not delete dummy interface " + KubeDummyIf + " due to " + err.Error())
 			return
 		}
 	}
 
+	if dryRun {
+		klog.Infof("Dry run: listed the NetworkServiceController configuration done by kube-router")
+		return
+	}
 	klog.Infof("Successfully cleaned the NetworkServiceController configuration done by kube-router")
 }
 
@@ -2297,6 +2568,8 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 		prometheus.MustRegister(metrics.ServicePpsIn)
 		prometheus.MustRegister(metrics.ServicePpsOut)
 		prometheus.MustRegister(metrics.ServiceTotalConn)
+		prometheus.MustRegister(metrics.ServiceEndpointHealthy)
+		prometheus.MustRegister(metrics.ServiceEndpointHealthCheckFailures)
 		nsc.MetricsEnabled = true
 	}
 
@@ -2305,6 +2578,12 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	nsc.gracefulPeriod = config.IpvsGracefulPeriod
 	nsc.gracefulTermination = config.IpvsGracefulTermination
 	nsc.globalHairpin = config.GlobalHairpinMode
+	if config.IpvsHealthCheckInterval <= 0 || config.IpvsHealthCheckTimeout <= 0 {
+		return nil, errors.New("IPVS health check interval and timeout must be greater than 0")
+	}
+	nsc.healthChecker = newEndpointHealthChecker(config.IpvsHealthCheckInterval, config.IpvsHealthCheckTimeout,
+		nsc.onEndpointHealthChange)
+	nsc.healthChecker.metricsEnabled = nsc.MetricsEnabled
 
 	nsc.serviceMap = make(serviceInfoMap)
 	nsc.endpointsMap = make(endpointsInfoMap)
@@ -2361,6 +2640,17 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	nsc.dsrTCPMSS = automtu - utils.IPInIPHeaderLength*3
 
 	nsc.podLister = podInformer.GetIndexer()
+	nsc.hostPortEnabled = config.EnableHostPort
+
+	switch config.ServiceProxyDataplane {
+	case dataplaneIPVS:
+	case dataplaneEBPF:
+		nsc.socketLB = newSocketLB(config.EBPFCgroupPath)
+	default:
+		return nil, fmt.Errorf("unknown service proxy dataplane %q, must be one of: %s, %s",
+			config.ServiceProxyDataplane, dataplaneIPVS, dataplaneEBPF)
+	}
+	nsc.PodEventHandler = nsc.newPodEventHandler()
 
 	nsc.svcLister = svcInformer.GetIndexer()
 	nsc.ServiceEventHandler = nsc.newSvcEventHandler()
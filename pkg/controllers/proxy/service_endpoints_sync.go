@@ -38,6 +38,10 @@ func (nsc *NetworkServicesController) syncIpvsServices(serviceInfoMap serviceInf
 	// cluster IP, nodeport and external IP services
 	activeServiceEndpointMap := make(map[string][]string)
 
+	if nsc.healthChecker != nil {
+		nsc.healthChecker.setTargets(buildHealthCheckTargets(serviceInfoMap, endpointsInfoMap))
+	}
+
 	err = nsc.setupClusterIPServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap)
 	if err != nil {
 		syncErrors = true
@@ -54,6 +58,11 @@ func (nsc *NetworkServicesController) syncIpvsServices(serviceInfoMap serviceInf
 		klog.Errorf("Error setting up IPVS services for service external IP's and load balancer IP's: %s",
 			err.Error())
 	}
+	err = nsc.setupPortRangeServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap)
+	if err != nil {
+		syncErrors = true
+		klog.Errorf("Error setting up IPVS services for service port ranges: %s", err.Error())
+	}
 	err = nsc.cleanupStaleVIPs(activeServiceEndpointMap)
 	if err != nil {
 		syncErrors = true
@@ -67,6 +76,14 @@ func (nsc *NetworkServicesController) syncIpvsServices(serviceInfoMap serviceInf
 
 	nsc.cleanupStaleMetrics(activeServiceEndpointMap)
 
+	if nsc.socketLB != nil {
+		err = nsc.socketLB.sync(nsc.buildSocketLBFrontends(serviceInfoMap, endpointsInfoMap))
+		if err != nil {
+			syncErrors = true
+			klog.Errorf("Error syncing socket load balancing BPF maps: %s", err.Error())
+		}
+	}
+
 	err = nsc.syncIpvsFirewall()
 	if err != nil {
 		syncErrors = true
@@ -125,7 +142,7 @@ func (nsc *NetworkServicesController) setupClusterIPServices(serviceInfoMap serv
 				Address:       net.ParseIP(endpoint.ip),
 				AddressFamily: syscall.AF_INET,
 				Port:          uint16(endpoint.port),
-				Weight:        1,
+				Weight:        nsc.endpointWeight(svc, endpoint),
 			}
 			// Conditions on which to add an endpoint on this node:
 			// 1) Service is not a local service
@@ -221,7 +238,7 @@ func (nsc *NetworkServicesController) setupNodePortServices(serviceInfoMap servi
 				Address:       net.ParseIP(endpoint.ip),
 				AddressFamily: syscall.AF_INET,
 				Port:          uint16(endpoint.port),
-				Weight:        1,
+				Weight:        nsc.endpointWeight(svc, endpoint),
 			}
 			for i := 0; i < len(ipvsNodeportSvcs); i++ {
 				if !svc.local || (svc.local && endpoint.isLocal) {
@@ -297,6 +314,119 @@ func (nsc *NetworkServicesController) setupExternalIPServices(serviceInfoMap ser
 	return nil
 }
 
+// setupPortRangeServices sets up a FWMARK based IPVS service for every VIP of a service that has a port range
+// configured via the kube-router.io/service.port-range annotation. Traffic to the VIP within the port range gets marked
+// in the mangle table and load balanced to destinations with port 0 so that IPVS keeps the original destination port.
+func (nsc *NetworkServicesController) setupPortRangeServices(serviceInfoMap serviceInfoMap,
+	endpointsInfoMap endpointsInfoMap, activeServiceEndpointMap map[string][]string) error {
+	for k, svc := range serviceInfoMap {
+		if svc.portRange == nil {
+			continue
+		}
+		endpoints := endpointsInfoMap[k]
+
+		vips := []string{svc.clusterIP.String()}
+		switch {
+		case svc.directServerReturn:
+			klog.Warningf("Port range is not supported for external IP's of DSR service %s/%s, only setting up "+
+				"the cluster IP", svc.namespace, svc.name)
+		case svc.local && !hasActiveEndpoints(endpoints):
+			klog.V(1).Infof("Skipping setting up port range for external IP's of the service %s/%s as it does not "+
+				"have active endpoints", svc.namespace, svc.name)
+		default:
+			extIPSet := sets.NewString(svc.externalIPs...)
+			if !svc.skipLbIps {
+				extIPSet = extIPSet.Union(sets.NewString(svc.loadBalancerIPs...))
+			}
+			vips = append(vips, extIPSet.List()...)
+		}
+
+		for _, vip := range vips {
+			fwMark, err := nsc.setupPortRangeForService(svc, vip, endpoints)
+			if err != nil {
+				klog.Errorf("Failed to setup port range %s/%s on %s for service %s/%s: %v", svc.portRange,
+					svc.portRange.protocol, vip, svc.namespace, svc.name, err)
+				continue
+			}
+
+			// every port of a service carries the same port range and so resolves to the same FW mark, only the
+			// endpoints of the ports are added up
+			portRangeServiceID := fmt.Sprint(fwMark)
+			if _, ok := activeServiceEndpointMap[portRangeServiceID]; !ok {
+				activeServiceEndpointMap[portRangeServiceID] = make([]string, 0)
+			}
+			for _, endpoint := range endpoints {
+				if svc.local && hasActiveEndpoints(endpoints) && !endpoint.isLocal {
+					continue
+				}
+				activeServiceEndpointMap[portRangeServiceID] = append(activeServiceEndpointMap[portRangeServiceID],
+					generateEndpointID(endpoint.ip, "0"))
+			}
+		}
+	}
+
+	return nil
+}
+
+// setupPortRangeForService creates the FWMARK based IPVS service for the port range of the service on the given VIP,
+// adds the mangle table rule that marks the traffic and adds the endpoints as destinations. It returns the FW mark
+// that was used for the IPVS service.
+func (nsc *NetworkServicesController) setupPortRangeForService(svc *serviceInfo, vip string,
+	endpoints []endpointsInfo) (uint32, error) {
+	protocol := convertSvcProtoToSysCallProto(svc.portRange.protocol)
+	dummyVipInterface, err := nsc.ln.getKubeDummyInterface()
+	if err != nil {
+		return 0, fmt.Errorf("failed creating dummy interface: %v", err)
+	}
+	ipvsSvcs, err := nsc.ln.ipvsGetServices()
+	if err != nil {
+		return 0, fmt.Errorf("failed get list of IPVS services due to: %v", err)
+	}
+
+	// ensure the VIP is assigned so that marked traffic is delivered locally to IPVS
+	err = nsc.ln.ipAddrAdd(dummyVipInterface, vip, true)
+	if err != nil && err.Error() != IfaceHasAddr {
+		return 0, fmt.Errorf("failed to assign ip %s to dummy interface %s due to %v", vip, KubeDummyIf, err)
+	}
+
+	fwMark, err := nsc.generateUniqueFWMark(vip, svc.portRange.protocol, svc.portRange.String())
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate FW mark: %v", err)
+	}
+	ipvsPortRangeSvc, err := nsc.ln.ipvsAddFWMarkService(ipvsSvcs, fwMark, protocol, 0,
+		svc.sessionAffinity, svc.sessionAffinityTimeoutSeconds, svc.scheduler, svc.flags)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create IPVS service for port range: %v", err)
+	}
+
+	// ensure there is iptables mangle table rule to FWMARK the packet, the traffic is not DSR so the TCPMSS rules are
+	// left out
+	err = setupMangleTableMarkRule(vip, svc.portRange.protocol, svc.portRange.String(), fmt.Sprint(fwMark))
+	if err != nil {
+		return 0, fmt.Errorf("failed to setup mangle table rule to mark the traffic to the port range: %v", err)
+	}
+
+	for _, endpoint := range endpoints {
+		// same conditions as for the cluster IP service, see setupClusterIPServices
+		if svc.local && hasActiveEndpoints(endpoints) && !endpoint.isLocal {
+			continue
+		}
+
+		// port 0 makes IPVS forward the traffic to the destination port the client used
+		dst := ipvs.Destination{
+			Address:       net.ParseIP(endpoint.ip),
+			AddressFamily: syscall.AF_INET,
+			Port:          0,
+			Weight:        nsc.endpointWeight(svc, endpoint),
+		}
+		if err = nsc.ln.ipvsAddServer(ipvsPortRangeSvc, &dst); err != nil {
+			return 0, fmt.Errorf("unable to add destination %s to port range service: %v", endpoint.ip, err)
+		}
+	}
+
+	return fwMark, nil
+}
+
 // setupExternalIPForService does the basic work to setup a non-DSR based external IP for service. This includes adding
 // the IPVS service to the host if it is missing, and setting up the dummy interface to be able to receive traffic on
 // the node.
@@ -353,7 +483,7 @@ func (nsc *NetworkServicesController) setupExternalIPForService(svc *serviceInfo
 			Address:       net.ParseIP(endpoint.ip),
 			AddressFamily: syscall.AF_INET,
 			Port:          uint16(endpoint.port),
-			Weight:        1,
+			Weight:        nsc.endpointWeight(svc, endpoint),
 		}
 
 		if err = nsc.ln.ipvsAddServer(ipvsExternalIPSvc, &dst); err != nil {
@@ -413,7 +543,7 @@ func (nsc *NetworkServicesController) setupExternalIPForDSRService(svc *serviceI
 	}
 
 	// do policy routing to deliver the packet locally so that IPVS can pick the packet
-	err = routeVIPTrafficToDirector("0x" + fmt.Sprintf("%x", fwMark))
+	err = routeVIPTrafficToDirector(fwMark)
 	if err != nil {
 		return fmt.Errorf("failed to setup ip rule to lookup traffic to external IP: %s through custom "+
 			"route table due to %v", externalIP, err)
@@ -432,7 +562,7 @@ func (nsc *NetworkServicesController) setupExternalIPForDSRService(svc *serviceI
 			AddressFamily:   syscall.AF_INET,
 			ConnectionFlags: ipvs.ConnectionFlagTunnel,
 			Port:            uint16(endpoint.port),
-			Weight:          1,
+			Weight:          nsc.endpointWeight(svc, endpoint),
 		}
 
 		// add the destination for the IPVS service for this external IP
@@ -564,7 +694,7 @@ func (nsc *NetworkServicesController) cleanupStaleIPVSConfig(activeServiceEndpoi
 			klog.V(1).Infof("Found a IPVS service %s which is no longer needed so cleaning up",
 				ipvsServiceString(ipvsSvc))
 			if ipvsSvc.FWMark != 0 {
-				_, _, _, err = nsc.lookupServiceByFWMark(ipvsSvc.FWMark)
+				_, _, _, err = nsc.lookupServiceKeyByFWMark(ipvsSvc.FWMark)
 				if err != nil {
 					klog.V(1).Infof("no FW mark found for service, nothing to cleanup: %v", err)
 				} else if err = nsc.cleanupDSRService(ipvsSvc.FWMark); err != nil {
@@ -606,16 +736,17 @@ func (nsc *NetworkServicesController) cleanupStaleIPVSConfig(activeServiceEndpoi
 }
 
 // cleanupDSRService takes an FW mark was its only input and uses that to lookup the service and then remove DSR
-// specific pieces of that service that may be left-over from the service provisioning.
+// specific pieces of that service that may be left-over from the service provisioning. As port range services are
+// FW mark based as well, it also removes the mangle rules of those.
 func (nsc *NetworkServicesController) cleanupDSRService(fwMark uint32) error {
-	ipAddress, proto, port, err := nsc.lookupServiceByFWMark(fwMark)
+	ipAddress, proto, port, err := nsc.lookupServiceKeyByFWMark(fwMark)
 	if err != nil {
 		return fmt.Errorf("no service was found for FW mark: %d, service may not be all the way cleaned up: %v",
 			fwMark, err)
 	}
 
 	// cleanup mangle rules
-	klog.V(2).Infof("service %s:%s:%d was found, continuing with DSR service cleanup", ipAddress, proto, port)
+	klog.V(2).Infof("service %s:%s:%s was found, continuing with DSR service cleanup", ipAddress, proto, port)
 	mangleTableRulesDump := bytes.Buffer{}
 	var mangleTableRules []string
 	if err := utils.SaveInto("mangle", &mangleTableRulesDump); err != nil {
@@ -633,7 +764,7 @@ func (nsc *NetworkServicesController) cleanupDSRService(fwMark uint32) error {
 			klog.V(2).Infof("found mangle rule to cleanup: %s", mangleTableRule)
 
 			// When we cleanup the iptables rule, we need to pass FW mark as an int string rather than a hex string
-			err = nsc.ln.cleanupMangleTableRule(ipAddress, proto, strconv.Itoa(port), strconv.Itoa(int(fwMark)),
+			err = nsc.ln.cleanupMangleTableRule(ipAddress, proto, port, strconv.Itoa(int(fwMark)),
 				nsc.dsrTCPMSS)
 			if err != nil {
 				klog.Errorf("failed to verify and cleanup any mangle table rule to FORWARD the traffic "+
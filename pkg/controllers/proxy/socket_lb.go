@@ -0,0 +1,441 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"syscall"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/rlimit"
+	"k8s.io/klog/v2"
+)
+
+const (
+	dataplaneIPVS = "ipvs"
+	dataplaneEBPF = "ebpf"
+
+	socketLBServicesMapName = "kr_lb_services"
+	socketLBBackendsMapName = "kr_lb_backends"
+	socketLBRevNatMapName   = "kr_lb_revnat"
+
+	socketLBMaxServices = 65536
+	socketLBMaxBackends = 262144
+	socketLBMaxRevNat   = 262144
+
+	// offsets of the fields in struct bpf_sock_addr, the context of BPF_PROG_TYPE_CGROUP_SOCK_ADDR programs
+	sockAddrUserIP4Offset  = 4
+	sockAddrUserPortOffset = 24
+	sockAddrProtocolOffset = 36
+
+	bpfAny = 0
+)
+
+// socketLBFrontend is the key of the services map, a VIP, port and protocol of a service. IP and port are kept in
+// network byte order as they are in struct bpf_sock_addr.
+type socketLBFrontend struct {
+	IP       [4]byte
+	Port     [2]byte
+	Protocol uint8
+	Pad      uint8
+}
+
+// socketLBService is the value of the services map, backends of a service are stored in the backends map under the
+// ID of the service and their index
+type socketLBService struct {
+	Count uint32
+	ID    uint32
+}
+
+type socketLBBackendKey struct {
+	ID    uint32
+	Index uint32
+}
+
+type socketLBBackend struct {
+	IP   [4]byte
+	Port [2]byte
+	Pad  [2]byte
+}
+
+// socketLB load balances connections that originate on the node at the socket level. BPF programs attached to the
+// cgroup v2 hierarchy rewrite the destination of connect(), sendmsg() and recvmsg() calls from a service VIP to one of
+// the backends of the service, so that the traffic never passes through IPVS and the iptables chains of kube-router.
+// Packets received from outside of the node are not seen by these hooks and are still load balanced by IPVS.
+type socketLB struct {
+	cgroupPath  string
+	servicesMap *ebpf.Map
+	backendsMap *ebpf.Map
+	revNatMap   *ebpf.Map
+	links       []link.Link
+	ids         map[socketLBFrontend]uint32
+	backends    map[socketLBFrontend][]socketLBBackend
+	nextID      uint32
+}
+
+func newSocketLB(cgroupPath string) *socketLB {
+	return &socketLB{
+		cgroupPath: cgroupPath,
+		ids:        make(map[socketLBFrontend]uint32),
+		backends:   make(map[socketLBFrontend][]socketLBBackend),
+		nextID:     1,
+	}
+}
+
+// newSocketLBFrontend returns the services map key for the given IPv4 VIP, port and protocol
+func newSocketLBFrontend(ip net.IP, port int, protocol string) (socketLBFrontend, error) {
+	var frontend socketLBFrontend
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return frontend, fmt.Errorf("%s is not an IPv4 address", ip)
+	}
+	copy(frontend.IP[:], ip4)
+	frontend.Port = [2]byte{byte(port >> 8), byte(port)}
+	frontend.Protocol = uint8(convertSvcProtoToSysCallProto(protocol))
+	return frontend, nil
+}
+
+func newSocketLBBackend(ip net.IP, port int) (socketLBBackend, error) {
+	var backend socketLBBackend
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return backend, fmt.Errorf("%s is not an IPv4 address", ip)
+	}
+	copy(backend.IP[:], ip4)
+	backend.Port = [2]byte{byte(port >> 8), byte(port)}
+	return backend, nil
+}
+
+// socketLBSelectInstructions looks up the VIP the socket is connecting or sending to in the services map and rewrites
+// it to a random backend of the service. When recordRevNat is set the chosen backend is recorded in the reverse NAT
+// map for the socket, so that the source of replies and the peer of the socket can be translated back to the VIP.
+func socketLBSelectInstructions(recordRevNat bool) asm.Instructions {
+	insns := asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+
+		// services map key on the stack at fp-8
+		asm.LoadMem(asm.R7, asm.R6, sockAddrUserIP4Offset, asm.Word),
+		asm.StoreMem(asm.RFP, -8, asm.R7, asm.Word),
+		asm.LoadMem(asm.R7, asm.R6, sockAddrUserPortOffset, asm.Word),
+		asm.StoreMem(asm.RFP, -4, asm.R7, asm.Half),
+		asm.LoadMem(asm.R7, asm.R6, sockAddrProtocolOffset, asm.Word),
+		asm.StoreMem(asm.RFP, -2, asm.R7, asm.Byte),
+		asm.StoreImm(asm.RFP, -1, 0, asm.Byte),
+		asm.LoadMapPtr(asm.R1, 0).WithReference(socketLBServicesMapName),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -8),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "out"),
+
+		// pick a random backend of the service
+		asm.LoadMem(asm.R7, asm.R0, 0, asm.Word),
+		asm.JEq.Imm(asm.R7, 0, "out"),
+		asm.LoadMem(asm.R8, asm.R0, 4, asm.Word),
+		asm.FnGetPrandomU32.Call(),
+		asm.Mod.Reg(asm.R0, asm.R7),
+		asm.StoreMem(asm.RFP, -16, asm.R8, asm.Word),
+		asm.StoreMem(asm.RFP, -12, asm.R0, asm.Word),
+		asm.LoadMapPtr(asm.R1, 0).WithReference(socketLBBackendsMapName),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -16),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "out"),
+		asm.LoadMem(asm.R7, asm.R0, 0, asm.Word),
+		asm.LoadMem(asm.R8, asm.R0, 4, asm.Half),
+	}
+
+	if recordRevNat {
+		insns = append(insns,
+			// reverse NAT map key (socket cookie, backend) at fp-32 and value (VIP) at fp-40
+			asm.Mov.Reg(asm.R1, asm.R6),
+			asm.FnGetSocketCookie.Call(),
+			asm.StoreMem(asm.RFP, -32, asm.R0, asm.DWord),
+			asm.StoreMem(asm.RFP, -24, asm.R7, asm.Word),
+			asm.StoreMem(asm.RFP, -20, asm.R8, asm.Half),
+			asm.StoreImm(asm.RFP, -18, 0, asm.Half),
+			asm.LoadMem(asm.R1, asm.RFP, -8, asm.Word),
+			asm.StoreMem(asm.RFP, -40, asm.R1, asm.Word),
+			asm.LoadMem(asm.R1, asm.RFP, -4, asm.Half),
+			asm.StoreMem(asm.RFP, -36, asm.R1, asm.Half),
+			asm.StoreImm(asm.RFP, -34, 0, asm.Half),
+			asm.LoadMapPtr(asm.R1, 0).WithReference(socketLBRevNatMapName),
+			asm.Mov.Reg(asm.R2, asm.RFP),
+			asm.Add.Imm(asm.R2, -32),
+			asm.Mov.Reg(asm.R3, asm.RFP),
+			asm.Add.Imm(asm.R3, -40),
+			asm.Mov.Imm(asm.R4, bpfAny),
+			asm.FnMapUpdateElem.Call(),
+		)
+	}
+
+	return append(insns,
+		asm.StoreMem(asm.R6, sockAddrUserIP4Offset, asm.R7, asm.Word),
+		asm.StoreMem(asm.R6, sockAddrUserPortOffset, asm.R8, asm.Word),
+		asm.Mov.Imm(asm.R0, 1).WithSymbol("out"),
+		asm.Return(),
+	)
+}
+
+// socketLBRevNatInstructions translates the address of the peer of a socket from a backend back to the VIP the
+// socket has been load balanced for, so that applications only ever see the VIP
+func socketLBRevNatInstructions() asm.Instructions {
+	return asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+		asm.FnGetSocketCookie.Call(),
+		asm.StoreMem(asm.RFP, -16, asm.R0, asm.DWord),
+		asm.LoadMem(asm.R7, asm.R6, sockAddrUserIP4Offset, asm.Word),
+		asm.StoreMem(asm.RFP, -8, asm.R7, asm.Word),
+		asm.LoadMem(asm.R7, asm.R6, sockAddrUserPortOffset, asm.Word),
+		asm.StoreMem(asm.RFP, -4, asm.R7, asm.Half),
+		asm.StoreImm(asm.RFP, -2, 0, asm.Half),
+		asm.LoadMapPtr(asm.R1, 0).WithReference(socketLBRevNatMapName),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -16),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "out"),
+		asm.LoadMem(asm.R7, asm.R0, 0, asm.Word),
+		asm.StoreMem(asm.R6, sockAddrUserIP4Offset, asm.R7, asm.Word),
+		asm.LoadMem(asm.R7, asm.R0, 4, asm.Half),
+		asm.StoreMem(asm.R6, sockAddrUserPortOffset, asm.R7, asm.Word),
+		asm.Mov.Imm(asm.R0, 1).WithSymbol("out"),
+		asm.Return(),
+	}
+}
+
+// start creates the BPF maps and attaches the load balancing programs to the cgroup v2 hierarchy
+func (lb *socketLB) start() error {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return fmt.Errorf("failed to remove memlock limit: %v", err)
+	}
+
+	var err error
+	lb.servicesMap, err = ebpf.NewMap(&ebpf.MapSpec{
+		Name:       socketLBServicesMapName,
+		Type:       ebpf.Hash,
+		KeySize:    8,
+		ValueSize:  8,
+		MaxEntries: socketLBMaxServices,
+	})
+	if err != nil {
+		lb.stop()
+		return fmt.Errorf("failed to create BPF map %s: %v", socketLBServicesMapName, err)
+	}
+	lb.backendsMap, err = ebpf.NewMap(&ebpf.MapSpec{
+		Name:       socketLBBackendsMapName,
+		Type:       ebpf.Hash,
+		KeySize:    8,
+		ValueSize:  8,
+		MaxEntries: socketLBMaxBackends,
+	})
+	if err != nil {
+		lb.stop()
+		return fmt.Errorf("failed to create BPF map %s: %v", socketLBBackendsMapName, err)
+	}
+	lb.revNatMap, err = ebpf.NewMap(&ebpf.MapSpec{
+		Name:       socketLBRevNatMapName,
+		Type:       ebpf.LRUHash,
+		KeySize:    16,
+		ValueSize:  8,
+		MaxEntries: socketLBMaxRevNat,
+	})
+	if err != nil {
+		lb.stop()
+		return fmt.Errorf("failed to create BPF map %s: %v", socketLBRevNatMapName, err)
+	}
+
+	programs := []struct {
+		name   string
+		attach ebpf.AttachType
+		insns  asm.Instructions
+	}{
+		{"kr_lb_connect4", ebpf.AttachCGroupInet4Connect, socketLBSelectInstructions(true)},
+		{"kr_lb_sendmsg4", ebpf.AttachCGroupUDP4Sendmsg, socketLBSelectInstructions(true)},
+		{"kr_lb_recvmsg4", ebpf.AttachCGroupUDP4Recvmsg, socketLBRevNatInstructions()},
+		{"kr_lb_peername4", ebpf.AttachCgroupInet4GetPeername, socketLBRevNatInstructions()},
+	}
+	for _, p := range programs {
+		for name, m := range map[string]*ebpf.Map{
+			socketLBServicesMapName: lb.servicesMap,
+			socketLBBackendsMapName: lb.backendsMap,
+			socketLBRevNatMapName:   lb.revNatMap,
+		} {
+			err = p.insns.AssociateMap(name, m)
+			if err != nil && !errors.Is(err, asm.ErrUnreferencedSymbol) {
+				lb.stop()
+				return fmt.Errorf("failed to associate BPF map %s with program %s: %v", name, p.name, err)
+			}
+		}
+		prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+			Name:         p.name,
+			Type:         ebpf.CGroupSockAddr,
+			AttachType:   p.attach,
+			Instructions: p.insns,
+			License:      "GPL",
+		})
+		if err != nil {
+			lb.stop()
+			return fmt.Errorf("failed to load BPF program %s: %v", p.name, err)
+		}
+		l, err := link.AttachCgroup(link.CgroupOptions{Path: lb.cgroupPath, Attach: p.attach, Program: prog})
+		// the link holds its own reference to the program
+		_ = prog.Close()
+		if err != nil {
+			lb.stop()
+			return fmt.Errorf("failed to attach BPF program %s to cgroup %s: %v", p.name, lb.cgroupPath, err)
+		}
+		lb.links = append(lb.links, l)
+	}
+
+	klog.Infof("Attached socket load balancing BPF programs to cgroup %s", lb.cgroupPath)
+	return nil
+}
+
+// stop detaches the programs and releases the maps, connections to services are handled by IPVS again afterwards
+func (lb *socketLB) stop() {
+	for _, l := range lb.links {
+		if err := l.Close(); err != nil {
+			klog.Errorf("Failed to detach socket load balancing BPF program: %v", err)
+		}
+	}
+	lb.links = nil
+	for _, m := range []*ebpf.Map{lb.servicesMap, lb.backendsMap, lb.revNatMap} {
+		if m != nil {
+			_ = m.Close()
+		}
+	}
+	lb.servicesMap, lb.backendsMap, lb.revNatMap = nil, nil, nil
+}
+
+// sync updates the BPF maps to load balance the given frontends to their backends. Backends are written before the
+// backend count of a service is raised and removed after it has been lowered, so that the programs never pick a
+// backend that does not exist.
+func (lb *socketLB) sync(frontends map[socketLBFrontend][]socketLBBackend) error {
+	if lb.servicesMap == nil {
+		return errors.New("socket load balancing BPF maps are not set up")
+	}
+
+	for frontend, backends := range frontends {
+		if reflect.DeepEqual(backends, lb.backends[frontend]) {
+			continue
+		}
+		id, ok := lb.ids[frontend]
+		if !ok {
+			id = lb.nextID
+			lb.nextID++
+			lb.ids[frontend] = id
+		}
+		for i, backend := range backends {
+			err := lb.backendsMap.Put(socketLBBackendKey{ID: id, Index: uint32(i)}, backend)
+			if err != nil {
+				return fmt.Errorf("failed to update backend %d of service %d: %v", i, id, err)
+			}
+		}
+		err := lb.servicesMap.Put(frontend, socketLBService{Count: uint32(len(backends)), ID: id})
+		if err != nil {
+			return fmt.Errorf("failed to update service %d: %v", id, err)
+		}
+		lb.deleteBackends(frontend, uint32(len(backends)))
+		lb.backends[frontend] = backends
+	}
+
+	for frontend, id := range lb.ids {
+		if _, ok := frontends[frontend]; ok {
+			continue
+		}
+		if err := lb.servicesMap.Delete(frontend); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("failed to delete service %d: %v", id, err)
+		}
+		lb.deleteBackends(frontend, 0)
+		delete(lb.backends, frontend)
+		delete(lb.ids, frontend)
+	}
+	return nil
+}
+
+// deleteBackends deletes the backends of the service that were programmed before starting at the given index
+func (lb *socketLB) deleteBackends(frontend socketLBFrontend, from uint32) {
+	id := lb.ids[frontend]
+	for i := from; i < uint32(len(lb.backends[frontend])); i++ {
+		err := lb.backendsMap.Delete(socketLBBackendKey{ID: id, Index: i})
+		if err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			klog.Errorf("Failed to delete backend %d of service %d: %v", i, id, err)
+		}
+	}
+}
+
+// buildSocketLBFrontends returns the frontends of the services that are load balanced at the socket level along with
+// their backends, following the same rules as the IPVS services. Services that rely on IPVS features the socket load
+// balancer does not implement, i.e. session affinity and port ranges, are left to IPVS.
+func (nsc *NetworkServicesController) buildSocketLBFrontends(serviceInfoMap serviceInfoMap,
+	endpointsInfoMap endpointsInfoMap) map[socketLBFrontend][]socketLBBackend {
+	frontends := make(map[socketLBFrontend][]socketLBBackend)
+	for k, svc := range serviceInfoMap {
+		if svc.sessionAffinity {
+			continue
+		}
+		switch convertSvcProtoToSysCallProto(svc.protocol) {
+		case syscall.IPPROTO_TCP, syscall.IPPROTO_UDP:
+		default:
+			continue
+		}
+		endpoints := endpointsInfoMap[k]
+
+		add := func(vip net.IP, port int, local bool) {
+			frontend, err := newSocketLBFrontend(vip, port, svc.protocol)
+			if err != nil {
+				klog.V(2).Infof("Not load balancing %s:%d at the socket level: %v", vip, port, err)
+				return
+			}
+			backends := make([]socketLBBackend, 0, len(endpoints))
+			for _, endpoint := range endpoints {
+				if local && !endpoint.isLocal {
+					continue
+				}
+				if nsc.endpointWeight(svc, endpoint) == 0 {
+					continue
+				}
+				backend, err := newSocketLBBackend(net.ParseIP(endpoint.ip), endpoint.port)
+				if err != nil {
+					continue
+				}
+				backends = append(backends, backend)
+			}
+			// services without backends are left to IPVS, which rejects the connections
+			if len(backends) == 0 {
+				return
+			}
+			// keep the order of the backends stable between syncs so that unchanged services are not updated
+			sort.Slice(backends, func(i, j int) bool {
+				return string(backends[i].IP[:])+string(backends[i].Port[:]) <
+					string(backends[j].IP[:])+string(backends[j].Port[:])
+			})
+			frontends[frontend] = backends
+		}
+
+		// cluster IP is load balanced to local endpoints only if it is a local service that has local endpoints
+		hasLocal := hasActiveEndpoints(endpoints)
+		if svc.clusterIP != nil {
+			add(svc.clusterIP, svc.port, svc.local && hasLocal)
+		}
+		if svc.nodePort != 0 && nsc.nodeIP != nil {
+			add(nsc.nodeIP, svc.nodePort, svc.local)
+		}
+		if svc.directServerReturn || (svc.local && !hasLocal) {
+			continue
+		}
+		externalIPs := svc.externalIPs
+		if !svc.skipLbIps {
+			externalIPs = append(append([]string{}, externalIPs...), svc.loadBalancerIPs...)
+		}
+		for _, externalIP := range externalIPs {
+			if ip := net.ParseIP(externalIP); ip != nil {
+				add(ip, svc.port, svc.local)
+			}
+		}
+	}
+	return frontends
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkServicesController_buildSocketLBFrontends(t *testing.T) {
+	nsc := getMoqNSC()
+	svcs := serviceInfoMap{
+		"default-web-tcp-http": &serviceInfo{
+			name:            "web",
+			namespace:       "default",
+			clusterIP:       net.ParseIP("10.96.0.10"),
+			port:            80,
+			protocol:        tcpProtocol,
+			nodePort:        30080,
+			externalIPs:     []string{"1.1.1.1"},
+			loadBalancerIPs: []string{"2.2.2.2"},
+			skipLbIps:       true,
+		},
+		"default-dns-udp-dns": &serviceInfo{
+			name:      "dns",
+			namespace: "default",
+			clusterIP: net.ParseIP("10.96.0.53"),
+			port:      53,
+			protocol:  udpProtocol,
+			local:     true,
+		},
+		"default-sticky-tcp-http": &serviceInfo{
+			name:            "sticky",
+			namespace:       "default",
+			clusterIP:       net.ParseIP("10.96.0.20"),
+			port:            80,
+			protocol:        tcpProtocol,
+			sessionAffinity: true,
+		},
+		"default-empty-tcp-http": &serviceInfo{
+			name:      "empty",
+			namespace: "default",
+			clusterIP: net.ParseIP("10.96.0.30"),
+			port:      80,
+			protocol:  tcpProtocol,
+		},
+	}
+	endpoints := endpointsInfoMap{
+		"default-web-tcp-http": {
+			{ip: "172.20.2.1", port: 8080, isLocal: false},
+			{ip: "172.20.1.1", port: 8080, isLocal: true},
+		},
+		"default-dns-udp-dns": {
+			{ip: "172.20.1.2", port: 5353, isLocal: true},
+			{ip: "172.20.2.2", port: 5353, isLocal: false},
+		},
+		"default-sticky-tcp-http": {
+			{ip: "172.20.1.3", port: 8080, isLocal: true},
+		},
+	}
+
+	frontend := func(ip string, port int, protocol string) socketLBFrontend {
+		f, err := newSocketLBFrontend(net.ParseIP(ip), port, protocol)
+		assert.NoError(t, err)
+		return f
+	}
+	backend := func(ip string, port int) socketLBBackend {
+		b, err := newSocketLBBackend(net.ParseIP(ip), port)
+		assert.NoError(t, err)
+		return b
+	}
+	webBackends := []socketLBBackend{backend("172.20.1.1", 8080), backend("172.20.2.1", 8080)}
+
+	assert.Equal(t, map[socketLBFrontend][]socketLBBackend{
+		frontend("10.96.0.10", 80, tcpProtocol):  webBackends,
+		frontend("10.0.0.0", 30080, tcpProtocol): webBackends,
+		frontend("1.1.1.1", 80, tcpProtocol):     webBackends,
+		frontend("10.96.0.53", 53, udpProtocol):  {backend("172.20.1.2", 5353)},
+	}, nsc.buildSocketLBFrontends(svcs, endpoints))
+}
+
+func TestNewSocketLBFrontend(t *testing.T) {
+	f, err := newSocketLBFrontend(net.ParseIP("10.96.0.10"), 443, tcpProtocol)
+	assert.NoError(t, err)
+	assert.Equal(t, socketLBFrontend{IP: [4]byte{10, 96, 0, 10}, Port: [2]byte{0x01, 0xbb}, Protocol: 6}, f)
+
+	_, err = newSocketLBFrontend(net.ParseIP("fd00::10"), 443, tcpProtocol)
+	assert.Error(t, err)
+}
@@ -225,19 +225,35 @@ func (nsc *NetworkServicesController) lookupFWMarkByService(ip, protocol, port s
 
 // lookupServiceByFWMark Lookup service ip, protocol, port by given FW Mark value (reverse of lookupFWMarkByService)
 func (nsc *NetworkServicesController) lookupServiceByFWMark(fwMark uint32) (string, string, int, error) {
+	ip, protocol, portStr, err := nsc.lookupServiceKeyByFWMark(fwMark)
+	if err != nil {
+		return "", "", 0, err
+	}
+	port, err := strconv.ParseInt(portStr, 10, 32)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("port number for service key for found FW mark was not a 32-bit int: %v", err)
+	}
+	return ip, protocol, int(port), nil
+}
+
+// lookupServiceKeyByFWMark Lookup service ip, protocol, port by given FW Mark value like lookupServiceByFWMark, but
+// returns the port as it was passed to generateUniqueFWMark so that it also works for port range services whose port
+// is a <start>:<end> range
+func (nsc *NetworkServicesController) lookupServiceKeyByFWMark(fwMark uint32) (string, string, string, error) {
 	serviceKey, ok := nsc.fwMarkMap[fwMark]
 	if !ok {
-		return "", "", 0, fmt.Errorf("could not find service matching the given FW mark")
+		return "", "", "", fmt.Errorf("could not find service matching the given FW mark")
 	}
 	serviceKeySplit := strings.Split(serviceKey, "-")
 	if len(serviceKeySplit) != 3 {
-		return "", "", 0, fmt.Errorf("service key for found FW mark did not have 3 parts, this shouldn't be possible")
+		return "", "", "", fmt.Errorf("service key for found FW mark did not have 3 parts, this shouldn't be possible")
 	}
-	port, err := strconv.ParseInt(serviceKeySplit[2], 10, 32)
-	if err != nil {
-		return "", "", 0, fmt.Errorf("port number for service key for found FW mark was not a 32-bit int: %v", err)
-	}
-	return serviceKeySplit[0], serviceKeySplit[1], int(port), nil
+	return serviceKeySplit[0], serviceKeySplit[1], serviceKeySplit[2], nil
+}
+
+// isPortRangeServiceKeyPort returns true if the port of a service key belongs to a port range service
+func isPortRangeServiceKeyPort(port string) bool {
+	return strings.Contains(port, ":")
 }
 
 // unsortedListsEquivalent compares two lists of endpointsInfo and considers them the same if they contains the same
@@ -358,3 +374,41 @@ func (nsc *NetworkServicesController) addDSRIPInsidePodNetNamespace(externalIP,
 
 	return nil
 }
+
+// parsePortRange parses a port range in the form of <start>-<end>[/<protocol>] as used by the
+// kube-router.io/service.port-range annotation, if protocol is not given tcp is assumed
+func parsePortRange(value string) (*portRange, error) {
+	const maxPort = 65535
+	pr := portRange{protocol: tcpProtocol}
+
+	rangeSpec := strings.TrimSpace(value)
+	if idx := strings.Index(rangeSpec, "/"); idx != -1 {
+		pr.protocol = strings.ToLower(strings.TrimSpace(rangeSpec[idx+1:]))
+		rangeSpec = strings.TrimSpace(rangeSpec[:idx])
+	}
+	if pr.protocol != tcpProtocol && pr.protocol != udpProtocol {
+		return nil, fmt.Errorf("unsupported protocol %q in port range %q", pr.protocol, value)
+	}
+
+	bounds := strings.Split(rangeSpec, "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("port range %q is not in the form <start>-<end>[/<protocol>]", value)
+	}
+	var err error
+	if pr.start, err = strconv.Atoi(strings.TrimSpace(bounds[0])); err != nil {
+		return nil, fmt.Errorf("invalid start port in port range %q: %v", value, err)
+	}
+	if pr.end, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+		return nil, fmt.Errorf("invalid end port in port range %q: %v", value, err)
+	}
+	if pr.start < 1 || pr.end > maxPort || pr.start > pr.end {
+		return nil, fmt.Errorf("port range %q must be within 1-%d and start must not exceed end", value, maxPort)
+	}
+
+	return &pr, nil
+}
+
+// String returns the port range in the <start>:<end> form understood by iptables
+func (pr *portRange) String() string {
+	return strconv.Itoa(pr.start) + ":" + strconv.Itoa(pr.end)
+}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"syscall"
 	"testing"
 
+	"github.com/moby/ipvs"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -132,3 +134,88 @@ func TestNetworkServicesController_lookupServiceByFWMark(t *testing.T) {
 		assert.Zero(t, foundPort, "port should be zero on error")
 	})
 }
+
+func TestParsePortRange(t *testing.T) {
+	t.Run("ensure port range with protocol is parsed", func(t *testing.T) {
+		pr, err := parsePortRange("10000-20000/udp")
+
+		assert.NoError(t, err, "there shouldn't have been an error parsing a valid port range")
+		assert.Equal(t, &portRange{start: 10000, end: 20000, protocol: "udp"}, pr)
+		assert.Equal(t, "10000:20000", pr.String(), "port range should be formatted the way iptables expects")
+	})
+
+	t.Run("ensure protocol defaults to tcp", func(t *testing.T) {
+		pr, err := parsePortRange(" 5060-5061 ")
+
+		assert.NoError(t, err, "there shouldn't have been an error parsing a valid port range")
+		assert.Equal(t, &portRange{start: 5060, end: 5061, protocol: "tcp"}, pr)
+	})
+
+	t.Run("ensure invalid port ranges are rejected", func(t *testing.T) {
+		for _, value := range []string{"", "10000", "10000-20000-30000", "a-b", "20000-10000", "0-100",
+			"1-65536", "10000-20000/sctp"} {
+			pr, err := parsePortRange(value)
+
+			assert.Errorf(t, err, "expected an error parsing port range %q", value)
+			assert.Nil(t, pr, "port range should be nil on error")
+		}
+	})
+}
+
+func TestNetworkServicesController_lookupServiceKeyByFWMark(t *testing.T) {
+	t.Run("ensure port range services can be found by FW mark", func(t *testing.T) {
+		nsc := getMoqNSC()
+		fwMark, err := nsc.generateUniqueFWMark("10.255.0.1", "udp", "10000:20000")
+		assert.NoError(t, err, "there shouldn't have been an error calling generateUniqueFWMark")
+
+		ip, protocol, port, err := nsc.lookupServiceKeyByFWMark(fwMark)
+
+		assert.NoError(t, err, "there shouldn't have been an error calling lookupServiceKeyByFWMark")
+		assert.Equal(t, "10.255.0.1", ip)
+		assert.Equal(t, "udp", protocol)
+		assert.Equal(t, "10000:20000", port)
+		assert.True(t, isPortRangeServiceKeyPort(port), "port should be recognized as a port range")
+
+		_, _, _, err = nsc.lookupServiceByFWMark(fwMark)
+		assert.Error(t, err, "lookupServiceByFWMark should not be able to convert a port range to a single port")
+	})
+}
+
+func TestOnePacketSchedFlag(t *testing.T) {
+	flags := schedFlags{onePacket: true}
+
+	t.Run("one-packet scheduling is set on UDP services and stable across syncs", func(t *testing.T) {
+		svc := &ipvs.Service{Protocol: syscall.IPPROTO_UDP, Flags: ipvsHashedFlagHex}
+		assert.True(t, changedIpvsSchedFlags(svc, syscall.IPPROTO_UDP, flags))
+		ipvsSetSchedFlags(svc, syscall.IPPROTO_UDP, flags)
+		assert.NotZero(t, svc.Flags&ipvsOnePacketFlagHex)
+		assert.NotZero(t, svc.Flags&ipvsHashedFlagHex, "other flags should be retained")
+		assert.False(t, changedIpvsSchedFlags(svc, syscall.IPPROTO_UDP, flags))
+
+		assert.True(t, changedIpvsSchedFlags(svc, syscall.IPPROTO_UDP, schedFlags{}))
+		ipvsSetSchedFlags(svc, syscall.IPPROTO_UDP, schedFlags{})
+		assert.Zero(t, svc.Flags&ipvsOnePacketFlagHex)
+	})
+
+	t.Run("one-packet scheduling is never set on TCP services", func(t *testing.T) {
+		svc := &ipvs.Service{Protocol: syscall.IPPROTO_TCP}
+		assert.False(t, changedIpvsSchedFlags(svc, syscall.IPPROTO_TCP, flags))
+		ipvsSetSchedFlags(svc, syscall.IPPROTO_TCP, flags)
+		assert.Zero(t, svc.Flags&ipvsOnePacketFlagHex)
+	})
+
+	t.Run("one-packet scheduling is kept on UDP FWMARK services listed without a protocol", func(t *testing.T) {
+		svc := &ipvs.Service{FWMark: 10, Flags: ipvsOnePacketFlagHex, SchedName: ipvs.RoundRobin}
+		// the ipvs handle is left unset, the service must be returned as is without being updated
+		ln := &linuxNetworking{}
+		var existing *ipvs.Service
+		assert.NotPanics(t, func() {
+			var err error
+			existing, err = ln.ipvsAddFWMarkService([]*ipvs.Service{svc}, 10, syscall.IPPROTO_UDP, 0, false, 0,
+				ipvs.RoundRobin, flags)
+			assert.NoError(t, err)
+		})
+		assert.Same(t, svc, existing)
+		assert.NotZero(t, svc.Flags&ipvsOnePacketFlagHex)
+	})
+}
@@ -0,0 +1,751 @@
+package routing
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"k8s.io/klog/v2"
+)
+
+// This file implements asynchronous mode Bidirectional Forwarding Detection (RFC 5880) for single hop sessions over
+// IPv4 and IPv6 (RFC 5881). Echo mode, demand mode and authentication are not supported.
+
+const (
+	bfdSingleHopPort   = 3784
+	bfdMinSourcePort   = 49152
+	bfdMaxSourcePort   = 65535
+	bfdVersion         = 1
+	bfdPacketLength    = 24
+	bfdTTL             = 255
+	bfdSlowTxInterval  = time.Second
+	bfdEventBufferSize = 64
+)
+
+type bfdState uint8
+
+const (
+	bfdStateAdminDown bfdState = iota
+	bfdStateDown
+	bfdStateInit
+	bfdStateUp
+)
+
+func (s bfdState) String() string {
+	switch s {
+	case bfdStateAdminDown:
+		return "AdminDown"
+	case bfdStateDown:
+		return "Down"
+	case bfdStateInit:
+		return "Init"
+	case bfdStateUp:
+		return "Up"
+	}
+	return fmt.Sprintf("Unknown(%d)", uint8(s))
+}
+
+type bfdDiag uint8
+
+const (
+	bfdDiagNone                bfdDiag = 0
+	bfdDiagDetectionTimeExpire bfdDiag = 1
+	bfdDiagNeighborSignaled    bfdDiag = 3
+	bfdDiagAdminDown           bfdDiag = 7
+)
+
+// bfdControlPacket is a BFD control packet, without authentication section
+type bfdControlPacket struct {
+	Diag                      bfdDiag
+	State                     bfdState
+	Poll                      bool
+	Final                     bool
+	DetectMult                uint8
+	MyDiscriminator           uint32
+	YourDiscriminator         uint32
+	DesiredMinTxInterval      time.Duration
+	RequiredMinRxInterval     time.Duration
+	RequiredMinEchoRxInterval time.Duration
+}
+
+func (p *bfdControlPacket) marshal() []byte {
+	b := make([]byte, bfdPacketLength)
+	b[0] = bfdVersion<<5 | uint8(p.Diag)&0x1f
+	b[1] = uint8(p.State) << 6
+	if p.Poll {
+		b[1] |= 0x20
+	}
+	if p.Final {
+		b[1] |= 0x10
+	}
+	b[2] = p.DetectMult
+	b[3] = bfdPacketLength
+	binary.BigEndian.PutUint32(b[4:], p.MyDiscriminator)
+	binary.BigEndian.PutUint32(b[8:], p.YourDiscriminator)
+	binary.BigEndian.PutUint32(b[12:], uint32(p.DesiredMinTxInterval.Microseconds()))
+	binary.BigEndian.PutUint32(b[16:], uint32(p.RequiredMinRxInterval.Microseconds()))
+	binary.BigEndian.PutUint32(b[20:], uint32(p.RequiredMinEchoRxInterval.Microseconds()))
+	return b
+}
+
+// parseBFDControlPacket parses and validates a received BFD control packet as per section 6.8.6 of RFC 5880
+func parseBFDControlPacket(b []byte) (*bfdControlPacket, error) {
+	if len(b) < bfdPacketLength {
+		return nil, fmt.Errorf("packet of %d bytes is too short", len(b))
+	}
+	if version := b[0] >> 5; version != bfdVersion {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	length := int(b[3])
+	if length < bfdPacketLength || length > len(b) {
+		return nil, fmt.Errorf("invalid length %d", length)
+	}
+	if b[1]&0x04 != 0 {
+		return nil, errors.New("authentication is not supported")
+	}
+	if b[1]&0x01 != 0 {
+		return nil, errors.New("multipoint bit is set")
+	}
+	p := &bfdControlPacket{
+		Diag:                      bfdDiag(b[0] & 0x1f),
+		State:                     bfdState(b[1] >> 6),
+		Poll:                      b[1]&0x20 != 0,
+		Final:                     b[1]&0x10 != 0,
+		DetectMult:                b[2],
+		MyDiscriminator:           binary.BigEndian.Uint32(b[4:]),
+		YourDiscriminator:         binary.BigEndian.Uint32(b[8:]),
+		DesiredMinTxInterval:      time.Duration(binary.BigEndian.Uint32(b[12:])) * time.Microsecond,
+		RequiredMinRxInterval:     time.Duration(binary.BigEndian.Uint32(b[16:])) * time.Microsecond,
+		RequiredMinEchoRxInterval: time.Duration(binary.BigEndian.Uint32(b[20:])) * time.Microsecond,
+	}
+	if p.DetectMult == 0 {
+		return nil, errors.New("detect multiplier is zero")
+	}
+	if p.MyDiscriminator == 0 {
+		return nil, errors.New("my discriminator is zero")
+	}
+	if p.YourDiscriminator == 0 && p.State != bfdStateDown && p.State != bfdStateAdminDown {
+		return nil, fmt.Errorf("your discriminator is zero in state %s", p.State)
+	}
+	if p.Poll && p.Final {
+		return nil, errors.New("both poll and final bits are set")
+	}
+	return p, nil
+}
+
+// bfdSessionConfig holds the timers of a BFD session
+type bfdSessionConfig struct {
+	DesiredMinTxInterval  time.Duration
+	RequiredMinRxInterval time.Duration
+	DetectMultiplier      uint8
+}
+
+// bfdPeer is the desired configuration of the BFD session with a peer
+type bfdPeer struct {
+	localAddress net.IP
+	config       bfdSessionConfig
+}
+
+// bfdSession is the state of a BFD session with a peer
+type bfdSession struct {
+	peer         net.IP
+	localAddress net.IP
+	remotePort   int
+	conn         *net.UDPConn
+	events       chan<- bfdEvent
+
+	mu                  sync.Mutex
+	config              bfdSessionConfig
+	state               bfdState
+	remoteState         bfdState
+	localDiscr          uint32
+	remoteDiscr         uint32
+	diag                bfdDiag
+	remoteMinRxInterval time.Duration
+	remoteDesiredMinTx  time.Duration
+	remoteDetectMult    uint8
+	pollActive          bool
+	sendFinal           bool
+	sendNow             bool
+	lastRx              time.Time
+	wakeCh              chan struct{}
+	stopCh              chan struct{}
+	stopOnce            sync.Once
+}
+
+// bfdEvent is sent whenever the state of a BFD session changes
+type bfdEvent struct {
+	peer        string
+	oldState    bfdState
+	newState    bfdState
+	remoteState bfdState
+	diag        bfdDiag
+}
+
+// bfdManager runs the BFD sessions with the peers of the node. It receives the control packets of all the sessions
+// and demultiplexes them, while each session sends its own control packets from a dedicated source port.
+type bfdManager struct {
+	mu         sync.Mutex
+	sessions   map[string]*bfdSession
+	byDiscr    map[uint32]*bfdSession
+	listeners  map[bool]net.PacketConn
+	port       int
+	remotePort int
+	events     chan bfdEvent
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
+
+	onStateChange func(event bfdEvent)
+}
+
+func newBFDManager(onStateChange func(event bfdEvent)) *bfdManager {
+	return &bfdManager{
+		sessions:      make(map[string]*bfdSession),
+		byDiscr:       make(map[uint32]*bfdSession),
+		listeners:     make(map[bool]net.PacketConn),
+		port:          bfdSingleHopPort,
+		remotePort:    bfdSingleHopPort,
+		events:        make(chan bfdEvent, bfdEventBufferSize),
+		stopCh:        make(chan struct{}),
+		onStateChange: onStateChange,
+	}
+}
+
+// run delivers the state changes of the sessions until the manager is stopped
+func (m *bfdManager) run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stopCh:
+				m.stop()
+				return
+			case event := <-m.events:
+				klog.Infof("BFD session with peer %s went from %s to %s (diagnostic %d)", event.peer,
+					event.oldState, event.newState, event.diag)
+				if m.onStateChange != nil {
+					m.onStateChange(event)
+				}
+			}
+		}
+	}()
+}
+
+// stop tears down all the sessions and closes the listeners
+func (m *bfdManager) stop() {
+	m.mu.Lock()
+	select {
+	case <-m.stopCh:
+		m.mu.Unlock()
+		return
+	default:
+	}
+	close(m.stopCh)
+	for address, session := range m.sessions {
+		session.stop()
+		delete(m.sessions, address)
+	}
+	for _, listener := range m.listeners {
+		_ = listener.Close()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+// sync reconciles the BFD sessions with the desired peers, keyed by peer address. Sessions are created for new peers,
+// their timers are updated when their config changed and the sessions with the peers no longer desired are removed.
+func (m *bfdManager) sync(desired map[string]bfdPeer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-m.stopCh:
+		return
+	default:
+	}
+
+	for address, peer := range desired {
+		if session, ok := m.sessions[address]; ok {
+			if session.localAddress.Equal(peer.localAddress) {
+				session.setConfig(peer.config)
+				continue
+			}
+			m.removeSession(address, session)
+		}
+		peerIP := net.ParseIP(address)
+		if peerIP == nil {
+			klog.Errorf("Not starting BFD session with invalid peer address %s", address)
+			continue
+		}
+		isIpv6 := peerIP.To4() == nil
+		if err := m.listen(isIpv6); err != nil {
+			klog.Errorf("Failed to start BFD session with peer %s: %s", address, err)
+			continue
+		}
+		session, err := m.newSession(peerIP, peer.localAddress, peer.config)
+		if err != nil {
+			klog.Errorf("Failed to start BFD session with peer %s: %s", address, err)
+			continue
+		}
+		m.sessions[address] = session
+		m.byDiscr[session.localDiscr] = session
+		klog.Infof("Started BFD session with peer %s from %s", address, peer.localAddress)
+	}
+
+	for address, session := range m.sessions {
+		if _, ok := desired[address]; !ok {
+			m.removeSession(address, session)
+			klog.Infof("Stopped BFD session with peer %s", address)
+		}
+	}
+}
+
+func (m *bfdManager) removeSession(address string, session *bfdSession) {
+	session.stop()
+	delete(m.sessions, address)
+	delete(m.byDiscr, session.localDiscr)
+}
+
+// getState returns the state of the BFD session with the peer and whether such a session exists
+func (m *bfdManager) getState(address string) (bfdState, bool) {
+	m.mu.Lock()
+	session, ok := m.sessions[address]
+	m.mu.Unlock()
+	if !ok {
+		return bfdStateDown, false
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.state, true
+}
+
+// listen starts receiving BFD control packets of the given IP family, if not done already
+func (m *bfdManager) listen(isIpv6 bool) error {
+	if _, ok := m.listeners[isIpv6]; ok {
+		return nil
+	}
+	network := "udp4"
+	if isIpv6 {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, &net.UDPAddr{Port: m.port})
+	if err != nil {
+		return fmt.Errorf("failed to listen for BFD control packets: %s", err)
+	}
+	var receive func(b []byte) (int, int, net.Addr, error)
+	if isIpv6 {
+		pc := ipv6.NewPacketConn(conn)
+		if err := pc.SetControlMessage(ipv6.FlagHopLimit, true); err != nil {
+			_ = conn.Close()
+			return fmt.Errorf("failed to enable receiving the hop limit of BFD control packets: %s", err)
+		}
+		receive = func(b []byte) (int, int, net.Addr, error) {
+			n, cm, src, err := pc.ReadFrom(b)
+			ttl := -1
+			if cm != nil {
+				ttl = cm.HopLimit
+			}
+			return n, ttl, src, err
+		}
+	} else {
+		pc := ipv4.NewPacketConn(conn)
+		if err := pc.SetControlMessage(ipv4.FlagTTL, true); err != nil {
+			_ = conn.Close()
+			return fmt.Errorf("failed to enable receiving the TTL of BFD control packets: %s", err)
+		}
+		receive = func(b []byte) (int, int, net.Addr, error) {
+			n, cm, src, err := pc.ReadFrom(b)
+			ttl := -1
+			if cm != nil {
+				ttl = cm.TTL
+			}
+			return n, ttl, src, err
+		}
+	}
+	m.listeners[isIpv6] = conn
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		buf := make([]byte, 1500)
+		for {
+			n, ttl, src, err := receive(buf)
+			if err != nil {
+				select {
+				case <-m.stopCh:
+					return
+				default:
+				}
+				klog.Errorf("Failed to receive BFD control packet: %s", err)
+				continue
+			}
+			udpAddr, ok := src.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			m.handlePacket(buf[:n], udpAddr.IP, ttl)
+		}
+	}()
+	return nil
+}
+
+// handlePacket validates a received control packet and hands it over to the session it belongs to
+func (m *bfdManager) handlePacket(b []byte, src net.IP, ttl int) {
+	// single hop sessions are protected against spoofing from remote networks through the TTL, see RFC 5881
+	if ttl != bfdTTL {
+		klog.V(2).Infof("Dropping BFD control packet from %s with TTL %d", src, ttl)
+		return
+	}
+	p, err := parseBFDControlPacket(b)
+	if err != nil {
+		klog.V(2).Infof("Dropping invalid BFD control packet from %s: %s", src, err)
+		return
+	}
+
+	m.mu.Lock()
+	var session *bfdSession
+	if p.YourDiscriminator != 0 {
+		session = m.byDiscr[p.YourDiscriminator]
+	} else {
+		for _, s := range m.sessions {
+			if s.peer.Equal(src) {
+				session = s
+				break
+			}
+		}
+	}
+	m.mu.Unlock()
+	if session == nil || !session.peer.Equal(src) {
+		klog.V(2).Infof("Dropping BFD control packet from %s not matching any session", src)
+		return
+	}
+	session.receive(p, time.Now())
+}
+
+func (m *bfdManager) newSession(peer, localAddress net.IP, config bfdSessionConfig) (*bfdSession, error) {
+	network := "udp4"
+	if peer.To4() == nil {
+		network = "udp6"
+	}
+	// RFC 5881 requires the source port to be in the dynamic range, and to be the same for all the control packets
+	// of a session
+	var conn *net.UDPConn
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		//nolint:gosec // the source port doesn't need a cryptographically secure random number
+		port := bfdMinSourcePort + rand.Intn(bfdMaxSourcePort-bfdMinSourcePort+1)
+		conn, err = net.ListenUDP(network, &net.UDPAddr{IP: localAddress, Port: port})
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open BFD control packets socket: %s", err)
+	}
+	if peer.To4() == nil {
+		err = ipv6.NewPacketConn(conn).SetHopLimit(bfdTTL)
+	} else {
+		err = ipv4.NewPacketConn(conn).SetTTL(bfdTTL)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to set the TTL of BFD control packets: %s", err)
+	}
+
+	s := newBFDSession(peer, localAddress, config, m.events)
+	s.conn = conn
+	s.remotePort = m.remotePort
+	for {
+		if _, ok := m.byDiscr[s.localDiscr]; !ok {
+			break
+		}
+		s.localDiscr = newBFDDiscriminator()
+	}
+	// the manager waits for the sessions to send their last control packet and close their socket when stopped
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		s.run()
+	}()
+	return s, nil
+}
+
+func newBFDDiscriminator() uint32 {
+	for {
+		//nolint:gosec // discriminators only need to be unique, not unpredictable
+		if discr := rand.Uint32(); discr != 0 {
+			return discr
+		}
+	}
+}
+
+func newBFDSession(peer, localAddress net.IP, config bfdSessionConfig, events chan<- bfdEvent) *bfdSession {
+	return &bfdSession{
+		peer:         peer,
+		localAddress: localAddress,
+		events:       events,
+		config:       config,
+		state:        bfdStateDown,
+		remoteState:  bfdStateDown,
+		localDiscr:   newBFDDiscriminator(),
+		// until a packet is received the remote system is assumed to accept packets as often as we send them
+		remoteMinRxInterval: time.Microsecond,
+		wakeCh:              make(chan struct{}, 1),
+		stopCh:              make(chan struct{}),
+	}
+}
+
+func (s *bfdSession) stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+// setConfig updates the timers of the session, a poll sequence is started so that the peer learns the new timers
+func (s *bfdSession) setConfig(config bfdSessionConfig) {
+	s.mu.Lock()
+	if s.config == config {
+		s.mu.Unlock()
+		return
+	}
+	s.config = config
+	s.pollActive = true
+	s.sendNow = true
+	s.mu.Unlock()
+	s.wake()
+}
+
+func (s *bfdSession) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// desiredMinTxInterval returns the interval advertised to the peer, which must not be less than a second while the
+// session is not up
+func (s *bfdSession) desiredMinTxInterval() time.Duration {
+	if s.state != bfdStateUp && s.config.DesiredMinTxInterval < bfdSlowTxInterval {
+		return bfdSlowTxInterval
+	}
+	return s.config.DesiredMinTxInterval
+}
+
+// txInterval returns the interval between two transmitted control packets, before jitter is applied
+func (s *bfdSession) txInterval() time.Duration {
+	interval := s.desiredMinTxInterval()
+	if s.remoteMinRxInterval > interval {
+		interval = s.remoteMinRxInterval
+	}
+	return interval
+}
+
+// detectionTime returns the time after which the session is declared down when no packet was received from the peer
+func (s *bfdSession) detectionTime() time.Duration {
+	interval := s.config.RequiredMinRxInterval
+	if s.remoteDesiredMinTx > interval {
+		interval = s.remoteDesiredMinTx
+	}
+	return time.Duration(s.remoteDetectMult) * interval
+}
+
+// setState changes the state of the session, the change is reported to the manager. It must be called with the
+// session lock held.
+func (s *bfdSession) setState(state bfdState, diag bfdDiag) {
+	if s.state == state {
+		return
+	}
+	event := bfdEvent{peer: s.peer.String(), oldState: s.state, newState: state, remoteState: s.remoteState,
+		diag: diag}
+	s.state = state
+	s.diag = diag
+	if state == bfdStateUp {
+		// the transmit interval is no longer held to a second, let the peer know through a poll sequence
+		s.pollActive = true
+	}
+	select {
+	case s.events <- event:
+	default:
+		klog.Errorf("Dropping BFD state change event of peer %s as the event queue is full", event.peer)
+	}
+}
+
+// receive runs the reception of a control packet from the peer as per section 6.8.6 of RFC 5880
+func (s *bfdSession) receive(p *bfdControlPacket, now time.Time) {
+	s.mu.Lock()
+	oldState := s.state
+
+	s.remoteDiscr = p.MyDiscriminator
+	s.remoteState = p.State
+	s.remoteMinRxInterval = p.RequiredMinRxInterval
+	s.remoteDesiredMinTx = p.DesiredMinTxInterval
+	s.remoteDetectMult = p.DetectMult
+	s.lastRx = now
+
+	if p.Final {
+		s.pollActive = false
+	}
+
+	if s.state == bfdStateAdminDown {
+		s.mu.Unlock()
+		return
+	}
+
+	if p.State == bfdStateAdminDown {
+		if s.state != bfdStateDown {
+			s.setState(bfdStateDown, bfdDiagNeighborSignaled)
+		}
+	} else {
+		switch s.state {
+		case bfdStateDown:
+			if p.State == bfdStateDown {
+				s.setState(bfdStateInit, bfdDiagNone)
+			} else if p.State == bfdStateInit {
+				s.setState(bfdStateUp, bfdDiagNone)
+			}
+		case bfdStateInit:
+			if p.State == bfdStateInit || p.State == bfdStateUp {
+				s.setState(bfdStateUp, bfdDiagNone)
+			}
+		case bfdStateUp:
+			if p.State == bfdStateDown {
+				s.setState(bfdStateDown, bfdDiagNeighborSignaled)
+			}
+		}
+	}
+
+	if p.Poll || s.state != oldState {
+		// answer the poll, or let the peer know about the new state, without waiting for the transmit interval
+		s.sendFinal = p.Poll
+		s.sendNow = true
+	}
+	s.mu.Unlock()
+
+	// the session goroutine is woken up in any case to rearm the detection timer
+	s.wake()
+}
+
+// checkDetectionTime declares the session down if no control packet was received from the peer within the detection
+// time, it returns the time left before the detection time expires
+func (s *bfdSession) checkDetectionTime(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != bfdStateInit && s.state != bfdStateUp {
+		return 0
+	}
+	left := s.lastRx.Add(s.detectionTime()).Sub(now)
+	if left > 0 {
+		return left
+	}
+	s.setState(bfdStateDown, bfdDiagDetectionTimeExpire)
+	s.remoteDiscr = 0
+	s.remoteMinRxInterval = time.Microsecond
+	return 0
+}
+
+// nextPacket returns the next control packet to send along with the jittered interval until the following one. A
+// packet answering a poll has the final bit set and is sent right away.
+func (s *bfdSession) nextPacket() (*bfdControlPacket, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := &bfdControlPacket{
+		Diag:                  s.diag,
+		State:                 s.state,
+		DetectMult:            s.config.DetectMultiplier,
+		MyDiscriminator:       s.localDiscr,
+		YourDiscriminator:     s.remoteDiscr,
+		DesiredMinTxInterval:  s.desiredMinTxInterval(),
+		RequiredMinRxInterval: s.config.RequiredMinRxInterval,
+	}
+	if s.sendFinal {
+		p.Final = true
+		s.sendFinal = false
+	} else if s.pollActive {
+		p.Poll = true
+	}
+
+	// the interval is reduced by 0 to 25%, or by 10 to 25% when the detect multiplier is one
+	interval := s.txInterval()
+	//nolint:gosec // jitter doesn't need a cryptographically secure random number
+	jitter := 75 + rand.Intn(26)
+	if s.config.DetectMultiplier == 1 {
+		//nolint:gosec // jitter doesn't need a cryptographically secure random number
+		jitter = 75 + rand.Intn(16)
+	}
+	return p, interval * time.Duration(jitter) / 100
+}
+
+// run sends the control packets of the session and watches the detection time until the session is stopped
+func (s *bfdSession) run() {
+	defer s.conn.Close()
+
+	txTimer := time.NewTimer(0)
+	defer txTimer.Stop()
+	detectTimer := time.NewTimer(time.Hour)
+	defer detectTimer.Stop()
+
+	send := func() time.Duration {
+		p, interval := s.nextPacket()
+		_, err := s.conn.WriteToUDP(p.marshal(), &net.UDPAddr{IP: s.peer, Port: s.remotePort})
+		if err != nil {
+			klog.V(1).Infof("Failed to send BFD control packet to %s: %s", s.peer, err)
+		}
+		return interval
+	}
+	rearmDetectTimer := func() {
+		left := s.checkDetectionTime(time.Now())
+		if left <= 0 {
+			left = time.Hour
+		}
+		if !detectTimer.Stop() {
+			select {
+			case <-detectTimer.C:
+			default:
+			}
+		}
+		detectTimer.Reset(left)
+	}
+
+	for {
+		select {
+		case <-s.stopCh:
+			// let the peer know that the session is administratively removed rather than failed
+			s.mu.Lock()
+			s.state = bfdStateAdminDown
+			s.diag = bfdDiagAdminDown
+			s.pollActive = false
+			s.mu.Unlock()
+			send()
+			return
+		case <-txTimer.C:
+			txTimer.Reset(send())
+		case <-detectTimer.C:
+			rearmDetectTimer()
+		case <-s.wakeCh:
+			rearmDetectTimer()
+			s.mu.Lock()
+			sendNow := s.sendNow
+			s.sendNow = false
+			s.mu.Unlock()
+			if sendNow {
+				// the transmit interval may have changed along with the state, so the periodic sending is rearmed
+				if !txTimer.Stop() {
+					select {
+					case <-txTimer.C:
+					default:
+					}
+				}
+				txTimer.Reset(send())
+			}
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package routing
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_bfdControlPacket(t *testing.T) {
+	testcases := []struct {
+		name        string
+		packet      *bfdControlPacket
+		modify      func(b []byte) []byte
+		expectedErr bool
+	}{
+		{
+			"valid packet is parsed back",
+			&bfdControlPacket{
+				Diag:                  bfdDiagDetectionTimeExpire,
+				State:                 bfdStateUp,
+				Poll:                  true,
+				DetectMult:            3,
+				MyDiscriminator:       1,
+				YourDiscriminator:     2,
+				DesiredMinTxInterval:  300 * time.Millisecond,
+				RequiredMinRxInterval: time.Second,
+			},
+			nil,
+			false,
+		},
+		{
+			"zero your discriminator is accepted in down state",
+			&bfdControlPacket{State: bfdStateDown, Final: true, DetectMult: 3, MyDiscriminator: 1},
+			nil,
+			false,
+		},
+		{
+			"zero your discriminator is rejected in up state",
+			&bfdControlPacket{State: bfdStateUp, DetectMult: 3, MyDiscriminator: 1},
+			nil,
+			true,
+		},
+		{
+			"zero my discriminator is rejected",
+			&bfdControlPacket{State: bfdStateDown, DetectMult: 3},
+			nil,
+			true,
+		},
+		{
+			"zero detect multiplier is rejected",
+			&bfdControlPacket{State: bfdStateDown, MyDiscriminator: 1},
+			nil,
+			true,
+		},
+		{
+			"unsupported version is rejected",
+			&bfdControlPacket{State: bfdStateDown, DetectMult: 3, MyDiscriminator: 1},
+			func(b []byte) []byte { b[0] = 2<<5 | b[0]&0x1f; return b },
+			true,
+		},
+		{
+			"authentication is rejected",
+			&bfdControlPacket{State: bfdStateDown, DetectMult: 3, MyDiscriminator: 1},
+			func(b []byte) []byte { b[1] |= 0x04; return b },
+			true,
+		},
+		{
+			"truncated packet is rejected",
+			&bfdControlPacket{State: bfdStateDown, DetectMult: 3, MyDiscriminator: 1},
+			func(b []byte) []byte { return b[:20] },
+			true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			b := testcase.packet.marshal()
+			if testcase.modify != nil {
+				b = testcase.modify(b)
+			}
+			packet, err := parseBFDControlPacket(b)
+			if testcase.expectedErr {
+				if err == nil {
+					t.Errorf("expected error parsing packet %v", b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse packet: %v", err)
+			}
+			if !reflect.DeepEqual(packet, testcase.packet) {
+				t.Logf("expected packet: %+v", testcase.packet)
+				t.Logf("actual packet: %+v", packet)
+				t.Error("parsed packet doesn't match the marshaled one")
+			}
+		})
+	}
+}
+
+func Test_bfdSessionReceive(t *testing.T) {
+	testcases := []struct {
+		name           string
+		localState     bfdState
+		remoteStates   []bfdState
+		expectedStates []bfdState
+	}{
+		{
+			"three way handshake from down",
+			bfdStateDown,
+			[]bfdState{bfdStateDown, bfdStateUp},
+			[]bfdState{bfdStateInit, bfdStateUp},
+		},
+		{
+			"peer in init brings the session up",
+			bfdStateDown,
+			[]bfdState{bfdStateInit},
+			[]bfdState{bfdStateUp},
+		},
+		{
+			"peer going down takes the session down",
+			bfdStateUp,
+			[]bfdState{bfdStateUp, bfdStateDown, bfdStateDown},
+			[]bfdState{bfdStateUp, bfdStateDown, bfdStateInit},
+		},
+		{
+			"peer going admin down takes the session down",
+			bfdStateUp,
+			[]bfdState{bfdStateAdminDown, bfdStateAdminDown},
+			[]bfdState{bfdStateDown, bfdStateDown},
+		},
+		{
+			"up peer is ignored while down",
+			bfdStateDown,
+			[]bfdState{bfdStateUp},
+			[]bfdState{bfdStateDown},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			events := make(chan bfdEvent, 10)
+			session := newBFDSession(net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"),
+				bfdSessionConfig{DesiredMinTxInterval: time.Second, RequiredMinRxInterval: time.Second,
+					DetectMultiplier: 3}, events)
+			session.state = testcase.localState
+			for i, remoteState := range testcase.remoteStates {
+				session.receive(&bfdControlPacket{
+					State:                 remoteState,
+					DetectMult:            3,
+					MyDiscriminator:       42,
+					YourDiscriminator:     session.localDiscr,
+					DesiredMinTxInterval:  time.Second,
+					RequiredMinRxInterval: time.Second,
+				}, time.Now())
+				if session.state != testcase.expectedStates[i] {
+					t.Errorf("expected state %s after receiving %s, got %s", testcase.expectedStates[i],
+						remoteState, session.state)
+				}
+			}
+		})
+	}
+}
+
+func Test_bfdSessionDetectionTime(t *testing.T) {
+	events := make(chan bfdEvent, 10)
+	session := newBFDSession(net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"),
+		bfdSessionConfig{DesiredMinTxInterval: 100 * time.Millisecond, RequiredMinRxInterval: 100 * time.Millisecond,
+			DetectMultiplier: 3}, events)
+	now := time.Now()
+	session.receive(&bfdControlPacket{State: bfdStateInit, DetectMult: 5, MyDiscriminator: 42,
+		YourDiscriminator: session.localDiscr, DesiredMinTxInterval: 200 * time.Millisecond,
+		RequiredMinRxInterval: 100 * time.Millisecond}, now)
+	if session.state != bfdStateUp {
+		t.Fatalf("expected the session to be up, got %s", session.state)
+	}
+
+	// the detection time is the remote detect multiplier times the slowest of the remote transmit interval and the
+	// local receive interval
+	if left := session.checkDetectionTime(now.Add(999 * time.Millisecond)); left != time.Millisecond {
+		t.Errorf("expected 1ms left before detection time expires, got %s", left)
+	}
+	if session.state != bfdStateUp {
+		t.Errorf("expected the session to still be up, got %s", session.state)
+	}
+	session.checkDetectionTime(now.Add(time.Second))
+	if session.state != bfdStateDown || session.diag != bfdDiagDetectionTimeExpire {
+		t.Errorf("expected the session to be down on detection time expiry, got %s with diagnostic %d",
+			session.state, session.diag)
+	}
+
+	expectedEvents := []bfdEvent{
+		{peer: "10.0.0.2", oldState: bfdStateDown, newState: bfdStateUp, remoteState: bfdStateInit},
+		{peer: "10.0.0.2", oldState: bfdStateUp, newState: bfdStateDown, remoteState: bfdStateInit,
+			diag: bfdDiagDetectionTimeExpire},
+	}
+	for _, expected := range expectedEvents {
+		event := <-events
+		if !reflect.DeepEqual(event, expected) {
+			t.Errorf("expected event %+v, got %+v", expected, event)
+		}
+	}
+}
+
+func getFreeUDPPort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("failed to find a free UDP port: %v", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func Test_bfdManager(t *testing.T) {
+	loopback := net.ParseIP("127.0.0.1")
+	config := bfdSessionConfig{
+		DesiredMinTxInterval:  20 * time.Millisecond,
+		RequiredMinRxInterval: 20 * time.Millisecond,
+		DetectMultiplier:      3,
+	}
+	portA, portB := getFreeUDPPort(t), getFreeUDPPort(t)
+
+	eventsA := make(chan bfdEvent, 10)
+	managerA := newBFDManager(func(event bfdEvent) { eventsA <- event })
+	managerA.port, managerA.remotePort = portA, portB
+	managerB := newBFDManager(nil)
+	managerB.port, managerB.remotePort = portB, portA
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	managerA.run(stopCh, &wg)
+	managerB.run(stopCh, &wg)
+	defer func() {
+		close(stopCh)
+		wg.Wait()
+	}()
+
+	managerA.sync(map[string]bfdPeer{"127.0.0.1": {localAddress: loopback, config: config}})
+	managerB.sync(map[string]bfdPeer{"127.0.0.1": {localAddress: loopback, config: config}})
+
+	waitForState := func(expected bfdState) bfdEvent {
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case event := <-eventsA:
+				if event.newState == expected {
+					return event
+				}
+			case <-timeout:
+				state, _ := managerA.getState("127.0.0.1")
+				t.Fatalf("timed out waiting for the session to be %s, it is %s", expected, state)
+			}
+		}
+	}
+	waitForState(bfdStateUp)
+
+	// the timers can change while the session is up
+	config.DesiredMinTxInterval = 10 * time.Millisecond
+	managerA.sync(map[string]bfdPeer{"127.0.0.1": {localAddress: loopback, config: config}})
+	time.Sleep(200 * time.Millisecond)
+	if state, _ := managerA.getState("127.0.0.1"); state != bfdStateUp {
+		t.Fatalf("expected the session to stay up after changing its timers, it is %s", state)
+	}
+
+	// removing the session on one side administratively takes it down on the other side
+	managerB.sync(map[string]bfdPeer{})
+	event := waitForState(bfdStateDown)
+	if event.remoteState != bfdStateAdminDown {
+		t.Errorf("expected the session to go down as the peer is admin down, got %+v", event)
+	}
+
+	managerA.sync(map[string]bfdPeer{})
+	if _, ok := managerA.getState("127.0.0.1"); ok {
+		t.Error("expected the session to be removed")
+	}
+}
+
+func Test_bfdManagerStop(t *testing.T) {
+	manager := newBFDManager(nil)
+	manager.port, manager.remotePort = getFreeUDPPort(t), getFreeUDPPort(t)
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	manager.run(stopCh, &wg)
+
+	manager.sync(map[string]bfdPeer{"127.0.0.1": {localAddress: net.ParseIP("127.0.0.1"),
+		config: bfdSessionConfig{DesiredMinTxInterval: time.Second, RequiredMinRxInterval: time.Second,
+			DetectMultiplier: 3}}})
+	manager.mu.Lock()
+	session, ok := manager.sessions["127.0.0.1"]
+	manager.mu.Unlock()
+	if !ok {
+		t.Fatal("expected a session to be created for the peer")
+	}
+
+	// the sessions have sent their last control packet and closed their socket once the manager is stopped
+	close(stopCh)
+	wg.Wait()
+	_, err := session.conn.WriteToUDP([]byte{0}, &net.UDPAddr{IP: session.peer, Port: session.remotePort})
+	if !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected the session socket to be closed when the manager is stopped, got %v", err)
+	}
+}
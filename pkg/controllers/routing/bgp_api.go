@@ -0,0 +1,161 @@
+package routing
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"k8s.io/klog/v2"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+)
+
+const (
+	bgpAPIPort = 50051
+
+	bgpAPIListenNodeIP   = "node-ip"
+	bgpAPIListenLoopback = "loopback"
+	bgpAPIListenUnix     = "unix"
+	bgpAPIListenNone     = "none"
+
+	bgpAPISocketDirPerm = 0700
+)
+
+// bgpAPIListenAddresses returns the addresses the GoBGP gRPC API listens on, none when the API is disabled. The
+// loopback address is kept whenever the API listens on TCP so that the gobgp CLI of the node keeps working.
+func bgpAPIListenAddresses(listen string, socket string, nodeIP net.IP) ([]string, error) {
+	loopback := net.JoinHostPort("127.0.0.1", strconv.Itoa(bgpAPIPort))
+	switch listen {
+	case bgpAPIListenNodeIP:
+		return []string{net.JoinHostPort(nodeIP.String(), strconv.Itoa(bgpAPIPort)), loopback}, nil
+	case bgpAPIListenLoopback:
+		return []string{loopback}, nil
+	case bgpAPIListenUnix:
+		if !filepath.IsAbs(socket) {
+			return nil, fmt.Errorf("the BGP API socket must be an absolute path, got \"%s\"", socket)
+		}
+		return []string{"unix://" + socket}, nil
+	case bgpAPIListenNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown BGP API listen mode \"%s\", expected one of %s, %s, %s or %s", listen,
+			bgpAPIListenNodeIP, bgpAPIListenLoopback, bgpAPIListenUnix, bgpAPIListenNone)
+	}
+}
+
+// newBGPAPIServerOptions returns the options of the BGP server setting up its gRPC API from the configuration, none
+// when the API is disabled
+func newBGPAPIServerOptions(config *options.KubeRouterConfig, nodeIP net.IP) ([]gobgp.ServerOption, error) {
+	addresses, err := bgpAPIListenAddresses(config.BGPAPIListen, config.BGPAPISocket, nodeIP)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := newBGPAPICredentials(config.BGPAPITLSCertFile, config.BGPAPITLSKeyFile,
+		config.BGPAPITLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the BGP API TLS configuration: %s", err)
+	}
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+
+	opts := []gobgp.ServerOption{gobgp.GrpcListenAddress(strings.Join(addresses, ","))}
+	if creds != nil {
+		if config.BGPAPIListen != bgpAPIListenNodeIP {
+			klog.Warningf("The BGP API only listens on local addresses with --bgp-api-listen=%s, its clients "+
+				"are not required to authenticate with TLS", config.BGPAPIListen)
+		}
+		opts = append(opts, gobgp.GrpcOption([]grpc.ServerOption{grpc.Creds(creds)}))
+	}
+	return opts, nil
+}
+
+// newBGPAPICredentials returns the credentials requiring the clients of the GoBGP gRPC API to authenticate with a
+// certificate signed by the client CA, nil when TLS is not configured
+func newBGPAPICredentials(certFile string, keyFile string, clientCAFile string) (credentials.TransportCredentials,
+	error) {
+	if certFile == "" && keyFile == "" && clientCAFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" || clientCAFile == "" {
+		return nil, errors.New("the certificate, the private key and the client CA must all be set to enable " +
+			"mutual TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the certificate: %s", err)
+	}
+	clientCA, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the client CA: %s", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(clientCA) {
+		return nil, fmt.Errorf("no PEM certificate found in %s", clientCAFile)
+	}
+	return &localPlaintextCredentials{
+		TransportCredentials: credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+			MinVersion:   tls.VersionTLS12,
+		}),
+		plaintext: insecure.NewCredentials(),
+	}, nil
+}
+
+// localPlaintextCredentials secures the connections to the GoBGP gRPC API with TLS, except the ones through the
+// loopback address or a Unix socket, as the gobgp CLI can't present a client certificate
+type localPlaintextCredentials struct {
+	credentials.TransportCredentials
+	plaintext credentials.TransportCredentials
+}
+
+func (c *localPlaintextCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if isLocalConn(conn) {
+		return c.plaintext.ServerHandshake(conn)
+	}
+	return c.TransportCredentials.ServerHandshake(conn)
+}
+
+func (c *localPlaintextCredentials) Clone() credentials.TransportCredentials {
+	return &localPlaintextCredentials{
+		TransportCredentials: c.TransportCredentials.Clone(),
+		plaintext:            c.plaintext.Clone(),
+	}
+}
+
+// isLocalConn returns whether the connection comes from the node itself, through the loopback address or a Unix
+// socket
+func isLocalConn(conn net.Conn) bool {
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.UnixAddr:
+		return true
+	case *net.TCPAddr:
+		return addr.IP.IsLoopback()
+	default:
+		return false
+	}
+}
+
+// prepareBGPAPISocket creates the directory of the Unix socket of the GoBGP gRPC API and removes the socket left by a
+// previous run, which would prevent the API from listening
+func prepareBGPAPISocket(socket string) error {
+	if err := os.MkdirAll(filepath.Dir(socket), bgpAPISocketDirPerm); err != nil {
+		return fmt.Errorf("failed to create the directory of the BGP API socket: %s", err)
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove the stale BGP API socket: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package routing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+)
+
+// newTestCertificate writes a certificate signed by the given CA, or a self-signed CA when it is nil, along with its
+// private key to the directory
+func newTestCertificate(t *testing.T, dir string, name string, ca *tls.Certificate) (*tls.Certificate, string,
+	string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{name},
+	}
+	parent, signer := template, interface{}(key)
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, certFile, keyFile
+}
+
+// remoteAddrConn overrides the remote address of a connection
+type remoteAddrConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (c *remoteAddrConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func Test_bgpAPIListenAddresses(t *testing.T) {
+	testcases := []struct {
+		name              string
+		listen            string
+		socket            string
+		expectedAddresses []string
+		expectError       bool
+	}{
+		{"node IP", bgpAPIListenNodeIP, "", []string{"10.0.0.1:50051", "127.0.0.1:50051"}, false},
+		{"loopback", bgpAPIListenLoopback, "", []string{"127.0.0.1:50051"}, false},
+		{"unix socket", bgpAPIListenUnix, "/var/run/kube-router/gobgp.sock",
+			[]string{"unix:///var/run/kube-router/gobgp.sock"}, false},
+		{"relative unix socket", bgpAPIListenUnix, "gobgp.sock", nil, true},
+		{"disabled", bgpAPIListenNone, "", nil, false},
+		{"unknown mode", "all", "", nil, true},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			addresses, err := bgpAPIListenAddresses(testcase.listen, testcase.socket, net.ParseIP("10.0.0.1"))
+			if testcase.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testcase.expectedAddresses, addresses)
+		})
+	}
+
+	addresses, err := bgpAPIListenAddresses(bgpAPIListenNodeIP, "", net.ParseIP("2001:db8::1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"[2001:db8::1]:50051", "127.0.0.1:50051"}, addresses)
+}
+
+func Test_newBGPAPICredentials(t *testing.T) {
+	dir := t.TempDir()
+	ca, caFile, _ := newTestCertificate(t, dir, "ca", nil)
+	_, certFile, keyFile := newTestCertificate(t, dir, "server", ca)
+	client, _, _ := newTestCertificate(t, dir, "client", ca)
+	otherCA, _, _ := newTestCertificate(t, dir, "other-ca", nil)
+	otherClient, _, _ := newTestCertificate(t, dir, "other-client", otherCA)
+
+	creds, err := newBGPAPICredentials("", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, creds, "TLS should be disabled when it is not configured")
+	_, err = newBGPAPICredentials(certFile, keyFile, "")
+	assert.Error(t, err, "mutual TLS should require a client CA")
+	_, err = newBGPAPICredentials(certFile, keyFile, keyFile)
+	assert.Error(t, err, "the client CA should be a PEM certificate")
+
+	creds, err = newBGPAPICredentials(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("failed to create credentials: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	handshake := func(remoteAddr net.Addr, clientCert *tls.Certificate) (string, error) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		defer listener.Close()
+		clientConn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer clientConn.Close()
+		serverConn, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("failed to accept: %v", err)
+		}
+		defer serverConn.Close()
+		config := &tls.Config{RootCAs: roots, ServerName: "server", MinVersion: tls.VersionTLS12}
+		if clientCert != nil {
+			config.Certificates = []tls.Certificate{*clientCert}
+		}
+		go func() {
+			_ = tls.Client(clientConn, config).Handshake()
+		}()
+		_, authInfo, err := creds.ServerHandshake(&remoteAddrConn{Conn: serverConn, remoteAddr: remoteAddr})
+		if err != nil {
+			return "", err
+		}
+		return authInfo.AuthType(), nil
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 40000}
+	authType, err := handshake(remote, client)
+	assert.NoError(t, err)
+	assert.Equal(t, "tls", authType)
+	_, err = handshake(remote, nil)
+	assert.Error(t, err, "remote clients without certificate should be rejected")
+	_, err = handshake(remote, otherClient)
+	assert.Error(t, err, "remote clients with a certificate of another CA should be rejected")
+
+	for _, local := range []net.Addr{
+		&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 40000},
+		&net.UnixAddr{Name: "@", Net: "unix"},
+	} {
+		authType, err = handshake(local, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "insecure", authType, "local clients should not have to use TLS")
+	}
+}
+
+func Test_newBGPAPIServerOptionsUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "kube-router", "gobgp.sock")
+	config := options.NewKubeRouterConfig()
+	config.BGPAPIListen = bgpAPIListenUnix
+	config.BGPAPISocket = socket
+	opts, err := newBGPAPIServerOptions(config, net.ParseIP("10.0.0.1"))
+	if err != nil {
+		t.Fatalf("failed to create BGP API options: %v", err)
+	}
+
+	// a socket left by a previous run must not prevent the API from listening
+	if err = os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		t.Fatalf("failed to create socket directory: %v", err)
+	}
+	if err = os.WriteFile(socket, nil, 0600); err != nil {
+		t.Fatalf("failed to create stale socket: %v", err)
+	}
+	if err = prepareBGPAPISocket(socket); err != nil {
+		t.Fatalf("failed to prepare BGP API socket: %v", err)
+	}
+	server := gobgp.NewBgpServer(opts...)
+	go server.Serve()
+	defer server.Stop()
+	err = server.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 64512, RouterId: "10.0.0.1", ListenPort: -1},
+	})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "unix://"+socket, grpc.WithBlock(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to connect to the BGP API: %v", err)
+	}
+	defer conn.Close()
+	response, err := gobgpapi.NewGobgpApiClient(conn).GetBgp(ctx, &gobgpapi.GetBgpRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(64512), response.GetGlobal().GetAsn())
+}
@@ -0,0 +1,206 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"k8s.io/klog/v2"
+)
+
+// bfdPeerConfig is the BFD configuration of a peer, overriding the one set through flags
+type bfdPeerConfig struct {
+	enabled bool
+	config  bfdSessionConfig
+}
+
+// validateBFDSessionConfig checks that the timers of a BFD session can be encoded in control packets
+func validateBFDSessionConfig(config bfdSessionConfig) error {
+	maxInterval := time.Duration(math.MaxUint32) * time.Microsecond
+	if config.DesiredMinTxInterval <= 0 || config.DesiredMinTxInterval > maxInterval {
+		return fmt.Errorf("BFD minimum transmit interval must be in the range 1us to %s", maxInterval)
+	}
+	if config.RequiredMinRxInterval <= 0 || config.RequiredMinRxInterval > maxInterval {
+		return fmt.Errorf("BFD minimum receive interval must be in the range 1us to %s", maxInterval)
+	}
+	if config.DetectMultiplier == 0 {
+		return fmt.Errorf("BFD detect multiplier must be at least 1")
+	}
+	return nil
+}
+
+// newBFDPeerConfig returns the BFD configuration of a BGPPeer, the settings it doesn't specify default to the flags
+func (nrc *NetworkRoutingController) newBFDPeerConfig(spec *v1alpha1.BFDSpec) (*bfdPeerConfig, error) {
+	peerConfig := &bfdPeerConfig{enabled: nrc.enableBFD, config: nrc.bfdConfig}
+	if spec.Enabled != nil {
+		peerConfig.enabled = *spec.Enabled
+	}
+	if spec.MinTxInterval != nil {
+		peerConfig.config.DesiredMinTxInterval = spec.MinTxInterval.Duration
+	}
+	if spec.MinRxInterval != nil {
+		peerConfig.config.RequiredMinRxInterval = spec.MinRxInterval.Duration
+	}
+	if spec.DetectMultiplier != 0 {
+		if spec.DetectMultiplier > math.MaxUint8 {
+			return nil, fmt.Errorf("BFD detect multiplier must be in the range 1 to %d", math.MaxUint8)
+		}
+		peerConfig.config.DetectMultiplier = uint8(spec.DetectMultiplier)
+	}
+	if err := validateBFDSessionConfig(peerConfig.config); err != nil {
+		return nil, err
+	}
+	return peerConfig, nil
+}
+
+// syncBFDSessions reconciles the BFD sessions with the peers of the BGP server, internal and external alike. Peers
+// with eBGP multihop are skipped as only single hop BFD is supported.
+func (nrc *NetworkRoutingController) syncBFDSessions() {
+	if nrc.bfdManager == nil {
+		return
+	}
+
+	nrc.bgpPeersMu.Lock()
+	overrides := make(map[string]bfdPeerConfig, len(nrc.bgpPeerBFD))
+	for address, peerConfig := range nrc.bgpPeerBFD {
+		overrides[address] = *peerConfig
+	}
+	nrc.bgpPeersMu.Unlock()
+
+	desired := make(map[string]bfdPeer)
+	err := nrc.bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{}, func(peer *gobgpapi.Peer) {
+		address := peer.Conf.NeighborAddress
+		peerConfig := bfdPeerConfig{enabled: nrc.enableBFD, config: nrc.bfdConfig}
+		if override, ok := overrides[address]; ok {
+			peerConfig = override
+		}
+		if !peerConfig.enabled {
+			return
+		}
+		if peer.EbgpMultihop != nil && peer.EbgpMultihop.Enabled && peer.EbgpMultihop.MultihopTtl > 1 {
+			klog.V(2).Infof("Not running BFD with peer %s as multihop BFD is not supported", address)
+			return
+		}
+		peerIP := net.ParseIP(address)
+		if peerIP == nil {
+			return
+		}
+		var localAddress net.IP
+		if peer.Transport != nil {
+			localAddress = net.ParseIP(peer.Transport.LocalAddress)
+		}
+		if localAddress == nil || localAddress.IsUnspecified() {
+			localAddress = nrc.getNodeIPForFamily(peerIP.To4() == nil)
+		}
+		desired[address] = bfdPeer{localAddress: localAddress, config: peerConfig.config}
+	})
+	if err != nil {
+		klog.Errorf("Failed to list BGP peers to sync BFD sessions: %s", err)
+		return
+	}
+
+	nrc.bfdManager.sync(desired)
+
+	// peers that were shut down by BFD but no longer run it are brought back up
+	nrc.bfdMu.Lock()
+	defer nrc.bfdMu.Unlock()
+	for address := range nrc.bfdDisabledPeers {
+		if _, ok := desired[address]; ok {
+			continue
+		}
+		nrc.enableBFDPeer(address)
+	}
+}
+
+// OnBFDStateChange handles the state changes of the BFD sessions. The BGP peer is shut down as soon as its BFD
+// session fails, withdrawing the routes injected from its paths, and started again once the session is up.
+func (nrc *NetworkRoutingController) OnBFDStateChange(event bfdEvent) {
+	nrc.bfdMu.Lock()
+	defer nrc.bfdMu.Unlock()
+
+	switch {
+	case event.newState == bfdStateUp:
+		if nrc.bfdDisabledPeers[event.peer] {
+			nrc.enableBFDPeer(event.peer)
+		}
+	case event.oldState == bfdStateUp:
+		// a peer administratively removing the session is not a forwarding failure, see section 6.8.6 of RFC 5880
+		if event.remoteState == bfdStateAdminDown {
+			return
+		}
+		nrc.disableBFDPeer(event.peer)
+	}
+}
+
+// disableBFDPeer shuts down the BGP peer and withdraws the routes injected from its best paths. It must be called with
+// the bfdMu lock held.
+func (nrc *NetworkRoutingController) disableBFDPeer(address string) {
+	paths := nrc.getPeerBestPaths(address)
+
+	err := nrc.bgpServer.DisablePeer(context.Background(), &gobgpapi.DisablePeerRequest{
+		Address:       address,
+		Communication: "BFD session down",
+	})
+	if err != nil {
+		klog.Errorf("Failed to shut down BGP peer %s after its BFD session went down: %s", address, err)
+		return
+	}
+	if nrc.bfdDisabledPeers == nil {
+		nrc.bfdDisabledPeers = make(map[string]bool)
+	}
+	nrc.bfdDisabledPeers[address] = true
+	klog.Warningf("Shut down BGP peer %s as its BFD session went down", address)
+
+	for _, path := range paths {
+		path.IsWithdraw = true
+		if err := nrc.injectRoute(path); err != nil {
+			klog.Errorf("Failed to withdraw route learned from BGP peer %s: %s", address, err)
+		}
+	}
+}
+
+// enableBFDPeer starts the BGP peer shut down by BFD again, unless it is shut down for exceeding its maximum number of
+// prefixes, in which case it is started after the restart time. It must be called with the bfdMu lock held.
+func (nrc *NetworkRoutingController) enableBFDPeer(address string) {
+	if nrc.isMaxPrefixesDisabledPeer(address) {
+		delete(nrc.bfdDisabledPeers, address)
+		klog.Infof("BFD session of BGP peer %s is up, it is started again after its maximum prefixes restart time",
+			address)
+		return
+	}
+	err := nrc.bgpServer.EnablePeer(context.Background(), &gobgpapi.EnablePeerRequest{Address: address})
+	if err != nil {
+		klog.Errorf("Failed to start BGP peer %s again: %s", address, err)
+		return
+	}
+	delete(nrc.bfdDisabledPeers, address)
+	klog.Infof("Started BGP peer %s again as its BFD session is up", address)
+}
+
+// getPeerBestPaths returns the best paths of the global RIB learned from the peer
+func (nrc *NetworkRoutingController) getPeerBestPaths(address string) []*gobgpapi.Path {
+	var paths []*gobgpapi.Path
+	for _, family := range []*gobgpapi.Family{
+		{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
+		{Afi: gobgpapi.Family_AFI_IP6, Safi: gobgpapi.Family_SAFI_UNICAST},
+	} {
+		err := nrc.bgpServer.ListPath(context.Background(), &gobgpapi.ListPathRequest{
+			TableType: gobgpapi.TableType_GLOBAL,
+			Family:    family,
+		}, func(destination *gobgpapi.Destination) {
+			for _, path := range destination.Paths {
+				if path.Best && path.NeighborIp == address {
+					paths = append(paths, path)
+				}
+			}
+		})
+		if err != nil {
+			klog.Errorf("Failed to list the paths learned from BGP peer %s: %s", address, err)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,111 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+)
+
+func listPeerAdminStates(t *testing.T, server *gobgp.BgpServer) map[string]gobgpapi.PeerState_AdminState {
+	states := make(map[string]gobgpapi.PeerState_AdminState)
+	err := server.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{}, func(peer *gobgpapi.Peer) {
+		states[peer.Conf.NeighborAddress] = peer.State.AdminState
+	})
+	if err != nil {
+		t.Fatalf("failed to list peers: %v", err)
+	}
+	return states
+}
+
+func Test_OnBFDStateChange(t *testing.T) {
+	testcases := []struct {
+		name          string
+		events        []bfdEvent
+		expectedState gobgpapi.PeerState_AdminState
+	}{
+		{
+			"peer is shut down when its session fails",
+			[]bfdEvent{
+				{peer: "10.0.0.254", oldState: bfdStateUp, newState: bfdStateDown, remoteState: bfdStateUp,
+					diag: bfdDiagDetectionTimeExpire},
+			},
+			gobgpapi.PeerState_DOWN,
+		},
+		{
+			"peer is started again when its session is back up",
+			[]bfdEvent{
+				{peer: "10.0.0.254", oldState: bfdStateUp, newState: bfdStateDown, remoteState: bfdStateDown,
+					diag: bfdDiagNeighborSignaled},
+				{peer: "10.0.0.254", oldState: bfdStateInit, newState: bfdStateUp, remoteState: bfdStateUp},
+			},
+			gobgpapi.PeerState_UP,
+		},
+		{
+			"peer is left up when the session is administratively removed",
+			[]bfdEvent{
+				{peer: "10.0.0.254", oldState: bfdStateUp, newState: bfdStateDown, remoteState: bfdStateAdminDown,
+					diag: bfdDiagNeighborSignaled},
+			},
+			gobgpapi.PeerState_UP,
+		},
+		{
+			"peer is left up when the session never came up",
+			[]bfdEvent{
+				{peer: "10.0.0.254", oldState: bfdStateInit, newState: bfdStateDown, remoteState: bfdStateInit,
+					diag: bfdDiagDetectionTimeExpire},
+			},
+			gobgpapi.PeerState_UP,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			nrc := &NetworkRoutingController{
+				nodeIP:    net.ParseIP("10.0.0.1"),
+				bgpServer: gobgp.NewBgpServer(),
+			}
+			go nrc.bgpServer.Serve()
+			err := nrc.bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+				Global: &gobgpapi.Global{Asn: 64512, RouterId: "10.0.0.1", ListenPort: -1},
+			})
+			if err != nil {
+				t.Fatalf("failed to start BGP server: %v", err)
+			}
+			defer func() {
+				if err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+					t.Fatalf("failed to stop BGP server: %v", err)
+				}
+			}()
+			err = nrc.bgpServer.AddPeer(context.Background(), &gobgpapi.AddPeerRequest{Peer: &gobgpapi.Peer{
+				Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.0.254", PeerAsn: 65000},
+			}})
+			if err != nil {
+				t.Fatalf("failed to add peer: %v", err)
+			}
+
+			for _, event := range testcase.events {
+				nrc.OnBFDStateChange(event)
+			}
+
+			// the admin state of a peer is changed asynchronously by gobgp
+			var states map[string]gobgpapi.PeerState_AdminState
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+				states = listPeerAdminStates(t, nrc.bgpServer)
+				if states["10.0.0.254"] == testcase.expectedState {
+					break
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			if states["10.0.0.254"] != testcase.expectedState {
+				t.Errorf("expected peer admin state %s, got %s", testcase.expectedState, states["10.0.0.254"])
+			}
+			if nrc.bfdDisabledPeers["10.0.0.254"] != (testcase.expectedState == gobgpapi.PeerState_DOWN) {
+				t.Errorf("unexpected peers shut down by BFD: %v", nrc.bfdDisabledPeers)
+			}
+		})
+	}
+}
@@ -0,0 +1,442 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"google.golang.org/protobuf/proto"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+)
+
+var (
+	// Error returned when the password of a BGPPeer can not be read from its Secret.
+	errBGPPeerPassword = errors.New("failed to read the peer password")
+)
+
+// fromUnstructured converts an object received from a dynamic informer, or its tombstone, into the given kube-router
+// custom resource
+func fromUnstructured(obj interface{}, into interface{}) error {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected object type: %T", obj)
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), into)
+}
+
+// getBGPConfiguration returns the cluster-wide BGPConfiguration, nil is returned when the BGP custom resources are
+// not enabled or the resource does not exist
+func (nrc *NetworkRoutingController) getBGPConfiguration() (*v1alpha1.BGPConfiguration, error) {
+	if nrc.bgpConfigLister == nil {
+		return nil, nil
+	}
+	obj, exists, err := nrc.bgpConfigLister.GetByKey(v1alpha1.DefaultBGPConfigurationName)
+	if err != nil || !exists {
+		return nil, err
+	}
+	bgpConfig := &v1alpha1.BGPConfiguration{}
+	if err := fromUnstructured(obj, bgpConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse BGPConfiguration %s: %s", v1alpha1.DefaultBGPConfigurationName, err)
+	}
+	return bgpConfig, nil
+}
+
+// applyBGPConfiguration applies the settings of the cluster-wide BGPConfiguration that can only be changed when the
+// BGP server is started, they take precedence over the equivalent command line flags
+func (nrc *NetworkRoutingController) applyBGPConfiguration(bgpConfig *v1alpha1.BGPConfiguration) error {
+	if bgpConfig == nil {
+		return nil
+	}
+	if bgpConfig.Spec.ASN != 0 {
+		if !isValidClusterAsn(bgpConfig.Spec.ASN) {
+			return fmt.Errorf("invalid ASN number %d in BGPConfiguration %s", bgpConfig.Spec.ASN, bgpConfig.Name)
+		}
+		klog.Infof("Using cluster ASN %d from BGPConfiguration %s", bgpConfig.Spec.ASN, bgpConfig.Name)
+		nrc.defaultNodeAsnNumber = bgpConfig.Spec.ASN
+	}
+	if bgpConfig.Spec.NodeToNodeMesh != nil {
+		klog.Infof("Setting node to node mesh to %t from BGPConfiguration %s", *bgpConfig.Spec.NodeToNodeMesh,
+			bgpConfig.Name)
+		nrc.bgpFullMeshMode = *bgpConfig.Spec.NodeToNodeMesh
+	}
+	spec := bgpConfig.Spec
+	nrc.bgpConfigSpec = &spec
+	return nil
+}
+
+func (nrc *NetworkRoutingController) newBGPPeerEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nrc.OnBGPPeerUpdate(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			nrc.OnBGPPeerUpdate(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			nrc.OnBGPPeerUpdate(obj)
+		},
+	}
+}
+
+// OnBGPPeerUpdate handles the updates of the BGPPeer watcher by reconciling the peers of the BGP server with the
+// BGPPeers selecting the node
+func (nrc *NetworkRoutingController) OnBGPPeerUpdate(obj interface{}) {
+	if !nrc.bgpServerStarted {
+		return
+	}
+	peer := &v1alpha1.BGPPeer{}
+	if err := fromUnstructured(obj, peer); err == nil {
+		klog.V(2).Infof("Received update of BGPPeer %s from watch API, syncing BGP peers", peer.Name)
+	}
+	nrc.syncBGPPeersAndPolicies()
+}
+
+func (nrc *NetworkRoutingController) newBGPConfigurationEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nrc.OnBGPConfigurationUpdate(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			nrc.OnBGPConfigurationUpdate(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			nrc.OnBGPConfigurationUpdate(obj)
+		},
+	}
+}
+
+// OnBGPConfigurationUpdate handles the updates of the BGPConfiguration watcher. The hold time is applied to the
+// BGPPeers right away, while changing the ASN or the node to node mesh requires kube-router to be restarted.
+func (nrc *NetworkRoutingController) OnBGPConfigurationUpdate(obj interface{}) {
+	bgpConfig := &v1alpha1.BGPConfiguration{}
+	if err := fromUnstructured(obj, bgpConfig); err != nil {
+		klog.Errorf("Failed to parse BGPConfiguration: %s", err)
+		return
+	}
+	if bgpConfig.Name != v1alpha1.DefaultBGPConfigurationName {
+		klog.V(1).Infof("Ignoring BGPConfiguration %s, only the one named %s is used", bgpConfig.Name,
+			v1alpha1.DefaultBGPConfigurationName)
+		return
+	}
+
+	current, err := nrc.getBGPConfiguration()
+	if err != nil {
+		klog.Errorf("Failed to get BGPConfiguration: %s", err)
+		return
+	}
+	var spec v1alpha1.BGPConfigurationSpec
+	if current != nil {
+		spec = current.Spec
+	}
+	var applied v1alpha1.BGPConfigurationSpec
+	if nrc.bgpConfigSpec != nil {
+		applied = *nrc.bgpConfigSpec
+	}
+	if spec.ASN != applied.ASN || !boolPtrEqual(spec.NodeToNodeMesh, applied.NodeToNodeMesh) {
+		klog.Warningf("The ASN or node to node mesh setting of BGPConfiguration %s changed, kube-router must be "+
+			"restarted for the change to take effect", v1alpha1.DefaultBGPConfigurationName)
+	}
+
+	if !nrc.bgpServerStarted {
+		return
+	}
+	nrc.syncBGPPeersAndPolicies()
+}
+
+func boolPtrEqual(a, b *bool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// syncBGPPeersAndPolicies reconciles the BGPPeers of the node, then updates the policies so that the external peer
+// neighbor set matches them and the BFD sessions so that they follow the peers
+func (nrc *NetworkRoutingController) syncBGPPeersAndPolicies() {
+	err := nrc.syncBGPPeers()
+	if err != nil {
+		klog.Errorf("Error syncing BGP peers from BGPPeer resources: %s", err)
+	}
+	err = nrc.AddPolicies()
+	if err != nil {
+		klog.Errorf("Error adding BGP policies: %s", err)
+	}
+}
+
+// syncBGPPeers reconciles the peers of the BGP server with the BGPPeer resources selecting the node. Peers are added,
+// updated and removed so that they match the resources, leaving the peers configured through flags or node
+// annotations untouched.
+func (nrc *NetworkRoutingController) syncBGPPeers() error {
+	if nrc.bgpPeerLister == nil {
+		return nil
+	}
+
+	// the BFD sessions are synced with the peers once they are added, updated or removed, after the lock is released
+	defer nrc.syncBFDSessions()
+	nrc.bgpPeersMu.Lock()
+	defer nrc.bgpPeersMu.Unlock()
+
+	node, err := nrc.getLocalNode()
+	if err != nil {
+		return err
+	}
+	bgpConfig, err := nrc.getBGPConfiguration()
+	if err != nil {
+		return err
+	}
+
+	staticPeers := make(map[string]bool)
+	for _, peer := range nrc.globalPeerRouters {
+		staticPeers[peer.Conf.NeighborAddress] = true
+	}
+
+	desiredPeers := make(map[string]*gobgpapi.Peer)
+	desiredBFD := make(map[string]*bfdPeerConfig)
+	desiredImports := make(map[string]*bgpPeerImport)
+	for _, obj := range nrc.bgpPeerLister.List() {
+		bgpPeer := &v1alpha1.BGPPeer{}
+		if err := fromUnstructured(obj, bgpPeer); err != nil {
+			klog.Errorf("Failed to parse BGPPeer: %s", err)
+			continue
+		}
+		selected, err := bgpPeerSelectsNode(bgpPeer, node)
+		if err != nil {
+			klog.Errorf("Invalid node selector in BGPPeer %s: %s", bgpPeer.Name, err)
+			continue
+		}
+		if !selected {
+			continue
+		}
+		peer, err := nrc.newPeerFromBGPPeer(bgpPeer, bgpConfig)
+		if err != nil {
+			// the session of an established peer is not torn down because its password Secret can not be read, the
+			// peer keeps its current config until the Secret is readable again
+			currentPeer, ok := nrc.bgpPeers[net.ParseIP(bgpPeer.Spec.PeerAddress).String()]
+			if !errors.Is(err, errBGPPeerPassword) || !ok {
+				klog.Errorf("Failed to process BGPPeer %s: %s", bgpPeer.Name, err)
+				continue
+			}
+			klog.Errorf("Failed to process BGPPeer %s, keeping the current config of peer %s: %s", bgpPeer.Name,
+				currentPeer.Conf.NeighborAddress, err)
+			peer = currentPeer
+		}
+		address := peer.Conf.NeighborAddress
+		if staticPeers[address] {
+			klog.Warningf("Ignoring BGPPeer %s as peer %s is already configured through flags or node annotations",
+				bgpPeer.Name, address)
+			continue
+		}
+		if _, ok := desiredPeers[address]; ok {
+			klog.Warningf("Ignoring BGPPeer %s as another BGPPeer selecting the node has the same peer address %s",
+				bgpPeer.Name, address)
+			continue
+		}
+		var peerImport *bgpPeerImport
+		if nrc.installExternalRoutes {
+			peerImport, err = newBGPPeerImport(bgpPeer, address)
+			if err != nil {
+				klog.Errorf("Invalid import filter in BGPPeer %s: %s", bgpPeer.Name, err)
+				continue
+			}
+		}
+		if bgpPeer.Spec.BFD != nil {
+			bfdConfig, err := nrc.newBFDPeerConfig(bgpPeer.Spec.BFD)
+			if err != nil {
+				klog.Errorf("Invalid BFD settings in BGPPeer %s: %s", bgpPeer.Name, err)
+				continue
+			}
+			desiredBFD[address] = bfdConfig
+		}
+		if peerImport != nil {
+			desiredImports[address] = peerImport
+		}
+		desiredPeers[address] = peer
+	}
+	nrc.bgpPeerBFD = desiredBFD
+	nrc.setBGPPeerImports(desiredImports)
+
+	for address, peer := range desiredPeers {
+		currentPeer, ok := nrc.bgpPeers[address]
+		if !ok {
+			err := nrc.bgpServer.AddPeer(context.Background(), &gobgpapi.AddPeerRequest{Peer: peer})
+			if err != nil {
+				klog.Errorf("Failed to add BGP peer %s: %s", address, err)
+				continue
+			}
+			klog.Infof("Added BGP peer %s in ASN %d from BGPPeer resources", address, peer.Conf.PeerAsn)
+			nrc.bgpPeers[address] = peer
+			continue
+		}
+		if proto.Equal(currentPeer, peer) {
+			continue
+		}
+		resp, err := nrc.bgpServer.UpdatePeer(context.Background(), &gobgpapi.UpdatePeerRequest{Peer: peer})
+		if err != nil {
+			klog.Errorf("Failed to update BGP peer %s: %s", address, err)
+			continue
+		}
+		if resp.NeedsSoftResetIn {
+			err = nrc.bgpServer.ResetPeer(context.Background(), &gobgpapi.ResetPeerRequest{Address: address,
+				Soft: true, Direction: gobgpapi.ResetPeerRequest_IN})
+			if err != nil {
+				klog.Errorf("Failed to soft reset BGP peer %s: %s", address, err)
+			}
+		}
+		klog.Infof("Updated BGP peer %s from BGPPeer resources", address)
+		nrc.bgpPeers[address] = peer
+	}
+
+	for address := range nrc.bgpPeers {
+		if _, ok := desiredPeers[address]; ok {
+			continue
+		}
+		err := nrc.bgpServer.DeletePeer(context.Background(), &gobgpapi.DeletePeerRequest{Address: address})
+		if err != nil {
+			klog.Errorf("Failed to remove BGP peer %s: %s", address, err)
+			continue
+		}
+		klog.Infof("Removed BGP peer %s as no BGPPeer resource selecting the node configures it anymore", address)
+		delete(nrc.bgpPeers, address)
+	}
+
+	return nil
+}
+
+// getBGPPeerAddresses returns the addresses of the peers configured from BGPPeer resources
+func (nrc *NetworkRoutingController) getBGPPeerAddresses() []string {
+	nrc.bgpPeersMu.Lock()
+	defer nrc.bgpPeersMu.Unlock()
+
+	addresses := make([]string, 0, len(nrc.bgpPeers))
+	for address := range nrc.bgpPeers {
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// getLocalNode returns the node kube-router runs on from the node lister
+func (nrc *NetworkRoutingController) getLocalNode() (*v1core.Node, error) {
+	obj, exists, err := nrc.nodeLister.GetByKey(nrc.nodeName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("node %s not found in the node lister", nrc.nodeName)
+	}
+	node, ok := obj.(*v1core.Node)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type: %T", obj)
+	}
+	return node, nil
+}
+
+// bgpPeerSelectsNode returns whether the node selector of the BGPPeer matches the node, a BGPPeer without node
+// selector selects all the nodes
+func bgpPeerSelectsNode(bgpPeer *v1alpha1.BGPPeer, node *v1core.Node) (bool, error) {
+	if bgpPeer.Spec.NodeSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(bgpPeer.Spec.NodeSelector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(node.Labels)), nil
+}
+
+// newPeerFromBGPPeer returns the neighbor config of a BGPPeer, the settings left unset in the resource are defaulted
+// from the cluster-wide BGPConfiguration and the command line flags
+func (nrc *NetworkRoutingController) newPeerFromBGPPeer(bgpPeer *v1alpha1.BGPPeer,
+	bgpConfig *v1alpha1.BGPConfiguration) (*gobgpapi.Peer, error) {
+	spec := bgpPeer.Spec
+
+	peerIP := net.ParseIP(spec.PeerAddress)
+	if peerIP == nil {
+		return nil, fmt.Errorf("could not parse peer address \"%s\" as an IP", spec.PeerAddress)
+	}
+
+	localAddress := spec.LocalAddress
+	if localAddress != "" {
+		localIP := net.ParseIP(localAddress)
+		if localIP == nil {
+			return nil, fmt.Errorf("could not parse local address \"%s\" as an IP", localAddress)
+		}
+		if (localIP.To4() == nil) != (peerIP.To4() == nil) {
+			return nil, fmt.Errorf("local address %s and peer address %s are not of the same IP family",
+				localAddress, spec.PeerAddress)
+		}
+	} else {
+		nodeIP := nrc.getNodeIPForFamily(peerIP.To4() == nil)
+		if nodeIP == nil {
+			return nil, fmt.Errorf("the node has no address of the IP family of peer address %s",
+				spec.PeerAddress)
+		}
+		localAddress = nodeIP.String()
+	}
+
+	port := spec.PeerPort
+	if port == 0 {
+		port = options.DefaultBgpPort
+	}
+
+	holdTime := nrc.bgpHoldtime
+	if bgpConfig != nil && bgpConfig.Spec.HoldTime != nil {
+		holdTime = bgpConfig.Spec.HoldTime.Seconds()
+	}
+	if spec.HoldTime != nil {
+		holdTime = spec.HoldTime.Seconds()
+	}
+	if holdTime > 65536 || holdTime < 3 {
+		return nil, errors.New("this is an incorrect BGP holdtime range, holdtime must be in the range " +
+			"3s to 18h12m16s")
+	}
+
+	var password string
+	if spec.PasswordSecretRef != nil {
+		var err error
+		password, err = nrc.getSecretKey(spec.PasswordSecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errBGPPeerPassword, err)
+		}
+	}
+
+	peers, err := newGlobalPeers([]net.IP{peerIP}, []uint32{port}, []uint32{spec.PeerASN}, []string{password},
+		[]uint32{spec.MaxPrefixes}, []string{localAddress}, holdTime, localAddress)
+	if err != nil {
+		return nil, err
+	}
+	peer := peers[0]
+
+	if spec.KeepaliveTime != nil {
+		if spec.KeepaliveTime.Seconds() >= holdTime {
+			return nil, fmt.Errorf("keepalive time %s must be less than the hold time", spec.KeepaliveTime.Duration)
+		}
+		peer.Timers.Config.KeepaliveInterval = uint64(spec.KeepaliveTime.Seconds())
+	}
+
+	multihopTTL := nrc.peerMultihopTTL
+	if spec.EBGPMultihopTTL != 0 {
+		if spec.EBGPMultihopTTL > 255 {
+			return nil, fmt.Errorf("eBGP multihop TTL %d is out of range", spec.EBGPMultihopTTL)
+		}
+		multihopTTL = uint8(spec.EBGPMultihopTTL)
+	}
+
+	nrc.configureExternalPeer(peer, nrc.bgpGracefulRestart, nrc.bgpGracefulRestartDeferralTime,
+		nrc.bgpGracefulRestartTime, multihopTTL)
+
+	return peer, nil
+}
@@ -0,0 +1,433 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+)
+
+func newUnstructured(t *testing.T, obj interface{}) *unstructured.Unstructured {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		t.Fatalf("failed to convert %v to unstructured: %v", obj, err)
+	}
+	return &unstructured.Unstructured{Object: content}
+}
+
+func newTestBGPPeer(name, address string, asn uint32) *v1alpha1.BGPPeer {
+	return &v1alpha1.BGPPeer{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.GroupName + "/" + v1alpha1.Version,
+			Kind:       "BGPPeer",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1alpha1.BGPPeerSpec{
+			PeerAddress: address,
+			PeerASN:     asn,
+		},
+	}
+}
+
+func Test_newPeerFromBGPPeer(t *testing.T) {
+	testcases := []struct {
+		name         string
+		bgpPeer      *v1alpha1.BGPPeer
+		bgpConfig    *v1alpha1.BGPConfiguration
+		secrets      []*v1core.Secret
+		expectedPeer *gobgpapi.Peer
+		expectErr    bool
+	}{
+		{
+			"peer defaults to node IP, standard port and hold time flag",
+			newTestBGPPeer("peer-1", "10.0.0.254", 65000),
+			nil,
+			nil,
+			&gobgpapi.Peer{
+				Conf:      &gobgpapi.PeerConf{NeighborAddress: "10.0.0.254", PeerAsn: 65000},
+				Timers:    &gobgpapi.Timers{Config: &gobgpapi.TimersConfig{HoldTime: 90}},
+				Transport: &gobgpapi.Transport{LocalAddress: "10.0.0.1", RemotePort: 179},
+			},
+			false,
+		},
+		{
+			"peer settings take precedence over the BGPConfiguration",
+			&v1alpha1.BGPPeer{
+				ObjectMeta: metav1.ObjectMeta{Name: "peer-1"},
+				Spec: v1alpha1.BGPPeerSpec{
+					PeerAddress:       "10.0.0.254",
+					PeerASN:           65000,
+					PeerPort:          10179,
+					LocalAddress:      "10.0.0.2",
+					PasswordSecretRef: &v1alpha1.SecretKeyReference{Namespace: "kube-system", Name: "bgp", Key: "pw"},
+					EBGPMultihopTTL:   4,
+					HoldTime:          &metav1.Duration{Duration: 30 * time.Second},
+					KeepaliveTime:     &metav1.Duration{Duration: 10 * time.Second},
+				},
+			},
+			&v1alpha1.BGPConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "default"},
+				Spec:       v1alpha1.BGPConfigurationSpec{HoldTime: &metav1.Duration{Duration: 60 * time.Second}},
+			},
+			[]*v1core.Secret{
+				{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "bgp"},
+					Data:       map[string][]byte{"pw": []byte("secret")},
+				},
+			},
+			&gobgpapi.Peer{
+				Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.0.254", PeerAsn: 65000, AuthPassword: "secret"},
+				Timers: &gobgpapi.Timers{Config: &gobgpapi.TimersConfig{HoldTime: 30,
+					KeepaliveInterval: 10}},
+				Transport:    &gobgpapi.Transport{LocalAddress: "10.0.0.2", RemotePort: 10179},
+				EbgpMultihop: &gobgpapi.EbgpMultihop{Enabled: true, MultihopTtl: 4},
+			},
+			false,
+		},
+		{
+			"hold time is taken from the BGPConfiguration",
+			newTestBGPPeer("peer-1", "10.0.0.254", 65000),
+			&v1alpha1.BGPConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "default"},
+				Spec:       v1alpha1.BGPConfigurationSpec{HoldTime: &metav1.Duration{Duration: 60 * time.Second}},
+			},
+			nil,
+			&gobgpapi.Peer{
+				Conf:      &gobgpapi.PeerConf{NeighborAddress: "10.0.0.254", PeerAsn: 65000},
+				Timers:    &gobgpapi.Timers{Config: &gobgpapi.TimersConfig{HoldTime: 60}},
+				Transport: &gobgpapi.Transport{LocalAddress: "10.0.0.1", RemotePort: 179},
+			},
+			false,
+		},
+		{
+			"missing password secret",
+			&v1alpha1.BGPPeer{
+				ObjectMeta: metav1.ObjectMeta{Name: "peer-1"},
+				Spec: v1alpha1.BGPPeerSpec{
+					PeerAddress:       "10.0.0.254",
+					PeerASN:           65000,
+					PasswordSecretRef: &v1alpha1.SecretKeyReference{Namespace: "kube-system", Name: "bgp", Key: "pw"},
+				},
+			},
+			nil,
+			nil,
+			nil,
+			true,
+		},
+		{
+			"peer of an IP family the node has no address of",
+			newTestBGPPeer("peer-1", "fd00::254", 65000),
+			nil,
+			nil,
+			nil,
+			true,
+		},
+		{
+			"invalid peer address",
+			newTestBGPPeer("peer-1", "10.0.0", 65000),
+			nil,
+			nil,
+			nil,
+			true,
+		},
+		{
+			"reserved peer ASN",
+			newTestBGPPeer("peer-1", "10.0.0.254", 23456),
+			nil,
+			nil,
+			nil,
+			true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			nrc := &NetworkRoutingController{
+				clientset:   fake.NewSimpleClientset(),
+				nodeIP:      net.ParseIP("10.0.0.1"),
+				bgpHoldtime: 90,
+			}
+			for _, secret := range testcase.secrets {
+				_, err := nrc.clientset.CoreV1().Secrets(secret.Namespace).Create(context.Background(), secret,
+					metav1.CreateOptions{})
+				if err != nil {
+					t.Fatalf("failed to create secret: %v", err)
+				}
+			}
+
+			peer, err := nrc.newPeerFromBGPPeer(testcase.bgpPeer, testcase.bgpConfig)
+			if testcase.expectErr != (err != nil) {
+				t.Logf("expected error: %t", testcase.expectErr)
+				t.Logf("actual error: %v", err)
+				t.Error("unexpected error")
+			}
+			if !reflect.DeepEqual(peer, testcase.expectedPeer) {
+				t.Logf("expected peer: %v", testcase.expectedPeer)
+				t.Logf("actual peer: %v", peer)
+				t.Error("unexpected peer")
+			}
+		})
+	}
+}
+
+func listPeerAddresses(t *testing.T, server *gobgp.BgpServer) []string {
+	addresses := make([]string, 0)
+	err := server.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{}, func(peer *gobgpapi.Peer) {
+		addresses = append(addresses, peer.Conf.NeighborAddress)
+	})
+	if err != nil {
+		t.Fatalf("failed to list peers: %v", err)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+func Test_syncBGPPeers(t *testing.T) {
+	rackPeer := newTestBGPPeer("rack-a", "10.0.0.253", 65001)
+	rackPeer.Spec.NodeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"rack": "a"}}
+	otherRackPeer := newTestBGPPeer("rack-b", "10.0.0.252", 65002)
+	otherRackPeer.Spec.NodeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"rack": "b"}}
+
+	nrc := &NetworkRoutingController{
+		clientset:   fake.NewSimpleClientset(),
+		nodeName:    "node-1",
+		nodeIP:      net.ParseIP("10.0.0.1"),
+		bgpHoldtime: 90,
+		bgpServer:   gobgp.NewBgpServer(),
+		bgpPeers:    make(map[string]*gobgpapi.Peer),
+		globalPeerRouters: []*gobgpapi.Peer{
+			{Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.0.100", PeerAsn: 65100}},
+		},
+		nodeLister:    cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		bgpPeerLister: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+	}
+	go nrc.bgpServer.Serve()
+	err := nrc.bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 64512, RouterId: "10.0.0.1", ListenPort: -1},
+	})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+
+	node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"rack": "a"}}}
+	if err := nrc.nodeLister.Add(node); err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+	for _, bgpPeer := range []*v1alpha1.BGPPeer{
+		newTestBGPPeer("all-nodes", "10.0.0.254", 65000),
+		rackPeer,
+		otherRackPeer,
+		newTestBGPPeer("conflicting", "10.0.0.100", 65000),
+	} {
+		if err := nrc.bgpPeerLister.Add(newUnstructured(t, bgpPeer)); err != nil {
+			t.Fatalf("failed to add BGPPeer: %v", err)
+		}
+	}
+
+	steps := []struct {
+		name              string
+		update            func(t *testing.T)
+		expectedAddresses []string
+	}{
+		{
+			"peers selecting the node are added",
+			func(t *testing.T) {},
+			[]string{"10.0.0.253", "10.0.0.254"},
+		},
+		{
+			"peer is updated when its resource changes",
+			func(t *testing.T) {
+				updated := newTestBGPPeer("all-nodes", "10.0.0.254", 65010)
+				if err := nrc.bgpPeerLister.Update(newUnstructured(t, updated)); err != nil {
+					t.Fatalf("failed to update BGPPeer: %v", err)
+				}
+			},
+			[]string{"10.0.0.253", "10.0.0.254"},
+		},
+		{
+			"peers are reconciled when the node labels change",
+			func(t *testing.T) {
+				node.Labels = map[string]string{"rack": "b"}
+				if err := nrc.nodeLister.Update(node); err != nil {
+					t.Fatalf("failed to update node: %v", err)
+				}
+			},
+			[]string{"10.0.0.252", "10.0.0.254"},
+		},
+		{
+			"peer is removed with its resource",
+			func(t *testing.T) {
+				if err := nrc.bgpPeerLister.Delete(newUnstructured(t, otherRackPeer)); err != nil {
+					t.Fatalf("failed to delete BGPPeer: %v", err)
+				}
+			},
+			[]string{"10.0.0.254"},
+		},
+		{
+			"peer with a password Secret is added",
+			func(t *testing.T) {
+				secret := &v1core.Secret{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "bgp"},
+					Data:       map[string][]byte{"pw": []byte("secret")},
+				}
+				_, err := nrc.clientset.CoreV1().Secrets("kube-system").Create(context.Background(), secret,
+					metav1.CreateOptions{})
+				if err != nil {
+					t.Fatalf("failed to create secret: %v", err)
+				}
+				securedPeer := newTestBGPPeer("secured", "10.0.0.251", 65003)
+				securedPeer.Spec.PasswordSecretRef = &v1alpha1.SecretKeyReference{Namespace: "kube-system",
+					Name: "bgp", Key: "pw"}
+				if err := nrc.bgpPeerLister.Add(newUnstructured(t, securedPeer)); err != nil {
+					t.Fatalf("failed to add BGPPeer: %v", err)
+				}
+			},
+			[]string{"10.0.0.251", "10.0.0.254"},
+		},
+		{
+			"peer is kept with its current password when its password Secret is removed",
+			func(t *testing.T) {
+				err := nrc.clientset.CoreV1().Secrets("kube-system").Delete(context.Background(), "bgp",
+					metav1.DeleteOptions{})
+				if err != nil {
+					t.Fatalf("failed to delete secret: %v", err)
+				}
+			},
+			[]string{"10.0.0.251", "10.0.0.254"},
+		},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			step.update(t)
+			if err := nrc.syncBGPPeers(); err != nil {
+				t.Fatalf("failed to sync BGP peers: %v", err)
+			}
+			addresses := listPeerAddresses(t, nrc.bgpServer)
+			if !reflect.DeepEqual(addresses, step.expectedAddresses) {
+				t.Logf("expected peers: %v", step.expectedAddresses)
+				t.Logf("actual peers: %v", addresses)
+				t.Error("unexpected peers")
+			}
+		})
+	}
+
+	err = nrc.bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{Address: "10.0.0.254"},
+		func(peer *gobgpapi.Peer) {
+			if peer.Conf.PeerAsn != 65010 {
+				t.Errorf("expected peer 10.0.0.254 to be updated to ASN 65010, got %d", peer.Conf.PeerAsn)
+			}
+		})
+	if err != nil {
+		t.Fatalf("failed to list peers: %v", err)
+	}
+	if password := nrc.bgpPeers["10.0.0.251"].Conf.AuthPassword; password != "secret" {
+		t.Errorf("expected peer 10.0.0.251 to keep its password, got %q", password)
+	}
+}
+
+func Test_ensurePolicy(t *testing.T) {
+	nrc := &NetworkRoutingController{
+		bgpServer: gobgp.NewBgpServer(),
+	}
+	go nrc.bgpServer.Serve()
+	err := nrc.bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 64512, RouterId: "10.0.0.1", ListenPort: -1},
+	})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	for _, name := range []string{"setA", "setB"} {
+		err = nrc.bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_PREFIX, Name: name,
+				Prefixes: []*gobgpapi.Prefix{{IpPrefix: "10.0.0.0/24", MaskLengthMin: 24, MaskLengthMax: 24}}},
+		})
+		if err != nil {
+			t.Fatalf("failed to add defined set: %v", err)
+		}
+	}
+
+	newStatement := func(prefixSet string) *gobgpapi.Statement {
+		return &gobgpapi.Statement{
+			Conditions: &gobgpapi.Conditions{
+				PrefixSet: &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: prefixSet},
+			},
+			Actions: &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_ACCEPT},
+		}
+	}
+
+	steps := []struct {
+		name               string
+		statements         []*gobgpapi.Statement
+		expectedStatements map[string]string
+	}{
+		{
+			"policy is added",
+			[]*gobgpapi.Statement{newStatement("setA")},
+			map[string]string{"test_policy_stmt0": "setA"},
+		},
+		{
+			"unchanged policy is left untouched",
+			[]*gobgpapi.Statement{newStatement("setA")},
+			map[string]string{"test_policy_stmt0": "setA"},
+		},
+		{
+			"changed statements replace the existing ones",
+			[]*gobgpapi.Statement{newStatement("setA"), newStatement("setB")},
+			map[string]string{"test_policy_1_stmt0": "setA", "test_policy_1_stmt1": "setB"},
+		},
+		{
+			"removed statements are removed",
+			[]*gobgpapi.Statement{newStatement("setB")},
+			map[string]string{"test_policy_2_stmt0": "setB"},
+		},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			err := nrc.ensurePolicy(&gobgpapi.Policy{Name: "test_policy", Statements: step.statements},
+				gobgpapi.ResetPeerRequest_OUT)
+			if err != nil {
+				t.Fatalf("failed to ensure policy: %v", err)
+			}
+			statements := make(map[string]string)
+			err = nrc.bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{Name: "test_policy"},
+				func(policy *gobgpapi.Policy) {
+					for _, statement := range policy.Statements {
+						statements[statement.Name] = statement.Conditions.PrefixSet.Name
+					}
+				})
+			if err != nil {
+				t.Fatalf("failed to list policy: %v", err)
+			}
+			if !reflect.DeepEqual(statements, step.expectedStatements) {
+				t.Logf("expected statements: %v", step.expectedStatements)
+				t.Logf("actual statements: %v", statements)
+				t.Error("unexpected statements")
+			}
+		})
+	}
+}
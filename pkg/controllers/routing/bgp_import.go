@@ -0,0 +1,481 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
+	"github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+)
+
+const bgpPeerImportDefinedSetPrefix = "bgppeerimport-"
+
+// bgpPeerImport is the import filter of a BGPPeer, selecting the routes learned from the peer that are installed in
+// the routing table of the node
+type bgpPeerImport struct {
+	// name is the name of the neighbor and community defined sets of the filter, the prefix defined sets add the IP
+	// family to it as GoBGP prefix sets can only hold prefixes of a single family
+	name             string
+	address          string
+	prefixes         []*gobgpapi.Prefix
+	prefixes6        []*gobgpapi.Prefix
+	communities      []string
+	largeCommunities []string
+}
+
+// newBGPPeerImport validates the import filter of the BGPPeer with the given peer address, nil is returned when it has
+// none
+func newBGPPeerImport(bgpPeer *v1alpha1.BGPPeer, address string) (*bgpPeerImport, error) {
+	spec := bgpPeer.Spec.Import
+	if spec == nil {
+		return nil, nil
+	}
+	if len(spec.Prefixes) == 0 {
+		return nil, errors.New("the import filter must have at least one prefix")
+	}
+
+	peerImport := &bgpPeerImport{name: bgpPeerImportDefinedSetPrefix + bgpPeer.Name, address: address}
+	for _, match := range spec.Prefixes {
+		ip, ipNet, err := net.ParseCIDR(match.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid import prefix %s: %s", match.CIDR, err)
+		}
+		maskLen, bits := ipNet.Mask.Size()
+		prefix := &gobgpapi.Prefix{
+			IpPrefix:      ipNet.String(),
+			MaskLengthMin: uint32(maskLen),
+			MaskLengthMax: uint32(maskLen),
+		}
+		if match.MaskLengthMin != 0 {
+			prefix.MaskLengthMin = match.MaskLengthMin
+		}
+		if match.MaskLengthMax != 0 {
+			prefix.MaskLengthMax = match.MaskLengthMax
+		}
+		if prefix.MaskLengthMin < uint32(maskLen) || prefix.MaskLengthMax > uint32(bits) ||
+			prefix.MaskLengthMin > prefix.MaskLengthMax {
+			return nil, fmt.Errorf("invalid mask length range %d..%d for import prefix %s", prefix.MaskLengthMin,
+				prefix.MaskLengthMax, match.CIDR)
+		}
+		if ip.To4() == nil {
+			peerImport.prefixes6 = append(peerImport.prefixes6, prefix)
+		} else {
+			peerImport.prefixes = append(peerImport.prefixes, prefix)
+		}
+	}
+
+	for _, community := range spec.Communities {
+		if err := validateCommunity(community); err == nil {
+			peerImport.communities = append(peerImport.communities, communityRegexp(community))
+			continue
+		}
+		if err := validateLargeCommunity(community); err != nil {
+			return nil, fmt.Errorf("invalid import community %s, it is neither a community nor a large community",
+				community)
+		}
+		peerImport.largeCommunities = append(peerImport.largeCommunities, community)
+	}
+
+	return peerImport, nil
+}
+
+// communityRegexp returns the regular expression GoBGP matches a community of a community defined set with, which is
+// also how it lists them
+func communityRegexp(community string) string {
+	const lowPartMask = 1<<bgpCommunityMaxPartSize - 1
+	if value, err := strconv.ParseUint(community, 10, bgpCommunityMaxSize); err == nil {
+		return fmt.Sprintf("^%d:%d$", value>>bgpCommunityMaxPartSize, value&lowPartMask)
+	}
+	for value, name := range bgp.WellKnownCommunityNameMap {
+		if community == name {
+			return fmt.Sprintf("^%d:%d$", value>>bgpCommunityMaxPartSize, value&lowPartMask)
+		}
+	}
+	return "^" + community + "$"
+}
+
+// familyDefinedSetName returns the name of the prefix defined set of the given IP family, as GoBGP does not accept
+// IPv4 and IPv6 prefixes in the same defined set
+func familyDefinedSetName(name string, isIpv6 bool) string {
+	if isIpv6 {
+		return name + "-v6"
+	}
+	return name + "-v4"
+}
+
+// prefixDefinedSetName returns the name of the defined set of the import prefixes of the given IP family
+func (peerImport *bgpPeerImport) prefixDefinedSetName(isIpv6 bool) string {
+	return familyDefinedSetName(peerImport.name, isIpv6)
+}
+
+// setBGPPeerImports replaces the import filters of the peers, the routes learned from the peers that no longer have
+// one are removed from the routing table of the node
+func (nrc *NetworkRoutingController) setBGPPeerImports(peerImports map[string]*bgpPeerImport) {
+	nrc.bgpPeerImportsMu.Lock()
+	defer nrc.bgpPeerImportsMu.Unlock()
+	nrc.bgpPeerImports = peerImports
+
+	for dst, nextHops := range nrc.importedRoutes {
+		removed := false
+		for address := range nextHops {
+			if _, ok := peerImports[address]; !ok {
+				klog.Infof("Removing the next hop of the route to %s learned from peer %s as it no longer has an "+
+					"import filter", dst, address)
+				delete(nextHops, address)
+				removed = true
+			}
+		}
+		if !removed {
+			continue
+		}
+		_, dstNet, err := net.ParseCIDR(dst)
+		if err != nil {
+			continue
+		}
+		if err = nrc.syncImportedRoute(dstNet); err != nil {
+			klog.Errorf("Failed to update route to %s: %s", dst, err)
+		}
+	}
+}
+
+// getBGPPeerImports returns the import filters of the peers, sorted by name
+func (nrc *NetworkRoutingController) getBGPPeerImports() []*bgpPeerImport {
+	nrc.bgpPeerImportsMu.Lock()
+	defer nrc.bgpPeerImportsMu.Unlock()
+
+	peerImports := make([]*bgpPeerImport, 0, len(nrc.bgpPeerImports))
+	for _, peerImport := range nrc.bgpPeerImports {
+		peerImports = append(peerImports, peerImport)
+	}
+	sort.Slice(peerImports, func(i, j int) bool { return peerImports[i].name < peerImports[j].name })
+	return peerImports
+}
+
+// isImportingPeer returns whether the routes learned from the peer are selected by an import filter
+func (nrc *NetworkRoutingController) isImportingPeer(address string) bool {
+	nrc.bgpPeerImportsMu.Lock()
+	defer nrc.bgpPeerImportsMu.Unlock()
+	_, ok := nrc.bgpPeerImports[address]
+	return ok
+}
+
+// isImportedRoute returns whether the route to the prefix is installed through next hops learned from external peers
+// with an import filter
+func (nrc *NetworkRoutingController) isImportedRoute(prefix string) bool {
+	nrc.bgpPeerImportsMu.Lock()
+	defer nrc.bgpPeerImportsMu.Unlock()
+	_, ok := nrc.importedRoutes[prefix]
+	return ok
+}
+
+// dropImportedRoute removes the route to a prefix installed through next hops learned from external peers with an
+// import filter, once its best path was learned from another peer
+func (nrc *NetworkRoutingController) dropImportedRoute(dst *net.IPNet) error {
+	nrc.bgpPeerImportsMu.Lock()
+	defer nrc.bgpPeerImportsMu.Unlock()
+	if _, ok := nrc.importedRoutes[dst.String()]; !ok {
+		return nil
+	}
+	klog.V(2).Infof("Removing route to %s learned from external peers as its best path is no longer imported", dst)
+	delete(nrc.importedRoutes, dst.String())
+	nrc.routeSyncer.delInjectedRoute(dst)
+	return deleteRoutesByDestination(nrc.routeSyncer.ln, dst)
+}
+
+// injectImportedRoute installs the route to a prefix learned from an external peer and selected by its import filter
+// through the next hop advertised by the peer, or removes it when the path is withdrawn
+func (nrc *NetworkRoutingController) injectImportedRoute(path *gobgpapi.Path, dst *net.IPNet, nextHop net.IP) error {
+	nrc.bgpPeerImportsMu.Lock()
+	defer nrc.bgpPeerImportsMu.Unlock()
+
+	if path.IsWithdraw {
+		klog.V(2).Infof("Removing route: '%s via %s' learned from peer %s from the routing table", dst, nextHop,
+			path.NeighborIp)
+		delete(nrc.importedRoutes[dst.String()], path.NeighborIp)
+	} else {
+		klog.V(2).Infof("Inject route: '%s via %s' learned from peer %s to routing table", dst, nextHop,
+			path.NeighborIp)
+		// without multipath the best path replaces the previous one
+		nrc.importedRoutes[dst.String()] = map[string]net.IP{path.NeighborIp: nextHop}
+	}
+	return nrc.syncImportedRoute(dst)
+}
+
+// syncImportedRoute installs the route to a prefix learned from external peers through the next hops tracked for it,
+// or removes it when none is left. bgpPeerImportsMu must be held.
+func (nrc *NetworkRoutingController) syncImportedRoute(dst *net.IPNet) error {
+	nextHops := nrc.importedRoutes[dst.String()]
+	if len(nextHops) == 0 {
+		delete(nrc.importedRoutes, dst.String())
+		nrc.routeSyncer.delInjectedRoute(dst)
+		return deleteRoutesByDestination(nrc.routeSyncer.ln, dst)
+	}
+
+	// replacing a route kube-router did not set up, such as the default route set up by DHCP, would leave the node
+	// without it once the path is withdrawn
+	hostRoute, err := nrc.findHostRoute(dst)
+	if err != nil {
+		return err
+	}
+	if hostRoute != nil {
+		nrc.routeSyncer.delInjectedRoute(dst)
+		if err = deleteRoutesByDestination(nrc.routeSyncer.ln, dst); err != nil {
+			return err
+		}
+		return fmt.Errorf("route to %s learned from external peers not installed as the node already has the route %s "+
+			"with metric %d, set --install-external-routes-metric to another metric", dst, hostRoute,
+			nrc.externalRoutesMetric)
+	}
+
+	nrc.routeSyncer.addInjectedRoute(dst, newImportedRoute(dst, nextHops, nrc.bgpMaxPaths, nrc.externalRoutesMetric))
+	// Immediately sync the local route table regardless of timer
+	nrc.routeSyncer.syncLocalRouteTable()
+	return nil
+}
+
+// findHostRoute returns the route of the main routing table to the destination that has the metric of the routes
+// learned from external peers and was not set up by kube-router, if any
+func (nrc *NetworkRoutingController) findHostRoute(dst *net.IPNet) (*netlink.Route, error) {
+	routes, err := nrc.routeSyncer.ln.routeListFiltered(getIPFamily(dst.IP), &netlink.Route{
+		Dst: routeFilterDst(dst), Table: syscall.RT_TABLE_MAIN,
+	}, netlink.RT_FILTER_DST|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get routes from netlink: %v", err)
+	}
+	for i, route := range routes {
+		if route.Protocol != zebraRouteOriginator && route.Priority == nrc.externalRoutesMetric {
+			return &routes[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// addBGPPeerImportDefinedSets creates or updates the neighbor, prefix, community and large community defined sets
+// matched by the import policy statements of the peers with an import filter
+func (nrc *NetworkRoutingController) addBGPPeerImportDefinedSets(peerImports []*bgpPeerImport) error {
+	var errs []string
+	for _, peerImport := range peerImports {
+		neighbor := peerImport.address + "/32"
+		if net.ParseIP(peerImport.address).To4() == nil {
+			neighbor = peerImport.address + "/128"
+		}
+		err := nrc.syncListDefinedSet(gobgpapi.DefinedType_NEIGHBOR, peerImport.name, []string{neighbor})
+		if err == nil && len(peerImport.prefixes) > 0 {
+			err = nrc.syncPrefixDefinedSet(peerImport.prefixDefinedSetName(false), peerImport.prefixes)
+		}
+		if err == nil && len(peerImport.prefixes6) > 0 {
+			err = nrc.syncPrefixDefinedSet(peerImport.prefixDefinedSetName(true), peerImport.prefixes6)
+		}
+		if err == nil && len(peerImport.communities) > 0 {
+			err = nrc.syncListDefinedSet(gobgpapi.DefinedType_COMMUNITY, peerImport.name, peerImport.communities)
+		}
+		if err == nil && len(peerImport.largeCommunities) > 0 {
+			err = nrc.syncListDefinedSet(gobgpapi.DefinedType_LARGE_COMMUNITY, peerImport.name,
+				peerImport.largeCommunities)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", peerImport.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// deleteStaleBGPPeerImportDefinedSets removes the defined sets of the import filters that no longer exist, the
+// import policy must no longer reference them
+func (nrc *NetworkRoutingController) deleteStaleBGPPeerImportDefinedSets(peerImports []*bgpPeerImport) error {
+	current := make(map[string]bool)
+	for _, peerImport := range peerImports {
+		current[peerImport.name] = true
+		if len(peerImport.prefixes) > 0 {
+			current[peerImport.prefixDefinedSetName(false)] = true
+		}
+		if len(peerImport.prefixes6) > 0 {
+			current[peerImport.prefixDefinedSetName(true)] = true
+		}
+	}
+	for _, definedType := range []gobgpapi.DefinedType{gobgpapi.DefinedType_NEIGHBOR, gobgpapi.DefinedType_PREFIX,
+		gobgpapi.DefinedType_COMMUNITY, gobgpapi.DefinedType_LARGE_COMMUNITY} {
+		stale := make([]*gobgpapi.DefinedSet, 0)
+		err := nrc.bgpServer.ListDefinedSet(context.Background(),
+			&gobgpapi.ListDefinedSetRequest{DefinedType: definedType},
+			func(ds *gobgpapi.DefinedSet) {
+				if !strings.HasPrefix(ds.Name, bgpPeerImportDefinedSetPrefix) {
+					return
+				}
+				// the community sets of an import filter that no longer has communities of their type are stale too
+				if current[ds.Name] && hasImportDefinedSet(peerImports, ds.Name, definedType) {
+					return
+				}
+				stale = append(stale, ds)
+			})
+		if err != nil {
+			return err
+		}
+		for _, ds := range stale {
+			err = nrc.bgpServer.DeleteDefinedSet(context.Background(),
+				&gobgpapi.DeleteDefinedSetRequest{DefinedSet: ds, All: true})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hasImportDefinedSet returns whether the import filter with the given name still needs its defined set of the given
+// type, the community sets are only needed when it selects routes by communities of their type
+func hasImportDefinedSet(peerImports []*bgpPeerImport, name string, definedType gobgpapi.DefinedType) bool {
+	for _, peerImport := range peerImports {
+		if peerImport.name != name {
+			continue
+		}
+		switch definedType {
+		case gobgpapi.DefinedType_COMMUNITY:
+			return len(peerImport.communities) > 0
+		case gobgpapi.DefinedType_LARGE_COMMUNITY:
+			return len(peerImport.largeCommunities) > 0
+		}
+	}
+	return true
+}
+
+// bgpPeerImportStatements returns the import policy statements accepting the routes selected by the import filters
+// of the peers, followed by a statement per peer rejecting the other routes learned from it. As the conditions of a
+// statement must all match, the routes carrying one of the communities and those carrying one of the large
+// communities are accepted by separate statements.
+func bgpPeerImportStatements(peerImports []*bgpPeerImport) []*gobgpapi.Statement {
+	statements := make([]*gobgpapi.Statement, 0)
+	for _, peerImport := range peerImports {
+		for _, isIpv6 := range []bool{false, true} {
+			prefixes := peerImport.prefixes
+			if isIpv6 {
+				prefixes = peerImport.prefixes6
+			}
+			if len(prefixes) == 0 {
+				continue
+			}
+			newConditions := func() *gobgpapi.Conditions {
+				return &gobgpapi.Conditions{
+					PrefixSet: &gobgpapi.MatchSet{
+						Type: gobgpapi.MatchSet_ANY,
+						Name: peerImport.prefixDefinedSetName(isIpv6),
+					},
+					NeighborSet: &gobgpapi.MatchSet{
+						Type: gobgpapi.MatchSet_ANY,
+						Name: peerImport.name,
+					},
+				}
+			}
+			var conditionsList []*gobgpapi.Conditions
+			if len(peerImport.communities) > 0 {
+				conditions := newConditions()
+				conditions.CommunitySet = &gobgpapi.MatchSet{
+					Type: gobgpapi.MatchSet_ANY,
+					Name: peerImport.name,
+				}
+				conditionsList = append(conditionsList, conditions)
+			}
+			if len(peerImport.largeCommunities) > 0 {
+				conditions := newConditions()
+				conditions.LargeCommunitySet = &gobgpapi.MatchSet{
+					Type: gobgpapi.MatchSet_ANY,
+					Name: peerImport.name,
+				}
+				conditionsList = append(conditionsList, conditions)
+			}
+			if len(conditionsList) == 0 {
+				conditionsList = append(conditionsList, newConditions())
+			}
+			for _, conditions := range conditionsList {
+				statements = append(statements, &gobgpapi.Statement{
+					Conditions: conditions,
+					Actions:    &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_ACCEPT},
+				})
+			}
+		}
+		statements = append(statements, &gobgpapi.Statement{
+			Conditions: &gobgpapi.Conditions{
+				NeighborSet: &gobgpapi.MatchSet{
+					Type: gobgpapi.MatchSet_ANY,
+					Name: peerImport.name,
+				},
+			},
+			Actions: &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_REJECT},
+		})
+	}
+	return statements
+}
+
+// syncListDefinedSet creates the neighbor, community or large community defined set, or updates it so that it holds
+// exactly the given entries
+func (nrc *NetworkRoutingController) syncListDefinedSet(definedType gobgpapi.DefinedType, name string,
+	list []string) error {
+	var currentDefinedSet *gobgpapi.DefinedSet
+	err := nrc.bgpServer.ListDefinedSet(context.Background(),
+		&gobgpapi.ListDefinedSetRequest{DefinedType: definedType, Name: name},
+		func(ds *gobgpapi.DefinedSet) {
+			currentDefinedSet = ds
+		})
+	if currentDefinedSet != nil && definedType == gobgpapi.DefinedType_LARGE_COMMUNITY {
+		// GoBGP anchors the large communities it is given into regular expressions, and anchors them again when
+		// given back the ones it lists
+		for i, entry := range currentDefinedSet.List {
+			currentDefinedSet.List[i] = strings.TrimSuffix(strings.TrimPrefix(entry, "^"), "$")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	toAdd := list
+	toDelete := make([]string, 0)
+	if currentDefinedSet != nil {
+		current := make(map[string]bool)
+		for _, entry := range currentDefinedSet.List {
+			current[entry] = true
+		}
+		desired := make(map[string]bool)
+		toAdd = make([]string, 0)
+		for _, entry := range list {
+			desired[entry] = true
+			if !current[entry] {
+				toAdd = append(toAdd, entry)
+			}
+		}
+		for _, entry := range currentDefinedSet.List {
+			if !desired[entry] {
+				toDelete = append(toDelete, entry)
+			}
+		}
+	}
+
+	if len(toAdd) > 0 {
+		err = nrc.bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{DefinedType: definedType, Name: name, List: toAdd}})
+		if err != nil {
+			return err
+		}
+	}
+	if len(toDelete) > 0 {
+		err = nrc.bgpServer.DeleteDefinedSet(context.Background(), &gobgpapi.DeleteDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{DefinedType: definedType, Name: name, List: toDelete},
+			All:        false})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
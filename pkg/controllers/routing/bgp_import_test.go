@@ -0,0 +1,394 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+)
+
+func Test_newBGPPeerImport(t *testing.T) {
+	testcases := []struct {
+		name           string
+		spec           *v1alpha1.BGPImportSpec
+		expectedImport *bgpPeerImport
+		expectErr      bool
+	}{
+		{
+			"peer without import filter",
+			nil,
+			nil,
+			false,
+		},
+		{
+			"default routes and prefixes of both families",
+			&v1alpha1.BGPImportSpec{
+				Prefixes: []v1alpha1.BGPPrefixMatch{
+					{CIDR: "0.0.0.0/0"},
+					{CIDR: "10.10.0.0/16", MaskLengthMax: 24},
+					{CIDR: "::/0"},
+				},
+			},
+			&bgpPeerImport{
+				name:    "bgppeerimport-upstream",
+				address: "10.0.0.254",
+				prefixes: []*gobgpapi.Prefix{
+					{IpPrefix: "0.0.0.0/0", MaskLengthMin: 0, MaskLengthMax: 0},
+					{IpPrefix: "10.10.0.0/16", MaskLengthMin: 16, MaskLengthMax: 24},
+				},
+				prefixes6: []*gobgpapi.Prefix{
+					{IpPrefix: "::/0", MaskLengthMin: 0, MaskLengthMax: 0},
+				},
+			},
+			false,
+		},
+		{
+			"communities are matched in the form GoBGP lists them",
+			&v1alpha1.BGPImportSpec{
+				Prefixes:    []v1alpha1.BGPPrefixMatch{{CIDR: "0.0.0.0/0"}},
+				Communities: []string{"65000:100", "4259840100", "no-export"},
+			},
+			&bgpPeerImport{
+				name:    "bgppeerimport-upstream",
+				address: "10.0.0.254",
+				prefixes: []*gobgpapi.Prefix{
+					{IpPrefix: "0.0.0.0/0", MaskLengthMin: 0, MaskLengthMax: 0},
+				},
+				communities: []string{"^65000:100$", "^65000:100$", "^65535:65281$"},
+			},
+			false,
+		},
+		{
+			"large communities are matched in their own defined set",
+			&v1alpha1.BGPImportSpec{
+				Prefixes:    []v1alpha1.BGPPrefixMatch{{CIDR: "0.0.0.0/0"}},
+				Communities: []string{"65000:100", "4200000000:100:1"},
+			},
+			&bgpPeerImport{
+				name:    "bgppeerimport-upstream",
+				address: "10.0.0.254",
+				prefixes: []*gobgpapi.Prefix{
+					{IpPrefix: "0.0.0.0/0", MaskLengthMin: 0, MaskLengthMax: 0},
+				},
+				communities:      []string{"^65000:100$"},
+				largeCommunities: []string{"4200000000:100:1"},
+			},
+			false,
+		},
+		{
+			"filter without prefixes",
+			&v1alpha1.BGPImportSpec{Communities: []string{"65000:100"}},
+			nil,
+			true,
+		},
+		{
+			"invalid prefix",
+			&v1alpha1.BGPImportSpec{Prefixes: []v1alpha1.BGPPrefixMatch{{CIDR: "10.10.0.0"}}},
+			nil,
+			true,
+		},
+		{
+			"mask length range shorter than the prefix",
+			&v1alpha1.BGPImportSpec{
+				Prefixes: []v1alpha1.BGPPrefixMatch{{CIDR: "10.10.0.0/16", MaskLengthMin: 8, MaskLengthMax: 24}},
+			},
+			nil,
+			true,
+		},
+		{
+			"mask length range longer than the address",
+			&v1alpha1.BGPImportSpec{
+				Prefixes: []v1alpha1.BGPPrefixMatch{{CIDR: "10.10.0.0/16", MaskLengthMax: 33}},
+			},
+			nil,
+			true,
+		},
+		{
+			"invalid community",
+			&v1alpha1.BGPImportSpec{
+				Prefixes:    []v1alpha1.BGPPrefixMatch{{CIDR: "0.0.0.0/0"}},
+				Communities: []string{"65536:100", "4200000000:100:4294967296"},
+			},
+			nil,
+			true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			bgpPeer := newTestBGPPeer("upstream", "10.0.0.254", 65000)
+			bgpPeer.Spec.Import = testcase.spec
+			peerImport, err := newBGPPeerImport(bgpPeer, "10.0.0.254")
+			if testcase.expectErr {
+				if err == nil {
+					t.Error("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(peerImport, testcase.expectedImport) {
+				t.Logf("expected import filter: %+v", testcase.expectedImport)
+				t.Logf("actual import filter: %+v", peerImport)
+				t.Error("unexpected import filter")
+			}
+		})
+	}
+}
+
+func Test_bgpPeerImportStatements(t *testing.T) {
+	peerImports := []*bgpPeerImport{
+		{
+			name:        "bgppeerimport-upstream",
+			address:     "10.0.0.254",
+			prefixes:    []*gobgpapi.Prefix{{IpPrefix: "0.0.0.0/0"}},
+			communities: []string{"^65000:100$"},
+		},
+		{
+			name:             "bgppeerimport-upstream6",
+			address:          "2001:db8::254",
+			prefixes6:        []*gobgpapi.Prefix{{IpPrefix: "::/0"}},
+			largeCommunities: []string{"4200000000:100:1"},
+		},
+	}
+	matchSet := func(name string) *gobgpapi.MatchSet {
+		return &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: name}
+	}
+	expectedStatements := []*gobgpapi.Statement{
+		{
+			Conditions: &gobgpapi.Conditions{
+				PrefixSet:    matchSet("bgppeerimport-upstream-v4"),
+				NeighborSet:  matchSet("bgppeerimport-upstream"),
+				CommunitySet: matchSet("bgppeerimport-upstream"),
+			},
+			Actions: &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_ACCEPT},
+		},
+		{
+			Conditions: &gobgpapi.Conditions{NeighborSet: matchSet("bgppeerimport-upstream")},
+			Actions:    &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_REJECT},
+		},
+		{
+			Conditions: &gobgpapi.Conditions{
+				PrefixSet:         matchSet("bgppeerimport-upstream6-v6"),
+				NeighborSet:       matchSet("bgppeerimport-upstream6"),
+				LargeCommunitySet: matchSet("bgppeerimport-upstream6"),
+			},
+			Actions: &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_ACCEPT},
+		},
+		{
+			Conditions: &gobgpapi.Conditions{NeighborSet: matchSet("bgppeerimport-upstream6")},
+			Actions:    &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_REJECT},
+		},
+	}
+
+	statements := bgpPeerImportStatements(peerImports)
+	if !reflect.DeepEqual(statements, expectedStatements) {
+		t.Logf("expected statements: %v", expectedStatements)
+		t.Logf("actual statements: %v", statements)
+		t.Error("unexpected statements")
+	}
+}
+
+func Test_bgpPeerImportDefinedSets(t *testing.T) {
+	nrc := &NetworkRoutingController{
+		bgpServer: gobgp.NewBgpServer(),
+	}
+	go nrc.bgpServer.Serve()
+	err := nrc.bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 64512, RouterId: "10.0.0.1", ListenPort: -1},
+	})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+
+	upstream := &bgpPeerImport{
+		name:    "bgppeerimport-upstream",
+		address: "10.0.0.254",
+		prefixes: []*gobgpapi.Prefix{
+			{IpPrefix: "0.0.0.0/0", MaskLengthMin: 0, MaskLengthMax: 0},
+			{IpPrefix: "10.10.0.0/16", MaskLengthMin: 16, MaskLengthMax: 24},
+		},
+		communities:      []string{"^65000:100$"},
+		largeCommunities: []string{"4200000000:100:1"},
+	}
+	upstream6 := &bgpPeerImport{
+		name:      "bgppeerimport-upstream6",
+		address:   "2001:db8::254",
+		prefixes6: []*gobgpapi.Prefix{{IpPrefix: "::/0", MaskLengthMin: 0, MaskLengthMax: 0}},
+	}
+	upstreamLarge := &bgpPeerImport{
+		name:             "bgppeerimport-upstream",
+		address:          "10.0.0.254",
+		prefixes:         []*gobgpapi.Prefix{{IpPrefix: "0.0.0.0/0", MaskLengthMin: 0, MaskLengthMax: 0}},
+		largeCommunities: []string{"4200000000:100:1", "4200000000:100:2"},
+	}
+	upstreamUpdated := &bgpPeerImport{
+		name:    "bgppeerimport-upstream",
+		address: "10.0.0.254",
+		prefixes: []*gobgpapi.Prefix{
+			{IpPrefix: "10.10.0.0/16", MaskLengthMin: 16, MaskLengthMax: 20},
+		},
+	}
+
+	steps := []struct {
+		name         string
+		peerImports  []*bgpPeerImport
+		expectedSets map[string][]string
+	}{
+		{
+			"defined sets are added for each import filter",
+			[]*bgpPeerImport{upstream, upstream6},
+			map[string][]string{
+				"COMMUNITY bgppeerimport-upstream":       {"^65000:100$"},
+				"LARGE_COMMUNITY bgppeerimport-upstream": {"^4200000000:100:1$"},
+				"NEIGHBOR bgppeerimport-upstream":        {"10.0.0.254/32"},
+				"NEIGHBOR bgppeerimport-upstream6":       {"2001:db8::254/128"},
+				"PREFIX bgppeerimport-upstream-v4":       {"0.0.0.0/0 0..0", "10.10.0.0/16 16..24"},
+				"PREFIX bgppeerimport-upstream6-v6":      {"::/0 0..0"},
+			},
+		},
+		{
+			"large community sets are updated in place",
+			[]*bgpPeerImport{upstreamLarge},
+			map[string][]string{
+				"LARGE_COMMUNITY bgppeerimport-upstream": {"^4200000000:100:1$", "^4200000000:100:2$"},
+				"NEIGHBOR bgppeerimport-upstream":        {"10.0.0.254/32"},
+				"PREFIX bgppeerimport-upstream-v4":       {"0.0.0.0/0 0..0"},
+			},
+		},
+		{
+			"defined sets follow the updated and removed import filters",
+			[]*bgpPeerImport{upstreamUpdated},
+			map[string][]string{
+				"NEIGHBOR bgppeerimport-upstream":  {"10.0.0.254/32"},
+				"PREFIX bgppeerimport-upstream-v4": {"10.10.0.0/16 16..20"},
+			},
+		},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if err := nrc.addBGPPeerImportDefinedSets(step.peerImports); err != nil {
+				t.Fatalf("failed to add defined sets: %v", err)
+			}
+			if err := nrc.deleteStaleBGPPeerImportDefinedSets(step.peerImports); err != nil {
+				t.Fatalf("failed to delete stale defined sets: %v", err)
+			}
+
+			sets := make(map[string][]string)
+			for _, definedType := range []gobgpapi.DefinedType{gobgpapi.DefinedType_PREFIX,
+				gobgpapi.DefinedType_NEIGHBOR, gobgpapi.DefinedType_COMMUNITY, gobgpapi.DefinedType_LARGE_COMMUNITY} {
+				err := nrc.bgpServer.ListDefinedSet(context.Background(),
+					&gobgpapi.ListDefinedSetRequest{DefinedType: definedType},
+					func(ds *gobgpapi.DefinedSet) {
+						key := definedType.String() + " " + ds.Name
+						entries := append([]string{}, ds.List...)
+						for _, prefix := range ds.Prefixes {
+							entries = append(entries, prefixKey(prefix))
+						}
+						sort.Strings(entries)
+						sets[key] = entries
+					})
+				if err != nil {
+					t.Fatalf("failed to list defined sets: %v", err)
+				}
+			}
+			if !reflect.DeepEqual(sets, step.expectedSets) {
+				t.Logf("expected defined sets: %v", step.expectedSets)
+				t.Logf("actual defined sets: %v", sets)
+				t.Error("unexpected defined sets")
+			}
+		})
+	}
+}
+
+func Test_injectImportedRouteKeepsHostRoute(t *testing.T) {
+	hostRoute := netlink.Route{
+		Gw: net.ParseIP("10.0.0.1"), Table: syscall.RT_TABLE_MAIN, Protocol: unix.RTPROT_DHCP,
+	}
+	newController := func(metric int) (*NetworkRoutingController, *fakeNetlink) {
+		fn := newFakeNetlink()
+		fn.routes = []netlink.Route{hostRoute}
+		syncer := newRouteSyncer(time.Minute, injectedRoutesCleanupDisabled)
+		syncer.ln = fn
+		syncer.routeReplacer = fn.routeReplace
+		return &NetworkRoutingController{
+			bgpMaxPaths:          1,
+			externalRoutesMetric: metric,
+			routeSyncer:          syncer,
+			importedRoutes:       make(map[string]map[string]net.IP),
+			bgpPeerImports: map[string]*bgpPeerImport{
+				"10.0.0.254": {name: "bgppeerimport-tor", address: "10.0.0.254"},
+			},
+		}, fn
+	}
+
+	nrc, fn := newController(0)
+	err := nrc.injectRoute(newTestPath(t, "0.0.0.0", 0, "10.0.0.254", "10.0.0.254"))
+	assert.Error(t, err, "a default route with the metric of the host default route should not be installed")
+	assert.Equal(t, []netlink.Route{hostRoute}, fn.routes)
+	assert.Empty(t, nrc.routeSyncer.routeTableStateMap)
+
+	nrc, fn = newController(50)
+	path := newTestPath(t, "0.0.0.0", 0, "10.0.0.254", "10.0.0.254")
+	if err = nrc.injectRoute(path); err != nil {
+		t.Fatalf("failed to inject route: %v", err)
+	}
+	assert.Len(t, fn.routes, 2, "the default route should be installed alongside the host default route")
+	assert.Equal(t, 50, fn.routes[1].Priority)
+	assert.Equal(t, "10.0.0.254", fn.routes[1].Gw.String())
+
+	path.IsWithdraw = true
+	if err = nrc.injectRoute(path); err != nil {
+		t.Fatalf("failed to withdraw route: %v", err)
+	}
+	assert.Equal(t, []netlink.Route{hostRoute}, fn.routes,
+		"the host default route should be left once the imported one is withdrawn")
+}
+
+func Test_injectRouteDropsStaleImportedRoute(t *testing.T) {
+	fn := newFakeNetlink()
+	syncer := newRouteSyncer(time.Minute, injectedRoutesCleanupDisabled)
+	syncer.ln = fn
+	syncer.routeReplacer = fn.routeReplace
+	nrc := &NetworkRoutingController{
+		bgpMaxPaths:    1,
+		routeSyncer:    syncer,
+		ln:             fn,
+		importedRoutes: make(map[string]map[string]net.IP),
+		bgpPeerImports: map[string]*bgpPeerImport{
+			"10.0.0.254": {name: "bgppeerimport-tor", address: "10.0.0.254"},
+		},
+	}
+
+	if err := nrc.injectRoute(newTestPath(t, "10.10.0.0", 16, "10.0.0.254", "10.0.0.254")); err != nil {
+		t.Fatalf("failed to inject route: %v", err)
+	}
+	assert.Contains(t, nrc.importedRoutes, "10.10.0.0/16")
+	assert.Len(t, fn.routes, 1)
+
+	// the best path moves to a peer without import filter
+	if err := nrc.injectRoute(newTestPath(t, "10.10.0.0", 16, "10.0.0.253", "10.0.0.253")); err != nil {
+		t.Fatalf("failed to inject route: %v", err)
+	}
+	assert.NotContains(t, nrc.importedRoutes, "10.10.0.0/16")
+	assert.NotContains(t, nrc.routeSyncer.routeTableStateMap, "10.10.0.0/16")
+	assert.Empty(t, fn.routes, "the route through the external peer should be removed")
+}
@@ -0,0 +1,92 @@
+package routing
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"k8s.io/klog/v2"
+)
+
+const (
+	maxPrefixesThresholdMax = 100
+
+	maxPrefixesWarning = "warning"
+	maxPrefixesMaximum = "maximum"
+)
+
+// OnPrefixLimit handles a peer sending more prefixes than the warning threshold of one of its address families, or
+// than the maximum, which is only known once GoBGP tears the session down with a Cease NOTIFICATION. The peer is then
+// shut down for the restart time, rather than letting GoBGP connect again within seconds only to receive the same
+// prefixes. It is called from the BGP server, so it must not wait for it.
+func (nrc *NetworkRoutingController) OnPrefixLimit(address string, family string, threshold string) {
+	if nrc.MetricsEnabled {
+		metrics.ControllerBGPPeerMaxPrefixesReached.WithLabelValues(address, threshold).Inc()
+	}
+	if threshold == maxPrefixesWarning {
+		klog.Warningf("BGP peer %s sent more than %d%% of its maximum number of %s prefixes", address,
+			nrc.peerMaxPrefixesThreshold, family)
+		return
+	}
+	klog.Warningf("BGP peer %s sent more than its maximum number of prefixes, the session is torn down", address)
+	if nrc.peerMaxPrefixesRestartTime > 0 {
+		go nrc.disableMaxPrefixesPeer(address)
+	}
+}
+
+// disableMaxPrefixesPeer shuts down the BGP peer that exceeded its maximum number of prefixes and starts it again
+// after the restart time
+func (nrc *NetworkRoutingController) disableMaxPrefixesPeer(address string) {
+	nrc.maxPrefixesMu.Lock()
+	defer nrc.maxPrefixesMu.Unlock()
+
+	if _, ok := nrc.maxPrefixesDisabledPeers[address]; ok {
+		return
+	}
+	err := nrc.bgpServer.DisablePeer(context.Background(), &gobgpapi.DisablePeerRequest{
+		Address:       address,
+		Communication: "Maximum number of prefixes reached",
+	})
+	if err != nil {
+		klog.Errorf("Failed to shut down BGP peer %s after it exceeded its maximum number of prefixes: %s", address,
+			err)
+		return
+	}
+	if nrc.maxPrefixesDisabledPeers == nil {
+		nrc.maxPrefixesDisabledPeers = make(map[string]*time.Timer)
+	}
+	nrc.maxPrefixesDisabledPeers[address] = time.AfterFunc(nrc.peerMaxPrefixesRestartTime, func() {
+		nrc.enableMaxPrefixesPeer(address)
+	})
+	klog.Warningf("Shut down BGP peer %s for %s as it exceeded its maximum number of prefixes", address,
+		nrc.peerMaxPrefixesRestartTime)
+}
+
+// enableMaxPrefixesPeer starts the BGP peer shut down for exceeding its maximum number of prefixes again, unless
+// BFD shut it down in the meantime, in which case it is started once its BFD session is up
+func (nrc *NetworkRoutingController) enableMaxPrefixesPeer(address string) {
+	nrc.bfdMu.Lock()
+	defer nrc.bfdMu.Unlock()
+	nrc.maxPrefixesMu.Lock()
+	defer nrc.maxPrefixesMu.Unlock()
+
+	delete(nrc.maxPrefixesDisabledPeers, address)
+	if nrc.bfdDisabledPeers[address] {
+		return
+	}
+	err := nrc.bgpServer.EnablePeer(context.Background(), &gobgpapi.EnablePeerRequest{Address: address})
+	if err != nil {
+		klog.Warningf("Failed to start BGP peer %s again after its maximum prefixes restart time: %s", address, err)
+		return
+	}
+	klog.Infof("Started BGP peer %s again after its maximum prefixes restart time", address)
+}
+
+// isMaxPrefixesDisabledPeer returns whether the BGP peer is shut down for exceeding its maximum number of prefixes
+func (nrc *NetworkRoutingController) isMaxPrefixesDisabledPeer(address string) bool {
+	nrc.maxPrefixesMu.Lock()
+	defer nrc.maxPrefixesMu.Unlock()
+	_, ok := nrc.maxPrefixesDisabledPeers[address]
+	return ok
+}
@@ -0,0 +1,92 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_newGlobalPeersMaxPrefixes(t *testing.T) {
+	nrc := &NetworkRoutingController{nodeIP: net.ParseIP("10.0.0.1"), peerMaxPrefixesThreshold: 75}
+	peers, err := newGlobalPeers([]net.IP{net.ParseIP("10.0.0.253"), net.ParseIP("10.0.0.254")}, nil,
+		[]uint32{65000, 65000}, nil, []uint32{1000, 0}, nil, 90, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to create peers: %v", err)
+	}
+	for _, peer := range peers {
+		nrc.configureExternalPeer(peer, false, 0, 0, 0)
+		// configuring a peer again must not lose its limit
+		nrc.configureExternalPeer(peer, false, 0, 0, 0)
+	}
+
+	assert.Equal(t, []*gobgpapi.AfiSafi{{
+		Config: &gobgpapi.AfiSafiConfig{
+			Family:  &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
+			Enabled: true,
+		},
+		PrefixLimits: &gobgpapi.PrefixLimit{
+			Family:               &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
+			MaxPrefixes:          1000,
+			ShutdownThresholdPct: 75,
+		},
+	}}, peers[0].AfiSafis)
+	assert.Nil(t, peers[1].AfiSafis, "the address families of a peer without limit should be left to GoBGP")
+
+	_, err = newGlobalPeers([]net.IP{net.ParseIP("10.0.0.253"), net.ParseIP("10.0.0.254")}, nil,
+		[]uint32{65000, 65000}, nil, []uint32{1000}, nil, 90, "10.0.0.1")
+	assert.Error(t, err, "the number of maximum prefixes should match the number of peers")
+}
+
+func Test_OnPrefixLimit(t *testing.T) {
+	nrc := &NetworkRoutingController{
+		bgpServer:                  gobgp.NewBgpServer(),
+		peerMaxPrefixesRestartTime: 500 * time.Millisecond,
+	}
+	go nrc.bgpServer.Serve()
+	err := nrc.bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 64512, RouterId: "10.0.0.1", ListenPort: -1},
+	})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	err = nrc.bgpServer.AddPeer(context.Background(), &gobgpapi.AddPeerRequest{Peer: &gobgpapi.Peer{
+		Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.0.254", PeerAsn: 65000},
+	}})
+	if err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	adminState := func() gobgpapi.PeerState_AdminState {
+		var state gobgpapi.PeerState_AdminState
+		err := nrc.bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{Address: "10.0.0.254"},
+			func(peer *gobgpapi.Peer) {
+				state = peer.State.AdminState
+			})
+		if err != nil {
+			t.Fatalf("failed to list peers: %v", err)
+		}
+		return state
+	}
+
+	nrc.OnPrefixLimit("10.0.0.254", "ipv4-unicast", maxPrefixesWarning)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, gobgpapi.PeerState_UP, adminState(), "the peer should not be shut down on a warning")
+
+	nrc.OnPrefixLimit("10.0.0.254", "ipv4-unicast", maxPrefixesMaximum)
+	assert.Eventually(t, func() bool {
+		return adminState() == gobgpapi.PeerState_DOWN && nrc.isMaxPrefixesDisabledPeer("10.0.0.254")
+	}, 5*time.Second, 10*time.Millisecond, "the peer should be shut down when it exceeds its maximum")
+
+	assert.Eventually(t, func() bool {
+		return adminState() == gobgpapi.PeerState_UP && !nrc.isMaxPrefixesDisabledPeer("10.0.0.254")
+	}, 5*time.Second, 10*time.Millisecond, "the peer should be started again after the restart time")
+}
@@ -0,0 +1,149 @@
+package routing
+
+import (
+	"net"
+	"sort"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// injectMultipathRoutes handles the paths watched from GoBGP when multipath is enabled. For each prefix whose paths
+// changed, GoBGP then sends all the paths that are as good as the best one, or the withdrawn best path when none is
+// left. The routes to the prefixes learned from external peers with an import filter are installed through the next
+// hops of all these paths, the other routes are injected through the best one as without multipath.
+func (nrc *NetworkRoutingController) injectMultipathRoutes(paths []*gobgpapi.Path) {
+	var prefixes []string
+	dsts := make(map[string]*net.IPNet)
+	pathsByPrefix := make(map[string][]*gobgpapi.Path)
+	for _, path := range paths {
+		if (path.Family.Afi != gobgpapi.Family_AFI_IP && path.Family.Afi != gobgpapi.Family_AFI_IP6) ||
+			path.Family.Safi != gobgpapi.Family_SAFI_UNICAST {
+			continue
+		}
+		if nrc.MetricsEnabled {
+			metrics.ControllerBGPadvertisementsReceived.Inc()
+		}
+		// the paths originated by the node are always the best ones
+		if path.NeighborIp == "<nil>" {
+			continue
+		}
+		dst, _, err := parseBGPPath(path)
+		if err != nil {
+			klog.Errorf("Failed to inject routes due to: " + err.Error())
+			continue
+		}
+		if _, ok := pathsByPrefix[dst.String()]; !ok {
+			prefixes = append(prefixes, dst.String())
+			dsts[dst.String()] = dst
+		}
+		pathsByPrefix[dst.String()] = append(pathsByPrefix[dst.String()], path)
+	}
+
+	for _, prefix := range prefixes {
+		klog.V(2).Infof("Processing %d bgp route advertisements for %s", len(pathsByPrefix[prefix]), prefix)
+		var err error
+		bestPath := pathsByPrefix[prefix][0]
+		if nrc.hasImportedPaths(prefix, pathsByPrefix[prefix]) {
+			err = nrc.injectImportedMultipathRoute(dsts[prefix], pathsByPrefix[prefix])
+			// once none of the paths is imported any longer, the route is injected through the best path
+			if err == nil && !bestPath.IsWithdraw && !nrc.isImportedRoute(prefix) {
+				err = nrc.injectRoute(bestPath)
+			}
+		} else {
+			err = nrc.injectRoute(bestPath)
+		}
+		if err != nil {
+			klog.Errorf("Failed to inject routes due to: " + err.Error())
+		}
+	}
+}
+
+// hasImportedPaths returns whether the paths of a prefix were learned from an external peer with an import filter,
+// or the route to the prefix was installed through such a peer before
+func (nrc *NetworkRoutingController) hasImportedPaths(prefix string, paths []*gobgpapi.Path) bool {
+	nrc.bgpPeerImportsMu.Lock()
+	defer nrc.bgpPeerImportsMu.Unlock()
+	if _, ok := nrc.importedRoutes[prefix]; ok {
+		return true
+	}
+	for _, path := range paths {
+		if _, ok := nrc.bgpPeerImports[path.NeighborIp]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// injectImportedMultipathRoute installs the route to a prefix through the next hops of all its paths learned from
+// external peers with an import filter, or removes it when there is none
+func (nrc *NetworkRoutingController) injectImportedMultipathRoute(dst *net.IPNet, paths []*gobgpapi.Path) error {
+	nrc.bgpPeerImportsMu.Lock()
+	defer nrc.bgpPeerImportsMu.Unlock()
+
+	nextHops := make(map[string]net.IP)
+	for _, path := range paths {
+		if _, ok := nrc.bgpPeerImports[path.NeighborIp]; !ok || path.IsWithdraw {
+			continue
+		}
+		_, nextHop, err := parseBGPPath(path)
+		if err != nil {
+			return err
+		}
+		if (nextHop.To4() == nil) != (dst.IP.To4() == nil) {
+			klog.Errorf("Next hop %s learned from peer %s for %s is of a different IP family", nextHop,
+				path.NeighborIp, dst)
+			continue
+		}
+		klog.V(2).Infof("Inject route: '%s via %s' learned from peer %s to routing table", dst, nextHop,
+			path.NeighborIp)
+		nextHops[path.NeighborIp] = nextHop
+	}
+	if len(nextHops) == 0 {
+		klog.V(2).Infof("Removing route to %s learned from external peers from the routing table", dst)
+	}
+	nrc.importedRoutes[dst.String()] = nextHops
+	return nrc.syncImportedRoute(dst)
+}
+
+// newImportedRoute returns the route to a prefix through the next hops learned from external peers, indexed by peer
+// address, with the given metric. A multipath route is returned when there are several next hops, up to maxPaths of
+// them.
+func newImportedRoute(dst *net.IPNet, nextHops map[string]net.IP, maxPaths int, metric int) *netlink.Route {
+	addresses := make([]string, 0, len(nextHops))
+	for address := range nextHops {
+		addresses = append(addresses, address)
+	}
+	// the selected next hops must not change from one update to the next when there are more than maxPaths of them
+	sort.Strings(addresses)
+
+	var gateways []net.IP
+	seen := make(map[string]bool)
+	for _, address := range addresses {
+		nextHop := nextHops[address]
+		if seen[nextHop.String()] {
+			continue
+		}
+		seen[nextHop.String()] = true
+		gateways = append(gateways, nextHop)
+		if len(gateways) == maxPaths {
+			break
+		}
+	}
+
+	route := &netlink.Route{
+		Dst:      dst,
+		Protocol: zebraRouteOriginator,
+		Priority: metric,
+	}
+	if len(gateways) == 1 {
+		route.Gw = gateways[0]
+		return route
+	}
+	for _, gateway := range gateways {
+		route.MultiPath = append(route.MultiPath, &netlink.NexthopInfo{Gw: gateway})
+	}
+	return route
+}
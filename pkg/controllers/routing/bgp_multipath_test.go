@@ -0,0 +1,148 @@
+package routing
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func newTestPath(t *testing.T, prefix string, prefixLen uint32, nextHop, neighbor string) *gobgpapi.Path {
+	nlri, err := anypb.New(&gobgpapi.IPAddressPrefix{Prefix: prefix, PrefixLen: prefixLen})
+	if err != nil {
+		t.Fatalf("failed to marshal nlri: %v", err)
+	}
+	nextHopAttr, err := anypb.New(&gobgpapi.NextHopAttribute{NextHop: nextHop})
+	if err != nil {
+		t.Fatalf("failed to marshal next hop: %v", err)
+	}
+	return &gobgpapi.Path{
+		Family:     &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
+		Nlri:       nlri,
+		Pattrs:     []*anypb.Any{nextHopAttr},
+		NeighborIp: neighbor,
+	}
+}
+
+func Test_newImportedRoute(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("0.0.0.0/0")
+	testcases := []struct {
+		name        string
+		nextHops    map[string]net.IP
+		maxPaths    int
+		expectedGw  net.IP
+		expectedGws []net.IP
+	}{
+		{
+			"single next hop",
+			map[string]net.IP{"10.0.0.253": net.ParseIP("10.0.0.253")},
+			4,
+			net.ParseIP("10.0.0.253"),
+			nil,
+		},
+		{
+			"next hops of several peers",
+			map[string]net.IP{"10.0.0.254": net.ParseIP("10.0.0.254"), "10.0.0.253": net.ParseIP("10.0.0.253")},
+			4,
+			nil,
+			[]net.IP{net.ParseIP("10.0.0.253"), net.ParseIP("10.0.0.254")},
+		},
+		{
+			"peers advertising the same next hop",
+			map[string]net.IP{"10.0.0.254": net.ParseIP("10.0.0.1"), "10.0.0.253": net.ParseIP("10.0.0.1")},
+			4,
+			net.ParseIP("10.0.0.1"),
+			nil,
+		},
+		{
+			"next hops are limited to the maximum number of paths",
+			map[string]net.IP{
+				"10.0.0.254": net.ParseIP("10.0.0.254"),
+				"10.0.0.253": net.ParseIP("10.0.0.253"),
+				"10.0.0.252": net.ParseIP("10.0.0.252"),
+			},
+			2,
+			nil,
+			[]net.IP{net.ParseIP("10.0.0.252"), net.ParseIP("10.0.0.253")},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			route := newImportedRoute(dst, testcase.nextHops, testcase.maxPaths, 100)
+			assert.Equal(t, dst, route.Dst)
+			assert.Equal(t, 100, route.Priority)
+			assert.Equal(t, netlink.RouteProtocol(zebraRouteOriginator), route.Protocol)
+			assert.Equal(t, testcase.expectedGw, route.Gw)
+			var gws []net.IP
+			for _, nextHop := range route.MultiPath {
+				gws = append(gws, nextHop.Gw)
+			}
+			assert.Equal(t, testcase.expectedGws, gws)
+		})
+	}
+}
+
+func Test_injectMultipathRoutes(t *testing.T) {
+	fn := newFakeNetlink()
+	syncer := newRouteSyncer(time.Minute, injectedRoutesCleanupEnabled)
+	syncer.ln = fn
+	syncer.routeReplacer = fn.routeReplace
+	nrc := &NetworkRoutingController{
+		bgpMaxPaths:    4,
+		routeSyncer:    syncer,
+		ln:             fn,
+		importedRoutes: make(map[string]map[string]net.IP),
+		bgpPeerImports: map[string]*bgpPeerImport{
+			"10.0.0.253": {name: "bgppeerimport-tor-a", address: "10.0.0.253"},
+			"10.0.0.254": {name: "bgppeerimport-tor-b", address: "10.0.0.254"},
+		},
+	}
+	gateways := func() []string {
+		if len(fn.routes) != 1 {
+			t.Fatalf("expected a single route, got %v", fn.routes)
+		}
+		if fn.routes[0].Gw != nil {
+			return []string{fn.routes[0].Gw.String()}
+		}
+		var gws []string
+		for _, nextHop := range fn.routes[0].MultiPath {
+			gws = append(gws, nextHop.Gw.String())
+		}
+		return gws
+	}
+
+	nrc.injectMultipathRoutes([]*gobgpapi.Path{
+		newTestPath(t, "0.0.0.0", 0, "10.0.0.254", "10.0.0.254"),
+		newTestPath(t, "0.0.0.0", 0, "10.0.0.253", "10.0.0.253"),
+	})
+	assert.Equal(t, []string{"10.0.0.253", "10.0.0.254"}, gateways(),
+		"the route should go through the next hops of both peers")
+
+	nrc.injectMultipathRoutes([]*gobgpapi.Path{
+		newTestPath(t, "0.0.0.0", 0, "10.0.0.254", "10.0.0.254"),
+	})
+	assert.Equal(t, []string{"10.0.0.254"}, gateways(),
+		"the route should only go through the remaining path")
+
+	nrc.injectMultipathRoutes([]*gobgpapi.Path{
+		newTestPath(t, "0.0.0.0", 0, "10.0.0.254", "10.0.0.254"),
+		newTestPath(t, "0.0.0.0", 0, "10.0.0.253", "10.0.0.253"),
+	})
+	nrc.setBGPPeerImports(map[string]*bgpPeerImport{
+		"10.0.0.253": {name: "bgppeerimport-tor-a", address: "10.0.0.253"},
+	})
+	assert.Equal(t, []string{"10.0.0.253"}, gateways(),
+		"the next hop of the peer without import filter should be removed")
+
+	nrc.injectMultipathRoutes([]*gobgpapi.Path{
+		newTestPath(t, "0.0.0.0", 0, "10.0.0.254", "10.0.0.254"),
+	})
+	assert.NotContains(t, nrc.importedRoutes, "0.0.0.0/0",
+		"the route should no longer be imported once the best path is learned from a peer without import filter")
+	assert.Empty(t, fn.routes)
+}
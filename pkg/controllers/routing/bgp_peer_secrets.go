@@ -0,0 +1,201 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"google.golang.org/protobuf/proto"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+)
+
+const secretKeyReferenceParts = 3
+
+// parseSecretKeyReferences parses the references to the Secrets holding the passwords of the peers, formatted as
+// namespace/name/key, a blank item is returned as nil for the peers without password Secret
+func parseSecretKeyReferences(refs []string) ([]*v1alpha1.SecretKeyReference, error) {
+	secretRefs := make([]*v1alpha1.SecretKeyReference, 0, len(refs))
+	for _, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			secretRefs = append(secretRefs, nil)
+			continue
+		}
+		parts := strings.Split(ref, "/")
+		if len(parts) != secretKeyReferenceParts || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("could not parse \"%s\" as a secret key reference, expected namespace/name/key",
+				ref)
+		}
+		secretRefs = append(secretRefs, &v1alpha1.SecretKeyReference{Namespace: parts[0], Name: parts[1],
+			Key: parts[2]})
+	}
+	return secretRefs, nil
+}
+
+// setPeerPasswordsFromSecrets sets the passwords of the peers to the values of the Secret keys referenced in the
+// same order, they take precedence over the passwords given directly
+func (nrc *NetworkRoutingController) setPeerPasswordsFromSecrets(peers []*gobgpapi.Peer,
+	refs []*v1alpha1.SecretKeyReference) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	if len(refs) != len(peers) {
+		return fmt.Errorf("the number of password secrets should either be zero, or one per peer router, use " +
+			"blank items for the routers without password secret")
+	}
+	for i, ref := range refs {
+		if ref == nil {
+			continue
+		}
+		password, err := nrc.getSecretKey(ref)
+		if err != nil {
+			return fmt.Errorf("failed to get the password of peer %s: %s", peers[i].Conf.NeighborAddress, err)
+		}
+		peers[i].Conf.AuthPassword = password
+	}
+	return nil
+}
+
+// getSecretKey returns the value of the key of the referenced Secret, from the Secret informer when the Secrets of
+// its namespace are watched
+func (nrc *NetworkRoutingController) getSecretKey(ref *v1alpha1.SecretKeyReference) (string, error) {
+	var secret *v1core.Secret
+	if nrc.secretLister != nil && ref.Namespace == nrc.secretNamespace {
+		obj, exists, err := nrc.secretLister.GetByKey(ref.Namespace + "/" + ref.Name)
+		if err != nil {
+			return "", fmt.Errorf("failed to get secret %s/%s: %s", ref.Namespace, ref.Name, err)
+		}
+		if !exists {
+			return "", fmt.Errorf("secret %s/%s not found", ref.Namespace, ref.Name)
+		}
+		var ok bool
+		if secret, ok = obj.(*v1core.Secret); !ok {
+			return "", fmt.Errorf("unexpected object type: %T", obj)
+		}
+	} else {
+		var err error
+		secret, err = nrc.clientset.CoreV1().Secrets(ref.Namespace).Get(context.Background(), ref.Name,
+			metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get secret %s/%s: %s", ref.Namespace, ref.Name, err)
+		}
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in secret %s/%s", ref.Key, ref.Namespace, ref.Name)
+	}
+	return string(value), nil
+}
+
+// referencesSecret returns whether one of the references points to the given Secret
+func referencesSecret(refs []*v1alpha1.SecretKeyReference, secret *v1core.Secret) bool {
+	for _, ref := range refs {
+		if ref != nil && ref.Namespace == secret.Namespace && ref.Name == secret.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (nrc *NetworkRoutingController) newSecretEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nrc.OnSecretUpdate(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			nrc.OnSecretUpdate(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			nrc.OnSecretUpdate(obj)
+		},
+	}
+}
+
+// OnSecretUpdate handles the updates of the Secret watcher. When the Secret holds the password of some peers, they
+// are updated with the new password, which resets their session.
+func (nrc *NetworkRoutingController) OnSecretUpdate(obj interface{}) {
+	if !nrc.bgpServerStarted {
+		return
+	}
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	secret, ok := obj.(*v1core.Secret)
+	if !ok {
+		klog.Errorf("unexpected object type: %T", obj)
+		return
+	}
+
+	if referencesSecret(nrc.globalPeerPasswordSecrets, secret) {
+		klog.V(2).Infof("Received update of secret %s/%s holding BGP peer passwords, syncing global peers",
+			secret.Namespace, secret.Name)
+		nrc.syncGlobalPeerPasswords()
+	}
+
+	node, err := nrc.getLocalNode()
+	if err != nil {
+		klog.Errorf("Failed to get the local node: %s", err)
+	} else {
+		refs, err := parseSecretKeyReferences(stringToSlice(node.Annotations[peerPasswordSecretAnnotation], ","))
+		if err == nil && referencesSecret(refs, secret) {
+			klog.V(2).Infof("Received update of secret %s/%s holding BGP peer passwords, syncing node peers",
+				secret.Namespace, secret.Name)
+			if err = nrc.syncNodePeers(node); err != nil {
+				klog.Errorf("Error syncing BGP peers from node annotations: %s", err)
+			}
+		}
+	}
+
+	if nrc.bgpPeerLister != nil {
+		for _, obj := range nrc.bgpPeerLister.List() {
+			bgpPeer := &v1alpha1.BGPPeer{}
+			if err := fromUnstructured(obj, bgpPeer); err != nil {
+				continue
+			}
+			if referencesSecret([]*v1alpha1.SecretKeyReference{bgpPeer.Spec.PasswordSecretRef}, secret) {
+				klog.V(2).Infof("Received update of secret %s/%s holding the BGP peer password of BGPPeer %s, "+
+					"syncing BGP peers", secret.Namespace, secret.Name, bgpPeer.Name)
+				nrc.syncBGPPeersAndPolicies()
+				break
+			}
+		}
+	}
+}
+
+// syncGlobalPeerPasswords updates the global peers configured through flags with the passwords of the Secrets they
+// reference
+func (nrc *NetworkRoutingController) syncGlobalPeerPasswords() {
+	nrc.bgpPeersMu.Lock()
+	defer nrc.bgpPeersMu.Unlock()
+
+	for i, ref := range nrc.globalPeerPasswordSecrets {
+		if ref == nil || i >= len(nrc.globalPeerRouters) {
+			continue
+		}
+		peer := nrc.globalPeerRouters[i]
+		address := peer.Conf.NeighborAddress
+		password, err := nrc.getSecretKey(ref)
+		if err != nil {
+			klog.Errorf("Failed to get the password of BGP peer %s, keeping the current one: %s", address, err)
+			continue
+		}
+		if password == peer.Conf.AuthPassword {
+			continue
+		}
+		updated := proto.Clone(peer).(*gobgpapi.Peer)
+		updated.Conf.AuthPassword = password
+		if _, err = nrc.bgpServer.UpdatePeer(context.Background(),
+			&gobgpapi.UpdatePeerRequest{Peer: updated}); err != nil {
+			klog.Errorf("Failed to update the password of BGP peer %s: %s", address, err)
+			continue
+		}
+		klog.Infof("Updated the password of BGP peer %s from secret %s/%s", address, ref.Namespace, ref.Name)
+		nrc.globalPeerRouters[i] = updated
+	}
+}
@@ -0,0 +1,217 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+)
+
+func newTestSecret(namespace, name, key, value string) *v1core.Secret {
+	return &v1core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Data:       map[string][]byte{key: []byte(value)},
+	}
+}
+
+func Test_parseSecretKeyReferences(t *testing.T) {
+	testcases := []struct {
+		name         string
+		refs         []string
+		expectedRefs []*v1alpha1.SecretKeyReference
+		expectError  bool
+	}{
+		{
+			"references with blank items",
+			[]string{"kube-system/bgp/tor-a", "", " kube-system/bgp/tor-b "},
+			[]*v1alpha1.SecretKeyReference{
+				{Namespace: "kube-system", Name: "bgp", Key: "tor-a"},
+				nil,
+				{Namespace: "kube-system", Name: "bgp", Key: "tor-b"},
+			},
+			false,
+		},
+		{
+			"reference without namespace",
+			[]string{"bgp/tor-a"},
+			nil,
+			true,
+		},
+		{
+			"reference with an empty key",
+			[]string{"kube-system/bgp/"},
+			nil,
+			true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			refs, err := parseSecretKeyReferences(testcase.refs)
+			if testcase.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testcase.expectedRefs, refs)
+		})
+	}
+}
+
+func Test_setPeerPasswordsFromSecrets(t *testing.T) {
+	newPeers := func() []*gobgpapi.Peer {
+		return []*gobgpapi.Peer{
+			{Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.0.253", AuthPassword: "from-flags"}},
+			{Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.0.254", AuthPassword: "from-flags"}},
+		}
+	}
+	refs := []*v1alpha1.SecretKeyReference{nil, {Namespace: "kube-system", Name: "bgp", Key: "tor-b"}}
+	secret := newTestSecret("kube-system", "bgp", "tor-b", "from-secret")
+
+	t.Run("secrets read from the API server", func(t *testing.T) {
+		nrc := &NetworkRoutingController{clientset: fake.NewSimpleClientset(secret)}
+		peers := newPeers()
+		assert.NoError(t, nrc.setPeerPasswordsFromSecrets(peers, refs))
+		assert.Equal(t, "from-flags", peers[0].Conf.AuthPassword)
+		assert.Equal(t, "from-secret", peers[1].Conf.AuthPassword)
+	})
+
+	t.Run("secrets read from the informer", func(t *testing.T) {
+		nrc := &NetworkRoutingController{
+			clientset:       fake.NewSimpleClientset(),
+			secretLister:    cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+			secretNamespace: "kube-system",
+		}
+		if err := nrc.secretLister.Add(secret); err != nil {
+			t.Fatalf("failed to add secret: %v", err)
+		}
+		peers := newPeers()
+		assert.NoError(t, nrc.setPeerPasswordsFromSecrets(peers, refs))
+		assert.Equal(t, "from-secret", peers[1].Conf.AuthPassword)
+	})
+
+	t.Run("secrets outside the watched namespace read from the API server", func(t *testing.T) {
+		nrc := &NetworkRoutingController{
+			clientset:       fake.NewSimpleClientset(newTestSecret("bgp", "bgp", "tor-b", "from-api")),
+			secretLister:    cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+			secretNamespace: "kube-system",
+		}
+		peers := newPeers()
+		assert.NoError(t, nrc.setPeerPasswordsFromSecrets(peers,
+			[]*v1alpha1.SecretKeyReference{nil, {Namespace: "bgp", Name: "bgp", Key: "tor-b"}}))
+		assert.Equal(t, "from-api", peers[1].Conf.AuthPassword)
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		nrc := &NetworkRoutingController{
+			clientset: fake.NewSimpleClientset(newTestSecret("kube-system", "bgp", "tor-a", "from-secret")),
+		}
+		assert.Error(t, nrc.setPeerPasswordsFromSecrets(newPeers(), refs))
+	})
+
+	t.Run("number of references not matching the peers", func(t *testing.T) {
+		nrc := &NetworkRoutingController{clientset: fake.NewSimpleClientset(secret)}
+		assert.Error(t, nrc.setPeerPasswordsFromSecrets(newPeers(), refs[1:]))
+	})
+}
+
+func Test_OnSecretUpdate(t *testing.T) {
+	nrc := &NetworkRoutingController{
+		clientset:        fake.NewSimpleClientset(),
+		nodeName:         "node-1",
+		nodeIP:           net.ParseIP("10.0.0.1"),
+		bgpHoldtime:      90,
+		bgpServer:        gobgp.NewBgpServer(),
+		bgpServerStarted: true,
+		bgpPeers:         make(map[string]*gobgpapi.Peer),
+		nodeLister:       cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		secretLister:     cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		secretNamespace:  "kube-system",
+	}
+	go nrc.bgpServer.Serve()
+	err := nrc.bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 64512, RouterId: "10.0.0.1", ListenPort: -1},
+	})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+
+	getPasswords := func() map[string]string {
+		passwords := make(map[string]string)
+		err := nrc.bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{},
+			func(peer *gobgpapi.Peer) {
+				passwords[peer.Conf.NeighborAddress] = peer.Conf.AuthPassword
+			})
+		if err != nil {
+			t.Fatalf("failed to list peers: %v", err)
+		}
+		return passwords
+	}
+	updateSecret := func(secret *v1core.Secret) {
+		if err := nrc.secretLister.Update(secret); err != nil {
+			t.Fatalf("failed to update secret: %v", err)
+		}
+		nrc.OnSecretUpdate(secret)
+	}
+
+	if err := nrc.secretLister.Add(newTestSecret("kube-system", "bgp", "tor-a", "first")); err != nil {
+		t.Fatalf("failed to add secret: %v", err)
+	}
+	node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{
+		Name: "node-1",
+		Annotations: map[string]string{
+			peerASNAnnotation:            "65000,65001",
+			peerIPAnnotation:             "10.0.0.253,10.0.0.254",
+			peerPasswordSecretAnnotation: "kube-system/bgp/tor-a,",
+		},
+	}}
+	if err := nrc.nodeLister.Add(node); err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+	if err := nrc.syncNodePeers(node); err != nil {
+		t.Fatalf("failed to sync node peers: %v", err)
+	}
+	assert.Equal(t, map[string]string{"10.0.0.253": "first", "10.0.0.254": ""}, getPasswords())
+
+	updateSecret(newTestSecret("kube-system", "bgp", "tor-a", "second"))
+	assert.Equal(t, map[string]string{"10.0.0.253": "second", "10.0.0.254": ""}, getPasswords(),
+		"the password of the node peer should be rotated")
+
+	updateSecret(newTestSecret("kube-system", "bgp", "other-key", "third"))
+	assert.Equal(t, map[string]string{"10.0.0.253": "second", "10.0.0.254": ""}, getPasswords(),
+		"the current password should be kept when the key is missing")
+
+	// global peers configured through flags
+	if err := nrc.syncNodePeers(&v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}); err != nil {
+		t.Fatalf("failed to sync node peers: %v", err)
+	}
+	nrc.nodePeerRouters = nil
+	nrc.globalPeerRouters = []*gobgpapi.Peer{
+		{Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.0.100", PeerAsn: 65100, AuthPassword: "second"}},
+	}
+	nrc.globalPeerPasswordSecrets = []*v1alpha1.SecretKeyReference{
+		{Namespace: "kube-system", Name: "bgp", Key: "tor-a"},
+	}
+	err = nrc.bgpServer.AddPeer(context.Background(), &gobgpapi.AddPeerRequest{Peer: nrc.globalPeerRouters[0]})
+	if err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	updateSecret(newTestSecret("kube-system", "bgp", "tor-a", "fourth"))
+	assert.Equal(t, map[string]string{"10.0.0.100": "fourth"}, getPasswords(),
+		"the password of the global peer should be rotated")
+	assert.Equal(t, "fourth", nrc.globalPeerRouters[0].Conf.AuthPassword)
+}
@@ -0,0 +1,216 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
+	"github.com/prometheus/client_golang/prometheus"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	bgpPeersEstablishedCondition v1core.NodeConditionType = "BGPPeersEstablished"
+
+	bgpPeersEstablishedReason    = "AllPeersEstablished"
+	bgpPeersNotEstablishedReason = "PeersNotEstablished"
+	bgpPeersNoneReason           = "NoPeers"
+)
+
+// syncBGPPeerStatus collects the state of the BGP sessions with the peers of the node from GoBGP, and exports it in
+// the per peer metrics and the BGPPeersEstablished condition of the node
+func (nrc *NetworkRoutingController) syncBGPPeerStatus() {
+	if !nrc.MetricsEnabled && !nrc.bgpPeersNodeCondition {
+		return
+	}
+	nrc.bgpPeerStatusMu.Lock()
+	defer nrc.bgpPeerStatusMu.Unlock()
+
+	var peers []*gobgpapi.Peer
+	err := nrc.bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{EnableAdvertised: true},
+		func(peer *gobgpapi.Peer) {
+			peers = append(peers, peer)
+		})
+	if err != nil {
+		klog.Errorf("Failed to list the BGP peers to report their state: %s", err)
+		return
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Conf.NeighborAddress < peers[j].Conf.NeighborAddress
+	})
+
+	if nrc.MetricsEnabled {
+		nrc.updateBGPPeerMetrics(peers, time.Now())
+	}
+	if nrc.bgpPeersNodeCondition {
+		if err = nrc.updateBGPPeersCondition(newBGPPeersCondition(peers)); err != nil {
+			klog.Errorf("Failed to update the %s condition of the node: %s", bgpPeersEstablishedCondition, err)
+		}
+	}
+}
+
+// watchBGPPeerState reports the state of the BGP sessions as soon as one changes, rather than on the next periodic
+// sync only
+func (nrc *NetworkRoutingController) watchBGPPeerState() {
+	err := nrc.bgpServer.WatchEvent(context.Background(), &gobgpapi.WatchEventRequest{
+		Peer: &gobgpapi.WatchEventRequest_Peer{},
+	}, func(r *gobgpapi.WatchEventResponse) {
+		if peer := r.GetPeer(); peer != nil && peer.Type == gobgpapi.WatchEventResponse_PeerEvent_STATE {
+			go nrc.syncBGPPeerStatus()
+		}
+	})
+	if err != nil {
+		klog.Errorf("Failed to watch the state of the BGP peers: %s", err)
+	}
+}
+
+// updateBGPPeerMetrics sets the per peer metrics, the series of the peers removed since the last update are deleted
+func (nrc *NetworkRoutingController) updateBGPPeerMetrics(peers []*gobgpapi.Peer, now time.Time) {
+	current := make(map[string]bool)
+	for _, peer := range peers {
+		address := peer.Conf.NeighborAddress
+		current[address] = true
+		state := peer.State
+
+		metrics.ControllerBGPPeerSessionState.WithLabelValues(address).Set(float64(state.SessionState))
+		uptime := 0.0
+		if state.SessionState == gobgpapi.PeerState_ESTABLISHED && peer.Timers.GetState().GetUptime() != nil {
+			uptime = now.Sub(peer.Timers.State.Uptime.AsTime()).Seconds()
+		}
+		metrics.ControllerBGPPeerUptime.WithLabelValues(address).Set(uptime)
+		metrics.ControllerBGPPeerFlaps.WithLabelValues(address).Set(float64(state.Flops))
+		metrics.ControllerBGPPeerNotifications.WithLabelValues(address, "received").Set(
+			float64(state.GetMessages().GetReceived().GetNotification()))
+		metrics.ControllerBGPPeerNotifications.WithLabelValues(address, "sent").Set(
+			float64(state.GetMessages().GetSent().GetNotification()))
+
+		var received, accepted, advertised uint64
+		for _, afiSafi := range peer.AfiSafis {
+			received += afiSafi.GetState().GetReceived()
+			accepted += afiSafi.GetState().GetAccepted()
+			advertised += afiSafi.GetState().GetAdvertised()
+		}
+		metrics.ControllerBGPPeerPrefixes.WithLabelValues(address, "received").Set(float64(received))
+		metrics.ControllerBGPPeerPrefixes.WithLabelValues(address, "accepted").Set(float64(accepted))
+		metrics.ControllerBGPPeerPrefixes.WithLabelValues(address, "advertised").Set(float64(advertised))
+		metrics.ControllerBGPPeerMaxPrefixes.WithLabelValues(address).Set(float64(getPeerMaxPrefixes(peer)))
+	}
+
+	for address := range nrc.bgpPeerMetricsPeers {
+		if current[address] {
+			continue
+		}
+		labels := prometheus.Labels{"peer": address}
+		metrics.ControllerBGPPeerSessionState.DeletePartialMatch(labels)
+		metrics.ControllerBGPPeerUptime.DeletePartialMatch(labels)
+		metrics.ControllerBGPPeerFlaps.DeletePartialMatch(labels)
+		metrics.ControllerBGPPeerNotifications.DeletePartialMatch(labels)
+		metrics.ControllerBGPPeerLastNotification.DeletePartialMatch(labels)
+		metrics.ControllerBGPPeerPrefixes.DeletePartialMatch(labels)
+		metrics.ControllerBGPPeerMaxPrefixes.DeletePartialMatch(labels)
+		metrics.ControllerBGPPeerMaxPrefixesReached.DeletePartialMatch(labels)
+	}
+	nrc.bgpPeerMetricsPeers = current
+}
+
+// OnBGPNotification exports the last NOTIFICATION message received from or sent to a BGP peer, which reports the
+// error the session was torn down for. It is called from the BGP server, so it must not wait for it.
+func (nrc *NetworkRoutingController) OnBGPNotification(address string, direction string, code uint8, subcode uint8) {
+	if !nrc.MetricsEnabled {
+		return
+	}
+	nrc.bgpPeerNotificationsMu.Lock()
+	defer nrc.bgpPeerNotificationsMu.Unlock()
+
+	metrics.ControllerBGPPeerLastNotification.DeletePartialMatch(prometheus.Labels{"peer": address,
+		"direction": direction})
+	metrics.ControllerBGPPeerLastNotification.WithLabelValues(address, direction, strconv.Itoa(int(code)),
+		strconv.Itoa(int(subcode)), bgp.NewNotificationErrorCode(code, subcode).String()).SetToCurrentTime()
+}
+
+// newBGPPeersCondition returns the BGPPeersEstablished condition reporting the state of the BGP sessions with the
+// given peers, it is true when all of them are established
+func newBGPPeersCondition(peers []*gobgpapi.Peer) *v1core.NodeCondition {
+	var notEstablished []string
+	for _, peer := range peers {
+		if peer.State.SessionState != gobgpapi.PeerState_ESTABLISHED {
+			// the state itself is left out so that the condition isn't patched on every connection retry
+			notEstablished = append(notEstablished, peer.Conf.NeighborAddress)
+		}
+	}
+
+	condition := &v1core.NodeCondition{
+		Type:    bgpPeersEstablishedCondition,
+		Status:  v1core.ConditionTrue,
+		Reason:  bgpPeersEstablishedReason,
+		Message: fmt.Sprintf("The BGP sessions with all the %d peers are established", len(peers)),
+	}
+	switch {
+	case len(peers) == 0:
+		condition.Reason = bgpPeersNoneReason
+		condition.Message = "The node has no BGP peers"
+	case len(notEstablished) > 0:
+		condition.Status = v1core.ConditionFalse
+		condition.Reason = bgpPeersNotEstablishedReason
+		condition.Message = fmt.Sprintf("The BGP sessions with %d of the %d peers are not established: %s",
+			len(notEstablished), len(peers), strings.Join(notEstablished, ", "))
+	}
+	return condition
+}
+
+// updateBGPPeersCondition patches the BGPPeersEstablished condition of the node when it changed since it was last
+// reported, its transition time is only updated when its status changes
+func (nrc *NetworkRoutingController) updateBGPPeersCondition(condition *v1core.NodeCondition) error {
+	reported := nrc.bgpPeersCondition
+	if reported == nil {
+		// the condition may have been reported before kube-router was restarted
+		node, err := nrc.clientset.CoreV1().Nodes().Get(context.Background(), nrc.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for i := range node.Status.Conditions {
+			if node.Status.Conditions[i].Type == bgpPeersEstablishedCondition {
+				reported = &node.Status.Conditions[i]
+			}
+		}
+	}
+	if reported != nil && reported.Status == condition.Status && reported.Reason == condition.Reason &&
+		reported.Message == condition.Message {
+		nrc.bgpPeersCondition = reported
+		return nil
+	}
+
+	now := metav1.Now()
+	condition.LastHeartbeatTime = now
+	condition.LastTransitionTime = now
+	if reported != nil && reported.Status == condition.Status {
+		condition.LastTransitionTime = reported.LastTransitionTime
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []*v1core.NodeCondition{condition},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = nrc.clientset.CoreV1().Nodes().PatchStatus(context.Background(), nrc.nodeName, patch)
+	if err != nil {
+		return err
+	}
+	if reported == nil || reported.Status != condition.Status {
+		klog.Infof("%s condition of the node is now %s: %s", bgpPeersEstablishedCondition, condition.Status,
+			condition.Message)
+	}
+	nrc.bgpPeersCondition = condition
+	return nil
+}
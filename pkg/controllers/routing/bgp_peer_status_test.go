@@ -0,0 +1,199 @@
+package routing
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestPeerStatus(address string, state gobgpapi.PeerState_SessionState) *gobgpapi.Peer {
+	return &gobgpapi.Peer{
+		Conf:  &gobgpapi.PeerConf{NeighborAddress: address},
+		State: &gobgpapi.PeerState{NeighborAddress: address, SessionState: state},
+	}
+}
+
+func Test_newBGPPeersCondition(t *testing.T) {
+	testcases := []struct {
+		name            string
+		peers           []*gobgpapi.Peer
+		expectedStatus  v1core.ConditionStatus
+		expectedReason  string
+		expectedMessage string
+	}{
+		{
+			"node without peers",
+			nil,
+			v1core.ConditionTrue,
+			bgpPeersNoneReason,
+			"The node has no BGP peers",
+		},
+		{
+			"all the sessions are established",
+			[]*gobgpapi.Peer{
+				newTestPeerStatus("10.0.0.2", gobgpapi.PeerState_ESTABLISHED),
+				newTestPeerStatus("10.0.0.254", gobgpapi.PeerState_ESTABLISHED),
+			},
+			v1core.ConditionTrue,
+			bgpPeersEstablishedReason,
+			"The BGP sessions with all the 2 peers are established",
+		},
+		{
+			"some sessions are not established",
+			[]*gobgpapi.Peer{
+				newTestPeerStatus("10.0.0.2", gobgpapi.PeerState_ESTABLISHED),
+				newTestPeerStatus("10.0.0.253", gobgpapi.PeerState_ACTIVE),
+				newTestPeerStatus("10.0.0.254", gobgpapi.PeerState_IDLE),
+			},
+			v1core.ConditionFalse,
+			bgpPeersNotEstablishedReason,
+			"The BGP sessions with 2 of the 3 peers are not established: 10.0.0.253, 10.0.0.254",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			condition := newBGPPeersCondition(testcase.peers)
+			assert.Equal(t, bgpPeersEstablishedCondition, condition.Type)
+			assert.Equal(t, testcase.expectedStatus, condition.Status)
+			assert.Equal(t, testcase.expectedReason, condition.Reason)
+			assert.Equal(t, testcase.expectedMessage, condition.Message)
+		})
+	}
+}
+
+func Test_updateBGPPeersCondition(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1core.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1core.NodeStatus{
+			Conditions: []v1core.NodeCondition{{Type: v1core.NodeReady, Status: v1core.ConditionTrue}},
+		},
+	})
+	nrc := &NetworkRoutingController{clientset: clientset, nodeName: "node-1"}
+	getCondition := func() *v1core.NodeCondition {
+		node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get node: %v", err)
+		}
+		assert.Len(t, node.Status.Conditions, 2, "the other conditions of the node should be kept")
+		for i := range node.Status.Conditions {
+			if node.Status.Conditions[i].Type == bgpPeersEstablishedCondition {
+				return &node.Status.Conditions[i]
+			}
+		}
+		t.Fatalf("condition %s not found", bgpPeersEstablishedCondition)
+		return nil
+	}
+
+	down := []*gobgpapi.Peer{newTestPeerStatus("10.0.0.254", gobgpapi.PeerState_ACTIVE)}
+	if err := nrc.updateBGPPeersCondition(newBGPPeersCondition(down)); err != nil {
+		t.Fatalf("failed to update condition: %v", err)
+	}
+	condition := getCondition()
+	assert.Equal(t, v1core.ConditionFalse, condition.Status)
+
+	clientset.ClearActions()
+	if err := nrc.updateBGPPeersCondition(newBGPPeersCondition(down)); err != nil {
+		t.Fatalf("failed to update condition: %v", err)
+	}
+	assert.Empty(t, clientset.Actions(), "an unchanged condition should not be patched")
+
+	nrc.bgpPeersCondition.LastTransitionTime = metav1.NewTime(time.Now().Add(-time.Hour))
+	up := []*gobgpapi.Peer{newTestPeerStatus("10.0.0.254", gobgpapi.PeerState_ESTABLISHED)}
+	if err := nrc.updateBGPPeersCondition(newBGPPeersCondition(up)); err != nil {
+		t.Fatalf("failed to update condition: %v", err)
+	}
+	condition = getCondition()
+	assert.Equal(t, v1core.ConditionTrue, condition.Status)
+	assert.WithinDuration(t, time.Now(), condition.LastTransitionTime.Time, time.Minute,
+		"the transition time should be updated when the status changes")
+}
+
+func Test_updateBGPPeerMetrics(t *testing.T) {
+	now := time.Now()
+	established := newTestPeerStatus("10.0.0.254", gobgpapi.PeerState_ESTABLISHED)
+	established.State.Flops = 2
+	established.State.Messages = &gobgpapi.Messages{
+		Received: &gobgpapi.Message{Notification: 3},
+		Sent:     &gobgpapi.Message{Notification: 1},
+	}
+	established.Timers = &gobgpapi.Timers{
+		State: &gobgpapi.TimersState{Uptime: timestamppb.New(now.Add(-time.Minute))},
+	}
+	established.AfiSafis = []*gobgpapi.AfiSafi{
+		{State: &gobgpapi.AfiSafiState{Received: 5, Accepted: 4, Advertised: 2}},
+		{State: &gobgpapi.AfiSafiState{Received: 1, Accepted: 1, Advertised: 2}},
+	}
+	idle := newTestPeerStatus("10.0.0.253", gobgpapi.PeerState_IDLE)
+
+	nrc := &NetworkRoutingController{}
+	nrc.updateBGPPeerMetrics([]*gobgpapi.Peer{established, idle}, now)
+
+	assert.Equal(t, 6.0, testutil.ToFloat64(metrics.ControllerBGPPeerSessionState.WithLabelValues("10.0.0.254")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ControllerBGPPeerSessionState.WithLabelValues("10.0.0.253")))
+	assert.Equal(t, 60.0, testutil.ToFloat64(metrics.ControllerBGPPeerUptime.WithLabelValues("10.0.0.254")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.ControllerBGPPeerUptime.WithLabelValues("10.0.0.253")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.ControllerBGPPeerFlaps.WithLabelValues("10.0.0.254")))
+	assert.Equal(t, 3.0, testutil.ToFloat64(
+		metrics.ControllerBGPPeerNotifications.WithLabelValues("10.0.0.254", "received")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(
+		metrics.ControllerBGPPeerNotifications.WithLabelValues("10.0.0.254", "sent")))
+	assert.Equal(t, 6.0, testutil.ToFloat64(metrics.ControllerBGPPeerPrefixes.WithLabelValues("10.0.0.254", "received")))
+	assert.Equal(t, 5.0, testutil.ToFloat64(metrics.ControllerBGPPeerPrefixes.WithLabelValues("10.0.0.254", "accepted")))
+	assert.Equal(t, 4.0, testutil.ToFloat64(
+		metrics.ControllerBGPPeerPrefixes.WithLabelValues("10.0.0.254", "advertised")))
+
+	nrc.updateBGPPeerMetrics([]*gobgpapi.Peer{established}, now)
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.ControllerBGPPeerSessionState),
+		"the series of the removed peer should be deleted")
+	assert.Equal(t, 3, testutil.CollectAndCount(metrics.ControllerBGPPeerPrefixes),
+		"the series of the removed peer should be deleted")
+}
+
+func Test_OnBGPNotification(t *testing.T) {
+	nrc := &NetworkRoutingController{MetricsEnabled: true}
+	// lastNotifications returns the direction, code and subcode of the series of the peer
+	lastNotifications := func() []string {
+		ch := make(chan prometheus.Metric, 10)
+		metrics.ControllerBGPPeerLastNotification.Collect(ch)
+		close(ch)
+		var series []string
+		for metric := range ch {
+			m := &dto.Metric{}
+			if err := metric.Write(m); err != nil {
+				t.Fatalf("failed to read metric: %v", err)
+			}
+			labels := make(map[string]string)
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["peer"] == "10.0.0.252" {
+				series = append(series, labels["direction"]+" "+labels["code"]+"/"+labels["subcode"])
+			}
+		}
+		sort.Strings(series)
+		return series
+	}
+
+	nrc.OnBGPNotification("10.0.0.252", notificationSent, 6, 2)
+	nrc.OnBGPNotification("10.0.0.252", notificationSent, 6, 1)
+	nrc.OnBGPNotification("10.0.0.252", notificationReceived, 4, 0)
+	assert.Equal(t, []string{"received 4/0", "sent 6/1"}, lastNotifications(),
+		"only the last message of each direction should be exported")
+
+	nrc.bgpPeerMetricsPeers = map[string]bool{"10.0.0.252": true}
+	nrc.updateBGPPeerMetrics(nil, time.Now())
+	assert.Empty(t, lastNotifications(), "the series of the removed peer should be deleted")
+}
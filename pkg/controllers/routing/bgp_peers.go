@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	gobgpapi "github.com/osrg/gobgp/v3/api"
 	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"google.golang.org/protobuf/proto"
 	v1core "k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
@@ -24,6 +26,8 @@ import (
 // we miss any events from API server this method which is called periodically
 // ensures peer relationship with removed nodes is deleted.
 func (nrc *NetworkRoutingController) syncInternalPeers() {
+	// the BFD sessions are synced with the peers once they are added or removed, after the lock is released
+	defer nrc.syncBFDSessions()
 	nrc.mu.Lock()
 	defer nrc.mu.Unlock()
 
@@ -113,39 +117,8 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 				DeferralTime:    uint32(nrc.bgpGracefulRestartDeferralTime.Seconds()),
 				LocalRestarting: true,
 			}
-
-			if nrc.isIpv6 {
-				n.AfiSafis = []*gobgpapi.AfiSafi{
-					{
-						Config: &gobgpapi.AfiSafiConfig{
-							Family:  &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP6, Safi: gobgpapi.Family_SAFI_UNICAST},
-							Enabled: true,
-						},
-						MpGracefulRestart: &gobgpapi.MpGracefulRestart{
-							Config: &gobgpapi.MpGracefulRestartConfig{
-								Enabled: true,
-							},
-							State: &gobgpapi.MpGracefulRestartState{},
-						},
-					},
-				}
-			} else {
-				n.AfiSafis = []*gobgpapi.AfiSafi{
-					{
-						Config: &gobgpapi.AfiSafiConfig{
-							Family:  &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
-							Enabled: true,
-						},
-						MpGracefulRestart: &gobgpapi.MpGracefulRestart{
-							Config: &gobgpapi.MpGracefulRestartConfig{
-								Enabled: true,
-							},
-							State: &gobgpapi.MpGracefulRestartState{},
-						},
-					},
-				}
-			}
 		}
+		n.AfiSafis = nrc.newAfiSafis(nrc.bgpGracefulRestart, 0)
 
 		// we are rr-server peer with other rr-client with reflection enabled
 		if nrc.bgpRRServer {
@@ -197,51 +170,8 @@ func (nrc *NetworkRoutingController) connectToExternalBGPPeers(server *gobgp.Bgp
 	bgpGracefulRestart bool, bgpGracefulRestartDeferralTime time.Duration, bgpGracefulRestartTime time.Duration,
 	peerMultihopTTL uint8) error {
 	for _, n := range peerNeighbors {
-
-		if bgpGracefulRestart {
-			n.GracefulRestart = &gobgpapi.GracefulRestart{
-				Enabled:         true,
-				RestartTime:     uint32(bgpGracefulRestartTime.Seconds()),
-				DeferralTime:    uint32(bgpGracefulRestartDeferralTime.Seconds()),
-				LocalRestarting: true,
-			}
-
-			if nrc.isIpv6 {
-				n.AfiSafis = []*gobgpapi.AfiSafi{
-					{
-						Config: &gobgpapi.AfiSafiConfig{
-							Family:  &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP6, Safi: gobgpapi.Family_SAFI_UNICAST},
-							Enabled: true,
-						},
-						MpGracefulRestart: &gobgpapi.MpGracefulRestart{
-							Config: &gobgpapi.MpGracefulRestartConfig{
-								Enabled: true,
-							},
-						},
-					},
-				}
-			} else {
-				n.AfiSafis = []*gobgpapi.AfiSafi{
-					{
-						Config: &gobgpapi.AfiSafiConfig{
-							Family:  &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
-							Enabled: true,
-						},
-						MpGracefulRestart: &gobgpapi.MpGracefulRestart{
-							Config: &gobgpapi.MpGracefulRestartConfig{
-								Enabled: true,
-							},
-						},
-					},
-				}
-			}
-		}
-		if peerMultihopTTL > 1 {
-			n.EbgpMultihop = &gobgpapi.EbgpMultihop{
-				Enabled:     true,
-				MultihopTtl: uint32(peerMultihopTTL),
-			}
-		}
+		nrc.configureExternalPeer(n, bgpGracefulRestart, bgpGracefulRestartDeferralTime, bgpGracefulRestartTime,
+			peerMultihopTTL)
 		err := server.AddPeer(context.Background(), &gobgpapi.AddPeerRequest{Peer: n})
 		if err != nil {
 			return fmt.Errorf("error peering with peer router "+
@@ -253,9 +183,83 @@ func (nrc *NetworkRoutingController) connectToExternalBGPPeers(server *gobgp.Bgp
 	return nil
 }
 
+// configureExternalPeer sets the graceful restart, address families and eBGP multihop settings of an external peer,
+// the maximum number of prefixes set by newGlobalPeers is kept on each of the address families
+func (nrc *NetworkRoutingController) configureExternalPeer(n *gobgpapi.Peer, bgpGracefulRestart bool,
+	bgpGracefulRestartDeferralTime time.Duration, bgpGracefulRestartTime time.Duration, peerMultihopTTL uint8) {
+	if bgpGracefulRestart {
+		n.GracefulRestart = &gobgpapi.GracefulRestart{
+			Enabled:         true,
+			RestartTime:     uint32(bgpGracefulRestartTime.Seconds()),
+			DeferralTime:    uint32(bgpGracefulRestartDeferralTime.Seconds()),
+			LocalRestarting: true,
+		}
+	}
+	n.AfiSafis = nrc.newAfiSafis(bgpGracefulRestart, getPeerMaxPrefixes(n))
+
+	if peerMultihopTTL > 1 {
+		n.EbgpMultihop = &gobgpapi.EbgpMultihop{
+			Enabled:     true,
+			MultihopTtl: uint32(peerMultihopTTL),
+		}
+	}
+}
+
+// newAfiSafis returns the address families to enable on a BGP peer. Dual-stack nodes enable both IPv4 and IPv6
+// unicast so that pod CIDRs of both families are exchanged over a single session, with IPv6 carried by MP-BGP. When
+// graceful restart is enabled, it is enabled for each of the address families, and so is the maximum number of
+// prefixes when it is not 0. Otherwise nil is returned for single stack nodes, leaving GoBGP to enable the address
+// family of the neighbor address.
+func (nrc *NetworkRoutingController) newAfiSafis(gracefulRestart bool, maxPrefixes uint32) []*gobgpapi.AfiSafi {
+	if nrc.nodeSecondaryIP == nil && !gracefulRestart && maxPrefixes == 0 {
+		return nil
+	}
+
+	afiSafis := make([]*gobgpapi.AfiSafi, 0)
+	for _, isIpv6 := range nrc.ipFamilies() {
+		family := &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST}
+		if isIpv6 {
+			family.Afi = gobgpapi.Family_AFI_IP6
+		}
+		afiSafi := &gobgpapi.AfiSafi{
+			Config: &gobgpapi.AfiSafiConfig{
+				Family:  family,
+				Enabled: true,
+			},
+		}
+		if gracefulRestart {
+			afiSafi.MpGracefulRestart = &gobgpapi.MpGracefulRestart{
+				Config: &gobgpapi.MpGracefulRestartConfig{
+					Enabled: true,
+				},
+				State: &gobgpapi.MpGracefulRestartState{},
+			}
+		}
+		if maxPrefixes != 0 {
+			afiSafi.PrefixLimits = &gobgpapi.PrefixLimit{
+				Family:               family,
+				MaxPrefixes:          maxPrefixes,
+				ShutdownThresholdPct: uint32(nrc.peerMaxPrefixesThreshold),
+			}
+		}
+		afiSafis = append(afiSafis, afiSafi)
+	}
+	return afiSafis
+}
+
+// getPeerMaxPrefixes returns the maximum number of prefixes accepted from the peer, 0 when it is not limited
+func getPeerMaxPrefixes(peer *gobgpapi.Peer) uint32 {
+	for _, afiSafi := range peer.AfiSafis {
+		if afiSafi.PrefixLimits != nil && afiSafi.PrefixLimits.MaxPrefixes != 0 {
+			return afiSafi.PrefixLimits.MaxPrefixes
+		}
+	}
+	return 0
+}
+
 // Does validation and returns neighbor configs
-func newGlobalPeers(ips []net.IP, ports []uint32, asns []uint32, passwords []string, localips []string,
-	holdtime float64, localAddress string) ([]*gobgpapi.Peer, error) {
+func newGlobalPeers(ips []net.IP, ports []uint32, asns []uint32, passwords []string, maxPrefixes []uint32,
+	localips []string, holdtime float64, localAddress string) ([]*gobgpapi.Peer, error) {
 	peers := make([]*gobgpapi.Peer, 0)
 
 	// Validations
@@ -275,6 +279,11 @@ func newGlobalPeers(ips []net.IP, ports []uint32, asns []uint32, passwords []str
 			"Example: \"port,,port\" OR [\"port\",\"\",\"port\"]", strconv.Itoa(options.DefaultBgpPort))
 	}
 
+	if len(ips) != len(maxPrefixes) && len(maxPrefixes) != 0 {
+		return nil, errors.New("invalid peer router config. The number of maximum prefixes should either be " +
+			"zero, or one per peer router. Use 0 for the routers without limit. Example: \"1000,0,1000\"")
+	}
+
 	if len(ips) != len(localips) && len(localips) != 0 {
 		return nil, fmt.Errorf("invalid peer router config. The number of localIPs should either be zero, or "+
 			"one per peer router. If blank items are used, it will default to nodeIP, %s. "+
@@ -314,6 +323,11 @@ func newGlobalPeers(ips []net.IP, ports []uint32, asns []uint32, passwords []str
 			peer.Conf.AuthPassword = passwords[i]
 		}
 
+		// the address families the limit applies to are set by configureExternalPeer
+		if len(maxPrefixes) != 0 && maxPrefixes[i] != 0 {
+			peer.AfiSafis = []*gobgpapi.AfiSafi{{PrefixLimits: &gobgpapi.PrefixLimit{MaxPrefixes: maxPrefixes[i]}}}
+		}
+
 		if len(localips) != 0 && localips[i] != "" {
 			peer.Transport.LocalAddress = localips[i]
 		}
@@ -339,7 +353,34 @@ func (nrc *NetworkRoutingController) newNodeEventHandler() cache.ResourceEventHa
 			nrc.OnNodeUpdate(obj)
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
-			// we are only interested in node add/delete, so skip update
+			// we are only interested in node add/delete, except for the WireGuard public keys of the nodes and the
+			// updates of the local node whose annotations and labels configure its BGP peers and policies
+			oldNode, ok := oldObj.(*v1core.Node)
+			if !ok {
+				return
+			}
+			newNode, ok := newObj.(*v1core.Node)
+			if !ok {
+				return
+			}
+			if oldNode.Annotations[wireGuardPublicKeyAnnotation] != newNode.Annotations[wireGuardPublicKeyAnnotation] {
+				klog.V(2).Infof("Received WireGuard public key update of node %s from watch API, syncing "+
+					"WireGuard peers", newNode.Name)
+				nrc.syncWireGuardPeers()
+			}
+			if newNode.Name != nrc.nodeName {
+				return
+			}
+			if !reflect.DeepEqual(oldNode.Annotations, newNode.Annotations) {
+				klog.V(2).Infof("Received annotations update of node %s from watch API, syncing BGP peers "+
+					"and policies", newNode.Name)
+				nrc.OnLocalNodeUpdate(oldNode, newNode)
+			} else if nrc.bgpPeerLister != nil && !reflect.DeepEqual(oldNode.Labels, newNode.Labels) {
+				klog.V(2).Infof("Received labels update of node %s from watch API, syncing BGP peers", newNode.Name)
+				if nrc.bgpServerStarted {
+					nrc.syncBGPPeersAndPolicies()
+				}
+			}
 		},
 		DeleteFunc: func(obj interface{}) {
 			node, ok := obj.(*v1core.Node)
@@ -373,6 +414,8 @@ func (nrc *NetworkRoutingController) newNodeEventHandler() cache.ResourceEventHa
 // new node is added or old node is deleted. So peer up with new node and drop peering
 // from old node
 func (nrc *NetworkRoutingController) OnNodeUpdate(_ interface{}) {
+	nrc.syncWireGuardPeers()
+
 	if !nrc.bgpServerStarted {
 		return
 	}
@@ -393,3 +436,122 @@ func (nrc *NetworkRoutingController) OnNodeUpdate(_ interface{}) {
 		nrc.disableSourceDestinationCheck()
 	}
 }
+
+// OnLocalNodeUpdate handles the annotation updates of the local node. The node specific external BGP peers are
+// reconciled in place and the policies are updated with the communities, custom import reject and path prepending
+// annotations, without restarting the BGP server.
+func (nrc *NetworkRoutingController) OnLocalNodeUpdate(oldNode, newNode *v1core.Node) {
+	if !nrc.bgpServerStarted {
+		return
+	}
+
+	// these are only read when the BGP server is started
+	for _, annotation := range []string{nodeASNAnnotation, rrServerAnnotation, rrClientAnnotation,
+		bgpLocalAddressAnnotation} {
+		if oldNode.Annotations[annotation] != newNode.Annotations[annotation] {
+			klog.Warningf("Annotation %s of node %s changed, kube-router must be restarted for the change to "+
+				"take effect", annotation, newNode.Name)
+		}
+	}
+
+	err := nrc.syncNodePeers(newNode)
+	if err != nil {
+		klog.Errorf("Error syncing BGP peers from node annotations: %s", err)
+	}
+
+	nrc.policyMu.Lock()
+	err = nrc.setPathPrependFromNode(newNode)
+	if err != nil {
+		klog.Errorf("Ignoring path prepend annotations of node %s: %s", newNode.Name, err)
+	}
+	nrc.setCommunitiesFromNode(newNode)
+	nrc.setCustomImportRejectFromNode(newNode)
+	nrc.policyMu.Unlock()
+
+	nrc.syncBGPPeersAndPolicies()
+}
+
+// syncNodePeers reconciles the node specific external BGP peers of the BGP server with the node annotations. Peers
+// are added, updated and removed in place so that the sessions with the unchanged peers are kept. The annotations are
+// ignored when the global peers are configured through flags.
+func (nrc *NetworkRoutingController) syncNodePeers(node *v1core.Node) error {
+	// the BFD sessions are synced with the peers once they are added, updated or removed, after the lock is released
+	defer nrc.syncBFDSessions()
+	nrc.bgpPeersMu.Lock()
+	defer nrc.bgpPeersMu.Unlock()
+
+	if len(nrc.globalPeerRouters) != 0 && len(nrc.nodePeerRouters) == 0 {
+		klog.V(1).Info("Global peers are configured through flags, ignoring the peer annotations of the node")
+		return nil
+	}
+
+	desiredPeers, _, err := nrc.newNodePeersFromAnnotations(node)
+	if err != nil {
+		return err
+	}
+
+	currentPeers := make(map[string]*gobgpapi.Peer)
+	for _, peer := range nrc.globalPeerRouters {
+		currentPeers[peer.Conf.NeighborAddress] = peer
+	}
+
+	peers := make([]*gobgpapi.Peer, 0)
+	for _, peer := range desiredPeers {
+		nrc.configureExternalPeer(peer, nrc.bgpGracefulRestart, nrc.bgpGracefulRestartDeferralTime,
+			nrc.bgpGracefulRestartTime, nrc.peerMultihopTTL)
+		address := peer.Conf.NeighborAddress
+
+		currentPeer, ok := currentPeers[address]
+		delete(currentPeers, address)
+		if !ok {
+			// node annotations take precedence over BGPPeer resources configuring the same peer
+			if currentPeer, ok = nrc.bgpPeers[address]; ok {
+				delete(nrc.bgpPeers, address)
+			}
+		}
+
+		if !ok {
+			err := nrc.bgpServer.AddPeer(context.Background(), &gobgpapi.AddPeerRequest{Peer: peer})
+			if err != nil {
+				klog.Errorf("Failed to add BGP peer %s: %s", address, err)
+				continue
+			}
+			klog.Infof("Added BGP peer %s in ASN %d from node annotations", address, peer.Conf.PeerAsn)
+		} else if !proto.Equal(currentPeer, peer) {
+			resp, err := nrc.bgpServer.UpdatePeer(context.Background(), &gobgpapi.UpdatePeerRequest{Peer: peer})
+			if err != nil {
+				klog.Errorf("Failed to update BGP peer %s: %s", address, err)
+				peers = append(peers, currentPeer)
+				continue
+			}
+			if resp.NeedsSoftResetIn {
+				err = nrc.bgpServer.ResetPeer(context.Background(), &gobgpapi.ResetPeerRequest{Address: address,
+					Soft: true, Direction: gobgpapi.ResetPeerRequest_IN})
+				if err != nil {
+					klog.Errorf("Failed to soft reset BGP peer %s: %s", address, err)
+				}
+			}
+			klog.Infof("Updated BGP peer %s from node annotations", address)
+		}
+		peers = append(peers, peer)
+	}
+
+	for address, peer := range currentPeers {
+		err := nrc.bgpServer.DeletePeer(context.Background(), &gobgpapi.DeletePeerRequest{Address: address})
+		if err != nil {
+			klog.Errorf("Failed to remove BGP peer %s: %s", address, err)
+			peers = append(peers, peer)
+			continue
+		}
+		klog.Infof("Removed BGP peer %s as it was removed from the node annotations", address)
+	}
+
+	addresses := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		addresses = append(addresses, peer.Conf.NeighborAddress)
+	}
+	nrc.globalPeerRouters = peers
+	nrc.nodePeerRouters = addresses
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func listPeerASNs(t *testing.T, server *gobgp.BgpServer) map[string]uint32 {
+	peers := make(map[string]uint32)
+	err := server.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{}, func(peer *gobgpapi.Peer) {
+		peers[peer.Conf.NeighborAddress] = peer.Conf.PeerAsn
+	})
+	if err != nil {
+		t.Fatalf("failed to list peers: %v", err)
+	}
+	return peers
+}
+
+func Test_syncNodePeers(t *testing.T) {
+	testcases := []struct {
+		name              string
+		globalPeerRouters []*gobgpapi.Peer
+		annotations       []map[string]string
+		expectedPeers     []map[string]uint32
+	}{
+		{
+			"peers follow the node annotations",
+			nil,
+			[]map[string]string{
+				{
+					peerIPAnnotation:  "10.0.0.253,10.0.0.254",
+					peerASNAnnotation: "65000,65000",
+				},
+				{
+					peerIPAnnotation:  "10.0.0.254,10.0.0.252",
+					peerASNAnnotation: "65001,65000",
+				},
+				{},
+			},
+			[]map[string]uint32{
+				{"10.0.0.253": 65000, "10.0.0.254": 65000},
+				{"10.0.0.252": 65000, "10.0.0.254": 65001},
+				{},
+			},
+		},
+		{
+			"invalid annotations leave the peers untouched",
+			nil,
+			[]map[string]string{
+				{
+					peerIPAnnotation:  "10.0.0.254",
+					peerASNAnnotation: "65000",
+				},
+				{
+					peerIPAnnotation:  "10.0.0.254,10.0.0.252",
+					peerASNAnnotation: "65000",
+				},
+			},
+			[]map[string]uint32{
+				{"10.0.0.254": 65000},
+				{"10.0.0.254": 65000},
+			},
+		},
+		{
+			"annotations are ignored when global peers are configured through flags",
+			[]*gobgpapi.Peer{
+				{Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.0.100", PeerAsn: 65100}},
+			},
+			[]map[string]string{
+				{
+					peerIPAnnotation:  "10.0.0.254",
+					peerASNAnnotation: "65000",
+				},
+			},
+			[]map[string]uint32{
+				{},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			nrc := &NetworkRoutingController{
+				nodeIP:            net.ParseIP("10.0.0.1"),
+				bgpHoldtime:       90,
+				bgpServer:         gobgp.NewBgpServer(),
+				globalPeerRouters: testcase.globalPeerRouters,
+			}
+			go nrc.bgpServer.Serve()
+			err := nrc.bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+				Global: &gobgpapi.Global{Asn: 64512, RouterId: "10.0.0.1", ListenPort: -1},
+			})
+			if err != nil {
+				t.Fatalf("failed to start BGP server: %v", err)
+			}
+			defer func() {
+				if err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+					t.Fatalf("failed to stop BGP server: %v", err)
+				}
+			}()
+
+			for i, annotations := range testcase.annotations {
+				node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: annotations}}
+				_ = nrc.syncNodePeers(node)
+
+				peers := listPeerASNs(t, nrc.bgpServer)
+				if !reflect.DeepEqual(peers, testcase.expectedPeers[i]) {
+					t.Logf("expected peers: %v", testcase.expectedPeers[i])
+					t.Logf("actual peers: %v", peers)
+					t.Errorf("unexpected peers after annotations update %d", i)
+				}
+				if testcase.globalPeerRouters != nil {
+					continue
+				}
+				addresses := make([]string, 0)
+				for address := range testcase.expectedPeers[i] {
+					addresses = append(addresses, address)
+				}
+				sort.Strings(addresses)
+				nodePeerRouters := append([]string{}, nrc.nodePeerRouters...)
+				sort.Strings(nodePeerRouters)
+				if !reflect.DeepEqual(nodePeerRouters, addresses) {
+					t.Logf("expected node peer routers: %v", addresses)
+					t.Logf("actual node peer routers: %v", nodePeerRouters)
+					t.Errorf("unexpected node peer routers after annotations update %d", i)
+				}
+			}
+		})
+	}
+}
+
+func Test_OnLocalNodeUpdate(t *testing.T) {
+	oldNode := &v1core.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Annotations: map[string]string{
+				nodeASNAnnotation:         "100",
+				peerIPAnnotation:          "10.0.0.254",
+				peerASNAnnotation:         "65000",
+				nodeCommunitiesAnnotation: "no-export",
+			},
+		},
+		Status: v1core.NodeStatus{
+			Addresses: []v1core.NodeAddress{{Type: v1core.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+		Spec: v1core.NodeSpec{PodCIDR: "172.20.0.0/24"},
+	}
+	nrc := &NetworkRoutingController{
+		clientset:          fake.NewSimpleClientset(),
+		hostnameOverride:   "node-1",
+		nodeName:           "node-1",
+		nodeIP:             net.ParseIP("10.0.0.1"),
+		routerID:           "10.0.0.1",
+		bgpPort:            10000,
+		bgpHoldtime:        90,
+		activeNodes:        make(map[string]bool),
+		podCidr:            "172.20.0.0/24",
+		podCIDRs:           []string{"172.20.0.0/24"},
+		advertisePodCidr:   true,
+		advertiseClusterIP: true,
+	}
+	startInformersForRoutes(nrc, nrc.clientset)
+	if err := createNodes(nrc.clientset, []*v1core.Node{oldNode}); err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+	waitForListerWithTimeout(nrc.nodeLister, time.Second*10, t)
+
+	if err := nrc.startBgpServer(false); err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	nrc.bgpServerStarted = true
+	if err := nrc.AddPolicies(); err != nil {
+		t.Fatalf("failed to add policies: %v", err)
+	}
+
+	newNode := oldNode.DeepCopy()
+	newNode.Annotations = map[string]string{
+		nodeASNAnnotation:                "100",
+		peerIPAnnotation:                 "10.0.0.253",
+		peerASNAnnotation:                "65001",
+		nodeCommunitiesAnnotation:        "65000:100,65000:1:2",
+		nodeCustomImportRejectAnnotation: "192.168.0.0/16",
+		pathPrependASNAnnotation:         "65000",
+		pathPrependRepeatNAnnotation:     "2",
+	}
+	nrc.OnLocalNodeUpdate(oldNode, newNode)
+
+	peers := listPeerASNs(t, nrc.bgpServer)
+	if !reflect.DeepEqual(peers, map[string]uint32{"10.0.0.253": 65001}) {
+		t.Errorf("unexpected peers after node update: %v", peers)
+	}
+
+	err := nrc.bgpServer.ListDefinedSet(context.Background(), &gobgpapi.ListDefinedSetRequest{
+		DefinedType: gobgpapi.DefinedType_NEIGHBOR, Name: "externalpeerset"}, func(ds *gobgpapi.DefinedSet) {
+		if !reflect.DeepEqual(ds.List, []string{"10.0.0.253/32"}) {
+			t.Errorf("unexpected external peer defined set: %v", ds.List)
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to list defined sets: %v", err)
+	}
+
+	err = nrc.bgpServer.ListDefinedSet(context.Background(), &gobgpapi.ListDefinedSetRequest{
+		DefinedType: gobgpapi.DefinedType_PREFIX, Name: "customimportrejectdefinedset"},
+		func(ds *gobgpapi.DefinedSet) {
+			if len(ds.Prefixes) != 1 || ds.Prefixes[0].IpPrefix != "192.168.0.0/16" {
+				t.Errorf("unexpected custom import reject defined set: %v", ds.Prefixes)
+			}
+		})
+	if err != nil {
+		t.Fatalf("failed to list defined sets: %v", err)
+	}
+
+	err = nrc.bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{Name: "kube_router_export"},
+		func(policy *gobgpapi.Policy) {
+			found := false
+			for _, statement := range policy.Statements {
+				if statement.Conditions.NeighborSet.Name != "externalpeerset" {
+					continue
+				}
+				found = true
+				if statement.Actions.Community == nil ||
+					!reflect.DeepEqual(statement.Actions.Community.Communities, []string{"65000:100"}) {
+					t.Errorf("unexpected communities in statement %s: %v", statement.Name, statement.Actions.Community)
+				}
+				if statement.Actions.LargeCommunity == nil ||
+					!reflect.DeepEqual(statement.Actions.LargeCommunity.Communities, []string{"65000:1:2"}) {
+					t.Errorf("unexpected large communities in statement %s: %v", statement.Name,
+						statement.Actions.LargeCommunity)
+				}
+			}
+			if !found {
+				t.Error("expected the export policy to have statements for the external peers")
+			}
+		})
+	if err != nil {
+		t.Fatalf("failed to list policies: %v", err)
+	}
+
+	err = nrc.bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{Name: "kube_router_import"},
+		func(policy *gobgpapi.Policy) {
+			found := false
+			for _, statement := range policy.Statements {
+				if statement.Conditions.PrefixSet.Name == "customimportrejectdefinedset" {
+					found = true
+				}
+			}
+			if !found {
+				t.Error("expected the custom import reject statement to be added to the import policy")
+			}
+		})
+	if err != nil {
+		t.Fatalf("failed to list policies: %v", err)
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 
 	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"google.golang.org/protobuf/proto"
 	v1core "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 
@@ -23,6 +25,9 @@ func (nrc *NetworkRoutingController) AddPolicies() error {
 		return nil
 	}
 
+	nrc.policyMu.Lock()
+	defer nrc.policyMu.Unlock()
+
 	err := nrc.addPodCidrDefinedSet()
 	if err != nil {
 		klog.Errorf("Failed to add `podcidrdefinedset` defined set: %s", err)
@@ -33,6 +38,11 @@ func (nrc *NetworkRoutingController) AddPolicies() error {
 		klog.Errorf("Failed to add `servicevipsdefinedset` defined set: %s", err)
 	}
 
+	serviceDefinedSets, err := nrc.addServiceCommunitiesDefinedSets()
+	if err != nil {
+		klog.Errorf("Failed to add the defined sets of the services with BGP communities: %s", err)
+	}
+
 	err = nrc.addDefaultRouteDefinedSet()
 	if err != nil {
 		klog.Errorf("Failed to add `defaultroutedefinedset` defined set: %s", err)
@@ -58,52 +68,101 @@ func (nrc *NetworkRoutingController) AddPolicies() error {
 		klog.Errorf("Failed to add `allpeerset` defined set: %s", err)
 	}
 
-	err = nrc.addExportPolicies()
+	err = nrc.addExportPolicies(serviceDefinedSets)
 	if err != nil {
 		return err
 	}
 
-	err = nrc.addImportPolicies()
+	// the defined sets of the services are only removed once the export policy no longer references them
+	err = nrc.deleteStaleServiceCommunitiesDefinedSets(serviceDefinedSets)
+	if err != nil {
+		klog.Errorf("Failed to remove the defined sets of the services without BGP communities: %s", err)
+	}
+
+	peerImports := nrc.getBGPPeerImports()
+	err = nrc.addBGPPeerImportDefinedSets(peerImports)
+	if err != nil {
+		klog.Errorf("Failed to add the defined sets of the BGP peer import filters: %s", err)
+	}
+
+	err = nrc.addImportPolicies(peerImports)
 	if err != nil {
 		return err
 	}
 
+	// the defined sets of the import filters are only removed once the import policy no longer references them
+	err = nrc.deleteStaleBGPPeerImportDefinedSets(peerImports)
+	if err != nil {
+		klog.Errorf("Failed to remove the defined sets of the removed BGP peer import filters: %s", err)
+	}
+
 	return nil
 }
 
-// create a defined set to represent just the pod CIDR associated with the node
+// create a defined set per IP family to represent just the pod CIDR(s) associated with the node, as GoBGP prefix sets
+// can only hold prefixes of a single family
 func (nrc *NetworkRoutingController) addPodCidrDefinedSet() error {
-	var currentDefinedSet *gobgpapi.DefinedSet
-	err := nrc.bgpServer.ListDefinedSet(context.Background(),
-		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_PREFIX, Name: "podcidrdefinedset"},
-		func(ds *gobgpapi.DefinedSet) {
-			currentDefinedSet = ds
-		})
-	if err != nil {
-		return err
-	}
-	if currentDefinedSet == nil {
-		cidrLen, err := strconv.Atoi(strings.Split(nrc.podCidr, "/")[1])
-		if err != nil || cidrLen < 0 || cidrLen > 32 {
-			return fmt.Errorf("the pod CIDR IP given is not a proper mask: %d", cidrLen)
+	for _, podCIDR := range nrc.podCIDRs {
+		ip, ipNet, err := net.ParseCIDR(podCIDR)
+		if err != nil {
+			return fmt.Errorf("the pod CIDR %s is not a proper CIDR: %s", podCIDR, err)
+		}
+		cidrLen, _ := ipNet.Mask.Size()
+		definedSetName := podCidrDefinedSetName(ip.To4() == nil)
+
+		var currentDefinedSet *gobgpapi.DefinedSet
+		err = nrc.bgpServer.ListDefinedSet(context.Background(),
+			&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_PREFIX, Name: definedSetName},
+			func(ds *gobgpapi.DefinedSet) {
+				currentDefinedSet = ds
+			})
+		if err != nil {
+			return err
+		}
+		if currentDefinedSet != nil {
+			continue
 		}
 		podCidrDefinedSet := &gobgpapi.DefinedSet{
 			DefinedType: gobgpapi.DefinedType_PREFIX,
-			Name:        "podcidrdefinedset",
+			Name:        definedSetName,
 			Prefixes: []*gobgpapi.Prefix{
 				{
-					IpPrefix:      nrc.podCidr,
+					IpPrefix:      podCIDR,
 					MaskLengthMin: uint32(cidrLen),
 					MaskLengthMax: uint32(cidrLen),
 				},
 			},
 		}
-		return nrc.bgpServer.AddDefinedSet(context.Background(),
+		err = nrc.bgpServer.AddDefinedSet(context.Background(),
 			&gobgpapi.AddDefinedSetRequest{DefinedSet: podCidrDefinedSet})
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// podCidrDefinedSetName returns the name of the defined set holding the node's pod CIDR of the given IP family
+func podCidrDefinedSetName(isIpv6 bool) string {
+	if isIpv6 {
+		return "podcidrdefinedsetv6"
+	}
+	return "podcidrdefinedset"
+}
+
+// podCidrDefinedSetNames returns the names of the pod CIDR defined sets of the node
+func (nrc *NetworkRoutingController) podCidrDefinedSetNames() []string {
+	names := make([]string, 0)
+	for _, podCIDR := range nrc.podCIDRs {
+		ip, _, err := net.ParseCIDR(podCIDR)
+		if err != nil {
+			continue
+		}
+		names = append(names, podCidrDefinedSetName(ip.To4() == nil))
+	}
+	return names
+}
+
 // create a defined set to represent all the advertisable IP associated with the services
 func (nrc *NetworkRoutingController) addServiceVIPsDefinedSet() error {
 	var currentDefinedSet *gobgpapi.DefinedSet
@@ -189,6 +248,175 @@ func (nrc *NetworkRoutingController) addServiceVIPsDefinedSet() error {
 	return nil
 }
 
+const serviceCommunitiesDefinedSetPrefix = "servicecommunitiesdefinedset-"
+
+// serviceCommunitiesDefinedSet is the defined set of the VIPs of a service with BGP communities set through annotations,
+// split in a prefix defined set per IP family
+type serviceCommunitiesDefinedSet struct {
+	name             string
+	prefixes         []*gobgpapi.Prefix
+	prefixes6        []*gobgpapi.Prefix
+	communities      []string
+	largeCommunities []string
+}
+
+// prefixDefinedSetName returns the name of the defined set of the VIPs of the given IP family
+func (definedSet *serviceCommunitiesDefinedSet) prefixDefinedSetName(isIpv6 bool) string {
+	return familyDefinedSetName(definedSet.name, isIpv6)
+}
+
+// serviceCommunitiesDefinedSetName returns the name of the defined set of the VIPs of the service
+func serviceCommunitiesDefinedSetName(svc *v1core.Service) string {
+	return serviceCommunitiesDefinedSetPrefix + svc.Namespace + "/" + svc.Name
+}
+
+// create a defined set for the VIPs of each service with BGP communities, so that the communities can be added to the
+// routes to its VIPs by a statement of the export policy
+func (nrc *NetworkRoutingController) addServiceCommunitiesDefinedSets() ([]*serviceCommunitiesDefinedSet, error) {
+	definedSets := make([]*serviceCommunitiesDefinedSet, 0)
+	var errs []string
+	services, attributes := nrc.getServicesBGPAttributes()
+	for i, svc := range services {
+		if !attributes[i].hasCommunities() {
+			continue
+		}
+		definedSet := &serviceCommunitiesDefinedSet{
+			name:             serviceCommunitiesDefinedSetName(svc),
+			communities:      attributes[i].communities,
+			largeCommunities: attributes[i].largeCommunities,
+		}
+		for _, vip := range nrc.getAllVIPsForService(svc) {
+			ip := net.ParseIP(vip)
+			switch {
+			case ip == nil:
+				continue
+			case ip.To4() != nil:
+				definedSet.prefixes = append(definedSet.prefixes,
+					&gobgpapi.Prefix{IpPrefix: vip + "/32", MaskLengthMin: 32, MaskLengthMax: 32})
+			default:
+				definedSet.prefixes6 = append(definedSet.prefixes6,
+					&gobgpapi.Prefix{IpPrefix: vip + "/128", MaskLengthMin: 128, MaskLengthMax: 128})
+			}
+		}
+		if len(definedSet.prefixes) == 0 && len(definedSet.prefixes6) == 0 {
+			continue
+		}
+		var err error
+		for _, isIpv6 := range []bool{false, true} {
+			prefixes := definedSet.prefixes
+			if isIpv6 {
+				prefixes = definedSet.prefixes6
+			}
+			if len(prefixes) == 0 {
+				continue
+			}
+			if err = nrc.syncPrefixDefinedSet(definedSet.prefixDefinedSetName(isIpv6), prefixes); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", definedSet.prefixDefinedSetName(isIpv6), err))
+				break
+			}
+		}
+		if err != nil {
+			continue
+		}
+		definedSets = append(definedSets, definedSet)
+	}
+	if len(errs) > 0 {
+		return definedSets, errors.New(strings.Join(errs, ", "))
+	}
+	return definedSets, nil
+}
+
+// deleteStaleServiceCommunitiesDefinedSets removes the defined sets of the services that no longer have BGP
+// communities
+func (nrc *NetworkRoutingController) deleteStaleServiceCommunitiesDefinedSets(
+	current []*serviceCommunitiesDefinedSet) error {
+	currentNames := make(map[string]bool)
+	for _, definedSet := range current {
+		if len(definedSet.prefixes) > 0 {
+			currentNames[definedSet.prefixDefinedSetName(false)] = true
+		}
+		if len(definedSet.prefixes6) > 0 {
+			currentNames[definedSet.prefixDefinedSetName(true)] = true
+		}
+	}
+	stale := make([]*gobgpapi.DefinedSet, 0)
+	err := nrc.bgpServer.ListDefinedSet(context.Background(),
+		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_PREFIX},
+		func(ds *gobgpapi.DefinedSet) {
+			if strings.HasPrefix(ds.Name, serviceCommunitiesDefinedSetPrefix) && !currentNames[ds.Name] {
+				stale = append(stale, ds)
+			}
+		})
+	if err != nil {
+		return err
+	}
+	for _, ds := range stale {
+		err = nrc.bgpServer.DeleteDefinedSet(context.Background(),
+			&gobgpapi.DeleteDefinedSetRequest{DefinedSet: ds, All: true})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncPrefixDefinedSet creates the prefix defined set, or updates it so that it holds exactly the given prefixes
+func (nrc *NetworkRoutingController) syncPrefixDefinedSet(name string, prefixes []*gobgpapi.Prefix) error {
+	var currentDefinedSet *gobgpapi.DefinedSet
+	err := nrc.bgpServer.ListDefinedSet(context.Background(),
+		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_PREFIX, Name: name},
+		func(ds *gobgpapi.DefinedSet) {
+			currentDefinedSet = ds
+		})
+	if err != nil {
+		return err
+	}
+
+	toAdd := prefixes
+	toDelete := make([]*gobgpapi.Prefix, 0)
+	if currentDefinedSet != nil {
+		current := make(map[string]bool)
+		for _, prefix := range currentDefinedSet.Prefixes {
+			current[prefixKey(prefix)] = true
+		}
+		desired := make(map[string]bool)
+		toAdd = make([]*gobgpapi.Prefix, 0)
+		for _, prefix := range prefixes {
+			desired[prefixKey(prefix)] = true
+			if !current[prefixKey(prefix)] {
+				toAdd = append(toAdd, prefix)
+			}
+		}
+		for _, prefix := range currentDefinedSet.Prefixes {
+			if !desired[prefixKey(prefix)] {
+				toDelete = append(toDelete, prefix)
+			}
+		}
+	}
+
+	if len(toAdd) > 0 {
+		err = nrc.bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_PREFIX, Name: name, Prefixes: toAdd}})
+		if err != nil {
+			return err
+		}
+	}
+	if len(toDelete) > 0 {
+		err = nrc.bgpServer.DeleteDefinedSet(context.Background(), &gobgpapi.DeleteDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_PREFIX, Name: name, Prefixes: toDelete},
+			All:        false})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixKey identifies a prefix of a defined set by its prefix and mask length range
+func prefixKey(prefix *gobgpapi.Prefix) string {
+	return fmt.Sprintf("%s %d..%d", prefix.IpPrefix, prefix.MaskLengthMin, prefix.MaskLengthMax)
+}
+
 // create a defined set to represent just the host default route
 func (nrc *NetworkRoutingController) addDefaultRouteDefinedSet() error {
 	var currentDefinedSet *gobgpapi.DefinedSet
@@ -219,7 +447,8 @@ func (nrc *NetworkRoutingController) addDefaultRouteDefinedSet() error {
 	return nil
 }
 
-// create a defined set to represent custom annotated routes to be rejected on import
+// create a defined set to represent custom annotated routes to be rejected on import, it is kept in sync with the
+// node annotation
 func (nrc *NetworkRoutingController) addCustomImportRejectDefinedSet() error {
 	var currentDefinedSet *gobgpapi.DefinedSet
 	err := nrc.bgpServer.ListDefinedSet(context.Background(),
@@ -230,16 +459,16 @@ func (nrc *NetworkRoutingController) addCustomImportRejectDefinedSet() error {
 	if err != nil {
 		return err
 	}
+	prefixes := make([]*gobgpapi.Prefix, 0)
+	for _, ipNet := range nrc.nodeCustomImportRejectIPNets {
+		prefix := new(gobgpapi.Prefix)
+		prefix.IpPrefix = ipNet.String()
+		mask, _ := ipNet.Mask.Size()
+		prefix.MaskLengthMin = uint32(mask)
+		prefix.MaskLengthMax = uint32(ipv4MaskMinBits)
+		prefixes = append(prefixes, prefix)
+	}
 	if currentDefinedSet == nil {
-		prefixes := make([]*gobgpapi.Prefix, 0)
-		for _, ipNet := range nrc.nodeCustomImportRejectIPNets {
-			prefix := new(gobgpapi.Prefix)
-			prefix.IpPrefix = ipNet.String()
-			mask, _ := ipNet.Mask.Size()
-			prefix.MaskLengthMin = uint32(mask)
-			prefix.MaskLengthMax = uint32(ipv4MaskMinBits)
-			prefixes = append(prefixes, prefix)
-		}
 		customImportRejectDefinedSet := &gobgpapi.DefinedSet{
 			DefinedType: gobgpapi.DefinedType_PREFIX,
 			Name:        "customimportrejectdefinedset",
@@ -248,6 +477,59 @@ func (nrc *NetworkRoutingController) addCustomImportRejectDefinedSet() error {
 		return nrc.bgpServer.AddDefinedSet(context.Background(),
 			&gobgpapi.AddDefinedSetRequest{DefinedSet: customImportRejectDefinedSet})
 	}
+
+	samePrefix := func(a, b *gobgpapi.Prefix) bool {
+		return a.IpPrefix == b.IpPrefix && a.MaskLengthMin == b.MaskLengthMin && a.MaskLengthMax == b.MaskLengthMax
+	}
+	toAdd := make([]*gobgpapi.Prefix, 0)
+	toDelete := make([]*gobgpapi.Prefix, 0)
+	for _, prefix := range prefixes {
+		add := true
+		for _, currentPrefix := range currentDefinedSet.Prefixes {
+			if samePrefix(prefix, currentPrefix) {
+				add = false
+			}
+		}
+		if add {
+			toAdd = append(toAdd, prefix)
+		}
+	}
+	for _, currentPrefix := range currentDefinedSet.Prefixes {
+		shouldDelete := true
+		for _, prefix := range prefixes {
+			if samePrefix(prefix, currentPrefix) {
+				shouldDelete = false
+			}
+		}
+		if shouldDelete {
+			toDelete = append(toDelete, currentPrefix)
+		}
+	}
+	if len(toAdd) > 0 {
+		err = nrc.bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{
+				DefinedType: gobgpapi.DefinedType_PREFIX,
+				Name:        "customimportrejectdefinedset",
+				Prefixes:    toAdd,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(toDelete) > 0 {
+		err = nrc.bgpServer.DeleteDefinedSet(context.Background(), &gobgpapi.DeleteDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{
+				DefinedType: gobgpapi.DefinedType_PREFIX,
+				Name:        "customimportrejectdefinedset",
+				Prefixes:    toDelete,
+			},
+			All: false,
+		})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -343,7 +625,6 @@ func (nrc *NetworkRoutingController) addiBGPPeersDefinedSet() ([]string, error)
 func (nrc *NetworkRoutingController) addExternalBGPPeersDefinedSet() ([]string, error) {
 
 	var currentDefinedSet *gobgpapi.DefinedSet
-	externalBgpPeers := make([]string, 0)
 	externalBGPPeerCIDRs := make([]string, 0)
 	err := nrc.bgpServer.ListDefinedSet(context.Background(),
 		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_NEIGHBOR, Name: "externalpeerset"},
@@ -353,21 +634,17 @@ func (nrc *NetworkRoutingController) addExternalBGPPeersDefinedSet() ([]string,
 	if err != nil {
 		return externalBGPPeerCIDRs, err
 	}
-	if len(nrc.globalPeerRouters) > 0 {
-		for _, peer := range nrc.globalPeerRouters {
-			externalBgpPeers = append(externalBgpPeers, peer.Conf.NeighborAddress)
+	for _, peer := range nrc.getExternalPeerAddresses() {
+		if net.ParseIP(peer).To4() == nil {
+			externalBGPPeerCIDRs = append(externalBGPPeerCIDRs, peer+"/128")
+		} else {
+			externalBGPPeerCIDRs = append(externalBGPPeerCIDRs, peer+"/32")
 		}
 	}
-	if len(nrc.nodePeerRouters) > 0 {
-		externalBgpPeers = append(externalBgpPeers, nrc.nodePeerRouters...)
-	}
-	if len(externalBgpPeers) == 0 {
-		return externalBGPPeerCIDRs, nil
-	}
-	for _, peer := range externalBgpPeers {
-		externalBGPPeerCIDRs = append(externalBGPPeerCIDRs, peer+"/32")
-	}
 	if currentDefinedSet == nil {
+		if len(externalBGPPeerCIDRs) == 0 {
+			return externalBGPPeerCIDRs, nil
+		}
 		eBGPPeerNS := &gobgpapi.DefinedSet{
 			DefinedType: gobgpapi.DefinedType_NEIGHBOR,
 			Name:        "externalpeerset",
@@ -377,9 +654,89 @@ func (nrc *NetworkRoutingController) addExternalBGPPeersDefinedSet() ([]string,
 		return externalBGPPeerCIDRs, err
 	}
 
+	// peers configured from BGPPeer resources come and go, so keep the set in sync with them
+	toAdd := make([]string, 0)
+	toDelete := make([]string, 0)
+	for _, peer := range externalBGPPeerCIDRs {
+		add := true
+		for _, currentPeer := range currentDefinedSet.List {
+			if peer == currentPeer {
+				add = false
+			}
+		}
+		if add {
+			toAdd = append(toAdd, peer)
+		}
+	}
+	for _, currentPeer := range currentDefinedSet.List {
+		shouldDelete := true
+		for _, peer := range externalBGPPeerCIDRs {
+			if peer == currentPeer {
+				shouldDelete = false
+			}
+		}
+		if shouldDelete {
+			toDelete = append(toDelete, currentPeer)
+		}
+	}
+	if len(toAdd) > 0 {
+		eBGPPeerNS := &gobgpapi.DefinedSet{
+			DefinedType: gobgpapi.DefinedType_NEIGHBOR,
+			Name:        "externalpeerset",
+			List:        toAdd,
+		}
+		err = nrc.bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{DefinedSet: eBGPPeerNS})
+		if err != nil {
+			return externalBGPPeerCIDRs, err
+		}
+	}
+	if len(toDelete) > 0 {
+		eBGPPeerNS := &gobgpapi.DefinedSet{
+			DefinedType: gobgpapi.DefinedType_NEIGHBOR,
+			Name:        "externalpeerset",
+			List:        toDelete,
+		}
+		err = nrc.bgpServer.DeleteDefinedSet(context.Background(),
+			&gobgpapi.DeleteDefinedSetRequest{DefinedSet: eBGPPeerNS, All: false})
+		if err != nil {
+			return externalBGPPeerCIDRs, err
+		}
+	}
+
 	return externalBGPPeerCIDRs, nil
 }
 
+// getExternalPeerAddresses returns the addresses of all the external BGP peers of the node, whether they are
+// configured through flags, node annotations or BGPPeer resources
+func (nrc *NetworkRoutingController) getExternalPeerAddresses() []string {
+	// the peers are updated concurrently by the node and Secret watchers, their addresses are read under the lock
+	nrc.bgpPeersMu.Lock()
+	externalBgpPeers := make([]string, 0)
+	for _, peer := range nrc.globalPeerRouters {
+		externalBgpPeers = append(externalBgpPeers, peer.Conf.NeighborAddress)
+	}
+	nodePeerRouters := append([]string(nil), nrc.nodePeerRouters...)
+	nrc.bgpPeersMu.Unlock()
+
+	// the node specific peers are part of the global peers once the BGP server is started
+	for _, peer := range nodePeerRouters {
+		found := false
+		for _, address := range externalBgpPeers {
+			if address == peer {
+				found = true
+				break
+			}
+		}
+		if !found {
+			externalBgpPeers = append(externalBgpPeers, peer)
+		}
+	}
+	bgpPeerAddresses := nrc.getBGPPeerAddresses()
+	sort.Strings(bgpPeerAddresses)
+	externalBgpPeers = append(externalBgpPeers, bgpPeerAddresses...)
+	return externalBgpPeers
+}
+
 // a slice of all peers is used as a match condition for reject statement of servicevipsdefinedset import policy
 func (nrc *NetworkRoutingController) addAllBGPPeersDefinedSet(iBGPPeerCIDRs, externalBGPPeerCIDRs []string) error {
 	var currentDefinedSet *gobgpapi.DefinedSet
@@ -464,7 +821,7 @@ func (nrc *NetworkRoutingController) addAllBGPPeersDefinedSet(iBGPPeerCIDRs, ext
 //   - each node is NOT allowed to advertise service VIP's (cluster ip, load balancer ip, external IP) to
 //     iBGP peers
 //   - an option to allow overriding the next-hop-address with the outgoing ip for external bgp peers
-func (nrc *NetworkRoutingController) addExportPolicies() error {
+func (nrc *NetworkRoutingController) addExportPolicies(serviceDefinedSets []*serviceCommunitiesDefinedSet) error {
 	statements := make([]*gobgpapi.Statement, 0)
 
 	var bgpActions gobgpapi.Actions
@@ -489,23 +846,25 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 			actions.Nexthop = &gobgpapi.NexthopAction{Self: true}
 		}
 		// statement to represent the export policy to permit advertising node's pod CIDR
-		statements = append(statements,
-			&gobgpapi.Statement{
-				Conditions: &gobgpapi.Conditions{
-					PrefixSet: &gobgpapi.MatchSet{
-						Type: gobgpapi.MatchSet_ANY,
-						Name: "podcidrdefinedset",
-					},
-					NeighborSet: &gobgpapi.MatchSet{
-						Type: gobgpapi.MatchSet_ANY,
-						Name: "iBGPpeerset",
+		for _, podCidrDefinedSet := range nrc.podCidrDefinedSetNames() {
+			statements = append(statements,
+				&gobgpapi.Statement{
+					Conditions: &gobgpapi.Conditions{
+						PrefixSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: podCidrDefinedSet,
+						},
+						NeighborSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: "iBGPpeerset",
+						},
 					},
-				},
-				Actions: &actions,
-			})
+					Actions: &actions,
+				})
+		}
 	}
 
-	if len(nrc.globalPeerRouters) > 0 || len(nrc.nodePeerRouters) > 0 {
+	if len(nrc.getExternalPeerAddresses()) > 0 {
 
 		bgpActions.RouteAction = gobgpapi.RouteAction_ACCEPT
 		if nrc.overrideNextHop {
@@ -519,6 +878,48 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 				Communities: nrc.nodeCommunities,
 			}
 		}
+		if len(nrc.nodeLargeCommunities) > 0 {
+			bgpActions.LargeCommunity = &gobgpapi.CommunityAction{
+				Type:        gobgpapi.CommunityAction_ADD,
+				Communities: nrc.nodeLargeCommunities,
+			}
+		}
+
+		// statements adding the communities of the services to the routes to their VIPs, as they have no route
+		// action the VIPs go on to be accepted by the next statement
+		for _, definedSet := range serviceDefinedSets {
+			actions := gobgpapi.Actions{}
+			if len(definedSet.communities) > 0 {
+				actions.Community = &gobgpapi.CommunityAction{
+					Type:        gobgpapi.CommunityAction_ADD,
+					Communities: definedSet.communities,
+				}
+			}
+			if len(definedSet.largeCommunities) > 0 {
+				actions.LargeCommunity = &gobgpapi.CommunityAction{
+					Type:        gobgpapi.CommunityAction_ADD,
+					Communities: definedSet.largeCommunities,
+				}
+			}
+			for _, isIpv6 := range []bool{false, true} {
+				if (isIpv6 && len(definedSet.prefixes6) == 0) || (!isIpv6 && len(definedSet.prefixes) == 0) {
+					continue
+				}
+				statements = append(statements, &gobgpapi.Statement{
+					Conditions: &gobgpapi.Conditions{
+						PrefixSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: definedSet.prefixDefinedSetName(isIpv6),
+						},
+						NeighborSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: "externalpeerset",
+						},
+					},
+					Actions: &actions,
+				})
+			}
+		}
 
 		// statement to represent the export policy to permit advertising cluster IP's
 		// only to the global BGP peer or node specific BGP peer
@@ -547,22 +948,30 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 					Communities: nrc.nodeCommunities,
 				}
 			}
+			if len(nrc.nodeLargeCommunities) > 0 {
+				actions.LargeCommunity = &gobgpapi.CommunityAction{
+					Type:        gobgpapi.CommunityAction_ADD,
+					Communities: nrc.nodeLargeCommunities,
+				}
+			}
 			if nrc.overrideNextHop {
 				actions.Nexthop = &gobgpapi.NexthopAction{Self: true}
 			}
-			statements = append(statements, &gobgpapi.Statement{
-				Conditions: &gobgpapi.Conditions{
-					PrefixSet: &gobgpapi.MatchSet{
-						Type: gobgpapi.MatchSet_ANY,
-						Name: "podcidrdefinedset",
+			for _, podCidrDefinedSet := range nrc.podCidrDefinedSetNames() {
+				statements = append(statements, &gobgpapi.Statement{
+					Conditions: &gobgpapi.Conditions{
+						PrefixSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: podCidrDefinedSet,
+						},
+						NeighborSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: "externalpeerset",
+						},
 					},
-					NeighborSet: &gobgpapi.MatchSet{
-						Type: gobgpapi.MatchSet_ANY,
-						Name: "externalpeerset",
-					},
-				},
-				Actions: &actions,
-			})
+					Actions: &actions,
+				})
+			}
 		}
 	}
 
@@ -571,22 +980,9 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 		Statements: statements,
 	}
 
-	policyAlreadyExists := false
-	checkExistingPolicy := func(existingPolicy *gobgpapi.Policy) {
-		if existingPolicy.Name == "kube_router_export" {
-			policyAlreadyExists = true
-		}
-	}
-	err := nrc.bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{}, checkExistingPolicy)
+	err := nrc.ensurePolicy(&definition, gobgpapi.ResetPeerRequest_OUT)
 	if err != nil {
-		return errors.New("Failed to verify if kube-router BGP export policy exists: " + err.Error())
-	}
-
-	if !policyAlreadyExists {
-		err = nrc.bgpServer.AddPolicy(context.Background(), &gobgpapi.AddPolicyRequest{Policy: &definition})
-		if err != nil {
-			return errors.New("Failed to add policy: " + err.Error())
-		}
+		return err
 	}
 
 	policyAssignmentExists := false
@@ -624,7 +1020,9 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 // BGP import policies are added so that the following conditions are met:
 //   - do not import Service VIPs advertised from any peers, instead each kube-router originates and injects
 //     Service VIPs into local rib.
-func (nrc *NetworkRoutingController) addImportPolicies() error {
+//   - do not import the default route, unless it is selected by the import filter of an external peer when
+//     --install-external-routes is set.
+func (nrc *NetworkRoutingController) addImportPolicies(peerImports []*bgpPeerImport) error {
 	statements := make([]*gobgpapi.Statement, 0)
 
 	actions := gobgpapi.Actions{
@@ -644,20 +1042,6 @@ func (nrc *NetworkRoutingController) addImportPolicies() error {
 		Actions: &actions,
 	})
 
-	statements = append(statements, &gobgpapi.Statement{
-		Conditions: &gobgpapi.Conditions{
-			PrefixSet: &gobgpapi.MatchSet{
-				Type: gobgpapi.MatchSet_ANY,
-				Name: "defaultroutedefinedset",
-			},
-			NeighborSet: &gobgpapi.MatchSet{
-				Type: gobgpapi.MatchSet_ANY,
-				Name: "allpeerset",
-			},
-		},
-		Actions: &actions,
-	})
-
 	if len(nrc.nodeCustomImportRejectIPNets) > 0 {
 		statements = append(statements, &gobgpapi.Statement{
 			Conditions: &gobgpapi.Conditions{
@@ -674,27 +1058,31 @@ func (nrc *NetworkRoutingController) addImportPolicies() error {
 		})
 	}
 
+	// the routes selected by the import filters of external peers are accepted before the default route is rejected
+	statements = append(statements, bgpPeerImportStatements(peerImports)...)
+
+	statements = append(statements, &gobgpapi.Statement{
+		Conditions: &gobgpapi.Conditions{
+			PrefixSet: &gobgpapi.MatchSet{
+				Type: gobgpapi.MatchSet_ANY,
+				Name: "defaultroutedefinedset",
+			},
+			NeighborSet: &gobgpapi.MatchSet{
+				Type: gobgpapi.MatchSet_ANY,
+				Name: "allpeerset",
+			},
+		},
+		Actions: &actions,
+	})
+
 	definition := gobgpapi.Policy{
 		Name:       "kube_router_import",
 		Statements: statements,
 	}
 
-	policyAlreadyExists := false
-	checkExistingPolicy := func(existingPolicy *gobgpapi.Policy) {
-		if existingPolicy.Name == "kube_router_import" {
-			policyAlreadyExists = true
-		}
-	}
-	err := nrc.bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{}, checkExistingPolicy)
+	err := nrc.ensurePolicy(&definition, gobgpapi.ResetPeerRequest_IN)
 	if err != nil {
-		return errors.New("Failed to verify if kube-router BGP import policy exists: " + err.Error())
-	}
-
-	if !policyAlreadyExists {
-		err = nrc.bgpServer.AddPolicy(context.Background(), &gobgpapi.AddPolicyRequest{Policy: &definition})
-		if err != nil {
-			return errors.New("Failed to add policy: " + err.Error())
-		}
+		return err
 	}
 
 	policyAssignmentExists := false
@@ -728,3 +1116,65 @@ func (nrc *NetworkRoutingController) addImportPolicies() error {
 
 	return nil
 }
+
+// ensurePolicy adds the policy to the BGP server, or updates its statements when they changed since the policy was
+// last applied. GoBGP can't replace the statements of a policy in place, so the new statements are added under
+// names unique to this update before the old ones are removed, then the peers are soft reset in the given direction
+// so that the routes are evaluated against the updated policy.
+func (nrc *NetworkRoutingController) ensurePolicy(definition *gobgpapi.Policy,
+	direction gobgpapi.ResetPeerRequest_SoftResetDirection) error {
+	var existingPolicy *gobgpapi.Policy
+	err := nrc.bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{Name: definition.Name},
+		func(policy *gobgpapi.Policy) {
+			existingPolicy = policy
+		})
+	if err != nil {
+		return fmt.Errorf("failed to verify if kube-router BGP policy %s exists: %s", definition.Name, err)
+	}
+
+	if nrc.appliedPolicies == nil {
+		nrc.appliedPolicies = make(map[string]*gobgpapi.Policy)
+	}
+	applied := proto.Clone(definition).(*gobgpapi.Policy)
+	if existingPolicy == nil {
+		err = nrc.bgpServer.AddPolicy(context.Background(), &gobgpapi.AddPolicyRequest{Policy: definition})
+		if err != nil {
+			return errors.New("Failed to add policy: " + err.Error())
+		}
+		nrc.appliedPolicies[definition.Name] = applied
+		return nil
+	}
+
+	if appliedPolicy, ok := nrc.appliedPolicies[definition.Name]; ok && proto.Equal(appliedPolicy, applied) {
+		return nil
+	}
+
+	nrc.policyGeneration++
+	for idx, statement := range definition.Statements {
+		statement.Name = fmt.Sprintf("%s_%d_stmt%d", definition.Name, nrc.policyGeneration, idx)
+	}
+	if len(definition.Statements) > 0 {
+		err = nrc.bgpServer.AddPolicy(context.Background(), &gobgpapi.AddPolicyRequest{Policy: definition})
+		if err != nil {
+			return fmt.Errorf("failed to add updated statements to policy %s: %s", definition.Name, err)
+		}
+	}
+	if len(existingPolicy.Statements) > 0 {
+		err = nrc.bgpServer.DeletePolicy(context.Background(), &gobgpapi.DeletePolicyRequest{
+			Policy: &gobgpapi.Policy{Name: definition.Name, Statements: existingPolicy.Statements},
+			All:    false,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to remove outdated statements from policy %s: %s", definition.Name, err)
+		}
+	}
+	nrc.appliedPolicies[definition.Name] = applied
+	klog.Infof("Updated the statements of BGP policy %s", definition.Name)
+
+	err = nrc.bgpServer.ResetPeer(context.Background(), &gobgpapi.ResetPeerRequest{Address: "all", Soft: true,
+		Direction: direction})
+	if err != nil {
+		return fmt.Errorf("failed to soft reset the BGP peers after updating policy %s: %s", definition.Name, err)
+	}
+	return nil
+}
@@ -47,6 +47,7 @@ func Test_AddPolicies(t *testing.T) {
 				activeNodes:       make(map[string]bool),
 				nodeAsnNumber:     100,
 				podCidr:           "172.20.0.0/24",
+				podCIDRs:          []string{"172.20.0.0/24"},
 			},
 			[]*v1core.Node{
 				{
@@ -190,6 +191,7 @@ func Test_AddPolicies(t *testing.T) {
 				activeNodes:       make(map[string]bool),
 				nodeAsnNumber:     100,
 				podCidr:           "172.20.0.0/24",
+				podCIDRs:          []string{"172.20.0.0/24"},
 			},
 			[]*v1core.Node{
 				{
@@ -326,7 +328,7 @@ func Test_AddPolicies(t *testing.T) {
 					Conditions: &gobgpapi.Conditions{
 						PrefixSet: &gobgpapi.MatchSet{
 							Type: gobgpapi.MatchSet_ANY,
-							Name: "defaultroutedefinedset",
+							Name: "customimportrejectdefinedset",
 						},
 						NeighborSet: &gobgpapi.MatchSet{
 							Type: gobgpapi.MatchSet_ANY,
@@ -343,7 +345,7 @@ func Test_AddPolicies(t *testing.T) {
 					Conditions: &gobgpapi.Conditions{
 						PrefixSet: &gobgpapi.MatchSet{
 							Type: gobgpapi.MatchSet_ANY,
-							Name: "customimportrejectdefinedset",
+							Name: "defaultroutedefinedset",
 						},
 						NeighborSet: &gobgpapi.MatchSet{
 							Type: gobgpapi.MatchSet_ANY,
@@ -371,6 +373,7 @@ func Test_AddPolicies(t *testing.T) {
 				bgpServer:         gobgp.NewBgpServer(),
 				activeNodes:       make(map[string]bool),
 				podCidr:           "172.20.0.0/24",
+				podCIDRs:          []string{"172.20.0.0/24"},
 				globalPeerRouters: []*gobgpapi.Peer{
 					{
 						Conf: &gobgpapi.PeerConf{
@@ -547,6 +550,7 @@ func Test_AddPolicies(t *testing.T) {
 				bgpServer:         gobgp.NewBgpServer(),
 				activeNodes:       make(map[string]bool),
 				podCidr:           "172.20.0.0/24",
+				podCIDRs:          []string{"172.20.0.0/24"},
 				globalPeerRouters: []*gobgpapi.Peer{
 					{
 						Conf: &gobgpapi.PeerConf{
@@ -706,6 +710,7 @@ func Test_AddPolicies(t *testing.T) {
 				bgpServer:         gobgp.NewBgpServer(),
 				activeNodes:       make(map[string]bool),
 				podCidr:           "172.20.0.0/24",
+				podCIDRs:          []string{"172.20.0.0/24"},
 				globalPeerRouters: []*gobgpapi.Peer{
 					{
 						Conf: &gobgpapi.PeerConf{
@@ -888,6 +893,7 @@ func Test_AddPolicies(t *testing.T) {
 				bgpServer:         gobgp.NewBgpServer(),
 				activeNodes:       make(map[string]bool),
 				podCidr:           "172.20.0.0/24",
+				podCIDRs:          []string{"172.20.0.0/24"},
 				globalPeerRouters: []*gobgpapi.Peer{
 					{
 						Conf: &gobgpapi.PeerConf{
@@ -1070,6 +1076,7 @@ func Test_AddPolicies(t *testing.T) {
 				advertisePodCidr:  true,
 				activeNodes:       make(map[string]bool),
 				podCidr:           "172.20.0.0/24",
+				podCIDRs:          []string{"172.20.0.0/24"},
 				globalPeerRouters: []*gobgpapi.Peer{
 					{
 						Conf: &gobgpapi.PeerConf{
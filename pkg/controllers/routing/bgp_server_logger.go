@@ -0,0 +1,77 @@
+package routing
+
+import (
+	gobgplog "github.com/osrg/gobgp/v3/pkg/log"
+	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
+)
+
+const (
+	// gobgpPrefixLimitMessage is the message GoBGP logs when a peer sends more prefixes than the warning threshold,
+	// along with the threshold in the Pct field, or than the maximum. The maximum is rather reported from the Cease
+	// NOTIFICATION message tearing the session down, which is defined by the protocol.
+	gobgpPrefixLimitMessage = "prefix limit reached"
+	// gobgpNotificationReceivedMessage and gobgpNotificationSentMessage are the messages GoBGP logs when a
+	// NOTIFICATION message is received from or sent to a peer, along with its error code and subcode
+	gobgpNotificationReceivedMessage = "received notification"
+	gobgpNotificationSentMessage     = "sent notification"
+
+	notificationReceived = "received"
+	notificationSent     = "sent"
+)
+
+// bgpServerLogger is the logger of the BGP server, it logs like the default GoBGP logger and reports the peers
+// reaching their warning threshold or their maximum number of prefixes and the NOTIFICATION messages exchanged with
+// the peers, as GoBGP provides no other way to know why a session was torn down
+type bgpServerLogger struct {
+	gobgplog.Logger
+	onPrefixLimit  func(address string, family string, threshold string)
+	onNotification func(address string, direction string, code uint8, subcode uint8)
+}
+
+func newBGPServerLogger(onPrefixLimit func(address string, family string, threshold string),
+	onNotification func(address string, direction string, code uint8, subcode uint8)) *bgpServerLogger {
+	return &bgpServerLogger{Logger: gobgplog.NewDefaultLogger(), onPrefixLimit: onPrefixLimit,
+		onNotification: onNotification}
+}
+
+func (l *bgpServerLogger) Warn(msg string, fields gobgplog.Fields) {
+	l.Logger.Warn(msg, fields)
+	address, _ := fields["Key"].(string)
+	switch msg {
+	case gobgpPrefixLimitMessage:
+		if _, ok := fields["Pct"]; !ok {
+			return
+		}
+		family, _ := fields["Family"].(string)
+		l.onPrefixLimit(address, family, maxPrefixesWarning)
+	case gobgpNotificationReceivedMessage, gobgpNotificationSentMessage:
+		code, subcode, ok := notificationErrorCode(fields)
+		if !ok {
+			return
+		}
+		direction := notificationSent
+		if msg == gobgpNotificationReceivedMessage {
+			direction = notificationReceived
+		}
+		l.onNotification(address, direction, code, subcode)
+		if direction == notificationSent && code == bgp.BGP_ERROR_CEASE &&
+			subcode == bgp.BGP_ERROR_SUB_MAXIMUM_NUMBER_OF_PREFIXES_REACHED {
+			// the address family is not part of the message, GoBGP logs it along with the prefix limit
+			l.onPrefixLimit(address, "", maxPrefixesMaximum)
+		}
+	}
+}
+
+// notificationErrorCode returns the error code and subcode of a NOTIFICATION message logged by GoBGP, they are either
+// logged in their own fields or in the error the message was sent for
+func notificationErrorCode(fields gobgplog.Fields) (uint8, uint8, bool) {
+	if msgErr, ok := fields["Data"].(*bgp.MessageError); ok {
+		return msgErr.TypeCode, msgErr.SubTypeCode, true
+	}
+	code, ok := fields["Code"].(uint8)
+	if !ok {
+		return 0, 0, false
+	}
+	subcode, _ := fields["Subcode"].(uint8)
+	return code, subcode, true
+}
@@ -0,0 +1,137 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func Test_bgpServerLogger(t *testing.T) {
+	var events []string
+	logger := newBGPServerLogger(func(address string, family string, threshold string) {
+		events = append(events, address+" "+family+" "+threshold)
+	}, func(address string, direction string, code uint8, subcode uint8) {
+		events = append(events, fmt.Sprintf("%s %s %d/%d", address, direction, code, subcode))
+	})
+
+	logger.Warn(gobgpPrefixLimitMessage, map[string]interface{}{"Topic": "Peer", "Key": "10.0.0.254",
+		"Family": "ipv4-unicast", "Pct": 75})
+	logger.Warn(gobgpPrefixLimitMessage, map[string]interface{}{"Topic": "Peer", "Key": "10.0.0.254",
+		"Family": "ipv4-unicast"})
+	logger.Warn(gobgpNotificationSentMessage, map[string]interface{}{"Topic": "Peer", "Key": "10.0.0.254",
+		"Code": uint8(bgp.BGP_ERROR_CEASE), "Subcode": uint8(bgp.BGP_ERROR_SUB_MAXIMUM_NUMBER_OF_PREFIXES_REACHED)})
+	logger.Warn(gobgpNotificationSentMessage, map[string]interface{}{"Topic": "Peer", "Key": "10.0.0.254",
+		"Data": bgp.NewMessageError(bgp.BGP_ERROR_HOLD_TIMER_EXPIRED, 0, nil, "hold timer expired")})
+	logger.Warn(gobgpNotificationReceivedMessage, map[string]interface{}{"Topic": "Peer", "Key": "10.0.0.254",
+		"Code": uint8(bgp.BGP_ERROR_CEASE), "Subcode": uint8(bgp.BGP_ERROR_SUB_ADMINISTRATIVE_SHUTDOWN)})
+	logger.Warn("hold timer expired", map[string]interface{}{"Topic": "Peer", "Key": "10.0.0.254"})
+
+	assert.Equal(t, []string{
+		"10.0.0.254 ipv4-unicast warning",
+		"10.0.0.254 sent 6/1",
+		"10.0.0.254  maximum",
+		"10.0.0.254 sent 4/0",
+		"10.0.0.254 received 6/2",
+	}, events)
+}
+
+// Test_bgpServerLoggerGoBGP checks that GoBGP reports a peer exceeding its maximum number of prefixes, and the
+// NOTIFICATION message tearing the session down, through the logger, as kube-router relies on it to shut the peer
+// down and to export why the session went down
+func Test_bgpServerLoggerGoBGP(t *testing.T) {
+	var mu sync.Mutex
+	var thresholds, notifications []string
+	onPrefixLimit := func(address string, family string, threshold string) {
+		mu.Lock()
+		defer mu.Unlock()
+		thresholds = append(thresholds, threshold)
+	}
+	onNotification := func(address string, direction string, code uint8, subcode uint8) {
+		mu.Lock()
+		defer mu.Unlock()
+		notifications = append(notifications, fmt.Sprintf("%s %s %d/%d", address, direction, code, subcode))
+	}
+	limited := gobgp.NewBgpServer(gobgp.LoggerOption(newBGPServerLogger(onPrefixLimit, onNotification)))
+	sender := gobgp.NewBgpServer(gobgp.LoggerOption(newBGPServerLogger(onPrefixLimit, onNotification)))
+	for _, server := range []*gobgp.BgpServer{limited, sender} {
+		go server.Serve()
+	}
+
+	family := &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST}
+	for _, s := range []struct {
+		server     *gobgp.BgpServer
+		routerID   string
+		port       int32
+		peerPort   uint32
+		maxPrefix  uint32
+		localAddr  string
+		neighborIP string
+	}{
+		{limited, "10.0.0.1", 10179, 10180, 2, "127.0.0.1", "127.0.0.2"},
+		{sender, "10.0.0.2", 10180, 10179, 0, "127.0.0.2", "127.0.0.1"},
+	} {
+		err := s.server.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{Global: &gobgpapi.Global{
+			Asn: 65000, RouterId: s.routerID, ListenPort: s.port, ListenAddresses: []string{s.localAddr},
+		}})
+		if err != nil {
+			t.Fatalf("failed to start BGP server: %v", err)
+		}
+		defer func(server *gobgp.BgpServer) {
+			if err := server.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+				t.Errorf("failed to stop BGP server: %v", err)
+			}
+		}(s.server)
+		peer := &gobgpapi.Peer{
+			Conf:      &gobgpapi.PeerConf{NeighborAddress: s.neighborIP, PeerAsn: 65000},
+			Transport: &gobgpapi.Transport{LocalAddress: s.localAddr, RemotePort: s.peerPort},
+			AfiSafis: []*gobgpapi.AfiSafi{{
+				Config: &gobgpapi.AfiSafiConfig{Family: family, Enabled: true},
+			}},
+		}
+		if s.maxPrefix != 0 {
+			peer.AfiSafis[0].PrefixLimits = &gobgpapi.PrefixLimit{Family: family, MaxPrefixes: s.maxPrefix}
+		}
+		if err := s.server.AddPeer(context.Background(), &gobgpapi.AddPeerRequest{Peer: peer}); err != nil {
+			t.Fatalf("failed to add peer: %v", err)
+		}
+	}
+
+	for _, prefix := range []string{"192.168.1.0", "192.168.2.0", "192.168.3.0"} {
+		nlri, _ := anypb.New(&gobgpapi.IPAddressPrefix{Prefix: prefix, PrefixLen: 24})
+		origin, _ := anypb.New(&gobgpapi.OriginAttribute{Origin: 0})
+		nextHop, _ := anypb.New(&gobgpapi.NextHopAttribute{NextHop: "10.0.0.2"})
+		_, err := sender.AddPath(context.Background(), &gobgpapi.AddPathRequest{Path: &gobgpapi.Path{
+			Family: family, Nlri: nlri, Pattrs: []*anypb.Any{origin, nextHop},
+		}})
+		if err != nil {
+			t.Fatalf("failed to add path: %v", err)
+		}
+	}
+
+	contains := func(events *[]string, expected string) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, event := range *events {
+				if event == expected {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	assert.Eventually(t, contains(&thresholds, maxPrefixesMaximum), 30*time.Second, 100*time.Millisecond,
+		"the peer exceeding its maximum number of prefixes should be reported")
+	assert.Eventually(t, contains(&notifications, "127.0.0.2 sent 6/1"),
+		30*time.Second, 100*time.Millisecond, "the NOTIFICATION sent to the peer should be reported")
+	assert.Eventually(t, contains(&notifications, "127.0.0.1 received 6/1"),
+		30*time.Second, 100*time.Millisecond, "the NOTIFICATION received from the peer should be reported")
+}
@@ -0,0 +1,188 @@
+package routing
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// sharedInterfaceNames are the interfaces shared by all the pods and remote nodes, the per node tunnels are found by
+// isPerNodeTunnel
+var sharedInterfaceNames = map[string]bool{
+	"kube-bridge":              true,
+	vxlanInterfaceName:         true,
+	vxlan6InterfaceName:        true,
+	wireGuardInterfaceName:     true,
+	singleTunnelInterfaceName:  true,
+	singleTunnel6InterfaceName: true,
+}
+
+// getCNIConfFile returns the CNI conf file kube-router manages
+func getCNIConfFile() string {
+	if cniConfFile := os.Getenv("KUBE_ROUTER_CNI_CONF_FILE"); cniConfFile != "" {
+		return cniConfFile
+	}
+	return "/etc/cni/net.d/10-kuberouter.conf"
+}
+
+// cleanupResource removes a resource set up by the routing controller, or only logs it in dry run mode. It returns
+// whether the resource was, or would have been, removed.
+func cleanupResource(dryRun bool, resource string, remove func() error) bool {
+	if dryRun {
+		klog.Infof("Dry run: would remove %s", resource)
+		return true
+	}
+	if err := remove(); err != nil && !isNotExistError(err) {
+		klog.Errorf("Failed to remove %s: %v", resource, err)
+		return false
+	}
+	klog.Infof("Removed %s", resource)
+	return true
+}
+
+// cleanupNetworking removes the injected routes, the policy based routing rules and table, the overlay interfaces,
+// kube-bridge and the CNI conf file. It returns the resources that were, or would have been in dry run mode, removed.
+func (nrc *NetworkRoutingController) cleanupNetworking(dryRun bool) []string {
+	var removed []string
+	cleanup := func(resource string, remove func() error) {
+		if cleanupResource(dryRun, resource, remove) {
+			removed = append(removed, resource)
+		}
+	}
+
+	routes, err := nrc.ln.routeListFiltered(netlink.FAMILY_ALL, &netlink.Route{
+		Table: syscall.RT_TABLE_MAIN, Protocol: zebraRouteOriginator,
+	}, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		klog.Errorf("Failed to list the injected routes: %v", err)
+	}
+	for i := range routes {
+		cleanup("route "+routes[i].String(), func() error { return nrc.ln.routeDel(&routes[i]) })
+	}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		rules, err := nrc.ln.ruleList(family)
+		if err != nil {
+			klog.Errorf("Failed to list the ip rules: %v", err)
+			continue
+		}
+		for i := range rules {
+			if rules[i].Table != customRouteTableNumber {
+				continue
+			}
+			cleanup(rules[i].String(), func() error { return nrc.ln.ruleDel(&rules[i]) })
+		}
+	}
+
+	routes, err = nrc.ln.routeListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: customRouteTableNumber},
+		netlink.RT_FILTER_TABLE)
+	if err != nil {
+		klog.Errorf("Failed to list the routes of the %s table: %v", customRouteTableName, err)
+	}
+	for i := range routes {
+		cleanup("route "+routes[i].String(), func() error { return nrc.ln.routeDel(&routes[i]) })
+	}
+
+	links, err := nrc.ln.linkList()
+	if err != nil {
+		klog.Errorf("Failed to list the interfaces: %v", err)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Attrs().Name < links[j].Attrs().Name })
+	for _, link := range links {
+		name := link.Attrs().Name
+		if !isPerNodeTunnel(link) && !sharedInterfaceNames[name] {
+			continue
+		}
+		link := link
+		cleanup("interface "+name, func() error { return nrc.ln.linkDel(link) })
+	}
+
+	found, err := rtTablesDel(customRouteTableID, customRouteTableName, true)
+	if err != nil {
+		klog.Errorf("Failed to look up the %s routing table name: %v", customRouteTableName, err)
+	}
+	if found {
+		cleanup("routing table name "+customRouteTableName, func() error {
+			_, err := rtTablesDel(customRouteTableID, customRouteTableName, false)
+			return err
+		})
+	}
+
+	if _, err = os.Stat(nrc.cniConfFile); err == nil {
+		cleanup("CNI conf file "+nrc.cniConfFile, func() error { return os.Remove(nrc.cniConfFile) })
+	}
+
+	return removed
+}
+
+// cleanupIptables removes the pod egress and forwarding iptables rules of both IP families
+func (nrc *NetworkRoutingController) cleanupIptables(dryRun bool) {
+	links, err := nrc.ln.linkList()
+	if err != nil {
+		klog.Errorf("Failed to list the interfaces: %v", err)
+	}
+
+	for _, isIpv6 := range []bool{false, true} {
+		iptablesCmdHandler, err := newIptablesCmdHandlerForFamily(isIpv6)
+		if err != nil {
+			// Level 1 logging as ip6tables is not available on every node
+			klog.V(1).Infof("Failed to create iptables handler: %v", err)
+			continue
+		}
+
+		podEgressArgs, podEgressArgsBad := podEgressArgs4, podEgressArgsBad4
+		if isIpv6 {
+			podEgressArgs, podEgressArgsBad = podEgressArgs6, podEgressArgsBad6
+		}
+		podEgressArgsRandomFully := append(append([]string{}, podEgressArgs...), "--random-fully")
+		natRules := append([][]string{podEgressArgs, podEgressArgsRandomFully}, podEgressArgsBad...)
+		forwardRules := forwardingRules("kube-bridge")[:2]
+		for _, link := range links {
+			forwardRules = append(forwardRules, forwardingRules(link.Attrs().Name)[2])
+		}
+
+		for _, chain := range []struct {
+			table, name string
+			rules       [][]string
+		}{{"nat", "POSTROUTING", natRules}, {"filter", "FORWARD", forwardRules}} {
+			for _, args := range chain.rules {
+				exists, err := iptablesCmdHandler.Exists(chain.table, chain.name, args...)
+				if err != nil || !exists {
+					continue
+				}
+				table, name, args := chain.table, chain.name, args
+				cleanupResource(dryRun, fmt.Sprintf("iptables rule -t %s -A %s %s", table, name,
+					strings.Join(args, " ")), func() error {
+					return iptablesCmdHandler.Delete(table, name, args...)
+				})
+			}
+		}
+	}
+}
+
+// cleanupIPSets destroys the ipsets of the pod subnets and node addresses of both IP families
+func cleanupIPSets(dryRun bool) {
+	ipset, err := utils.NewIPSet(false)
+	if err != nil {
+		klog.Errorf("Failed to clean up ipsets: " + err.Error())
+		return
+	}
+	if err = ipset.Save(); err != nil {
+		klog.Errorf("Failed to clean up ipsets: " + err.Error())
+		return
+	}
+	for _, name := range []string{podSubnetsIPSetName, nodeAddrsIPSetName, "inet6:" + podSubnetsIPSetName,
+		"inet6:" + nodeAddrsIPSetName} {
+		if ipset.Get(name) == nil {
+			continue
+		}
+		name := name
+		cleanupResource(dryRun, "ipset "+name, func() error { return ipset.Destroy(name) })
+	}
+}
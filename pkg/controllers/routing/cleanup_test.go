@@ -0,0 +1,128 @@
+package routing
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func Test_cleanupNetworking(t *testing.T) {
+	newLink := func(link netlink.Link, name string) netlink.Link {
+		link.Attrs().Name = name
+		return link
+	}
+	newRoute := func(dst string, table int, protocol netlink.RouteProtocol) netlink.Route {
+		_, dstNet, _ := net.ParseCIDR(dst)
+		return netlink.Route{Dst: dstNet, Table: table, Protocol: protocol}
+	}
+	newRule := func(family int, src string, table int) netlink.Rule {
+		_, srcNet, _ := net.ParseCIDR(src)
+		rule := netlink.NewRule()
+		rule.Family = family
+		rule.Src = srcNet
+		rule.Table = table
+		return *rule
+	}
+
+	tmpDir := t.TempDir()
+	cniConfFile := filepath.Join(tmpDir, "10-kuberouter.conflist")
+	savedRTTablesFile := rtTablesFile
+	rtTablesFile = filepath.Join(tmpDir, "rt_tables")
+	defer func() { rtTablesFile = savedRTTablesFile }()
+
+	setup := func() (*fakeNetlink, *NetworkRoutingController) {
+		fn := newFakeNetlink(
+			newLink(&netlink.Device{}, "eth0"),
+			newLink(&netlink.Iptun{}, "tunl0"),
+			newLink(&netlink.Iptun{}, generateTunnelName("10.0.1.2")),
+			newLink(&netlink.Vxlan{}, vxlanInterfaceName),
+			newLink(&netlink.Bridge{}, "kube-bridge"),
+		)
+		fn.routes = []netlink.Route{
+			newRoute("10.244.2.0/24", syscall.RT_TABLE_MAIN, zebraRouteOriginator),
+			newRoute("192.168.1.0/24", syscall.RT_TABLE_MAIN, syscall.RTPROT_KERNEL),
+			newRoute("192.168.1.2/32", customRouteTableNumber, syscall.RTPROT_BOOT),
+		}
+		fn.rules = []netlink.Rule{
+			newRule(netlink.FAMILY_V4, "10.244.1.0/24", customRouteTableNumber),
+			newRule(netlink.FAMILY_V4, "10.10.0.0/16", 100),
+			newRule(netlink.FAMILY_V6, "fd00:10:244:1::/64", customRouteTableNumber),
+		}
+		if err := os.WriteFile(rtTablesFile, []byte("255\tlocal\n77 kube-router\n"), 0600); err != nil {
+			t.Fatalf("failed to write rt_tables: %v", err)
+		}
+		if err := os.WriteFile(cniConfFile, []byte("{}"), 0600); err != nil {
+			t.Fatalf("failed to write CNI conf: %v", err)
+		}
+		return fn, &NetworkRoutingController{ln: fn, cniConfFile: cniConfFile}
+	}
+
+	expectedRemoved := []string{
+		"route " + newRoute("10.244.2.0/24", syscall.RT_TABLE_MAIN, zebraRouteOriginator).String(),
+		newRule(netlink.FAMILY_V4, "10.244.1.0/24", customRouteTableNumber).String(),
+		newRule(netlink.FAMILY_V6, "fd00:10:244:1::/64", customRouteTableNumber).String(),
+		"route " + newRoute("192.168.1.2/32", customRouteTableNumber, syscall.RTPROT_BOOT).String(),
+		"interface kube-bridge",
+		"interface " + vxlanInterfaceName,
+		"interface " + generateTunnelName("10.0.1.2"),
+		"routing table name " + customRouteTableName,
+		"CNI conf file " + cniConfFile,
+	}
+
+	t.Run("dry run only lists the configuration", func(t *testing.T) {
+		fn, nrc := setup()
+		removed := nrc.cleanupNetworking(true)
+		if !reflect.DeepEqual(removed, expectedRemoved) {
+			t.Errorf("expected to list %v, got %v", expectedRemoved, removed)
+		}
+		if len(fn.routes) != 3 || len(fn.rules) != 3 || len(fn.links) != 5 {
+			t.Errorf("expected the dry run to leave the routes, rules and links, got %v, %v and %v", fn.routes,
+				fn.rules, fn.links)
+		}
+		b, _ := os.ReadFile(rtTablesFile)
+		if string(b) != "255\tlocal\n77 kube-router\n" {
+			t.Errorf("expected the dry run to leave rt_tables, got %q", string(b))
+		}
+		if _, err := os.Stat(cniConfFile); err != nil {
+			t.Errorf("expected the dry run to leave the CNI conf file: %v", err)
+		}
+	})
+
+	t.Run("cleanup removes the configuration", func(t *testing.T) {
+		fn, nrc := setup()
+		removed := nrc.cleanupNetworking(false)
+		if !reflect.DeepEqual(removed, expectedRemoved) {
+			t.Errorf("expected to remove %v, got %v", expectedRemoved, removed)
+		}
+
+		expectedRoutes := []netlink.Route{newRoute("192.168.1.0/24", syscall.RT_TABLE_MAIN, syscall.RTPROT_KERNEL)}
+		if !reflect.DeepEqual(fn.routes, expectedRoutes) {
+			t.Errorf("expected routes %v, got %v", expectedRoutes, fn.routes)
+		}
+		expectedRules := []netlink.Rule{newRule(netlink.FAMILY_V4, "10.10.0.0/16", 100)}
+		if !reflect.DeepEqual(fn.rules, expectedRules) {
+			t.Errorf("expected rules %v, got %v", expectedRules, fn.rules)
+		}
+		var links []string
+		for name := range fn.links {
+			links = append(links, name)
+		}
+		sort.Strings(links)
+		if !reflect.DeepEqual(links, []string{"eth0", "tunl0"}) {
+			t.Errorf("expected links %v, got %v", []string{"eth0", "tunl0"}, links)
+		}
+		b, _ := os.ReadFile(rtTablesFile)
+		if string(b) != "255\tlocal\n" {
+			t.Errorf("expected the kube-router entry to be removed from rt_tables, got %q", string(b))
+		}
+		if _, err := os.Stat(cniConfFile); !os.IsNotExist(err) {
+			t.Errorf("expected the CNI conf file to be removed: %v", err)
+		}
+	})
+}
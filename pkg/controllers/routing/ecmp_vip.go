@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 
 	"google.golang.org/protobuf/types/known/anypb"
@@ -20,8 +21,9 @@ import (
 	"k8s.io/klog/v2"
 )
 
-// bgpAdvertiseVIP advertises the service vip (cluster ip or load balancer ip or external IP) the configured peers
-func (nrc *NetworkRoutingController) bgpAdvertiseVIP(vip string) error {
+// bgpAdvertiseVIP advertises the service vip (cluster ip or load balancer ip or external IP) the configured peers,
+// along with the MED and local preference of the service when set through its annotations
+func (nrc *NetworkRoutingController) bgpAdvertiseVIP(vip string, svcAttributes *serviceBGPAttributes) error {
 
 	klog.V(2).Infof("Advertising route: '%s/%s via %s' to peers",
 		vip, strconv.Itoa(32), nrc.nodeIP.String())
@@ -33,6 +35,18 @@ func (nrc *NetworkRoutingController) bgpAdvertiseVIP(vip string) error {
 		NextHop: nrc.nodeIP.String(),
 	})
 	attrs := []*anypb.Any{a1, a2}
+	if svcAttributes != nil && svcAttributes.med != nil {
+		med, _ := anypb.New(&gobgpapi.MultiExitDiscAttribute{
+			Med: *svcAttributes.med,
+		})
+		attrs = append(attrs, med)
+	}
+	if svcAttributes != nil && svcAttributes.localPref != nil {
+		localPref, _ := anypb.New(&gobgpapi.LocalPrefAttribute{
+			LocalPref: *svcAttributes.localPref,
+		})
+		attrs = append(attrs, localPref)
+	}
 	nlri1, _ := anypb.New(&gobgpapi.IPAddressPrefix{
 		Prefix:    vip,
 		PrefixLen: 32,
@@ -86,8 +100,9 @@ func (nrc *NetworkRoutingController) bgpWithdrawVIP(vip string) error {
 }
 
 func (nrc *NetworkRoutingController) advertiseVIPs(vips []string) {
+	vipAttributes := nrc.getVIPBGPAttributes()
 	for _, vip := range vips {
-		err := nrc.bgpAdvertiseVIP(vip)
+		err := nrc.bgpAdvertiseVIP(vip, vipAttributes[vip])
 		if err != nil {
 			klog.Errorf("error advertising IP: %q, error: %v", vip, err)
 		}
@@ -496,6 +511,117 @@ func (nrc *NetworkRoutingController) getAllVIPsForService(svc *v1core.Service) [
 
 }
 
+// serviceBGPAttributes holds the BGP attributes of the routes to the VIPs of a service, as set through its annotations
+type serviceBGPAttributes struct {
+	communities      []string
+	largeCommunities []string
+	med              *uint32
+	localPref        *uint32
+}
+
+// hasCommunities returns whether communities are to be added to the routes to the VIPs of the service
+func (a *serviceBGPAttributes) hasCommunities() bool {
+	return len(a.communities) > 0 || len(a.largeCommunities) > 0
+}
+
+// isEmpty returns whether no BGP attribute is set for the service
+func (a *serviceBGPAttributes) isEmpty() bool {
+	return !a.hasCommunities() && a.med == nil && a.localPref == nil
+}
+
+// getServiceBGPAttributes parses the BGP attributes annotations of the service. Invalid values are logged and ignored.
+func getServiceBGPAttributes(svc *v1core.Service) *serviceBGPAttributes {
+	attributes := &serviceBGPAttributes{}
+
+	if value, ok := svc.Annotations[svcCommunitiesAnnotation]; ok {
+		for _, community := range stringToSlice(value, ",") {
+			community = strings.TrimSpace(community)
+			if err := validateCommunity(community); err != nil {
+				klog.Warningf("cannot add BGP community '%s' from annotation of service %s/%s as it does not "+
+					"appear to be a valid community identifier", community, svc.Namespace, svc.Name)
+				continue
+			}
+			attributes.communities = append(attributes.communities, community)
+		}
+	}
+
+	if value, ok := svc.Annotations[svcLargeCommunitiesAnnotation]; ok {
+		for _, community := range stringToSlice(value, ",") {
+			community = strings.TrimSpace(community)
+			if err := validateLargeCommunity(community); err != nil {
+				klog.Warningf("cannot add BGP large community '%s' from annotation of service %s/%s: %s",
+					community, svc.Namespace, svc.Name, err)
+				continue
+			}
+			attributes.largeCommunities = append(attributes.largeCommunities, community)
+		}
+	}
+
+	parseUint32 := func(annotation string) *uint32 {
+		value, ok := svc.Annotations[annotation]
+		if !ok {
+			return nil
+		}
+		parsed, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+		if err != nil {
+			klog.Warningf("cannot parse annotation %s of service %s/%s: %s", annotation, svc.Namespace,
+				svc.Name, err)
+			return nil
+		}
+		result := uint32(parsed)
+		return &result
+	}
+	attributes.med = parseUint32(svcMEDAnnotation)
+	attributes.localPref = parseUint32(svcLocalPrefAnnotation)
+
+	return attributes
+}
+
+// getServicesBGPAttributes returns the BGP attributes of the services that have some, sorted by namespace and name
+func (nrc *NetworkRoutingController) getServicesBGPAttributes() ([]*v1core.Service, []*serviceBGPAttributes) {
+	services := make([]*v1core.Service, 0)
+	for _, obj := range nrc.svcLister.List() {
+		svc, ok := obj.(*v1core.Service)
+		if !ok || utils.ServiceIsHeadless(svc) {
+			continue
+		}
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Name < services[j].Name
+	})
+
+	servicesWithAttributes := make([]*v1core.Service, 0)
+	attributes := make([]*serviceBGPAttributes, 0)
+	for _, svc := range services {
+		svcAttributes := getServiceBGPAttributes(svc)
+		if svcAttributes.isEmpty() {
+			continue
+		}
+		servicesWithAttributes = append(servicesWithAttributes, svc)
+		attributes = append(attributes, svcAttributes)
+	}
+	return servicesWithAttributes, attributes
+}
+
+// getVIPBGPAttributes returns the BGP attributes of the routes to the VIPs, keyed by VIP. When services sharing a VIP
+// both set attributes, the ones of the first service in namespace and name order are used.
+func (nrc *NetworkRoutingController) getVIPBGPAttributes() map[string]*serviceBGPAttributes {
+	vipAttributes := make(map[string]*serviceBGPAttributes)
+	services, attributes := nrc.getServicesBGPAttributes()
+	for i, svc := range services {
+		for _, vip := range nrc.getAllVIPsForService(svc) {
+			if _, ok := vipAttributes[vip]; !ok {
+				vipAttributes[vip] = attributes[i]
+			}
+		}
+	}
+	return vipAttributes
+}
+
 func isEndpointsForLeaderElection(ep *v1core.Endpoints) bool {
 	_, isLeaderElection := ep.Annotations[resourcelock.LeaderElectionRecordAnnotationKey]
 	return isLeaderElection
@@ -2,8 +2,12 @@ package routing
 
 import (
 	"context"
+	"net"
+	"reflect"
 	"testing"
+	"time"
 
+	gobgpapi "github.com/osrg/gobgp/v3/api"
 	v1core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
@@ -373,3 +377,288 @@ func Test_getVIPsForService(t *testing.T) {
 		})
 	}
 }
+
+func uint32Ptr(value uint32) *uint32 {
+	return &value
+}
+
+func Test_getServiceBGPAttributes(t *testing.T) {
+	testcases := []struct {
+		name        string
+		annotations map[string]string
+		expected    *serviceBGPAttributes
+	}{
+		{
+			"no annotations",
+			nil,
+			&serviceBGPAttributes{},
+		},
+		{
+			"all attributes",
+			map[string]string{
+				svcCommunitiesAnnotation:      "65000:100, no-export",
+				svcLargeCommunitiesAnnotation: "65000:1:2,4200000000:3:4",
+				svcMEDAnnotation:              "50",
+				svcLocalPrefAnnotation:        "200",
+			},
+			&serviceBGPAttributes{
+				communities:      []string{"65000:100", "no-export"},
+				largeCommunities: []string{"65000:1:2", "4200000000:3:4"},
+				med:              uint32Ptr(50),
+				localPref:        uint32Ptr(200),
+			},
+		},
+		{
+			"invalid values are ignored",
+			map[string]string{
+				svcCommunitiesAnnotation:      "65000:100,not-a-community",
+				svcLargeCommunitiesAnnotation: "65000:1,65000:1:2:3,65000:1:99999999999",
+				svcMEDAnnotation:              "-1",
+				svcLocalPrefAnnotation:        "4294967296",
+			},
+			&serviceBGPAttributes{
+				communities: []string{"65000:100"},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			svc := &v1core.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "svc-1", Namespace: "default", Annotations: testcase.annotations},
+			}
+			attributes := getServiceBGPAttributes(svc)
+			if !reflect.DeepEqual(attributes, testcase.expected) {
+				t.Logf("expected attributes: %+v", testcase.expected)
+				t.Logf("actual attributes: %+v", attributes)
+				t.Error("unexpected service BGP attributes")
+			}
+		})
+	}
+}
+
+func Test_serviceBGPAttributes(t *testing.T) {
+	nrc := &NetworkRoutingController{
+		clientset:          fake.NewSimpleClientset(),
+		hostnameOverride:   "node-1",
+		nodeName:           "node-1",
+		nodeIP:             net.ParseIP("10.0.0.1"),
+		routerID:           "10.0.0.1",
+		bgpPort:            10000,
+		bgpHoldtime:        90,
+		activeNodes:        make(map[string]bool),
+		podCidr:            "172.20.0.0/24",
+		podCIDRs:           []string{"172.20.0.0/24"},
+		advertiseClusterIP: true,
+	}
+	node := &v1core.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Annotations: map[string]string{
+				nodeASNAnnotation: "100",
+				peerIPAnnotation:  "10.0.0.254",
+				peerASNAnnotation: "65000",
+			},
+		},
+		Status: v1core.NodeStatus{
+			Addresses: []v1core.NodeAddress{{Type: v1core.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	}
+	services := []*v1core.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "svc-1",
+				Namespace: "default",
+				Annotations: map[string]string{
+					svcCommunitiesAnnotation:      "65000:100",
+					svcLargeCommunitiesAnnotation: "65000:1:2",
+					svcMEDAnnotation:              "50",
+					svcLocalPrefAnnotation:        "200",
+				},
+			},
+			Spec: v1core.ServiceSpec{Type: ClusterIPST, ClusterIP: "10.96.0.1"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "svc-2", Namespace: "default"},
+			Spec:       v1core.ServiceSpec{Type: ClusterIPST, ClusterIP: "10.96.0.2"},
+		},
+	}
+	startInformersForRoutes(nrc, nrc.clientset)
+	if err := createNodes(nrc.clientset, []*v1core.Node{node}); err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+	if err := createServices(nrc.clientset, services); err != nil {
+		t.Fatalf("failed to create services: %v", err)
+	}
+	waitForListerWithTimeout(nrc.nodeLister, time.Second*10, t)
+	waitForListerWithTimeout(nrc.svcLister, time.Second*10, t)
+
+	if err := nrc.startBgpServer(false); err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	if err := nrc.AddPolicies(); err != nil {
+		t.Fatalf("failed to add policies: %v", err)
+	}
+	toAdvertise, _, _ := nrc.getActiveVIPs()
+	nrc.advertiseVIPs(toAdvertise)
+
+	definedSetName := serviceCommunitiesDefinedSetPrefix + "default/svc-1-v4"
+	definedSet6Name := serviceCommunitiesDefinedSetPrefix + "default/svc-1-v6"
+	listDefinedSet := func(definedSetName string) *gobgpapi.DefinedSet {
+		var definedSet *gobgpapi.DefinedSet
+		err := nrc.bgpServer.ListDefinedSet(context.Background(), &gobgpapi.ListDefinedSetRequest{
+			DefinedType: gobgpapi.DefinedType_PREFIX, Name: definedSetName}, func(ds *gobgpapi.DefinedSet) {
+			definedSet = ds
+		})
+		if err != nil {
+			t.Fatalf("failed to list defined sets: %v", err)
+		}
+		return definedSet
+	}
+	definedSet := listDefinedSet(definedSetName)
+	if definedSet == nil || len(definedSet.Prefixes) != 1 || definedSet.Prefixes[0].IpPrefix != "10.96.0.1/32" {
+		t.Errorf("unexpected defined set of the service: %v", definedSet)
+	}
+
+	err := nrc.bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{Name: "kube_router_export"},
+		func(policy *gobgpapi.Policy) {
+			found := false
+			for _, statement := range policy.Statements {
+				if statement.Conditions.PrefixSet == nil || statement.Conditions.PrefixSet.Name != definedSetName {
+					continue
+				}
+				found = true
+				if statement.Actions.RouteAction != gobgpapi.RouteAction_NONE {
+					t.Errorf("expected the statement of the service not to accept nor reject the routes, got %s",
+						statement.Actions.RouteAction)
+				}
+				if statement.Actions.Community == nil || len(statement.Actions.Community.Communities) != 1 {
+					t.Errorf("unexpected communities in the statement of the service: %v",
+						statement.Actions.Community)
+				}
+				if statement.Actions.LargeCommunity == nil ||
+					!reflect.DeepEqual(statement.Actions.LargeCommunity.Communities, []string{"65000:1:2"}) {
+					t.Errorf("unexpected large communities in the statement of the service: %v",
+						statement.Actions.LargeCommunity)
+				}
+			}
+			if !found {
+				t.Error("expected the export policy to have a statement for the service")
+			}
+		})
+	if err != nil {
+		t.Fatalf("failed to list policies: %v", err)
+	}
+
+	expectedAttributes := map[string][2]uint32{
+		"10.96.0.1": {50, 200},
+		"10.96.0.2": {0, 0},
+	}
+	checkedPaths := 0
+	err = nrc.bgpServer.ListPath(context.Background(), &gobgpapi.ListPathRequest{
+		TableType: gobgpapi.TableType_GLOBAL,
+		Family:    &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
+	}, func(destination *gobgpapi.Destination) {
+		for _, path := range destination.Paths {
+			var prefix gobgpapi.IPAddressPrefix
+			if err := path.Nlri.UnmarshalTo(&prefix); err != nil {
+				t.Fatalf("invalid nlri in path: %v", err)
+			}
+			var attributes [2]uint32
+			for _, pattr := range path.Pattrs {
+				var med gobgpapi.MultiExitDiscAttribute
+				var localPref gobgpapi.LocalPrefAttribute
+				if pattr.UnmarshalTo(&med) == nil {
+					attributes[0] = med.Med
+				} else if pattr.UnmarshalTo(&localPref) == nil {
+					attributes[1] = localPref.LocalPref
+				}
+			}
+			expected, ok := expectedAttributes[prefix.Prefix]
+			if !ok {
+				continue
+			}
+			checkedPaths++
+			if attributes != expected {
+				t.Errorf("expected MED and local preference %v for %s, got %v", expected, prefix.Prefix, attributes)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to list paths: %v", err)
+	}
+	if checkedPaths != len(expectedAttributes) {
+		t.Errorf("expected paths for %d VIPs, found %d", len(expectedAttributes), checkedPaths)
+	}
+
+	// the IPv6 VIPs of the service are in a defined set of their own, as a prefix set holds a single IP family
+	svc := services[0].DeepCopy()
+	svc.Annotations[svcAdvertiseExternalAnnotation] = "true"
+	svc.Spec.ExternalIPs = []string{"2001:db8::1"}
+	if _, err := nrc.clientset.CoreV1().Services("default").Update(context.Background(), svc,
+		metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update service: %v", err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		obj, exists, _ := nrc.svcLister.GetByKey("default/svc-1")
+		if exists && len(obj.(*v1core.Service).Spec.ExternalIPs) == 1 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	definedSets, err := nrc.addServiceCommunitiesDefinedSets()
+	if err != nil {
+		t.Fatalf("failed to add the defined sets of the services: %v", err)
+	}
+	definedSet = listDefinedSet(definedSet6Name)
+	if definedSet == nil || len(definedSet.Prefixes) != 1 || definedSet.Prefixes[0].IpPrefix != "2001:db8::1/128" {
+		t.Errorf("unexpected IPv6 defined set of the service: %v", definedSet)
+	}
+	if err = nrc.addExportPolicies(definedSets); err != nil {
+		t.Fatalf("failed to add export policies: %v", err)
+	}
+	err = nrc.bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{Name: "kube_router_export"},
+		func(policy *gobgpapi.Policy) {
+			found := make(map[string]bool)
+			for _, statement := range policy.Statements {
+				if statement.Conditions.PrefixSet != nil {
+					found[statement.Conditions.PrefixSet.Name] = true
+				}
+			}
+			if !found[definedSetName] || !found[definedSet6Name] {
+				t.Errorf("expected the export policy to have a statement per IP family for the service, got %v",
+					found)
+			}
+		})
+	if err != nil {
+		t.Fatalf("failed to list policies: %v", err)
+	}
+
+	// the defined sets of the service are removed along with its annotations
+	svc = services[0].DeepCopy()
+	svc.Annotations = nil
+	if _, err := nrc.clientset.CoreV1().Services("default").Update(context.Background(), svc,
+		metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update service: %v", err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		obj, exists, _ := nrc.svcLister.GetByKey("default/svc-1")
+		if exists && len(obj.(*v1core.Service).Annotations) == 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := nrc.AddPolicies(); err != nil {
+		t.Fatalf("failed to add policies: %v", err)
+	}
+	for _, name := range []string{definedSetName, definedSet6Name} {
+		if definedSet := listDefinedSet(name); definedSet != nil {
+			t.Errorf("expected the defined set %s of the service to be removed, got %v", name, definedSet)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// IntrospectionPath is the path of the health and metrics servers the routing state is served on
+	IntrospectionPath = "/debug/routing"
+
+	redactedPassword = "<redacted>"
+)
+
+var introspectionFamilies = map[string]*gobgpapi.Family{
+	"ipv4-unicast": {Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
+	"ipv6-unicast": {Afi: gobgpapi.Family_AFI_IP6, Safi: gobgpapi.Family_SAFI_UNICAST},
+}
+
+// introspectionDefinedTypes are the types of defined sets GoBGP can list
+var introspectionDefinedTypes = []gobgpapi.DefinedType{
+	gobgpapi.DefinedType_PREFIX,
+	gobgpapi.DefinedType_NEIGHBOR,
+	gobgpapi.DefinedType_AS_PATH,
+	gobgpapi.DefinedType_COMMUNITY,
+	gobgpapi.DefinedType_EXT_COMMUNITY,
+	gobgpapi.DefinedType_LARGE_COMMUNITY,
+}
+
+// routingIntrospection is the routing state of the node served as JSON, the GoBGP objects are encoded as in the
+// GoBGP API
+type routingIntrospection struct {
+	Global            json.RawMessage              `json:"global"`
+	GlobalRIB         map[string][]json.RawMessage `json:"globalRib"`
+	Peers             []peerIntrospection          `json:"peers"`
+	DefinedSets       []json.RawMessage            `json:"definedSets"`
+	Policies          []json.RawMessage            `json:"policies"`
+	PolicyAssignments []json.RawMessage            `json:"policyAssignments"`
+	InjectedRoutes    []injectedRouteIntrospection `json:"injectedRoutes"`
+	Tunnels           []tunnelIntrospection        `json:"tunnels"`
+}
+
+type peerIntrospection struct {
+	Neighbor json.RawMessage              `json:"neighbor"`
+	AdjIn    map[string][]json.RawMessage `json:"adjIn"`
+	AdjOut   map[string][]json.RawMessage `json:"adjOut"`
+}
+
+type injectedRouteIntrospection struct {
+	Destination string                 `json:"destination"`
+	Gateway     string                 `json:"gateway,omitempty"`
+	Interface   string                 `json:"interface,omitempty"`
+	NextHops    []nextHopIntrospection `json:"nextHops,omitempty"`
+}
+
+type nextHopIntrospection struct {
+	Gateway   string `json:"gateway,omitempty"`
+	Interface string `json:"interface,omitempty"`
+}
+
+type tunnelIntrospection struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	InUse  bool   `json:"inUse"`
+}
+
+// IntrospectionHandler writes the routing state of the node as JSON: the GoBGP global configuration, RIB and
+// neighbors with their RIBs, the defined sets and policies, and the routes and tunnels injected by kube-router. The
+// passwords of the neighbors are redacted.
+func (nrc *NetworkRoutingController) IntrospectionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !nrc.bgpServerStarted {
+		http.Error(w, "The BGP server is not started yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	state, err := nrc.getRoutingIntrospection()
+	if err != nil {
+		klog.Errorf("Failed to get the routing state: %s", err)
+		http.Error(w, "Failed to get the routing state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		klog.Errorf("Failed to encode the routing state: %s", err)
+		http.Error(w, "Failed to encode the routing state", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(append(body, '\n')); err != nil {
+		klog.Errorf("Failed to write body: %s", err)
+	}
+}
+
+// getRoutingIntrospection collects the routing state of the node from the BGP server and the route syncer
+func (nrc *NetworkRoutingController) getRoutingIntrospection() (*routingIntrospection, error) {
+	ctx := context.Background()
+	state := &routingIntrospection{}
+
+	bgp, err := nrc.bgpServer.GetBgp(ctx, &gobgpapi.GetBgpRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the BGP global configuration: %s", err)
+	}
+	if state.Global, err = protojson.Marshal(bgp.Global); err != nil {
+		return nil, err
+	}
+	if state.GlobalRIB, err = nrc.listIntrospectionPaths(gobgpapi.TableType_GLOBAL, ""); err != nil {
+		return nil, err
+	}
+
+	var peers []*gobgpapi.Peer
+	err = nrc.bgpServer.ListPeer(ctx, &gobgpapi.ListPeerRequest{}, func(peer *gobgpapi.Peer) {
+		peers = append(peers, peer)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the BGP peers: %s", err)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Conf.NeighborAddress < peers[j].Conf.NeighborAddress
+	})
+	state.Peers = make([]peerIntrospection, 0, len(peers))
+	for _, peer := range peers {
+		if peer.Conf.AuthPassword != "" {
+			peer = proto.Clone(peer).(*gobgpapi.Peer)
+			peer.Conf.AuthPassword = redactedPassword
+		}
+		peerState := peerIntrospection{}
+		if peerState.Neighbor, err = protojson.Marshal(peer); err != nil {
+			return nil, err
+		}
+		address := peer.Conf.NeighborAddress
+		if peerState.AdjIn, err = nrc.listIntrospectionPaths(gobgpapi.TableType_ADJ_IN, address); err != nil {
+			return nil, err
+		}
+		if peerState.AdjOut, err = nrc.listIntrospectionPaths(gobgpapi.TableType_ADJ_OUT, address); err != nil {
+			return nil, err
+		}
+		state.Peers = append(state.Peers, peerState)
+	}
+
+	var marshalErr error
+	state.DefinedSets = make([]json.RawMessage, 0)
+	for _, definedType := range introspectionDefinedTypes {
+		err = nrc.bgpServer.ListDefinedSet(ctx, &gobgpapi.ListDefinedSetRequest{
+			DefinedType: definedType,
+		}, func(ds *gobgpapi.DefinedSet) {
+			marshalErr = appendProtoJSON(&state.DefinedSets, ds, marshalErr)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the BGP defined sets: %s", err)
+		}
+	}
+	state.Policies = make([]json.RawMessage, 0)
+	err = nrc.bgpServer.ListPolicy(ctx, &gobgpapi.ListPolicyRequest{}, func(policy *gobgpapi.Policy) {
+		marshalErr = appendProtoJSON(&state.Policies, policy, marshalErr)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the BGP policies: %s", err)
+	}
+	state.PolicyAssignments = make([]json.RawMessage, 0)
+	err = nrc.bgpServer.ListPolicyAssignment(ctx, &gobgpapi.ListPolicyAssignmentRequest{},
+		func(assignment *gobgpapi.PolicyAssignment) {
+			marshalErr = appendProtoJSON(&state.PolicyAssignments, assignment, marshalErr)
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the BGP policy assignments: %s", err)
+	}
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+
+	if state.InjectedRoutes, state.Tunnels, err = nrc.getInjectedRoutesIntrospection(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// listIntrospectionPaths returns the destinations of the global RIB, or of the adj-in or adj-out RIB of the peer, by
+// address family
+func (nrc *NetworkRoutingController) listIntrospectionPaths(tableType gobgpapi.TableType,
+	address string) (map[string][]json.RawMessage, error) {
+	rib := make(map[string][]json.RawMessage, len(introspectionFamilies))
+	for name, family := range introspectionFamilies {
+		destinations := make([]json.RawMessage, 0)
+		var marshalErr error
+		err := nrc.bgpServer.ListPath(context.Background(), &gobgpapi.ListPathRequest{
+			TableType: tableType,
+			Name:      address,
+			Family:    family,
+			SortType:  gobgpapi.ListPathRequest_PREFIX,
+		}, func(destination *gobgpapi.Destination) {
+			marshalErr = appendProtoJSON(&destinations, destination, marshalErr)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the %s paths of the %s RIB %s: %s", name, tableType, address, err)
+		}
+		if marshalErr != nil {
+			return nil, marshalErr
+		}
+		rib[name] = destinations
+	}
+	return rib, nil
+}
+
+// appendProtoJSON appends the GoBGP object encoded as JSON, unless encoding a previous one failed
+func appendProtoJSON(messages *[]json.RawMessage, m proto.Message, err error) error {
+	if err != nil {
+		return err
+	}
+	message, err := protojson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	*messages = append(*messages, message)
+	return nil
+}
+
+// getInjectedRoutesIntrospection returns the routes kube-router injects into the kernel's routing table, along with
+// the tunnels it created to the other nodes
+func (nrc *NetworkRoutingController) getInjectedRoutesIntrospection() ([]injectedRouteIntrospection,
+	[]tunnelIntrospection, error) {
+	links, err := nrc.routeSyncer.ln.linkList()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the interfaces: %s", err)
+	}
+	linkNames := make(map[int]string, len(links))
+	for _, link := range links {
+		linkNames[link.Attrs().Index] = link.Attrs().Name
+	}
+
+	usedLinks := make(map[int]bool)
+	routes := make([]injectedRouteIntrospection, 0)
+	for dst, route := range nrc.routeSyncer.getInjectedRoutes() {
+		routeState := injectedRouteIntrospection{Destination: dst, Interface: linkNames[route.LinkIndex]}
+		if route.Gw != nil {
+			routeState.Gateway = route.Gw.String()
+		}
+		usedLinks[route.LinkIndex] = true
+		for _, nextHop := range route.MultiPath {
+			nextHopState := nextHopIntrospection{Interface: linkNames[nextHop.LinkIndex]}
+			if nextHop.Gw != nil {
+				nextHopState.Gateway = nextHop.Gw.String()
+			}
+			usedLinks[nextHop.LinkIndex] = true
+			routeState.NextHops = append(routeState.NextHops, nextHopState)
+		}
+		routes = append(routes, routeState)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Destination < routes[j].Destination
+	})
+
+	tunnels := make([]tunnelIntrospection, 0)
+	for _, link := range links {
+		if !isPerNodeTunnel(link) {
+			continue
+		}
+		tunnel := tunnelIntrospection{Name: link.Attrs().Name, Type: link.Type(), InUse: usedLinks[link.Attrs().Index]}
+		switch t := link.(type) {
+		case *netlink.Iptun:
+			tunnel.Local, tunnel.Remote = t.Local.String(), t.Remote.String()
+		case *netlink.Ip6tnl:
+			tunnel.Local, tunnel.Remote = t.Local.String(), t.Remote.String()
+		}
+		tunnels = append(tunnels, tunnel)
+	}
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].Name < tunnels[j].Name
+	})
+	return routes, tunnels, nil
+}
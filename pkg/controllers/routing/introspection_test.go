@@ -0,0 +1,153 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func Test_IntrospectionHandler(t *testing.T) {
+	tunnel := &netlink.Iptun{
+		LinkAttrs: netlink.LinkAttrs{Name: "tun-0a000002"},
+		Local:     net.ParseIP("10.0.0.1"),
+		Remote:    net.ParseIP("10.0.0.2"),
+	}
+	staleTunnel := &netlink.Iptun{
+		LinkAttrs: netlink.LinkAttrs{Name: "tun-0a000003"},
+		Local:     net.ParseIP("10.0.0.1"),
+		Remote:    net.ParseIP("10.0.0.3"),
+	}
+	eth0 := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}}
+	fn := newFakeNetlink(eth0, tunnel, staleTunnel)
+
+	nrc := &NetworkRoutingController{
+		bgpServer:   gobgp.NewBgpServer(),
+		routeSyncer: newRouteSyncer(time.Minute, injectedRoutesCleanupDisabled),
+	}
+	nrc.routeSyncer.ln = fn
+
+	get := func(method string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		nrc.IntrospectionHandler(recorder, httptest.NewRequest(method, IntrospectionPath, nil))
+		return recorder
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, get(http.MethodGet).Code,
+		"the routing state should not be served before the BGP server is started")
+
+	go nrc.bgpServer.Serve()
+	err := nrc.bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 64512, RouterId: "10.0.0.1", ListenPort: -1},
+	})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	nrc.bgpServerStarted = true
+
+	err = nrc.bgpServer.AddPeer(context.Background(), &gobgpapi.AddPeerRequest{Peer: &gobgpapi.Peer{
+		Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.0.254", PeerAsn: 65000, AuthPassword: "s3cr3t"},
+	}})
+	if err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	nlri, _ := anypb.New(&gobgpapi.IPAddressPrefix{Prefix: "10.244.1.0", PrefixLen: 24})
+	origin, _ := anypb.New(&gobgpapi.OriginAttribute{Origin: 0})
+	nextHop, _ := anypb.New(&gobgpapi.NextHopAttribute{NextHop: "10.0.0.1"})
+	_, err = nrc.bgpServer.AddPath(context.Background(), &gobgpapi.AddPathRequest{Path: &gobgpapi.Path{
+		Family: &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
+		Nlri:   nlri, Pattrs: []*anypb.Any{origin, nextHop},
+	}})
+	if err != nil {
+		t.Fatalf("failed to add path: %v", err)
+	}
+	err = nrc.bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{
+		DefinedSet: &gobgpapi.DefinedSet{
+			DefinedType: gobgpapi.DefinedType_NEIGHBOR, Name: "externalpeerset", List: []string{"10.0.0.254/32"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to add defined set: %v", err)
+	}
+
+	_, podCIDR, _ := net.ParseCIDR("10.244.2.0/24")
+	nrc.routeSyncer.addInjectedRoute(podCIDR, &netlink.Route{
+		Dst: podCIDR, Gw: net.ParseIP("10.0.0.2"), LinkIndex: tunnel.Index,
+	})
+	_, external, _ := net.ParseCIDR("192.168.0.0/16")
+	nrc.routeSyncer.addInjectedRoute(external, &netlink.Route{
+		Dst: external,
+		MultiPath: []*netlink.NexthopInfo{
+			{Gw: net.ParseIP("10.0.0.253"), LinkIndex: eth0.Index},
+			{Gw: net.ParseIP("10.0.0.254"), LinkIndex: eth0.Index},
+		},
+	})
+
+	recorder := get(http.MethodGet)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.NotContains(t, recorder.Body.String(), "s3cr3t", "the passwords of the peers should be redacted")
+
+	var state struct {
+		Global struct {
+			Asn uint32 `json:"asn"`
+		} `json:"global"`
+		GlobalRIB map[string][]struct {
+			Prefix string `json:"prefix"`
+		} `json:"globalRib"`
+		Peers []struct {
+			Neighbor struct {
+				Conf struct {
+					NeighborAddress string `json:"neighborAddress"`
+					AuthPassword    string `json:"authPassword"`
+				} `json:"conf"`
+			} `json:"neighbor"`
+			AdjIn map[string][]json.RawMessage `json:"adjIn"`
+		} `json:"peers"`
+		DefinedSets []struct {
+			Name string `json:"name"`
+		} `json:"definedSets"`
+		InjectedRoutes []injectedRouteIntrospection `json:"injectedRoutes"`
+		Tunnels        []tunnelIntrospection        `json:"tunnels"`
+	}
+	if err = json.Unmarshal(recorder.Body.Bytes(), &state); err != nil {
+		t.Fatalf("failed to decode the routing state: %v", err)
+	}
+
+	assert.Equal(t, uint32(64512), state.Global.Asn)
+	assert.Len(t, state.GlobalRIB["ipv4-unicast"], 1)
+	assert.Equal(t, "10.244.1.0/24", state.GlobalRIB["ipv4-unicast"][0].Prefix)
+	assert.Empty(t, state.GlobalRIB["ipv6-unicast"])
+	assert.Len(t, state.Peers, 1)
+	assert.Equal(t, "10.0.0.254", state.Peers[0].Neighbor.Conf.NeighborAddress)
+	assert.Equal(t, redactedPassword, state.Peers[0].Neighbor.Conf.AuthPassword)
+	assert.Contains(t, state.Peers[0].AdjIn, "ipv4-unicast")
+	assert.Len(t, state.DefinedSets, 1)
+	assert.Equal(t, "externalpeerset", state.DefinedSets[0].Name)
+	assert.Equal(t, []injectedRouteIntrospection{
+		{Destination: "10.244.2.0/24", Gateway: "10.0.0.2", Interface: "tun-0a000002"},
+		{Destination: "192.168.0.0/16", NextHops: []nextHopIntrospection{
+			{Gateway: "10.0.0.253", Interface: "eth0"},
+			{Gateway: "10.0.0.254", Interface: "eth0"},
+		}},
+	}, state.InjectedRoutes)
+	assert.Equal(t, []tunnelIntrospection{
+		{Name: "tun-0a000002", Type: "ipip", Local: "10.0.0.1", Remote: "10.0.0.2", InUse: true},
+		{Name: "tun-0a000003", Type: "ipip", Local: "10.0.0.1", Remote: "10.0.0.3", InUse: false},
+	}, state.Tunnels)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, get(http.MethodPost).Code)
+}
@@ -0,0 +1,87 @@
+package routing
+
+import (
+	"github.com/vishvananda/netlink"
+)
+
+// netlinkCalls contains the netlink calls used to reconcile the overlay tunnels, the policy based routing rules and the
+// injected routes, so that the reconciliation can be tested without changing the host networking
+type netlinkCalls interface {
+	linkByName(name string) (netlink.Link, error)
+	linkList() ([]netlink.Link, error)
+	linkAdd(link netlink.Link) error
+	linkDel(link netlink.Link) error
+	linkSetUp(link netlink.Link) error
+	linkSubscribe(ch chan<- netlink.LinkUpdate, done <-chan struct{}, options netlink.LinkSubscribeOptions) error
+	ruleList(family int) ([]netlink.Rule, error)
+	ruleAdd(rule *netlink.Rule) error
+	ruleDel(rule *netlink.Rule) error
+	routeListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error)
+	routeReplace(route *netlink.Route) error
+	routeDel(route *netlink.Route) error
+	routeSubscribe(ch chan<- netlink.RouteUpdate, done <-chan struct{}, options netlink.RouteSubscribeOptions) error
+}
+
+// linuxNetworking implements netlinkCalls on the network namespace of kube-router
+type linuxNetworking struct{}
+
+func (ln *linuxNetworking) linkByName(name string) (netlink.Link, error) {
+	return netlink.LinkByName(name)
+}
+
+func (ln *linuxNetworking) linkList() ([]netlink.Link, error) {
+	return netlink.LinkList()
+}
+
+func (ln *linuxNetworking) linkAdd(link netlink.Link) error {
+	return netlink.LinkAdd(link)
+}
+
+func (ln *linuxNetworking) linkDel(link netlink.Link) error {
+	return netlink.LinkDel(link)
+}
+
+func (ln *linuxNetworking) linkSetUp(link netlink.Link) error {
+	return netlink.LinkSetUp(link)
+}
+
+func (ln *linuxNetworking) linkSubscribe(ch chan<- netlink.LinkUpdate, done <-chan struct{},
+	options netlink.LinkSubscribeOptions) error {
+	return netlink.LinkSubscribeWithOptions(ch, done, options)
+}
+
+func (ln *linuxNetworking) ruleList(family int) ([]netlink.Rule, error) {
+	return netlink.RuleList(family)
+}
+
+func (ln *linuxNetworking) ruleAdd(rule *netlink.Rule) error {
+	return netlink.RuleAdd(rule)
+}
+
+func (ln *linuxNetworking) ruleDel(rule *netlink.Rule) error {
+	return netlink.RuleDel(rule)
+}
+
+func (ln *linuxNetworking) routeListFiltered(family int, filter *netlink.Route,
+	filterMask uint64) ([]netlink.Route, error) {
+	return netlink.RouteListFiltered(family, filter, filterMask)
+}
+
+func (ln *linuxNetworking) routeReplace(route *netlink.Route) error {
+	return netlink.RouteReplace(route)
+}
+
+func (ln *linuxNetworking) routeDel(route *netlink.Route) error {
+	// netlink refuses to delete the multipath default routes as listed from the kernel, without destination nor gateway
+	if route.Dst == nil {
+		withDst := *route
+		withDst.Dst = getRouteDst(route)
+		route = &withDst
+	}
+	return netlink.RouteDel(route)
+}
+
+func (ln *linuxNetworking) routeSubscribe(ch chan<- netlink.RouteUpdate, done <-chan struct{},
+	options netlink.RouteSubscribeOptions) error {
+	return netlink.RouteSubscribeWithOptions(ch, done, options)
+}
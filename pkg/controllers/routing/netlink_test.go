@@ -0,0 +1,139 @@
+package routing
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// fakeNetlink is an in memory netlinkCalls keeping the links, rules and routes it is given
+type fakeNetlink struct {
+	links   map[string]netlink.Link
+	rules   []netlink.Rule
+	routes  []netlink.Route
+	deleted []string
+}
+
+func newFakeNetlink(links ...netlink.Link) *fakeNetlink {
+	fn := &fakeNetlink{links: make(map[string]netlink.Link)}
+	for _, link := range links {
+		_ = fn.linkAdd(link)
+	}
+	return fn
+}
+
+func (fn *fakeNetlink) linkByName(name string) (netlink.Link, error) {
+	link, ok := fn.links[name]
+	if !ok {
+		return nil, netlink.LinkNotFoundError{}
+	}
+	return link, nil
+}
+
+func (fn *fakeNetlink) linkList() ([]netlink.Link, error) {
+	links := make([]netlink.Link, 0, len(fn.links))
+	for _, link := range fn.links {
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+func (fn *fakeNetlink) linkAdd(link netlink.Link) error {
+	if _, ok := fn.links[link.Attrs().Name]; ok {
+		return syscall.EEXIST
+	}
+	link.Attrs().Index = len(fn.links) + len(fn.deleted) + 1
+	fn.links[link.Attrs().Name] = link
+	return nil
+}
+
+func (fn *fakeNetlink) linkDel(link netlink.Link) error {
+	if _, ok := fn.links[link.Attrs().Name]; !ok {
+		return errors.New("link not found")
+	}
+	delete(fn.links, link.Attrs().Name)
+	fn.deleted = append(fn.deleted, link.Attrs().Name)
+	return nil
+}
+
+func (fn *fakeNetlink) linkSetUp(link netlink.Link) error {
+	link.Attrs().Flags |= net.FlagUp
+	return nil
+}
+
+func (fn *fakeNetlink) linkSubscribe(chan<- netlink.LinkUpdate, <-chan struct{}, netlink.LinkSubscribeOptions) error {
+	return nil
+}
+
+func (fn *fakeNetlink) ruleList(family int) ([]netlink.Rule, error) {
+	var rules []netlink.Rule
+	for _, rule := range fn.rules {
+		if rule.Family == family {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (fn *fakeNetlink) ruleAdd(rule *netlink.Rule) error {
+	fn.rules = append(fn.rules, *rule)
+	return nil
+}
+
+func (fn *fakeNetlink) ruleDel(rule *netlink.Rule) error {
+	for i, existing := range fn.rules {
+		if existing.Family == rule.Family && existing.Table == rule.Table && existing.Src.String() == rule.Src.String() {
+			fn.rules = append(fn.rules[:i], fn.rules[i+1:]...)
+			return nil
+		}
+	}
+	return syscall.ENOENT
+}
+
+func (fn *fakeNetlink) routeListFiltered(_ int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error) {
+	var routes []netlink.Route
+	for _, route := range fn.routes {
+		if filterMask&netlink.RT_FILTER_TABLE != 0 && route.Table != filter.Table {
+			continue
+		}
+		if filterMask&netlink.RT_FILTER_PROTOCOL != 0 && route.Protocol != filter.Protocol {
+			continue
+		}
+		// the kernel lists the default routes without destination
+		if filterMask&netlink.RT_FILTER_DST != 0 && routeFilterDst(getRouteDst(&route)).String() != filter.Dst.String() {
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+func (fn *fakeNetlink) routeReplace(route *netlink.Route) error {
+	for i, existing := range fn.routes {
+		if existing.Table == route.Table && existing.Dst.String() == route.Dst.String() &&
+			existing.Priority == route.Priority {
+			fn.routes[i] = *route
+			return nil
+		}
+	}
+	fn.routes = append(fn.routes, *route)
+	return nil
+}
+
+func (fn *fakeNetlink) routeDel(route *netlink.Route) error {
+	for i, existing := range fn.routes {
+		if existing.Table == route.Table && existing.Dst.String() == route.Dst.String() &&
+			existing.LinkIndex == route.LinkIndex {
+			fn.routes = append(fn.routes[:i], fn.routes[i+1:]...)
+			return nil
+		}
+	}
+	return syscall.ESRCH
+}
+
+func (fn *fakeNetlink) routeSubscribe(chan<- netlink.RouteUpdate, <-chan struct{},
+	netlink.RouteSubscribeOptions) error {
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"os"
 	"os/exec"
@@ -12,10 +13,12 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"google.golang.org/protobuf/types/known/anypb"
 
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
 	"github.com/cloudnativelabs/kube-router/pkg/healthcheck"
 	"github.com/cloudnativelabs/kube-router/pkg/metrics"
 	"github.com/cloudnativelabs/kube-router/pkg/options"
@@ -27,6 +30,7 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	v1core "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
@@ -35,10 +39,11 @@ import (
 const (
 	IfaceNotFound = "Link not found"
 
-	customRouteTableID   = "77"
-	customRouteTableName = "kube-router"
-	podSubnetsIPSetName  = "kube-router-pod-subnets"
-	nodeAddrsIPSetName   = "kube-router-node-ips"
+	customRouteTableID     = "77"
+	customRouteTableNumber = 77
+	customRouteTableName   = "kube-router"
+	podSubnetsIPSetName    = "kube-router-pod-subnets"
+	nodeAddrsIPSetName     = "kube-router-node-ips"
 
 	nodeASNAnnotation                = "kube-router.io/node.asn"
 	nodeCommunitiesAnnotation        = "kube-router.io/node.bgp.communities"
@@ -50,6 +55,8 @@ const (
 	peerLocalIPAnnotation            = "kube-router.io/peer.localips"
 	//nolint:gosec // this is not a hardcoded password
 	peerPasswordAnnotation             = "kube-router.io/peer.passwords"
+	peerPasswordSecretAnnotation       = "kube-router.io/peer.password-secrets"
+	peerMaxPrefixesAnnotation          = "kube-router.io/peer.max-prefixes"
 	peerPortAnnotation                 = "kube-router.io/peer.ports"
 	rrClientAnnotation                 = "kube-router.io/rr.client"
 	rrServerAnnotation                 = "kube-router.io/rr.server"
@@ -58,6 +65,11 @@ const (
 	svcAdvertiseClusterAnnotation      = "kube-router.io/service.advertise.clusterip"
 	svcAdvertiseExternalAnnotation     = "kube-router.io/service.advertise.externalip"
 	svcAdvertiseLoadBalancerAnnotation = "kube-router.io/service.advertise.loadbalancerip"
+	svcCommunitiesAnnotation           = "kube-router.io/service.bgp.communities"
+	svcLargeCommunitiesAnnotation      = "kube-router.io/service.bgp.large-communities"
+	svcMEDAnnotation                   = "kube-router.io/service.bgp.med"
+	svcLocalPrefAnnotation             = "kube-router.io/service.bgp.local-pref"
+	wireGuardPublicKeyAnnotation       = "kube-router.io/wireguard.public-key"
 
 	// Deprecated: use kube-router.io/service.advertise.loadbalancer instead
 	svcSkipLbIpsAnnotation = "kube-router.io/service.skiplbips"
@@ -74,20 +86,28 @@ const (
 	ipv4MaskMinBits         = 32
 	// Taken from: https://github.com/torvalds/linux/blob/master/include/uapi/linux/rtnetlink.h#L284
 	zebraRouteOriginator = 0x11
+	// the defaults of `ip -6 tunnel add`
+	ip6TunnelHopLimit   = 64
+	ip6TunnelEncapLimit = 4
 )
 
 // NetworkRoutingController is struct to hold necessary information required by controller
 type NetworkRoutingController struct {
 	nodeIP                         net.IP
+	nodeSecondaryIP                net.IP
 	nodeName                       string
 	nodeSubnet                     net.IPNet
+	nodeSecondarySubnet            net.IPNet
 	nodeInterface                  string
+	nodeSecondaryInterface         string
 	routerID                       string
 	isIpv6                         bool
 	activeNodes                    map[string]bool
 	mu                             sync.Mutex
 	clientset                      kubernetes.Interface
 	bgpServer                      *gobgp.BgpServer
+	bgpAPIOptions                  []gobgp.ServerOption
+	bgpAPISocket                   string
 	syncPeriod                     time.Duration
 	clusterCIDR                    string
 	enablePodEgress                bool
@@ -101,7 +121,9 @@ type NetworkRoutingController struct {
 	nodeAsnNumber                  uint32
 	nodeCustomImportRejectIPNets   []net.IPNet
 	nodeCommunities                []string
+	nodeLargeCommunities           []string
 	globalPeerRouters              []*gobgpapi.Peer
+	globalPeerPasswordSecrets      []*v1alpha1.SecretKeyReference
 	nodePeerRouters                []string
 	enableCNI                      bool
 	bgpFullMeshMode                bool
@@ -110,9 +132,23 @@ type NetworkRoutingController struct {
 	bgpGracefulRestartTime         time.Duration
 	bgpGracefulRestartDeferralTime time.Duration
 	ipSetHandler                   *utils.IPSet
+	secondaryIPSetHandler          *utils.IPSet
 	enableOverlays                 bool
 	overlayType                    string
+	overlayEncap                   string
+	overlaySingleTunnel            bool
+	vxlanPort                      uint16
+	wireGuardPort                  uint16
+	wireGuardKeyRotationPeriod     time.Duration
+	wireGuardKey                   wgtypes.Key
+	wireGuardKeyTime               time.Time
+	wireGuardPeerIPs               map[string]bool
+	wireGuardMu                    sync.Mutex
 	peerMultihopTTL                uint8
+	peerMaxPrefixesThreshold       uint8
+	peerMaxPrefixesRestartTime     time.Duration
+	maxPrefixesDisabledPeers       map[string]*time.Timer
+	maxPrefixesMu                  sync.Mutex
 	MetricsEnabled                 bool
 	bgpServerStarted               bool
 	bgpHoldtime                    float64
@@ -130,17 +166,49 @@ type NetworkRoutingController struct {
 	localAddressList               []string
 	overrideNextHop                bool
 	podCidr                        string
+	podCIDRs                       []string
 	CNIFirewallSetup               *sync.Cond
 	ipsetMutex                     *sync.Mutex
 	routeSyncer                    *routeSyncer
-
-	nodeLister cache.Indexer
-	svcLister  cache.Indexer
-	epLister   cache.Indexer
-
-	NodeEventHandler      cache.ResourceEventHandler
-	ServiceEventHandler   cache.ResourceEventHandler
-	EndpointsEventHandler cache.ResourceEventHandler
+	ln                             netlinkCalls
+	bgpConfigSpec                  *v1alpha1.BGPConfigurationSpec
+	bgpPeers                       map[string]*gobgpapi.Peer
+	bgpPeerBFD                     map[string]*bfdPeerConfig
+	bgpPeersMu                     sync.Mutex
+	installExternalRoutes          bool
+	bgpPeerImports                 map[string]*bgpPeerImport
+	importedRoutes                 map[string]map[string]net.IP
+	bgpMaxPaths                    int
+	externalRoutesMetric           int
+	bgpPeerImportsMu               sync.Mutex
+	bgpPeersNodeCondition          bool
+	bgpPeersCondition              *v1core.NodeCondition
+	bgpPeerMetricsPeers            map[string]bool
+	bgpPeerStatusMu                sync.Mutex
+	bgpPeerNotificationsMu         sync.Mutex
+	enableBFD                      bool
+	bfdConfig                      bfdSessionConfig
+	bfdManager                     *bfdManager
+	bfdDisabledPeers               map[string]bool
+	bfdMu                          sync.Mutex
+	appliedPolicies                map[string]*gobgpapi.Policy
+	policyGeneration               uint
+	policyMu                       sync.Mutex
+
+	nodeLister      cache.Indexer
+	svcLister       cache.Indexer
+	epLister        cache.Indexer
+	bgpPeerLister   cache.Indexer
+	bgpConfigLister cache.Indexer
+	secretLister    cache.Indexer
+	secretNamespace string
+
+	NodeEventHandler             cache.ResourceEventHandler
+	ServiceEventHandler          cache.ResourceEventHandler
+	EndpointsEventHandler        cache.ResourceEventHandler
+	BGPPeerEventHandler          cache.ResourceEventHandler
+	BGPConfigurationEventHandler cache.ResourceEventHandler
+	SecretEventHandler           cache.ResourceEventHandler
 }
 
 // Run runs forever until we are notified on stop channel
@@ -171,22 +239,35 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 
 	nrc.CNIFirewallSetup.Broadcast()
 
-	// Handle ipip tunnel overlay
+	// Handle ipip tunnel or vxlan overlay
 	if nrc.enableOverlays {
-		klog.V(1).Info("IPIP Tunnel Overlay enabled in configuration.")
+		klog.V(1).Infof("Overlay enabled in configuration with %s encapsulation.", nrc.overlayEncap)
 		klog.V(1).Info("Setting up overlay networking.")
 		err = nrc.enablePolicyBasedRouting()
 		if err != nil {
 			klog.Errorf("Failed to enable required policy based routing: %s", err.Error())
 		}
 	} else {
-		klog.V(1).Info("IPIP Tunnel Overlay disabled in configuration.")
+		klog.V(1).Info("Overlay disabled in configuration.")
 		klog.V(1).Info("Cleaning up old overlay networking if needed.")
 		err = nrc.disablePolicyBasedRouting()
 		if err != nil {
 			klog.Errorf("Failed to disable policy based routing: %s", err.Error())
 		}
 	}
+	if !nrc.enableOverlays || nrc.overlayEncap != overlayEncapVXLAN {
+		deleteVXLANInterfaces()
+	}
+	if !nrc.enableOverlays || nrc.overlayEncap != overlayEncapIPIP || !nrc.overlaySingleTunnel {
+		deleteSingleTunnelInterfaces()
+	}
+	if nrc.enableOverlays && nrc.overlayEncap == overlayEncapWireGuard {
+		if err = nrc.initWireGuard(); err != nil {
+			klog.Errorf("Failed to set up the WireGuard overlay: %s", err)
+		}
+	} else {
+		deleteWireGuardInterface()
+	}
 
 	klog.V(1).Info("Performing cleanup of depreciated rules/ipsets (if needed).")
 	err = nrc.deleteBadPodEgressRules()
@@ -234,7 +315,7 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 	}
 
 	if nrc.autoMTU {
-		mtu, err := utils.GetMTUFromNodeIP(nrc.nodeIP)
+		mtu, err := nrc.getPodMTU()
 		if err != nil {
 			klog.Errorf("Failed to find MTU for node IP: %s for intelligently setting the kube-bridge MTU "+
 				"due to %s.", nrc.nodeIP, err.Error())
@@ -270,7 +351,7 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 		klog.Errorf("Failed to enable iptables for bridge. Network policies and service proxy may "+
 			"not work: %s", sysctlErr.Error())
 	}
-	if nrc.isIpv6 {
+	if nrc.isIpv6 || nrc.nodeSecondaryIP != nil {
 		sysctlErr = utils.SetSysctl(utils.BridgeNFCallIP6Tables, 1)
 		if sysctlErr != nil {
 			klog.Errorf("Failed to enable ip6tables for bridge. Network policies and service proxy may "+
@@ -306,6 +387,18 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 	}
 
 	nrc.bgpServerStarted = true
+	// the routes are learned again from the BGP server from now on, so the ones it doesn't have are stale, unless the
+	// peers still have to send them again after a graceful restart
+	var cleanupDelay time.Duration
+	if nrc.bgpGracefulRestart {
+		cleanupDelay = nrc.bgpGracefulRestartDeferralTime
+	}
+	nrc.routeSyncer.startCleanup(cleanupDelay)
+	if nrc.bfdManager != nil {
+		nrc.bfdManager.run(stopCh, wg)
+		// the sessions with the peers added while starting the BGP server are not left to the first periodic sync
+		nrc.syncBFDSessions()
+	}
 	if !nrc.bgpGracefulRestart {
 		defer func() {
 			err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
@@ -356,6 +449,11 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 			klog.Errorf("Error advertising route: %s", err.Error())
 		}
 
+		err = nrc.syncBGPPeers()
+		if err != nil {
+			klog.Errorf("Error syncing BGP peers from BGPPeer resources: %s", err.Error())
+		}
+
 		err = nrc.AddPolicies()
 		if err != nil {
 			klog.Errorf("Error adding BGP policies: %s", err.Error())
@@ -365,6 +463,17 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 			nrc.syncInternalPeers()
 		}
 
+		nrc.syncBFDSessions()
+
+		nrc.syncBGPPeerStatus()
+
+		if nrc.enableOverlays && nrc.overlayEncap == overlayEncapWireGuard {
+			if err := nrc.rotateWireGuardKey(); err != nil {
+				klog.Errorf("Failed to rotate the WireGuard key: %s", err)
+			}
+			nrc.syncWireGuardPeers()
+		}
+
 		if err == nil {
 			healthcheck.SendHeartBeat(healthChan, "NRC")
 		} else {
@@ -446,7 +555,7 @@ func (nrc *NetworkRoutingController) configureMTU(mtu int) error {
 }
 
 func (nrc *NetworkRoutingController) autoConfigureMTU() error {
-	mtu, err := utils.GetMTUFromNodeIP(nrc.nodeIP)
+	mtu, err := nrc.getPodMTU()
 	if err != nil {
 		return fmt.Errorf("failed to generate MTU: %s", err.Error())
 	}
@@ -455,9 +564,12 @@ func (nrc *NetworkRoutingController) autoConfigureMTU() error {
 
 func (nrc *NetworkRoutingController) watchBgpUpdates() {
 	pathWatch := func(r *gobgpapi.WatchEventResponse) {
-		if table := r.GetTable(); table != nil {
+		if table := r.GetTable(); table != nil && nrc.bgpMaxPaths > 1 {
+			nrc.injectMultipathRoutes(table.Paths)
+		} else if table != nil {
 			for _, path := range table.Paths {
-				if path.Family.Afi == gobgpapi.Family_AFI_IP || path.Family.Safi == gobgpapi.Family_SAFI_UNICAST {
+				if (path.Family.Afi == gobgpapi.Family_AFI_IP || path.Family.Afi == gobgpapi.Family_AFI_IP6) &&
+					path.Family.Safi == gobgpapi.Family_SAFI_UNICAST {
 					if nrc.MetricsEnabled {
 						metrics.ControllerBGPadvertisementsReceived.Inc()
 					}
@@ -487,18 +599,37 @@ func (nrc *NetworkRoutingController) watchBgpUpdates() {
 }
 
 func (nrc *NetworkRoutingController) advertisePodRoute() error {
+	for _, podCIDR := range nrc.podCIDRs {
+		if err := nrc.advertisePodCIDR(podCIDR); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// advertisePodCIDR advertises a single pod CIDR of the node to its peers. IPv4 pod CIDRs are advertised with a
+// regular next hop, IPv6 ones through MP-BGP with the node's IPv6 address as next hop.
+func (nrc *NetworkRoutingController) advertisePodCIDR(podCIDR string) error {
+	ip, ipNet, err := net.ParseCIDR(podCIDR)
+	if err != nil {
+		return fmt.Errorf("the pod CIDR %s is not a proper CIDR: %s", podCIDR, err)
+	}
+	subnet := ip.String()
+	cidrLen, _ := ipNet.Mask.Size()
+	isIpv6 := ip.To4() == nil
+
+	nextHop := nrc.getNodeIPForFamily(isIpv6)
+	if nextHop == nil {
+		klog.V(1).Infof("Not advertising pod CIDR %s as the node has no address of the same IP family", podCIDR)
+		return nil
+	}
+
 	if nrc.MetricsEnabled {
 		metrics.ControllerBGPadvertisementsSent.WithLabelValues("pod-route").Inc()
 	}
 
-	cidrStr := strings.Split(nrc.podCidr, "/")
-	subnet := cidrStr[0]
-	cidrLen, err := strconv.Atoi(cidrStr[1])
-	if err != nil || cidrLen < 0 || cidrLen > 32 {
-		return fmt.Errorf("the pod CIDR IP given is not a proper mask: %d", cidrLen)
-	}
-	if nrc.isIpv6 {
-		klog.V(2).Infof("Advertising route: '%s/%d via %s' to peers", subnet, cidrLen, nrc.nodeIP.String())
+	if isIpv6 {
+		klog.V(2).Infof("Advertising route: '%s/%d via %s' to peers", subnet, cidrLen, nextHop.String())
 
 		v6Family := &gobgpapi.Family{
 			Afi:  gobgpapi.Family_AFI_IP6,
@@ -506,14 +637,14 @@ func (nrc *NetworkRoutingController) advertisePodRoute() error {
 		}
 		nlri, _ := anypb.New(&gobgpapi.IPAddressPrefix{
 			PrefixLen: uint32(cidrLen),
-			Prefix:    cidrStr[0],
+			Prefix:    subnet,
 		})
 		a1, _ := anypb.New(&gobgpapi.OriginAttribute{
 			Origin: 0,
 		})
 		v6Attrs, _ := anypb.New(&gobgpapi.MpReachNLRIAttribute{
 			Family:   v6Family,
-			NextHops: []string{nrc.nodeIP.String()},
+			NextHops: []string{nextHop.String()},
 			Nlris:    []*anypb.Any{nlri},
 		})
 		_, err := nrc.bgpServer.AddPath(context.Background(), &gobgpapi.AddPathRequest{
@@ -528,17 +659,17 @@ func (nrc *NetworkRoutingController) advertisePodRoute() error {
 		}
 	} else {
 
-		klog.V(2).Infof("Advertising route: '%s/%d via %s' to peers", subnet, cidrLen, nrc.nodeIP.String())
+		klog.V(2).Infof("Advertising route: '%s/%d via %s' to peers", subnet, cidrLen, nextHop.String())
 		nlri, _ := anypb.New(&gobgpapi.IPAddressPrefix{
 			PrefixLen: uint32(cidrLen),
-			Prefix:    cidrStr[0],
+			Prefix:    subnet,
 		})
 
 		a1, _ := anypb.New(&gobgpapi.OriginAttribute{
 			Origin: 0,
 		})
 		a2, _ := anypb.New(&gobgpapi.NextHopAttribute{
-			NextHop: nrc.nodeIP.String(),
+			NextHop: nextHop.String(),
 		})
 		attrs := []*anypb.Any{a1, a2}
 
@@ -566,8 +697,23 @@ func (nrc *NetworkRoutingController) injectRoute(path *gobgpapi.Path) error {
 		return err
 	}
 
+	isIpv6 := nextHop.To4() == nil
+	if isIpv6 != (dst.IP.To4() == nil) {
+		return fmt.Errorf("route not injected for %s as its next hop %s is of a different IP family", dst, nextHop)
+	}
+
+	// routes learned from external peers are only installed when selected by the import filter of the peer
+	if nrc.isImportingPeer(path.NeighborIp) {
+		return nrc.injectImportedRoute(path, dst, nextHop)
+	}
+	if err = nrc.dropImportedRoute(dst); err != nil {
+		return err
+	}
+
+	nodeSubnet := nrc.getNodeSubnetForFamily(isIpv6)
+
 	tunnelName := generateTunnelName(nextHop.String())
-	sameSubnet := nrc.nodeSubnet.Contains(nextHop)
+	sameSubnet := nodeSubnet.Contains(nextHop)
 
 	// If we've made it this far, then it is likely that the node is holding a destination route for this path already.
 	// If the path we've received from GoBGP is a withdrawal, we should clean up any lingering routes that may exist
@@ -587,48 +733,65 @@ func (nrc *NetworkRoutingController) injectRoute(path *gobgpapi.Path) error {
 				nextHop.String())
 			// Also delete route from state map so that it doesn't get re-synced after deletion
 			nrc.routeSyncer.delInjectedRoute(dst)
-			nrc.cleanupTunnel(dst, tunnelName)
+			nrc.cleanupTunnel(dst, nextHop, tunnelName)
 			return nil
 		}
 
 		// Also delete route from state map so that it doesn't get re-synced after deletion
 		nrc.routeSyncer.delInjectedRoute(dst)
-		return deleteRoutesByDestination(dst)
+		return deleteRoutesByDestination(nrc.routeSyncer.ln, dst)
 	}
 
-	shouldCreateTunnel := func() bool {
-		if !nrc.enableOverlays {
-			return false
-		}
-		if nrc.overlayType == "full" {
-			return true
-		}
-		if nrc.overlayType == "subnet" && !sameSubnet {
-			return true
-		}
-		return false
-	}
-
-	// create IPIP tunnels only when node is not in same subnet or overlay-type is set to 'full'
+	// create IPIP tunnels or VXLAN entries only when node is not in same subnet or overlay-type is set to 'full'
 	// if the user has disabled overlays, don't create tunnels. If we're not creating a tunnel, check to see if there is
 	// any cleanup that needs to happen.
-	if shouldCreateTunnel() {
-		link, err = nrc.setupOverlayTunnel(tunnelName, nextHop)
+	if nrc.isOverlayRequired(sameSubnet) {
+		switch nrc.overlayEncap {
+		case overlayEncapVXLAN:
+			link, err = nrc.setupVXLANOverlay(tunnelName, nextHop)
+		case overlayEncapWireGuard:
+			link, err = nrc.setupWireGuardOverlay(tunnelName, nextHop)
+		case overlayEncapIPIP:
+			if nrc.overlaySingleTunnel {
+				link, err = nrc.setupSingleTunnel(tunnelName, nextHop)
+				break
+			}
+			link, err = nrc.setupOverlayTunnel(tunnelName, nextHop)
+		}
 		if err != nil {
 			return err
 		}
 	} else {
 		// knowing that a tunnel shouldn't exist for this route, check to see if there are any lingering tunnels /
 		// routes that need to be cleaned up.
-		nrc.cleanupTunnel(dst, tunnelName)
+		nrc.cleanupTunnel(dst, nextHop, tunnelName)
 	}
 
 	switch {
+	case link != nil && nrc.overlayEncap == overlayEncapVXLAN:
+		// VXLAN routes go through the next hop, which the neighbor entry on the VXLAN interface resolves to the node
+		route = &netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Src:       nrc.getNodeIPForFamily(isIpv6),
+			Dst:       dst,
+			Gw:        nextHop,
+			Flags:     int(netlink.FLAG_ONLINK),
+			Protocol:  zebraRouteOriginator,
+		}
+	case link != nil && nrc.overlayEncap == overlayEncapIPIP && nrc.overlaySingleTunnel:
+		// routes through the single tunnel device carry the destination of the tunnel
+		route = &netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Src:       nrc.getNodeIPForFamily(isIpv6),
+			Dst:       dst,
+			Encap:     &ipTunnelEncap{Dst: nextHop},
+			Protocol:  zebraRouteOriginator,
+		}
 	case link != nil:
-		// if we setup an overlay tunnel link, then use it for destination routing
+		// if we setup an overlay tunnel or WireGuard link, then use it for destination routing
 		route = &netlink.Route{
 			LinkIndex: link.Attrs().Index,
-			Src:       nrc.nodeIP,
+			Src:       nrc.getNodeIPForFamily(isIpv6),
 			Dst:       dst,
 			Protocol:  zebraRouteOriginator,
 		}
@@ -653,6 +816,53 @@ func (nrc *NetworkRoutingController) injectRoute(path *gobgpapi.Path) error {
 	return nil
 }
 
+// reinjectRoutes injects the routes to the destinations again from the best paths of the BGP server, which also sets
+// up their tunnels or overlay interfaces again
+func (nrc *NetworkRoutingController) reinjectRoutes(dsts []*net.IPNet) {
+	for _, dst := range dsts {
+		family := &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST}
+		if dst.IP.To4() == nil {
+			family.Afi = gobgpapi.Family_AFI_IP6
+		}
+		var paths []*gobgpapi.Path
+		err := nrc.bgpServer.ListPath(context.Background(), &gobgpapi.ListPathRequest{
+			TableType: gobgpapi.TableType_GLOBAL,
+			Family:    family,
+			Prefixes:  []*gobgpapi.TableLookupPrefix{{Prefix: dst.String()}},
+		}, func(destination *gobgpapi.Destination) {
+			for _, path := range destination.Paths {
+				if path.Best && path.NeighborIp != "<nil>" {
+					paths = append(paths, path)
+				}
+			}
+		})
+		if err != nil {
+			klog.Errorf("Failed to list the paths to %s: %s", dst, err)
+			continue
+		}
+		for _, path := range paths {
+			if err = nrc.injectRoute(path); err != nil {
+				klog.Errorf("Failed to inject routes due to: " + err.Error())
+			}
+		}
+	}
+}
+
+// isOverlayRequired returns whether the traffic to a next hop goes through the overlay, given whether it is in the
+// subnet of the node
+func (nrc *NetworkRoutingController) isOverlayRequired(sameSubnet bool) bool {
+	if !nrc.enableOverlays {
+		return false
+	}
+	if nrc.overlayType == "full" {
+		return true
+	}
+	if nrc.overlayType == "subnet" && !sameSubnet {
+		return true
+	}
+	return false
+}
+
 func (nrc *NetworkRoutingController) isPeerEstablished(peerIP string) (bool, error) {
 	var peerConnected bool
 	peerFunc := func(peer *gobgpapi.Peer) {
@@ -670,93 +880,167 @@ func (nrc *NetworkRoutingController) isPeerEstablished(peerIP string) (bool, err
 	return peerConnected, nil
 }
 
-// cleanupTunnel removes any traces of tunnels / routes that were setup by nrc.setupOverlayTunnel() and are no longer
-// needed. All errors are logged only, as we want to attempt to perform all cleanup actions regardless of their success
-func (nrc *NetworkRoutingController) cleanupTunnel(destinationSubnet *net.IPNet, tunnelName string) {
+// cleanupTunnel removes any traces of tunnels / routes that were setup by nrc.setupOverlayTunnel() or
+// nrc.setupVXLANOverlay() and are no longer needed. All errors are logged only, as we want to attempt to perform all
+// cleanup actions regardless of their success
+func (nrc *NetworkRoutingController) cleanupTunnel(destinationSubnet *net.IPNet, nextHop net.IP, tunnelName string) {
 	klog.V(1).Infof("Cleaning up old routes for %s if there are any", destinationSubnet.String())
-	if err := deleteRoutesByDestination(destinationSubnet); err != nil {
+	// the route is removed from the route state map first, so that its deletion is not taken for tampering
+	nrc.routeSyncer.delInjectedRoute(destinationSubnet)
+	if err := deleteRoutesByDestination(nrc.routeSyncer.ln, destinationSubnet); err != nil {
 		klog.Errorf("Failed to cleanup routes: %v", err)
 	}
 
 	klog.V(1).Infof("Cleaning up any lingering tunnel interfaces named: %s", tunnelName)
-	if link, err := netlink.LinkByName(tunnelName); err == nil {
-		if err = netlink.LinkDel(link); err != nil {
+	if link, err := nrc.ln.linkByName(tunnelName); err == nil {
+		nrc.routeSyncer.expectLinkDeletion(link.Attrs().Index)
+		if err = nrc.ln.linkDel(link); err != nil {
 			klog.Errorf("Failed to delete tunnel link for the node due to " + err.Error())
 		}
 	}
+
+	nrc.cleanupVXLANPeer(nextHop)
+	nrc.cleanupWireGuardPeer(nextHop)
+	nrc.cleanupSingleTunnelPeer(nextHop)
 }
 
-// setupOverlayTunnel attempts to create an tunnel link and corresponding routes for IPIP based overlay networks
+// setupOverlayTunnel attempts to create an tunnel link and corresponding routes for IPIP based overlay networks. IPv6
+// next hops get an ip6ip6 tunnel instead, as IPIP can only carry IPv4 traffic.
 func (nrc *NetworkRoutingController) setupOverlayTunnel(tunnelName string, nextHop net.IP) (netlink.Link, error) {
-	var out []byte
-	link, err := netlink.LinkByName(tunnelName)
-
-	// an error here indicates that the the tunnel didn't exist, so we need to create it, if it already exists there's
-	// nothing to do here
+	tunnel, err := nrc.newOverlayTunnel(tunnelName, nextHop)
 	if err != nil {
-		cmdArgs := []string{"tunnel", "add", tunnelName, "mode", "ipip", "local", nrc.nodeIP.String(), "remote",
-			nextHop.String()}
-		// need to skip binding device if nrc.nodeInterface is loopback, otherwise packets never leave
-		// from egress interface to the tunnel peer.
-		if nrc.nodeInterface != "lo" {
-			cmdArgs = append(cmdArgs, []string{"dev", nrc.nodeInterface}...)
-		}
-		out, err := exec.Command("ip", cmdArgs...).CombinedOutput()
-		if err != nil {
+		return nil, fmt.Errorf("route not injected for the route advertised by the node %s: %s", nextHop, err)
+	}
+
+	// an error here indicates that the the tunnel didn't exist, so we need to create it, if it already exists with the
+	// same endpoints there's nothing to do here
+	link, err := nrc.ln.linkByName(tunnelName)
+	switch {
+	case err != nil:
+		link = nil
+	case !isSameTunnel(link, tunnel):
+		klog.Infof("Recreating tunnel interface %s for the node %s as its endpoints changed", tunnelName, nextHop)
+		nrc.routeSyncer.expectLinkDeletion(link.Attrs().Index)
+		if err = nrc.ln.linkDel(link); err != nil {
 			return nil, fmt.Errorf("route not injected for the route advertised by the node %s "+
-				"Failed to create tunnel interface %s. error: %s, output: %s",
-				nextHop, tunnelName, err, string(out))
+				"Failed to delete tunnel interface %s. error: %s", nextHop, tunnelName, err)
 		}
+		link = nil
+	default:
+		klog.V(1).Infof("Tunnel interface: %s for the node %s already exists.", tunnelName, nextHop)
+	}
 
-		link, err = netlink.LinkByName(tunnelName)
+	if link == nil {
+		if err = nrc.ln.linkAdd(tunnel); err != nil {
+			return nil, fmt.Errorf("route not injected for the route advertised by the node %s "+
+				"Failed to create tunnel interface %s. error: %s", nextHop, tunnelName, err)
+		}
+		link, err = nrc.ln.linkByName(tunnelName)
 		if err != nil {
 			return nil, fmt.Errorf("route not injected for the route advertised by the node %s "+
 				"Failed to get tunnel interface by name error: %s", tunnelName, err)
 		}
-		if err = netlink.LinkSetUp(link); err != nil {
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		if err = nrc.ln.linkSetUp(link); err != nil {
 			return nil, errors.New("Failed to bring tunnel interface " + tunnelName + " up due to: " + err.Error())
 		}
-	} else {
-		klog.V(1).Infof(
-			"Tunnel interface: " + tunnelName + " for the node " + nextHop.String() + " already exists.")
 	}
 
 	// Now that the tunnel link exists, we need to add a route to it, so the node knows where to send traffic bound for
 	// this interface
-	out, err = exec.Command("ip", "route", "list", "table", customRouteTableID).CombinedOutput()
-	if err != nil || !strings.Contains(string(out), "dev "+tunnelName+" scope") {
-		//nolint:gosec // this exec should be safe from command injection given the parameter's context
-		if out, err = exec.Command("ip", "route", "add", nextHop.String(), "dev", tunnelName, "table",
-			customRouteTableID).CombinedOutput(); err != nil {
-			return nil, fmt.Errorf("failed to add route in custom route table, err: %s, output: %s", err, string(out))
-		}
+	if err = nrc.addCustomTableHostRoute(link, nextHop, nil); err != nil {
+		return nil, fmt.Errorf("failed to add route in custom route table, err: %s", err)
 	}
 
 	return link, nil
 }
 
-// Cleanup performs the cleanup of configurations done
-func (nrc *NetworkRoutingController) Cleanup() {
+// newOverlayTunnel returns the IPIP tunnel from the node to the next hop, or the ip6ip6 tunnel if the next hop is an
+// IPv6 address, with the same settings `ip tunnel add` uses by default
+func (nrc *NetworkRoutingController) newOverlayTunnel(tunnelName string, nextHop net.IP) (netlink.Link, error) {
+	isIpv6 := nextHop.To4() == nil
+	localIP := nrc.getNodeIPForFamily(isIpv6)
+	if localIP == nil {
+		return nil, errors.New("the node has no address of the same IP family to create a tunnel from")
+	}
+	nodeInterface := nrc.nodeInterface
+	if nrc.nodeSecondaryIP != nil && isIpv6 != nrc.isIpv6 {
+		nodeInterface = nrc.nodeSecondaryInterface
+	}
+
+	// need to skip binding device if the node interface is loopback, otherwise packets never leave
+	// from egress interface to the tunnel peer.
+	var nodeLinkIndex uint32
+	if nodeInterface != "lo" {
+		nodeLink, err := nrc.ln.linkByName(nodeInterface)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get node interface %s: %s", nodeInterface, err)
+		}
+		nodeLinkIndex = uint32(nodeLink.Attrs().Index)
+	}
+
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Name = tunnelName
+	if isIpv6 {
+		return &netlink.Ip6tnl{
+			LinkAttrs:  linkAttrs,
+			Link:       nodeLinkIndex,
+			Local:      localIP,
+			Remote:     nextHop,
+			Ttl:        ip6TunnelHopLimit,
+			EncapLimit: ip6TunnelEncapLimit,
+			Proto:      syscall.IPPROTO_IPV6,
+		}, nil
+	}
+	return &netlink.Iptun{
+		LinkAttrs: linkAttrs,
+		Link:      nodeLinkIndex,
+		Local:     localIP.To4(),
+		Remote:    nextHop.To4(),
+		PMtuDisc:  1,
+		Proto:     syscall.IPPROTO_IPIP,
+	}, nil
+}
+
+// isSameTunnel returns whether the existing link is a tunnel of the same type and endpoints as the desired one
+func isSameTunnel(existing, desired netlink.Link) bool {
+	switch desiredTunnel := desired.(type) {
+	case *netlink.Iptun:
+		existingTunnel, ok := existing.(*netlink.Iptun)
+		return ok && existingTunnel.Local.Equal(desiredTunnel.Local) &&
+			existingTunnel.Remote.Equal(desiredTunnel.Remote)
+	case *netlink.Ip6tnl:
+		existingTunnel, ok := existing.(*netlink.Ip6tnl)
+		return ok && existingTunnel.Local.Equal(desiredTunnel.Local) &&
+			existingTunnel.Remote.Equal(desiredTunnel.Remote)
+	}
+	return false
+}
+
+// Cleanup performs the cleanup of configurations done: the iptables rules and ipsets, the injected routes, the policy
+// based routing rules and table, the overlay and kube-bridge interfaces and the CNI conf file. In dry run mode they
+// are only logged.
+func (nrc *NetworkRoutingController) Cleanup(dryRun bool) {
 	klog.Infof("Cleaning up NetworkRoutesController configurations")
 
-	// Pod egress cleanup
-	err := nrc.deletePodEgressRule()
-	if err != nil {
-		// Changed to level 1 logging as errors occur when ipsets have already been cleaned and needlessly worries users
-		klog.V(1).Infof("Error deleting Pod egress iptables rule: %v", err)
+	// Cleanup() is also called on controllers that aren't fully instantiated
+	if nrc.ln == nil {
+		nrc.ln = &linuxNetworking{}
 	}
-
-	err = nrc.deleteBadPodEgressRules()
-	if err != nil {
-		// Changed to level 1 logging as errors occur when ipsets have already been cleaned and needlessly worries users
-		klog.V(1).Infof("Error deleting Pod egress iptables rule: %s", err.Error())
+	if nrc.cniConfFile == "" {
+		nrc.cniConfFile = getCNIConfFile()
 	}
 
+	nrc.cleanupIptables(dryRun)
+
 	// For some reason, if we go too fast into the ipset logic below it causes the system to think that the above
 	// iptables rules are still referencing the ipsets below, and we get errors
-	time.Sleep(1 * time.Second)
+	if !dryRun {
+		time.Sleep(1 * time.Second)
+	}
 
-	// delete all ipsets created by kube-router
+	// delete all ipsets created by the routing controller
 	// There are certain actions like Cleanup() actions that aren't working with full instantiations of the controller
 	// and in these instances the mutex may not be present and may not need to be present as they are operating out of a
 	// single goroutine where there is no need for locking
@@ -769,20 +1053,14 @@ func (nrc *NetworkRoutingController) Cleanup() {
 			klog.V(1).Infof("Returned ipset mutex lock")
 		}()
 	}
-	ipset, err := utils.NewIPSet(nrc.isIpv6)
-	if err != nil {
-		klog.Errorf("Failed to clean up ipsets: " + err.Error())
+	cleanupIPSets(dryRun)
+
+	nrc.cleanupNetworking(dryRun)
+
+	if dryRun {
+		klog.Infof("Dry run: listed the NetworkRoutesController configuration done by kube-router")
 		return
 	}
-	err = ipset.Save()
-	if err != nil {
-		klog.Errorf("Failed to clean up ipsets: " + err.Error())
-	}
-	err = ipset.DestroyAllWithin()
-	if err != nil {
-		klog.Warningf("Error deleting ipset: %s", err.Error())
-	}
-
 	klog.Infof("Successfully cleaned the NetworkRoutesController configuration done by kube-router")
 }
 
@@ -804,38 +1082,59 @@ func (nrc *NetworkRoutingController) syncNodeIPSets() error {
 
 	nodes := nrc.nodeLister.List()
 
-	// Collect active PodCIDR(s) and NodeIPs from nodes
-	currentPodCidrs := make([]string, 0)
-	currentNodeIPs := make([]string, 0)
+	// Collect active PodCIDR(s) and NodeIPs from nodes, grouped by IP family (keyed on whether they are IPv6)
+	currentPodCidrs := make(map[bool][]string)
+	currentNodeIPs := make(map[bool][]string)
 	for _, obj := range nodes {
 		node := obj.(*v1core.Node)
-		podCIDR := node.GetAnnotations()["kube-router.io/pod-cidr"]
-		if podCIDR == "" {
-			podCIDR = node.Spec.PodCIDR
-		}
-		if podCIDR == "" {
-			klog.Warningf("Couldn't determine PodCIDR of the %v node", node.Name)
+		podCIDRs, err := utils.GetPodCIDRsFromNodeSpec(node)
+		if err != nil {
+			klog.Warningf("Couldn't determine PodCIDR of the %v node: %v", node.Name, err)
 			continue
 		}
-		currentPodCidrs = append(currentPodCidrs, podCIDR)
-		nodeIP, err := utils.GetNodeIP(node)
+		for _, podCIDR := range podCIDRs {
+			ip, _, err := net.ParseCIDR(podCIDR)
+			if err != nil {
+				continue
+			}
+			currentPodCidrs[ip.To4() == nil] = append(currentPodCidrs[ip.To4() == nil], podCIDR)
+		}
+		for _, isIpv6 := range nrc.ipFamilies() {
+			nodeIP, err := utils.GetNodeIPByFamily(node, isIpv6)
+			if err != nil {
+				klog.Errorf("Failed to find a node IP, cannot add to node ipset which could affect routing: %v", err)
+				continue
+			}
+			currentNodeIPs[isIpv6] = append(currentNodeIPs[isIpv6], nodeIP.String())
+		}
+	}
+
+	for _, isIpv6 := range nrc.ipFamilies() {
+		err = syncNodeIPSetsForFamily(nrc.getIPSetHandlerForFamily(isIpv6), currentPodCidrs[isIpv6],
+			currentNodeIPs[isIpv6])
 		if err != nil {
-			klog.Errorf("Failed to find a node IP, cannot add to node ipset which could affect routing: %v", err)
-			continue
+			return err
 		}
-		currentNodeIPs = append(currentNodeIPs, nodeIP.String())
 	}
 
+	return nil
+}
+
+// syncNodeIPSetsForFamily refreshes the pod subnets and node addresses ipsets of the given handler, which only holds
+// sets of a single IP family
+func syncNodeIPSetsForFamily(ipSetHandler *utils.IPSet, currentPodCidrs, currentNodeIPs []string) error {
+	var err error
+
 	// Syncing Pod subnet ipset entries
-	psSet := nrc.ipSetHandler.Get(podSubnetsIPSetName)
+	psSet := ipSetHandler.Get(podSubnetsIPSetName)
 	if psSet == nil {
 		klog.Infof("Creating missing ipset \"%s\"", podSubnetsIPSetName)
-		_, err = nrc.ipSetHandler.Create(podSubnetsIPSetName, utils.OptionTimeout, "0")
+		_, err = ipSetHandler.Create(podSubnetsIPSetName, utils.TypeHashNet, utils.OptionTimeout, "0")
 		if err != nil {
 			return fmt.Errorf("ipset \"%s\" not found in controller instance",
 				podSubnetsIPSetName)
 		}
-		psSet = nrc.ipSetHandler.Get(podSubnetsIPSetName)
+		psSet = ipSetHandler.Get(podSubnetsIPSetName)
 		if nil == psSet {
 			return fmt.Errorf("failed to get ipsethandler for ipset \"%s\"", podSubnetsIPSetName)
 		}
@@ -846,15 +1145,15 @@ func (nrc *NetworkRoutingController) syncNodeIPSets() error {
 	}
 
 	// Syncing Node Addresses ipset entries
-	naSet := nrc.ipSetHandler.Get(nodeAddrsIPSetName)
+	naSet := ipSetHandler.Get(nodeAddrsIPSetName)
 	if naSet == nil {
 		klog.Infof("Creating missing ipset \"%s\"", nodeAddrsIPSetName)
-		_, err = nrc.ipSetHandler.Create(nodeAddrsIPSetName, utils.OptionTimeout, "0")
+		_, err = ipSetHandler.Create(nodeAddrsIPSetName, utils.TypeHashIP, utils.OptionTimeout, "0")
 		if err != nil {
 			return fmt.Errorf("ipset \"%s\" not found in controller instance",
 				nodeAddrsIPSetName)
 		}
-		naSet = nrc.ipSetHandler.Get(nodeAddrsIPSetName)
+		naSet = ipSetHandler.Get(nodeAddrsIPSetName)
 		if nil == naSet {
 			return fmt.Errorf("failed to get ipsethandler for ipset \"%s\"", nodeAddrsIPSetName)
 		}
@@ -867,8 +1166,45 @@ func (nrc *NetworkRoutingController) syncNodeIPSets() error {
 	return nil
 }
 
+// ipFamilies returns the IP families the node routes pod traffic for, as whether they are IPv6, starting with the
+// family of the node IP. Dual-stack nodes return both families.
+func (nrc *NetworkRoutingController) ipFamilies() []bool {
+	if nrc.nodeSecondaryIP != nil {
+		return []bool{nrc.isIpv6, !nrc.isIpv6}
+	}
+	return []bool{nrc.isIpv6}
+}
+
+// getNodeIPForFamily returns the address of the node of the given IP family, or nil when the node has none
+func (nrc *NetworkRoutingController) getNodeIPForFamily(isIpv6 bool) net.IP {
+	if nrc.isIpv6 == isIpv6 {
+		return nrc.nodeIP
+	}
+	return nrc.nodeSecondaryIP
+}
+
+// getNodeSubnetForFamily returns the subnet of the node address of the given IP family
+func (nrc *NetworkRoutingController) getNodeSubnetForFamily(isIpv6 bool) net.IPNet {
+	if nrc.isIpv6 == isIpv6 {
+		return nrc.nodeSubnet
+	}
+	return nrc.nodeSecondarySubnet
+}
+
+// getIPSetHandlerForFamily returns the ipset handler managing the sets of the given IP family
+func (nrc *NetworkRoutingController) getIPSetHandlerForFamily(isIpv6 bool) *utils.IPSet {
+	if nrc.isIpv6 == isIpv6 {
+		return nrc.ipSetHandler
+	}
+	return nrc.secondaryIPSetHandler
+}
+
 func (nrc *NetworkRoutingController) newIptablesCmdHandler() (*iptables.IPTables, error) {
-	if nrc.isIpv6 {
+	return newIptablesCmdHandlerForFamily(nrc.isIpv6)
+}
+
+func newIptablesCmdHandlerForFamily(isIpv6 bool) (*iptables.IPTables, error) {
+	if isIpv6 {
 		return iptables.NewWithProtocol(iptables.ProtocolIPv6)
 	}
 	return iptables.NewWithProtocol(iptables.ProtocolIPv4)
@@ -878,51 +1214,51 @@ func (nrc *NetworkRoutingController) newIptablesCmdHandler() (*iptables.IPTables
 // this rules will be appended so that any iptables rules for network policies will take
 // precedence
 func (nrc *NetworkRoutingController) enableForwarding() error {
-
-	iptablesCmdHandler, _ := nrc.newIptablesCmdHandler()
-
-	comment := "allow outbound traffic from pods"
-	args := []string{"-m", "comment", "--comment", comment, "-i", "kube-bridge", "-j", "ACCEPT"}
-	exists, err := iptablesCmdHandler.Exists("filter", "FORWARD", args...)
-	if err != nil {
-		return fmt.Errorf("failed to run iptables command: %s", err.Error())
-	}
-	if !exists {
-		err := iptablesCmdHandler.Insert("filter", "FORWARD", 1, args...)
-		if err != nil {
-			return fmt.Errorf("failed to run iptables command: %s", err.Error())
+	for _, isIpv6 := range nrc.ipFamilies() {
+		nodeInterface := nrc.nodeInterface
+		if isIpv6 != nrc.isIpv6 {
+			nodeInterface = nrc.nodeSecondaryInterface
 		}
-	}
-
-	comment = "allow inbound traffic to pods"
-	args = []string{"-m", "comment", "--comment", comment, "-o", "kube-bridge", "-j", "ACCEPT"}
-	exists, err = iptablesCmdHandler.Exists("filter", "FORWARD", args...)
-	if err != nil {
-		return fmt.Errorf("failed to run iptables command: %s", err.Error())
-	}
-	if !exists {
-		err = iptablesCmdHandler.Insert("filter", "FORWARD", 1, args...)
-		if err != nil {
-			return fmt.Errorf("failed to run iptables command: %s", err.Error())
+		if err := enableForwardingForFamily(isIpv6, nodeInterface); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	comment = "allow outbound node port traffic on node interface with which node ip is associated"
-	args = []string{"-m", "comment", "--comment", comment, "-o", nrc.nodeInterface, "-j", "ACCEPT"}
-	exists, err = iptablesCmdHandler.Exists("filter", "FORWARD", args...)
+func enableForwardingForFamily(isIpv6 bool, nodeInterface string) error {
+	iptablesCmdHandler, err := newIptablesCmdHandlerForFamily(isIpv6)
 	if err != nil {
-		return fmt.Errorf("failed to run iptables command: %s", err.Error())
+		return fmt.Errorf("failed to create iptables handler: %s", err.Error())
 	}
-	if !exists {
-		err = iptablesCmdHandler.Insert("filter", "FORWARD", 1, args...)
+
+	for _, args := range forwardingRules(nodeInterface) {
+		exists, err := iptablesCmdHandler.Exists("filter", "FORWARD", args...)
 		if err != nil {
 			return fmt.Errorf("failed to run iptables command: %s", err.Error())
 		}
+		if !exists {
+			err = iptablesCmdHandler.Insert("filter", "FORWARD", 1, args...)
+			if err != nil {
+				return fmt.Errorf("failed to run iptables command: %s", err.Error())
+			}
+		}
 	}
 
 	return nil
 }
 
+// forwardingRules returns the FORWARD chain rules permitting the traffic from and to the pods and the outbound node
+// port traffic on the node interface
+func forwardingRules(nodeInterface string) [][]string {
+	return [][]string{
+		{"-m", "comment", "--comment", "allow outbound traffic from pods", "-i", "kube-bridge", "-j", "ACCEPT"},
+		{"-m", "comment", "--comment", "allow inbound traffic to pods", "-o", "kube-bridge", "-j", "ACCEPT"},
+		{"-m", "comment", "--comment", "allow outbound node port traffic on node interface with which node ip is " +
+			"associated", "-o", nodeInterface, "-j", "ACCEPT"},
+	}
+}
+
 func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 	var nodeAsnNumber uint32
 	node, err := utils.GetNodeObject(nrc.clientset, nrc.hostnameOverride)
@@ -969,71 +1305,26 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 		nrc.bgpRRClient = true
 	}
 
-	if prependASN, okASN := node.ObjectMeta.Annotations[pathPrependASNAnnotation]; okASN {
-		prependRepeatN, okRepeatN := node.ObjectMeta.Annotations[pathPrependRepeatNAnnotation]
-
-		if !okRepeatN {
-			return fmt.Errorf("both %s and %s must be set", pathPrependASNAnnotation, pathPrependRepeatNAnnotation)
-		}
-
-		_, err := strconv.ParseUint(prependASN, 0, asnMaxBitSize)
-		if err != nil {
-			return errors.New("failed to parse ASN number specified to prepend")
-		}
-
-		repeatN, err := strconv.ParseUint(prependRepeatN, 0, prependPathMaxBits)
-		if err != nil {
-			return errors.New("failed to parse number of times ASN should be repeated")
-		}
-
-		nrc.pathPrepend = true
-		nrc.pathPrependAS = prependASN
-		nrc.pathPrependCount = uint8(repeatN)
+	err = nrc.setPathPrependFromNode(node)
+	if err != nil {
+		return err
 	}
 
-	var nodeCommunities []string
-	nodeBGPCommunitiesAnnotation, ok := node.ObjectMeta.Annotations[nodeCommunitiesAnnotation]
-	if !ok {
-		klog.V(1).Info("Did not find any BGP communities on current node's annotations. " +
-			"Not exporting communities.")
-	} else {
-		nodeCommunities = stringToSlice(nodeBGPCommunitiesAnnotation, ",")
-		for _, nodeCommunity := range nodeCommunities {
-			if err = validateCommunity(nodeCommunity); err != nil {
-				klog.Warningf("cannot add BGP community '%s' from node annotation as it does not appear "+
-					"to be a valid community identifier", nodeCommunity)
-				continue
-			}
-			klog.V(1).Infof("Adding the node community found from node annotation: %s", nodeCommunity)
-			nrc.nodeCommunities = append(nrc.nodeCommunities, nodeCommunity)
-		}
-		if len(nrc.nodeCommunities) < 1 {
-			klog.Warningf("Found a community specified via annotation %s with value %s but none could be "+
-				"validated", nodeCommunitiesAnnotation, nodeBGPCommunitiesAnnotation)
-		}
-	}
+	nrc.setCommunitiesFromNode(node)
 
-	// Get Custom Import Reject CIDRs from annotations
-	nodeBGPCustomImportRejectAnnotation, ok := node.ObjectMeta.Annotations[nodeCustomImportRejectAnnotation]
-	if !ok {
-		klog.V(1).Info("Did not find any node.bgp.customimportreject on current node's annotations. " +
-			"Skip configuring it.")
-	} else {
-		ipNetStrings := stringToSlice(nodeBGPCustomImportRejectAnnotation, ",")
-		ipNets, err := stringSliceToIPNets(ipNetStrings)
-		if err != nil {
-			klog.Warningf("Failed to parse node.bgp.customimportreject specified for the node, skip configuring it")
-		} else {
-			nrc.nodeCustomImportRejectIPNets = ipNets
-		}
-	}
+	nrc.setCustomImportRejectFromNode(node)
 
+	serverOptions := []gobgp.ServerOption{gobgp.LoggerOption(newBGPServerLogger(nrc.OnPrefixLimit,
+		nrc.OnBGPNotification))}
 	if grpcServer {
-		nrc.bgpServer = gobgp.NewBgpServer(
-			gobgp.GrpcListenAddress(nrc.nodeIP.String() + ":50051" + "," + "127.0.0.1:50051"))
-	} else {
-		nrc.bgpServer = gobgp.NewBgpServer()
+		if nrc.bgpAPISocket != "" {
+			if err := prepareBGPAPISocket(nrc.bgpAPISocket); err != nil {
+				return err
+			}
+		}
+		serverOptions = append(serverOptions, nrc.bgpAPIOptions...)
 	}
+	nrc.bgpServer = gobgp.NewBgpServer(serverOptions...)
 	go nrc.bgpServer.Serve()
 
 	var localAddressList []string
@@ -1051,6 +1342,8 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 		RouterId:        nrc.routerID,
 		ListenAddresses: localAddressList,
 		ListenPort:      int32(nrc.bgpPort),
+		// GoBGP then sends all the paths of a prefix that are as good as its best path to watchBgpUpdates
+		UseMultiplePaths: nrc.bgpMaxPaths > 1,
 	}
 
 	if err := nrc.bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{Global: global}); err != nil {
@@ -1058,140 +1351,228 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 	}
 
 	go nrc.watchBgpUpdates()
+	if nrc.MetricsEnabled || nrc.bgpPeersNodeCondition {
+		nrc.watchBGPPeerState()
+	}
 
 	// If the global routing peer is configured then peer with it
 	// else attempt to get peers from node specific BGP annotations.
 	if len(nrc.globalPeerRouters) == 0 {
-		// Get Global Peer Router ASN configs
-		nodeBgpPeerAsnsAnnotation, ok := node.ObjectMeta.Annotations[peerASNAnnotation]
-		if !ok {
-			klog.Infof("Could not find BGP peer info for the node in the node annotations so " +
-				"skipping configuring peer.")
-			return nil
-		}
-
-		asnStrings := stringToSlice(nodeBgpPeerAsnsAnnotation, ",")
-		peerASNs, err := stringSliceToUInt32(asnStrings)
+		nrc.globalPeerRouters, nrc.nodePeerRouters, err = nrc.newNodePeersFromAnnotations(node)
 		if err != nil {
 			err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
 			if err2 != nil {
 				klog.Errorf("Failed to stop bgpServer: %s", err2)
 			}
-			return fmt.Errorf("failed to parse node's Peer ASN Numbers Annotation: %s", err)
-		}
 
-		// Get Global Peer Router IP Address configs
-		nodeBgpPeersAnnotation, ok := node.ObjectMeta.Annotations[peerIPAnnotation]
-		if !ok {
-			klog.Infof("Could not find BGP peer info for the node in the node annotations " +
-				"so skipping configuring peer.")
-			return nil
+			return err
 		}
-		ipStrings := stringToSlice(nodeBgpPeersAnnotation, ",")
-		peerIPs, err := stringSliceToIPs(ipStrings)
+	}
+
+	if len(nrc.globalPeerRouters) != 0 {
+		err := nrc.connectToExternalBGPPeers(nrc.bgpServer, nrc.globalPeerRouters, nrc.bgpGracefulRestart,
+			nrc.bgpGracefulRestartDeferralTime, nrc.bgpGracefulRestartTime, nrc.peerMultihopTTL)
 		if err != nil {
 			err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
 			if err2 != nil {
 				klog.Errorf("Failed to stop bgpServer: %s", err2)
 			}
 
-			return fmt.Errorf("failed to parse node's Peer Addresses Annotation: %s", err)
+			return fmt.Errorf("failed to peer with Global Peer Router(s): %s",
+				err)
 		}
+	} else {
+		klog.Infof("No Global Peer Routers configured. Peering skipped.")
+	}
 
-		// Get Global Peer Router ASN configs
-		nodeBgpPeerPortsAnnotation, ok := node.ObjectMeta.Annotations[peerPortAnnotation]
-		// Default to default BGP port if port annotation is not found
-		var peerPorts = make([]uint32, 0)
-		if ok {
-			portStrings := stringToSlice(nodeBgpPeerPortsAnnotation, ",")
-			peerPorts, err = stringSliceToUInt32(portStrings)
-			if err != nil {
-				err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
-				if err2 != nil {
-					klog.Errorf("Failed to stop bgpServer: %s", err2)
-				}
-				return fmt.Errorf("failed to parse node's Peer Port Numbers Annotation: %s", err)
-			}
-		}
+	return nil
+}
 
-		// Get Global Peer Router Password configs
-		var peerPasswords []string
-		nodeBGPPasswordsAnnotation, ok := node.ObjectMeta.Annotations[peerPasswordAnnotation]
-		if !ok {
-			klog.Infof("Could not find BGP peer password info in the node's annotations. Assuming no passwords.")
-		} else {
-			passStrings := stringToSlice(nodeBGPPasswordsAnnotation, ",")
-			peerPasswords, err = stringSliceB64Decode(passStrings)
-			if err != nil {
-				err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
-				if err2 != nil {
-					klog.Errorf("Failed to stop bgpServer: %s", err2)
-				}
-				return fmt.Errorf("failed to parse node's Peer Passwords Annotation")
-			}
-		}
+// setPathPrependFromNode sets the AS path prepending of the advertised routes from the node annotations
+func (nrc *NetworkRoutingController) setPathPrependFromNode(node *v1core.Node) error {
+	prependASN, okASN := node.ObjectMeta.Annotations[pathPrependASNAnnotation]
+	if !okASN {
+		nrc.pathPrepend = false
+		return nil
+	}
+	prependRepeatN, okRepeatN := node.ObjectMeta.Annotations[pathPrependRepeatNAnnotation]
 
-		// Get Global Peer Router LocalIP configs
-		var peerLocalIPs []string
-		nodeBGPPeerLocalIPs, ok := node.ObjectMeta.Annotations[peerLocalIPAnnotation]
-		if !ok {
-			klog.Infof("Could not find BGP peer local ip info in the node's annotations. Assuming node IP.")
-		} else {
-			peerLocalIPs = stringToSlice(nodeBGPPeerLocalIPs, ",")
-			err = func() error {
-				for _, s := range peerLocalIPs {
-					if s != "" {
-						ip := net.ParseIP(s)
-						if ip == nil {
-							return fmt.Errorf("could not parse \"%s\" as an IP", s)
-						}
-					}
-				}
+	if !okRepeatN {
+		return fmt.Errorf("both %s and %s must be set", pathPrependASNAnnotation, pathPrependRepeatNAnnotation)
+	}
 
-				return nil
-			}()
-			if err != nil {
-				err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
-				if err2 != nil {
-					klog.Errorf("Failed to stop bgpServer: %s", err2)
-				}
+	_, err := strconv.ParseUint(prependASN, 0, asnMaxBitSize)
+	if err != nil {
+		return errors.New("failed to parse ASN number specified to prepend")
+	}
 
-				return fmt.Errorf("failed to parse node's Peer Local Addresses Annotation: %s", err)
-			}
+	repeatN, err := strconv.ParseUint(prependRepeatN, 0, prependPathMaxBits)
+	if err != nil {
+		return errors.New("failed to parse number of times ASN should be repeated")
+	}
+
+	nrc.pathPrepend = true
+	nrc.pathPrependAS = prependASN
+	nrc.pathPrependCount = uint8(repeatN)
+	return nil
+}
+
+// setCommunitiesFromNode sets the BGP communities, standard and large, added to the routes advertised to external
+// peers from the node annotations, the communities that can't be validated are skipped
+func (nrc *NetworkRoutingController) setCommunitiesFromNode(node *v1core.Node) {
+	nrc.nodeCommunities = nil
+	nrc.nodeLargeCommunities = nil
+	nodeBGPCommunitiesAnnotation, ok := node.ObjectMeta.Annotations[nodeCommunitiesAnnotation]
+	if !ok {
+		klog.V(1).Info("Did not find any BGP communities on current node's annotations. " +
+			"Not exporting communities.")
+		return
+	}
+	nodeCommunities := stringToSlice(nodeBGPCommunitiesAnnotation, ",")
+	for _, nodeCommunity := range nodeCommunities {
+		if err := validateLargeCommunity(nodeCommunity); err == nil {
+			klog.V(1).Infof("Adding the node large community found from node annotation: %s", nodeCommunity)
+			nrc.nodeLargeCommunities = append(nrc.nodeLargeCommunities, nodeCommunity)
+			continue
+		}
+		if err := validateCommunity(nodeCommunity); err != nil {
+			klog.Warningf("cannot add BGP community '%s' from node annotation as it does not appear "+
+				"to be a valid community or large community identifier", nodeCommunity)
+			continue
 		}
+		klog.V(1).Infof("Adding the node community found from node annotation: %s", nodeCommunity)
+		nrc.nodeCommunities = append(nrc.nodeCommunities, nodeCommunity)
+	}
+	if len(nrc.nodeCommunities) < 1 && len(nrc.nodeLargeCommunities) < 1 {
+		klog.Warningf("Found a community specified via annotation %s with value %s but none could be "+
+			"validated", nodeCommunitiesAnnotation, nodeBGPCommunitiesAnnotation)
+	}
+}
 
-		// Create and set Global Peer Router complete configs
-		nrc.globalPeerRouters, err = newGlobalPeers(peerIPs, peerPorts, peerASNs, peerPasswords, peerLocalIPs,
-			nrc.bgpHoldtime, nrc.nodeIP.String())
-		if err != nil {
-			err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
-			if err2 != nil {
-				klog.Errorf("Failed to stop bgpServer: %s", err2)
-			}
+// setCustomImportRejectFromNode sets the CIDRs rejected on import from the node annotations
+func (nrc *NetworkRoutingController) setCustomImportRejectFromNode(node *v1core.Node) {
+	nodeBGPCustomImportRejectAnnotation, ok := node.ObjectMeta.Annotations[nodeCustomImportRejectAnnotation]
+	if !ok {
+		klog.V(1).Info("Did not find any node.bgp.customimportreject on current node's annotations. " +
+			"Skip configuring it.")
+		nrc.nodeCustomImportRejectIPNets = nil
+		return
+	}
+	ipNetStrings := stringToSlice(nodeBGPCustomImportRejectAnnotation, ",")
+	ipNets, err := stringSliceToIPNets(ipNetStrings)
+	if err != nil {
+		klog.Warningf("Failed to parse node.bgp.customimportreject specified for the node, skip configuring it")
+		return
+	}
+	nrc.nodeCustomImportRejectIPNets = ipNets
+}
 
-			return fmt.Errorf("failed to process Global Peer Router configs: %s", err)
+// newNodePeersFromAnnotations returns the configs of the node specific external BGP peers along with their addresses,
+// nil is returned when the node annotations don't configure any peer
+func (nrc *NetworkRoutingController) newNodePeersFromAnnotations(node *v1core.Node) ([]*gobgpapi.Peer, []string,
+	error) {
+	// Get Global Peer Router ASN configs
+	nodeBgpPeerAsnsAnnotation, ok := node.ObjectMeta.Annotations[peerASNAnnotation]
+	if !ok {
+		klog.Infof("Could not find BGP peer info for the node in the node annotations so " +
+			"skipping configuring peer.")
+		return nil, nil, nil
+	}
+
+	asnStrings := stringToSlice(nodeBgpPeerAsnsAnnotation, ",")
+	peerASNs, err := stringSliceToUInt32(asnStrings)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse node's Peer ASN Numbers Annotation: %s", err)
+	}
+
+	// Get Global Peer Router IP Address configs
+	nodeBgpPeersAnnotation, ok := node.ObjectMeta.Annotations[peerIPAnnotation]
+	if !ok {
+		klog.Infof("Could not find BGP peer info for the node in the node annotations " +
+			"so skipping configuring peer.")
+		return nil, nil, nil
+	}
+	ipStrings := stringToSlice(nodeBgpPeersAnnotation, ",")
+	peerIPs, err := stringSliceToIPs(ipStrings)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse node's Peer Addresses Annotation: %s", err)
+	}
+
+	// Get Global Peer Router ASN configs
+	nodeBgpPeerPortsAnnotation, ok := node.ObjectMeta.Annotations[peerPortAnnotation]
+	// Default to default BGP port if port annotation is not found
+	var peerPorts = make([]uint32, 0)
+	if ok {
+		portStrings := stringToSlice(nodeBgpPeerPortsAnnotation, ",")
+		peerPorts, err = stringSliceToUInt32(portStrings)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse node's Peer Port Numbers Annotation: %s", err)
 		}
+	}
 
-		nrc.nodePeerRouters = ipStrings
+	// Get Global Peer Router Password configs
+	var peerPasswords []string
+	nodeBGPPasswordsAnnotation, ok := node.ObjectMeta.Annotations[peerPasswordAnnotation]
+	if !ok {
+		klog.Infof("Could not find BGP peer password info in the node's annotations. Assuming no passwords.")
+	} else {
+		passStrings := stringToSlice(nodeBGPPasswordsAnnotation, ",")
+		peerPasswords, err = stringSliceB64Decode(passStrings)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse node's Peer Passwords Annotation")
+		}
 	}
 
-	if len(nrc.globalPeerRouters) != 0 {
-		err := nrc.connectToExternalBGPPeers(nrc.bgpServer, nrc.globalPeerRouters, nrc.bgpGracefulRestart,
-			nrc.bgpGracefulRestartDeferralTime, nrc.bgpGracefulRestartTime, nrc.peerMultihopTTL)
+	// Get Global Peer Router Max Prefixes configs
+	var peerMaxPrefixes []uint32
+	nodeBGPPeerMaxPrefixesAnnotation, ok := node.ObjectMeta.Annotations[peerMaxPrefixesAnnotation]
+	if ok {
+		peerMaxPrefixes, err = stringSliceToUInt32(stringToSlice(nodeBGPPeerMaxPrefixesAnnotation, ","))
 		if err != nil {
-			err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
-			if err2 != nil {
-				klog.Errorf("Failed to stop bgpServer: %s", err2)
-			}
+			return nil, nil, fmt.Errorf("failed to parse node's Peer Max Prefixes Annotation: %s", err)
+		}
+	}
 
-			return fmt.Errorf("failed to peer with Global Peer Router(s): %s",
-				err)
+	// Get Global Peer Router Password Secret configs
+	var peerPasswordSecrets []*v1alpha1.SecretKeyReference
+	nodeBGPPasswordSecretsAnnotation, ok := node.ObjectMeta.Annotations[peerPasswordSecretAnnotation]
+	if ok {
+		peerPasswordSecrets, err = parseSecretKeyReferences(stringToSlice(nodeBGPPasswordSecretsAnnotation, ","))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse node's Peer Password Secrets Annotation: %s", err)
 		}
+	}
+
+	// Get Global Peer Router LocalIP configs
+	var peerLocalIPs []string
+	nodeBGPPeerLocalIPs, ok := node.ObjectMeta.Annotations[peerLocalIPAnnotation]
+	if !ok {
+		klog.Infof("Could not find BGP peer local ip info in the node's annotations. Assuming node IP.")
 	} else {
-		klog.Infof("No Global Peer Routers configured. Peering skipped.")
+		peerLocalIPs = stringToSlice(nodeBGPPeerLocalIPs, ",")
+		for _, s := range peerLocalIPs {
+			if s != "" {
+				ip := net.ParseIP(s)
+				if ip == nil {
+					return nil, nil, fmt.Errorf("failed to parse node's Peer Local Addresses Annotation: "+
+						"could not parse \"%s\" as an IP", s)
+				}
+			}
+		}
 	}
 
-	return nil
+	// Create and set Global Peer Router complete configs
+	peers, err := newGlobalPeers(peerIPs, peerPorts, peerASNs, peerPasswords, peerMaxPrefixes, peerLocalIPs,
+		nrc.bgpHoldtime, nrc.nodeIP.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to process Global Peer Router configs: %s", err)
+	}
+	err = nrc.setPeerPasswordsFromSecrets(peers, peerPasswordSecrets)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to process Global Peer Router configs: %s", err)
+	}
+
+	return peers, ipStrings, nil
 }
 
 // func (nrc *NetworkRoutingController) getExternalNodeIPs(
@@ -1200,7 +1581,9 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 func NewNetworkRoutingController(clientset kubernetes.Interface,
 	kubeRouterConfig *options.KubeRouterConfig,
 	nodeInformer cache.SharedIndexInformer, svcInformer cache.SharedIndexInformer,
-	epInformer cache.SharedIndexInformer, ipsetMutex *sync.Mutex) (*NetworkRoutingController, error) {
+	epInformer cache.SharedIndexInformer, bgpPeerInformer cache.SharedIndexInformer,
+	bgpConfigInformer cache.SharedIndexInformer, secretInformer cache.SharedIndexInformer,
+	ipsetMutex *sync.Mutex) (*NetworkRoutingController, error) {
 
 	var err error
 
@@ -1211,7 +1594,18 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 		prometheus.MustRegister(metrics.ControllerBGPadvertisementsSent)
 		prometheus.MustRegister(metrics.ControllerBGPInternalPeersSyncTime)
 		prometheus.MustRegister(metrics.ControllerBPGpeers)
+		prometheus.MustRegister(metrics.ControllerBGPPeerFlaps)
+		prometheus.MustRegister(metrics.ControllerBGPPeerLastNotification)
+		prometheus.MustRegister(metrics.ControllerBGPPeerMaxPrefixes)
+		prometheus.MustRegister(metrics.ControllerBGPPeerMaxPrefixesReached)
+		prometheus.MustRegister(metrics.ControllerBGPPeerNotifications)
+		prometheus.MustRegister(metrics.ControllerBGPPeerPrefixes)
+		prometheus.MustRegister(metrics.ControllerBGPPeerSessionState)
+		prometheus.MustRegister(metrics.ControllerBGPPeerUptime)
 		prometheus.MustRegister(metrics.ControllerRoutesSyncTime)
+		prometheus.MustRegister(metrics.ControllerRoutesStale)
+		prometheus.MustRegister(metrics.ControllerRoutesStaleRemoved)
+		prometheus.MustRegister(metrics.ControllerRoutesTampered)
 		nrc.MetricsEnabled = true
 	}
 
@@ -1227,12 +1621,30 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	nrc.overrideNextHop = kubeRouterConfig.OverrideNextHop
 	nrc.clientset = clientset
 	nrc.activeNodes = make(map[string]bool)
+	nrc.bgpPeers = make(map[string]*gobgpapi.Peer)
+	nrc.installExternalRoutes = kubeRouterConfig.InstallExternalRoutes
+	nrc.importedRoutes = make(map[string]map[string]net.IP)
+	nrc.externalRoutesMetric = kubeRouterConfig.InstallExternalRoutesMetric
+	if nrc.externalRoutesMetric < 0 {
+		return nil, fmt.Errorf("invalid external routes metric %d, must not be negative", nrc.externalRoutesMetric)
+	}
+	nrc.bgpPeersNodeCondition = kubeRouterConfig.BGPPeersNodeCondition
 	nrc.bgpRRClient = false
 	nrc.bgpRRServer = false
 	nrc.bgpServerStarted = false
 	nrc.disableSrcDstCheck = kubeRouterConfig.DisableSrcDstCheck
 	nrc.initSrcDstCheckDone = false
-	nrc.routeSyncer = newRouteSyncer(kubeRouterConfig.InjectedRoutesSyncPeriod)
+	switch kubeRouterConfig.InjectedRoutesCleanup {
+	case injectedRoutesCleanupEnabled, injectedRoutesCleanupDryRun, injectedRoutesCleanupDisabled:
+	default:
+		return nil, fmt.Errorf("unknown injected routes cleanup mode %q, must be one of: %s, %s, %s",
+			kubeRouterConfig.InjectedRoutesCleanup, injectedRoutesCleanupEnabled, injectedRoutesCleanupDryRun,
+			injectedRoutesCleanupDisabled)
+	}
+	nrc.routeSyncer = newRouteSyncer(kubeRouterConfig.InjectedRoutesSyncPeriod, kubeRouterConfig.InjectedRoutesCleanup)
+	nrc.routeSyncer.metricsEnabled = nrc.MetricsEnabled
+	nrc.routeSyncer.routeReinjector = nrc.reinjectRoutes
+	nrc.ln = &linuxNetworking{}
 
 	nrc.bgpHoldtime = kubeRouterConfig.BGPHoldTime.Seconds()
 	if nrc.bgpHoldtime > 65536 || nrc.bgpHoldtime < 3 {
@@ -1240,6 +1652,26 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 			"3s to 18h12m16s")
 	}
 
+	nrc.bgpMaxPaths = kubeRouterConfig.BGPMaxPaths
+	if nrc.bgpMaxPaths < 1 {
+		return nil, fmt.Errorf("invalid BGP max paths %d, must be at least 1", nrc.bgpMaxPaths)
+	}
+	// only the routes imported from external peers are installed as multipath routes
+	if nrc.bgpMaxPaths > 1 && !nrc.installExternalRoutes {
+		return nil, fmt.Errorf("BGP max paths %d requires --install-external-routes, multipath routes are only "+
+			"installed for the prefixes imported from external peers", nrc.bgpMaxPaths)
+	}
+
+	nrc.enableBFD = kubeRouterConfig.EnableBFD
+	nrc.bfdConfig = bfdSessionConfig{
+		DesiredMinTxInterval:  kubeRouterConfig.BFDMinTxInterval,
+		RequiredMinRxInterval: kubeRouterConfig.BFDMinRxInterval,
+		DetectMultiplier:      kubeRouterConfig.BFDDetectMultiplier,
+	}
+	if err := validateBFDSessionConfig(nrc.bfdConfig); err != nil {
+		return nil, err
+	}
+
 	nrc.hostnameOverride = kubeRouterConfig.HostnameOverride
 	node, err := utils.GetNodeObject(clientset, nrc.hostnameOverride)
 	if err != nil {
@@ -1268,36 +1700,74 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	nrc.ec2IamAuthorized = true
 
 	if nrc.enableCNI {
-		nrc.cniConfFile = os.Getenv("KUBE_ROUTER_CNI_CONF_FILE")
-		if nrc.cniConfFile == "" {
-			nrc.cniConfFile = "/etc/cni/net.d/10-kuberouter.conf"
-		}
+		nrc.cniConfFile = getCNIConfFile()
 		if _, err := os.Stat(nrc.cniConfFile); os.IsNotExist(err) {
 			return nil, errors.New("CNI conf file " + nrc.cniConfFile + " does not exist.")
 		}
 	}
 
-	cidr, err := utils.GetPodCidrFromNodeSpec(clientset, nrc.hostnameOverride)
+	cidrs, err := utils.GetPodCIDRsFromNodeSpec(node)
 	if err != nil {
 		klog.Fatalf("Failed to get pod CIDR from node spec. kube-router relies on kube-controller-manager to "+
 			"allocate pod CIDR for the node or an annotation `kube-router.io/pod-cidr`. Error: %v", err)
 		return nil, fmt.Errorf("failed to get pod CIDR details from Node.spec: %s", err.Error())
 	}
-	nrc.podCidr = cidr
+	if _, ok := node.Annotations["kube-router.io/pod-cidr"]; ok {
+		klog.Warningf("The `kube-router.io/pod-cidr` annotation is deprecated, use the " +
+			"`kube-router.io/pod-cidrs` annotation instead")
+	}
+	nrc.podCidr = getPodCIDRForFamily(cidrs, nrc.isIpv6)
+	nrc.podCIDRs = cidrs
 
-	nrc.ipSetHandler, err = utils.NewIPSet(nrc.isIpv6)
+	nrc.nodeSubnet, nrc.nodeInterface, err = getNodeSubnet(nodeIP)
 	if err != nil {
-		return nil, err
+		return nil, errors.New("failed find the subnet of the node IP and interface on" +
+			"which its configured: " + err.Error())
 	}
 
-	_, err = nrc.ipSetHandler.Create(podSubnetsIPSetName, utils.TypeHashNet, utils.OptionTimeout, "0")
-	if err != nil {
-		return nil, err
+	// On dual-stack nodes, pod CIDRs of the other IP family than the node IP are routed through the node's address
+	// of that family
+	for _, cidr := range nrc.podCIDRs {
+		ip, _, _ := net.ParseCIDR(cidr)
+		if (ip.To4() == nil) == nrc.isIpv6 {
+			continue
+		}
+		secondaryIP, err := utils.GetNodeIPByFamily(node, !nrc.isIpv6)
+		if err != nil {
+			klog.Warningf("Pod CIDR %s will not be routed as the node has no address of the same IP family: %v",
+				cidr, err)
+			break
+		}
+		nrc.nodeSecondarySubnet, nrc.nodeSecondaryInterface, err = getNodeSubnet(secondaryIP)
+		if err != nil {
+			return nil, errors.New("failed find the subnet of the secondary node IP and interface on" +
+				"which its configured: " + err.Error())
+		}
+		nrc.nodeSecondaryIP = secondaryIP
+		break
 	}
 
-	_, err = nrc.ipSetHandler.Create(nodeAddrsIPSetName, utils.TypeHashIP, utils.OptionTimeout, "0")
-	if err != nil {
-		return nil, err
+	for _, isIpv6 := range nrc.ipFamilies() {
+		ipSetHandler, err := utils.NewIPSet(isIpv6)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = ipSetHandler.Create(podSubnetsIPSetName, utils.TypeHashNet, utils.OptionTimeout, "0")
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = ipSetHandler.Create(nodeAddrsIPSetName, utils.TypeHashIP, utils.OptionTimeout, "0")
+		if err != nil {
+			return nil, err
+		}
+
+		if isIpv6 == nrc.isIpv6 {
+			nrc.ipSetHandler = ipSetHandler
+		} else {
+			nrc.secondaryIPSetHandler = ipSetHandler
+		}
 	}
 
 	if kubeRouterConfig.EnablePodEgress || len(nrc.clusterCIDR) != 0 {
@@ -1305,8 +1775,7 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	}
 
 	if kubeRouterConfig.ClusterAsn != 0 {
-		if !((kubeRouterConfig.ClusterAsn >= 64512 && kubeRouterConfig.ClusterAsn <= 65535) ||
-			(kubeRouterConfig.ClusterAsn >= 4200000000 && kubeRouterConfig.ClusterAsn <= 4294967294)) {
+		if kubeRouterConfig.ClusterAsn > math.MaxUint32 || !isValidClusterAsn(uint32(kubeRouterConfig.ClusterAsn)) {
 			return nil, errors.New("invalid ASN number for cluster ASN")
 		}
 		nrc.defaultNodeAsnNumber = uint32(kubeRouterConfig.ClusterAsn)
@@ -1314,6 +1783,18 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 		nrc.defaultNodeAsnNumber = 64512 // this magic number is first of the private ASN range, use it as default
 	}
 
+	if bgpConfigInformer != nil {
+		nrc.bgpConfigLister = bgpConfigInformer.GetIndexer()
+		bgpConfig, err := nrc.getBGPConfiguration()
+		if err != nil {
+			return nil, err
+		}
+		if err = nrc.applyBGPConfiguration(bgpConfig); err != nil {
+			return nil, err
+		}
+		nrc.BGPConfigurationEventHandler = nrc.newBGPConfigurationEventHandler()
+	}
+
 	nrc.advertiseClusterIP = kubeRouterConfig.AdvertiseClusterIP
 	nrc.advertiseExternalIP = kubeRouterConfig.AdvertiseExternalIP
 	nrc.advertiseLoadBalancerIP = kubeRouterConfig.AdvertiseLoadBalancerIP
@@ -1321,6 +1802,17 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	nrc.autoMTU = kubeRouterConfig.AutoMTU
 	nrc.enableOverlays = kubeRouterConfig.EnableOverlay
 	nrc.overlayType = kubeRouterConfig.OverlayType
+	switch kubeRouterConfig.OverlayEncap {
+	case overlayEncapIPIP, overlayEncapVXLAN, overlayEncapWireGuard:
+		nrc.overlayEncap = kubeRouterConfig.OverlayEncap
+	default:
+		return nil, fmt.Errorf("unknown overlay encapsulation %q, must be one of: %s, %s, %s",
+			kubeRouterConfig.OverlayEncap, overlayEncapIPIP, overlayEncapVXLAN, overlayEncapWireGuard)
+	}
+	nrc.overlaySingleTunnel = kubeRouterConfig.OverlaySingleTunnel
+	nrc.vxlanPort = kubeRouterConfig.VXLANPort
+	nrc.wireGuardPort = kubeRouterConfig.WireGuardPort
+	nrc.wireGuardKeyRotationPeriod = kubeRouterConfig.WireGuardKeyRotationPeriod
 	nrc.CNIFirewallSetup = sync.NewCond(&sync.Mutex{})
 
 	nrc.bgpPort = kubeRouterConfig.BGPPort
@@ -1357,16 +1849,43 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 		}
 	}
 
+	peerMaxPrefixes := make([]uint32, 0)
+	for _, i := range kubeRouterConfig.PeerMaxPrefixes {
+		peerMaxPrefixes = append(peerMaxPrefixes, uint32(i))
+	}
+	if kubeRouterConfig.PeerMaxPrefixesThreshold > maxPrefixesThresholdMax {
+		return nil, fmt.Errorf("the maximum prefixes warning threshold is a percentage, it must be at most %d",
+			maxPrefixesThresholdMax)
+	}
+	nrc.peerMaxPrefixesThreshold = kubeRouterConfig.PeerMaxPrefixesThreshold
+	nrc.peerMaxPrefixesRestartTime = kubeRouterConfig.PeerMaxPrefixesRestartTime
+
 	nrc.globalPeerRouters, err = newGlobalPeers(kubeRouterConfig.PeerRouters, peerPorts,
-		peerASNs, peerPasswords, nil, nrc.bgpHoldtime, nrc.nodeIP.String())
+		peerASNs, peerPasswords, peerMaxPrefixes, nil, nrc.bgpHoldtime, nrc.nodeIP.String())
 	if err != nil {
 		return nil, fmt.Errorf("error processing Global Peer Router configs: %s", err)
 	}
 
-	nrc.nodeSubnet, nrc.nodeInterface, err = getNodeSubnet(nodeIP)
+	if secretInformer != nil {
+		nrc.secretLister = secretInformer.GetIndexer()
+		nrc.secretNamespace = kubeRouterConfig.PeerPasswordSecretsNamespace
+		nrc.SecretEventHandler = nrc.newSecretEventHandler()
+	}
+	nrc.globalPeerPasswordSecrets, err = parseSecretKeyReferences(kubeRouterConfig.PeerPasswordSecrets)
 	if err != nil {
-		return nil, errors.New("failed find the subnet of the node IP and interface on" +
-			"which its configured: " + err.Error())
+		return nil, fmt.Errorf("failed to parse CLI Peer Password Secrets flag: %s", err)
+	}
+	err = nrc.setPeerPasswordsFromSecrets(nrc.globalPeerRouters, nrc.globalPeerPasswordSecrets)
+	if err != nil {
+		return nil, fmt.Errorf("error processing Global Peer Router configs: %s", err)
+	}
+
+	nrc.bgpAPIOptions, err = newBGPAPIServerOptions(kubeRouterConfig, nrc.nodeIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure the BGP API: %s", err)
+	}
+	if kubeRouterConfig.BGPAPIListen == bgpAPIListenUnix {
+		nrc.bgpAPISocket = kubeRouterConfig.BGPAPISocket
 	}
 
 	bgpLocalAddressListAnnotation, ok := node.ObjectMeta.Annotations[bgpLocalAddressAnnotation]
@@ -1395,5 +1914,24 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	nrc.nodeLister = nodeInformer.GetIndexer()
 	nrc.NodeEventHandler = nrc.newNodeEventHandler()
 
+	if bgpPeerInformer != nil {
+		nrc.bgpPeerLister = bgpPeerInformer.GetIndexer()
+		nrc.BGPPeerEventHandler = nrc.newBGPPeerEventHandler()
+	}
+	if nrc.installExternalRoutes && nrc.bgpPeerLister == nil {
+		klog.Warningf("--install-external-routes has no effect without --enable-bgp-crds, the routes to install are " +
+			"selected by the import filters of BGPPeer resources")
+	}
+
+	// BGPPeers may enable BFD with their peer even when it is disabled for the other peers
+	if nrc.enableBFD || nrc.bgpPeerLister != nil {
+		nrc.bfdManager = newBFDManager(nrc.OnBFDStateChange)
+	}
+
 	return &nrc, nil
 }
+
+// isValidClusterAsn returns whether the ASN is in one of the private ASN ranges
+func isValidClusterAsn(asn uint32) bool {
+	return (asn >= 64512 && asn <= 65535) || (asn >= 4200000000 && asn <= 4294967294)
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"reflect"
+	"syscall"
 	"testing"
 	"time"
 
@@ -18,6 +19,9 @@ import (
 
 	gobgpapi "github.com/osrg/gobgp/v3/api"
 	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 func Test_advertiseClusterIPs(t *testing.T) {
@@ -1180,6 +1184,7 @@ func Test_advertisePodRoute(t *testing.T) {
 			&NetworkRoutingController{
 				bgpServer: gobgp.NewBgpServer(),
 				podCidr:   "172.20.0.0/24",
+				podCIDRs:  []string{"172.20.0.0/24"},
 				nodeIP:    net.ParseIP("10.0.0.1"),
 			},
 			"node-1",
@@ -1202,6 +1207,7 @@ func Test_advertisePodRoute(t *testing.T) {
 				bgpServer:        gobgp.NewBgpServer(),
 				hostnameOverride: "node-1",
 				podCidr:          "172.20.0.0/24",
+				podCIDRs:         []string{"172.20.0.0/24"},
 				nodeIP:           net.ParseIP("10.0.0.1"),
 			},
 			"",
@@ -1218,6 +1224,31 @@ func Test_advertisePodRoute(t *testing.T) {
 			},
 			nil,
 		},
+		{
+			"add bgp paths for both pod cidrs of a dual-stack node",
+			&NetworkRoutingController{
+				bgpServer:       gobgp.NewBgpServer(),
+				podCidr:         "172.20.0.0/24",
+				podCIDRs:        []string{"172.20.0.0/24", "fd00:20::/64"},
+				nodeIP:          net.ParseIP("10.0.0.1"),
+				nodeSecondaryIP: net.ParseIP("fd00::1"),
+			},
+			"node-1",
+			&v1core.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node-1",
+				},
+				Spec: v1core.NodeSpec{
+					PodCIDR:  "172.20.0.0/24",
+					PodCIDRs: []string{"172.20.0.0/24", "fd00:20::/64"},
+				},
+			},
+			map[string]bool{
+				"172.20.0.0/24": true,
+				"fd00:20::/64":  true,
+			},
+			nil,
+		},
 		/* disabling tests for now, as node POD cidr is read just once at the starting of the program
 		   Tests needs to be adopted to catch the errors when NetworkRoutingController starts
 			{
@@ -1943,6 +1974,11 @@ func Test_generateTunnelName(t *testing.T) {
 			"100.200.300.400",
 			"tun100200300400",
 		},
+		{
+			"IPv6 address is hashed",
+			"fd00::1",
+			"tun6cb2a2b4daa4",
+		},
 	}
 
 	for _, testcase := range testcases {
@@ -1979,6 +2015,235 @@ func createNodes(clientset kubernetes.Interface, nodes []*v1core.Node) error {
 	return nil
 }
 
+func Test_setupOverlayTunnel(t *testing.T) {
+	newNodeLink := func() netlink.Link {
+		linkAttrs := netlink.NewLinkAttrs()
+		linkAttrs.Name = "eth0"
+		return &netlink.Device{LinkAttrs: linkAttrs}
+	}
+	newTunnel := func(name, local, remote string) netlink.Link {
+		linkAttrs := netlink.NewLinkAttrs()
+		linkAttrs.Name = name
+		return &netlink.Iptun{LinkAttrs: linkAttrs, Local: net.ParseIP(local).To4(), Remote: net.ParseIP(remote).To4()}
+	}
+
+	testcases := []struct {
+		name            string
+		existingLinks   []netlink.Link
+		nextHop         string
+		expectedTunnel  netlink.Link
+		expectedDeleted []string
+	}{
+		{
+			"an IPIP tunnel bound to the node interface is created for an IPv4 next hop",
+			[]netlink.Link{newNodeLink()},
+			"10.0.1.2",
+			&netlink.Iptun{Link: 1, Local: net.ParseIP("10.0.0.1").To4(), Remote: net.ParseIP("10.0.1.2").To4(),
+				PMtuDisc: 1, Proto: syscall.IPPROTO_IPIP},
+			nil,
+		},
+		{
+			"an ip6ip6 tunnel is created for an IPv6 next hop",
+			[]netlink.Link{newNodeLink()},
+			"2001:db8:1::2",
+			&netlink.Ip6tnl{Link: 1, Local: net.ParseIP("2001:db8::1"), Remote: net.ParseIP("2001:db8:1::2"),
+				Ttl: ip6TunnelHopLimit, EncapLimit: ip6TunnelEncapLimit, Proto: syscall.IPPROTO_IPV6},
+			nil,
+		},
+		{
+			"an existing tunnel with the same endpoints is kept",
+			[]netlink.Link{newNodeLink(), newTunnel(generateTunnelName("10.0.1.2"), "10.0.0.1", "10.0.1.2")},
+			"10.0.1.2",
+			&netlink.Iptun{Local: net.ParseIP("10.0.0.1").To4(), Remote: net.ParseIP("10.0.1.2").To4()},
+			nil,
+		},
+		{
+			"an existing tunnel with other endpoints is recreated",
+			[]netlink.Link{newNodeLink(), newTunnel(generateTunnelName("10.0.1.2"), "10.0.0.9", "10.0.1.2")},
+			"10.0.1.2",
+			&netlink.Iptun{Link: 1, Local: net.ParseIP("10.0.0.1").To4(), Remote: net.ParseIP("10.0.1.2").To4(),
+				PMtuDisc: 1, Proto: syscall.IPPROTO_IPIP},
+			[]string{generateTunnelName("10.0.1.2")},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			fn := newFakeNetlink(testcase.existingLinks...)
+			nrc := &NetworkRoutingController{
+				nodeIP:                 net.ParseIP("10.0.0.1"),
+				nodeInterface:          "eth0",
+				nodeSecondaryIP:        net.ParseIP("2001:db8::1"),
+				nodeSecondaryInterface: "eth0",
+				routeSyncer:            newRouteSyncer(time.Minute, injectedRoutesCleanupDisabled),
+				ln:                     fn,
+			}
+			nextHop := net.ParseIP(testcase.nextHop)
+			tunnelName := generateTunnelName(testcase.nextHop)
+
+			link, err := nrc.setupOverlayTunnel(tunnelName, nextHop)
+			if err != nil {
+				t.Fatalf("failed to setup overlay tunnel: %v", err)
+			}
+
+			if link.Attrs().Name != tunnelName || link.Attrs().Flags&net.FlagUp == 0 {
+				t.Errorf("expected tunnel %s to be up, got %+v", tunnelName, link.Attrs())
+			}
+			// only the tunnel settings are compared
+			*testcase.expectedTunnel.Attrs() = *link.Attrs()
+			if !reflect.DeepEqual(link, testcase.expectedTunnel) {
+				t.Errorf("expected tunnel %+v, got %+v", testcase.expectedTunnel, link)
+			}
+			if !reflect.DeepEqual(fn.deleted, testcase.expectedDeleted) {
+				t.Errorf("expected deleted links %v, got %v", testcase.expectedDeleted, fn.deleted)
+			}
+
+			expectedRoutes := []netlink.Route{{
+				LinkIndex: link.Attrs().Index,
+				Dst:       getHostIPNet(nextHop),
+				Scope:     netlink.SCOPE_LINK,
+				Table:     customRouteTableNumber,
+			}}
+			if !reflect.DeepEqual(fn.routes, expectedRoutes) {
+				t.Errorf("expected custom table routes %v, got %v", expectedRoutes, fn.routes)
+			}
+		})
+	}
+}
+
+func Test_injectRouteIPv6(t *testing.T) {
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Name = "eth0"
+	fn := newFakeNetlink(&netlink.Device{LinkAttrs: linkAttrs})
+	var replaced []*netlink.Route
+	nrc := &NetworkRoutingController{
+		nodeIP:                 net.ParseIP("10.0.0.1"),
+		nodeInterface:          "eth0",
+		nodeSecondaryIP:        net.ParseIP("2001:db8::1"),
+		nodeSecondaryInterface: "eth0",
+		enableOverlays:         true,
+		overlayType:            "full",
+		overlayEncap:           overlayEncapIPIP,
+		routeSyncer:            newRouteSyncer(time.Minute, injectedRoutesCleanupDisabled),
+		ln:                     fn,
+	}
+	nrc.routeSyncer.routeReplacer = func(route *netlink.Route) error {
+		replaced = append(replaced, route)
+		return nil
+	}
+
+	// GoBGP holds the next hop of the IPv6 paths in the MP_REACH_NLRI attribute instead of the NEXT_HOP attribute
+	family := &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP6, Safi: gobgpapi.Family_SAFI_UNICAST}
+	nlri, _ := anypb.New(&gobgpapi.IPAddressPrefix{Prefix: "2001:db8:42::", PrefixLen: 64})
+	origin, _ := anypb.New(&gobgpapi.OriginAttribute{Origin: 0})
+	mpReach, _ := anypb.New(&gobgpapi.MpReachNLRIAttribute{
+		Family: family, NextHops: []string{"2001:db8:1::2"}, Nlris: []*anypb.Any{nlri},
+	})
+	path := &gobgpapi.Path{
+		Family: family, Nlri: nlri, Pattrs: []*anypb.Any{origin, mpReach}, NeighborIp: "2001:db8:1::2",
+	}
+
+	if err := nrc.injectRoute(path); err != nil {
+		t.Fatalf("failed to inject route: %v", err)
+	}
+
+	tunnel, err := fn.linkByName(generateTunnelName("2001:db8:1::2"))
+	if err != nil {
+		t.Fatalf("expected a tunnel to the IPv6 next hop: %v", err)
+	}
+	if _, ok := tunnel.(*netlink.Ip6tnl); !ok {
+		t.Errorf("expected an ip6ip6 tunnel, got %+v", tunnel)
+	}
+	_, dst, _ := net.ParseCIDR("2001:db8:42::/64")
+	expectedRoute := &netlink.Route{
+		LinkIndex: tunnel.Attrs().Index,
+		Src:       net.ParseIP("2001:db8::1"),
+		Dst:       dst,
+		Protocol:  zebraRouteOriginator,
+	}
+	if !reflect.DeepEqual(nrc.routeSyncer.routeTableStateMap[dst.String()], expectedRoute) {
+		t.Errorf("expected injected route %v, got %v", expectedRoute,
+			nrc.routeSyncer.routeTableStateMap[dst.String()])
+	}
+	if !reflect.DeepEqual(replaced, []*netlink.Route{expectedRoute}) {
+		t.Errorf("expected the route %v to be synced to the routing table, got %v", expectedRoute, replaced)
+	}
+}
+
+// notifyingNetlink passes the routes it deletes to the route syncer, as the kernel route updates would
+type notifyingNetlink struct {
+	*fakeNetlink
+	syncer *routeSyncer
+}
+
+func (nn *notifyingNetlink) routeDel(route *netlink.Route) error {
+	if err := nn.fakeNetlink.routeDel(route); err != nil {
+		return err
+	}
+	update := netlink.RouteUpdate{Type: syscall.RTM_DELROUTE, Route: *route}
+	update.Table = syscall.RT_TABLE_MAIN
+	nn.syncer.handleRouteUpdate(update)
+	return nil
+}
+
+func Test_injectRouteFromTunnelToDirect(t *testing.T) {
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Name = "eth0"
+	fn := newFakeNetlink(&netlink.Device{LinkAttrs: linkAttrs})
+	syncer := newRouteSyncer(time.Minute, injectedRoutesCleanupDisabled)
+	syncer.ln = &notifyingNetlink{fakeNetlink: fn, syncer: syncer}
+	var replaced []netlink.Route
+	syncer.routeReplacer = func(route *netlink.Route) error {
+		replaced = append(replaced, *route)
+		return fn.routeReplace(route)
+	}
+	_, nodeSubnet, _ := net.ParseCIDR("10.0.0.0/24")
+	nrc := &NetworkRoutingController{
+		nodeIP:         net.ParseIP("10.0.0.1"),
+		nodeInterface:  "eth0",
+		nodeSubnet:     *nodeSubnet,
+		enableOverlays: true,
+		overlayType:    "full",
+		overlayEncap:   overlayEncapIPIP,
+		routeSyncer:    syncer,
+		ln:             fn,
+	}
+	path := newTestPath(t, "10.244.2.0", 24, "10.0.0.2", "10.0.0.2")
+
+	if err := nrc.injectRoute(path); err != nil {
+		t.Fatalf("failed to inject route: %v", err)
+	}
+	tunnel, err := fn.linkByName(generateTunnelName("10.0.0.2"))
+	if err != nil {
+		t.Fatalf("expected a tunnel to the next hop: %v", err)
+	}
+
+	// the next hop is in the subnet of the node, so the route no longer goes through the tunnel
+	nrc.overlayType = "subnet"
+	replaced = nil
+	if err = nrc.injectRoute(path); err != nil {
+		t.Fatalf("failed to inject route: %v", err)
+	}
+
+	assert.Equal(t, []string{tunnel.Attrs().Name}, fn.deleted)
+	assert.True(t, syncer.ownLinkDeletions[tunnel.Attrs().Index],
+		"the tunnel deletion should not be counted as tampering")
+	for _, route := range replaced {
+		assert.NotEqual(t, tunnel.Attrs().Index, route.LinkIndex,
+			"the route through the tunnel should not be installed again as tampered with")
+	}
+	var mainRoutes []netlink.Route
+	for _, route := range fn.routes {
+		if route.Table != customRouteTableNumber {
+			mainRoutes = append(mainRoutes, route)
+		}
+	}
+	assert.Len(t, mainRoutes, 1)
+	assert.Equal(t, "10.244.2.0/24", mainRoutes[0].Dst.String())
+	assert.Equal(t, "10.0.0.2", mainRoutes[0].Gw.String())
+	assert.Zero(t, mainRoutes[0].LinkIndex)
+}
+
 func startInformersForRoutes(nrc *NetworkRoutingController, clientset kubernetes.Interface) {
 	informerFactory := informers.NewSharedInformerFactory(clientset, 0)
 	svcInformer := informerFactory.Core().V1().Services().Informer()
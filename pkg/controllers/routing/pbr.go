@@ -1,14 +1,20 @@
 package routing
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
-	"os/exec"
 	"strings"
+	"syscall"
 
 	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netlink"
 )
 
+// rtTablesFile is the file naming the routing tables for the ip command
+var rtTablesFile = "/etc/iproute2/rt_tables"
+
 // setup a custom routing table that will be used for policy based routing to ensure traffic originating
 // on tunnel interface only leaves through tunnel interface irrespective rp_filter enabled/disabled
 func (nrc *NetworkRoutingController) enablePolicyBasedRouting() error {
@@ -17,16 +23,9 @@ func (nrc *NetworkRoutingController) enablePolicyBasedRouting() error {
 		return fmt.Errorf("failed to update rt_tables file: %s", err)
 	}
 
-	out, err := exec.Command("ip", "rule", "list").Output()
-	if err != nil {
-		return fmt.Errorf("failed to verify if `ip rule` exists: %s", err.Error())
-	}
-
-	if !strings.Contains(string(out), nrc.podCidr) {
-		//nolint:gosec // this exec should be safe from command injection given the parameter's context
-		err = exec.Command("ip", "rule", "add", "from", nrc.podCidr, "lookup", customRouteTableID).Run()
-		if err != nil {
-			return fmt.Errorf("failed to add ip rule due to: %s", err.Error())
+	for _, podCIDR := range nrc.podCIDRs {
+		if err = nrc.addPodCIDRRule(podCIDR); err != nil {
+			return err
 		}
 	}
 
@@ -39,31 +38,126 @@ func (nrc *NetworkRoutingController) disablePolicyBasedRouting() error {
 		return fmt.Errorf("failed to update rt_tables file: %s", err)
 	}
 
-	out, err := exec.Command("ip", "rule", "list").Output()
+	for _, podCIDR := range nrc.podCIDRs {
+		if err = nrc.delPodCIDRRules(podCIDR); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addPodCIDRRule adds the policy rule looking up the custom route table for the traffic from the pod CIDR, unless it
+// already exists
+func (nrc *NetworkRoutingController) addPodCIDRRule(podCIDR string) error {
+	rules, src, err := nrc.listPodCIDRRules(podCIDR)
 	if err != nil {
-		return fmt.Errorf("failed to verify if `ip rule` exists: %s",
-			err.Error())
+		return err
+	}
+	if len(rules) > 0 {
+		return nil
 	}
 
-	if strings.Contains(string(out), nrc.podCidr) {
-		//nolint:gosec // this exec should be safe from command injection given the parameter's context
-		err = exec.Command("ip", "rule", "del", "from", nrc.podCidr, "table", customRouteTableID).Run()
-		if err != nil {
+	rule := netlink.NewRule()
+	rule.Family = getIPFamily(src.IP)
+	rule.Src = src
+	rule.Table = customRouteTableNumber
+	if err = nrc.ln.ruleAdd(rule); err != nil {
+		return fmt.Errorf("failed to add ip rule due to: %s", err.Error())
+	}
+	return nil
+}
+
+// delPodCIDRRules removes the policy rules looking up the custom route table for the traffic from the pod CIDR
+func (nrc *NetworkRoutingController) delPodCIDRRules(podCIDR string) error {
+	rules, _, err := nrc.listPodCIDRRules(podCIDR)
+	if err != nil {
+		return err
+	}
+	for i := range rules {
+		if err = nrc.ln.ruleDel(&rules[i]); err != nil && !isNotExistError(err) {
 			return fmt.Errorf("failed to delete ip rule: %s", err.Error())
 		}
 	}
+	return nil
+}
 
+// listPodCIDRRules returns the policy rules looking up the custom route table for the traffic from the pod CIDR, along
+// with the parsed pod CIDR
+func (nrc *NetworkRoutingController) listPodCIDRRules(podCIDR string) ([]netlink.Rule, *net.IPNet, error) {
+	_, src, err := net.ParseCIDR(podCIDR)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse pod CIDR %s: %s", podCIDR, err)
+	}
+	rules, err := nrc.ln.ruleList(getIPFamily(src.IP))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list ip rules: %s", err.Error())
+	}
+
+	var matching []netlink.Rule
+	for _, rule := range rules {
+		if rule.Table == customRouteTableNumber && rule.Src != nil && rule.Src.String() == src.String() {
+			matching = append(matching, rule)
+		}
+	}
+	return matching, src, nil
+}
+
+// addCustomTableHostRoute routes the traffic to the node IP originating from the pods through the overlay link, in the
+// custom routing table used for policy based routing. The encapsulation is only needed by collect metadata devices.
+func (nrc *NetworkRoutingController) addCustomTableHostRoute(link netlink.Link, nodeIP net.IP,
+	encap netlink.Encap) error {
+	return nrc.ln.routeReplace(&netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       getHostIPNet(nodeIP),
+		Scope:     netlink.SCOPE_LINK,
+		Table:     customRouteTableNumber,
+		Encap:     encap,
+	})
+}
+
+// delCustomTableHostRoute removes the route added by addCustomTableHostRoute, it is not an error if there is none
+func (nrc *NetworkRoutingController) delCustomTableHostRoute(link netlink.Link, nodeIP net.IP) error {
+	err := nrc.ln.routeDel(&netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       getHostIPNet(nodeIP),
+		Scope:     netlink.SCOPE_LINK,
+		Table:     customRouteTableNumber,
+	})
+	if err != nil && !isNotExistError(err) {
+		return err
+	}
 	return nil
 }
 
+// getHostIPNet returns the host prefix of the IP
+func getHostIPNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(net.IPv4len*8, net.IPv4len*8)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(net.IPv6len*8, net.IPv6len*8)}
+}
+
+func isNotExistError(err error) bool {
+	return errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ESRCH)
+}
+
+// getIPFamily returns the netlink family of the IP
+func getIPFamily(ip net.IP) int {
+	if ip.To4() == nil {
+		return netlink.FAMILY_V6
+	}
+	return netlink.FAMILY_V4
+}
+
 func rtTablesAdd(tableNumber, tableName string) error {
-	b, err := os.ReadFile("/etc/iproute2/rt_tables")
+	b, err := os.ReadFile(rtTablesFile)
 	if err != nil {
 		return fmt.Errorf("failed to read: %s", err.Error())
 	}
 
 	if !strings.Contains(string(b), tableName) {
-		f, err := os.OpenFile("/etc/iproute2/rt_tables", os.O_APPEND|os.O_WRONLY, 0600)
+		f, err := os.OpenFile(rtTablesFile, os.O_APPEND|os.O_WRONLY, 0600)
 		if err != nil {
 			return fmt.Errorf("failed to open: %s", err.Error())
 		}
@@ -75,3 +169,35 @@ func rtTablesAdd(tableNumber, tableName string) error {
 
 	return nil
 }
+
+// rtTablesDel removes the entry naming the routing table, it returns whether there was one. In dry run mode the file is
+// left unchanged.
+func rtTablesDel(tableNumber, tableName string, dryRun bool) (bool, error) {
+	b, err := os.ReadFile(rtTablesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read: %s", err.Error())
+	}
+
+	entry := tableNumber + " " + tableName
+	lines := strings.SplitAfter(string(b), "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.TrimSpace(line) != entry {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == len(lines) {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+
+	if err = os.WriteFile(rtTablesFile, []byte(strings.Join(kept, "")), 0644); err != nil {
+		return true, fmt.Errorf("failed to write: %s", err.Error())
+	}
+	return true, nil
+}
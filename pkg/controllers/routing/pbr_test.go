@@ -0,0 +1,52 @@
+package routing
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func Test_podCIDRRules(t *testing.T) {
+	newRule := func(family int, src string, table int) netlink.Rule {
+		_, srcNet, _ := net.ParseCIDR(src)
+		rule := netlink.NewRule()
+		rule.Family = family
+		rule.Src = srcNet
+		rule.Table = table
+		return *rule
+	}
+	otherRule := newRule(netlink.FAMILY_V4, "10.244.1.0/24", 100)
+
+	fn := newFakeNetlink()
+	fn.rules = []netlink.Rule{otherRule}
+	nrc := &NetworkRoutingController{podCIDRs: []string{"10.244.1.0/24", "fd00:10:244:1::/64"}, ln: fn}
+
+	// adding the rules again must not duplicate them
+	for i := 0; i < 2; i++ {
+		for _, podCIDR := range nrc.podCIDRs {
+			if err := nrc.addPodCIDRRule(podCIDR); err != nil {
+				t.Fatalf("failed to add pod CIDR rule: %v", err)
+			}
+		}
+	}
+	expectedRules := []netlink.Rule{
+		otherRule,
+		newRule(netlink.FAMILY_V4, "10.244.1.0/24", customRouteTableNumber),
+		newRule(netlink.FAMILY_V6, "fd00:10:244:1::/64", customRouteTableNumber),
+	}
+	if !reflect.DeepEqual(fn.rules, expectedRules) {
+		t.Errorf("expected rules %v, got %v", expectedRules, fn.rules)
+	}
+
+	// only the rules looking up the custom route table are removed
+	for _, podCIDR := range nrc.podCIDRs {
+		if err := nrc.delPodCIDRRules(podCIDR); err != nil {
+			t.Fatalf("failed to delete pod CIDR rules: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(fn.rules, []netlink.Rule{otherRule}) {
+		t.Errorf("expected rules %v, got %v", []netlink.Rule{otherRule}, fn.rules)
+	}
+}
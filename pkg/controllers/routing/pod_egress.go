@@ -27,13 +27,22 @@ var (
 )
 
 func (nrc *NetworkRoutingController) createPodEgressRule() error {
-	iptablesCmdHandler, err := nrc.newIptablesCmdHandler()
+	for _, isIpv6 := range nrc.ipFamilies() {
+		if err := createPodEgressRuleForFamily(isIpv6); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createPodEgressRuleForFamily(isIpv6 bool) error {
+	iptablesCmdHandler, err := newIptablesCmdHandlerForFamily(isIpv6)
 	if err != nil {
 		return errors.New("Failed create iptables handler:" + err.Error())
 	}
 
 	podEgressArgs := podEgressArgs4
-	if nrc.isIpv6 {
+	if isIpv6 {
 		podEgressArgs = podEgressArgs6
 	}
 	if iptablesCmdHandler.HasRandomFully() {
@@ -52,13 +61,22 @@ func (nrc *NetworkRoutingController) createPodEgressRule() error {
 }
 
 func (nrc *NetworkRoutingController) deletePodEgressRule() error {
-	iptablesCmdHandler, err := nrc.newIptablesCmdHandler()
+	for _, isIpv6 := range nrc.ipFamilies() {
+		if err := deletePodEgressRuleForFamily(isIpv6); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deletePodEgressRuleForFamily(isIpv6 bool) error {
+	iptablesCmdHandler, err := newIptablesCmdHandlerForFamily(isIpv6)
 	if err != nil {
 		return errors.New("Failed create iptables handler:" + err.Error())
 	}
 
 	podEgressArgs := podEgressArgs4
-	if nrc.isIpv6 {
+	if isIpv6 {
 		podEgressArgs = podEgressArgs6
 	}
 	if iptablesCmdHandler.HasRandomFully() {
@@ -83,18 +101,27 @@ func (nrc *NetworkRoutingController) deletePodEgressRule() error {
 }
 
 func (nrc *NetworkRoutingController) deleteBadPodEgressRules() error {
-	iptablesCmdHandler, err := nrc.newIptablesCmdHandler()
+	for _, isIpv6 := range nrc.ipFamilies() {
+		if err := deleteBadPodEgressRulesForFamily(isIpv6); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteBadPodEgressRulesForFamily(isIpv6 bool) error {
+	iptablesCmdHandler, err := newIptablesCmdHandlerForFamily(isIpv6)
 	if err != nil {
 		return errors.New("Failed create iptables handler:" + err.Error())
 	}
 	podEgressArgsBad := podEgressArgsBad4
-	if nrc.isIpv6 {
+	if isIpv6 {
 		podEgressArgsBad = podEgressArgsBad6
 	}
 
 	// If random fully is supported remove the original rule as well
 	if iptablesCmdHandler.HasRandomFully() {
-		if !nrc.isIpv6 {
+		if !isIpv6 {
 			podEgressArgsBad = append(podEgressArgsBad, podEgressArgs4)
 		} else {
 			podEgressArgsBad = append(podEgressArgsBad, podEgressArgs6)
@@ -1,19 +1,44 @@
 package routing
 
 import (
+	"errors"
 	"net"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
 	"github.com/vishvananda/netlink"
 	"k8s.io/klog/v2"
 )
 
+const (
+	injectedRoutesCleanupEnabled  = "enabled"
+	injectedRoutesCleanupDryRun   = "dry-run"
+	injectedRoutesCleanupDisabled = "disabled"
+
+	metricTypeRoute  = "route"
+	metricTypeTunnel = "tunnel"
+
+	netlinkUpdatesBufferSize = 64
+)
+
 type routeSyncer struct {
 	routeTableStateMap       map[string]*netlink.Route
 	injectedRoutesSyncPeriod time.Duration
 	mutex                    sync.Mutex
 	routeReplacer            func(route *netlink.Route) error
+	ln                       netlinkCalls
+	cleanupMode              string
+	cleanupStarted           bool
+	cleanupNotBefore         time.Time
+	staleRoutes              map[string]bool
+	staleTunnels             map[string]bool
+	metricsEnabled           bool
+	routeReinjector          func(dsts []*net.IPNet)
+	ownLinkDeletions         map[int]bool
 }
 
 // addInjectedRoute adds a route to the route map that is regularly synced to the kernel's routing table
@@ -34,6 +59,17 @@ func (rs *routeSyncer) delInjectedRoute(dst *net.IPNet) {
 	}
 }
 
+// getInjectedRoutes returns a copy of the route map that is regularly synced to the kernel's routing table
+func (rs *routeSyncer) getInjectedRoutes() map[string]*netlink.Route {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	routes := make(map[string]*netlink.Route, len(rs.routeTableStateMap))
+	for dst, route := range rs.routeTableStateMap {
+		routes[dst] = route
+	}
+	return routes
+}
+
 // syncLocalRouteTable iterates over the local route state map and syncs all routes to the kernel's routing table
 func (rs *routeSyncer) syncLocalRouteTable() {
 	rs.mutex.Lock()
@@ -48,7 +84,250 @@ func (rs *routeSyncer) syncLocalRouteTable() {
 	}
 }
 
-// run starts a goroutine that calls syncLocalRouteTable on interval injectedRoutesSyncPeriod
+// startCleanup lets the synchronizations following the delay remove the stale routes and tunnels. It is called once
+// the BGP server runs, so that the routes injected before a restart are not removed before they could be learned
+// again. With BGP graceful restart, the peers keep their routes and only send them again within the deferral time.
+func (rs *routeSyncer) startCleanup(delay time.Duration) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.cleanupStarted = true
+	rs.cleanupNotBefore = time.Now().Add(delay)
+}
+
+// cleanupStaleRoutes removes the routes injected by kube-router and the tunnels it created that are no longer in the
+// route state map, for instance those to the nodes removed while kube-router was not running. A route or tunnel is
+// only removed once it was found stale by two consecutive synchronizations, so that the ones being set up in the
+// meantime are left alone. In dry run mode they are only logged.
+func (rs *routeSyncer) cleanupStaleRoutes() {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if rs.cleanupMode == injectedRoutesCleanupDisabled || !rs.cleanupStarted || time.Now().Before(rs.cleanupNotBefore) {
+		return
+	}
+	klog.V(2).Infof("Running stale injected routes cleanup")
+
+	usedLinks := make(map[int]bool)
+	for _, route := range rs.routeTableStateMap {
+		if route.LinkIndex > 0 {
+			usedLinks[route.LinkIndex] = true
+		}
+	}
+
+	routes, err := rs.ln.routeListFiltered(netlink.FAMILY_ALL, &netlink.Route{
+		Table: syscall.RT_TABLE_MAIN, Protocol: zebraRouteOriginator,
+	}, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		klog.Errorf("Failed to list the injected routes: %v", err)
+		return
+	}
+	staleRoutes := make(map[string]bool)
+	for i, route := range routes {
+		dst := getRouteDst(&routes[i]).String()
+		if _, ok := rs.routeTableStateMap[dst]; ok {
+			continue
+		}
+		staleRoutes[dst] = true
+		if rs.staleRoutes[dst] && rs.removeStale(metricTypeRoute, route.String(), func() error {
+			return rs.ln.routeDel(&routes[i])
+		}) {
+			delete(staleRoutes, dst)
+		}
+	}
+
+	links, err := rs.ln.linkList()
+	if err != nil {
+		klog.Errorf("Failed to list the tunnel interfaces: %v", err)
+		return
+	}
+	staleTunnels := make(map[string]bool)
+	for _, link := range links {
+		if !isPerNodeTunnel(link) || usedLinks[link.Attrs().Index] {
+			continue
+		}
+		name := link.Attrs().Name
+		staleTunnels[name] = true
+		if rs.staleTunnels[name] && rs.removeStale(metricTypeTunnel, name, func() error {
+			return rs.ln.linkDel(link)
+		}) {
+			delete(staleTunnels, name)
+		}
+	}
+
+	rs.staleRoutes, rs.staleTunnels = staleRoutes, staleTunnels
+	if rs.metricsEnabled {
+		metrics.ControllerRoutesStale.WithLabelValues(metricTypeRoute).Set(float64(len(staleRoutes)))
+		metrics.ControllerRoutesStale.WithLabelValues(metricTypeTunnel).Set(float64(len(staleTunnels)))
+	}
+}
+
+// removeStale removes a stale route or tunnel unless in dry run mode, and returns whether it was removed
+func (rs *routeSyncer) removeStale(staleType, name string, remove func() error) bool {
+	if rs.cleanupMode == injectedRoutesCleanupDryRun {
+		klog.Infof("Stale %s %s would be removed, but the cleanup runs in dry run mode", staleType, name)
+		return false
+	}
+	klog.Infof("Removing stale %s %s", staleType, name)
+	if err := remove(); err != nil {
+		klog.Errorf("Failed to remove stale %s %s: %v", staleType, name, err)
+		return false
+	}
+	if rs.metricsEnabled {
+		metrics.ControllerRoutesStaleRemoved.WithLabelValues(staleType).Inc()
+	}
+	return true
+}
+
+// isPerNodeTunnel returns whether the link is one of the tunnels created by setupOverlayTunnel, whose names are
+// generated by generateTunnelName. The tunl0 fallback device of the ipip module shares their prefix.
+func isPerNodeTunnel(link netlink.Link) bool {
+	if !strings.HasPrefix(link.Attrs().Name, "tun") || link.Attrs().Name == "tunl0" {
+		return false
+	}
+	switch link.(type) {
+	case *netlink.Iptun, *netlink.Ip6tnl:
+		return true
+	}
+	return false
+}
+
+// getRouteDst returns the destination of a route listed from the kernel, which reports the default routes, installed
+// for the prefixes learned from external peers, without one
+func getRouteDst(route *netlink.Route) *net.IPNet {
+	if route.Dst != nil {
+		return route.Dst
+	}
+	if route.Family == netlink.FAMILY_V6 {
+		return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, net.IPv6len*8)}
+	}
+	return &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, net.IPv4len*8)}
+}
+
+// routeFilterDst returns the destination to filter the routes listed from the kernel on, which is nil for the default
+// routes as the kernel reports them without one
+func routeFilterDst(dst *net.IPNet) *net.IPNet {
+	if ones, _ := dst.Mask.Size(); ones == 0 {
+		return nil
+	}
+	return dst
+}
+
+// expectLinkDeletion tells the routeSyncer that kube-router itself deletes the link, so that setting up the routes
+// through it again is not counted as tampering
+func (rs *routeSyncer) expectLinkDeletion(index int) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.ownLinkDeletions[index] = true
+}
+
+// handleRouteUpdate installs an injected route again as soon as something else than kube-router deletes it. Routes
+// kube-router deletes itself are either no longer in the route state map or already installed again by then.
+func (rs *routeSyncer) handleRouteUpdate(update netlink.RouteUpdate) {
+	if update.Type != syscall.RTM_DELROUTE || update.Protocol != zebraRouteOriginator ||
+		update.Table != syscall.RT_TABLE_MAIN {
+		return
+	}
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	dst := getRouteDst(&update.Route)
+	route, ok := rs.routeTableStateMap[dst.String()]
+	if !ok || (route.LinkIndex != 0 && route.LinkIndex != update.LinkIndex) || !route.Gw.Equal(update.Gw) {
+		return
+	}
+	routes, err := rs.ln.routeListFiltered(getIPFamily(dst.IP), &netlink.Route{
+		Dst: routeFilterDst(dst), Table: syscall.RT_TABLE_MAIN, Protocol: zebraRouteOriginator,
+	}, netlink.RT_FILTER_DST|netlink.RT_FILTER_TABLE|netlink.RT_FILTER_PROTOCOL)
+	if err == nil && len(routes) > 0 {
+		return
+	}
+
+	err = rs.routeReplacer(route)
+	if errors.Is(err, syscall.ENODEV) {
+		// the interface of the route was deleted, handleLinkUpdate sets it up again
+		return
+	}
+	klog.Warningf("Injected route %s was deleted outside of kube-router, installing it again", route)
+	if rs.metricsEnabled {
+		metrics.ControllerRoutesTampered.WithLabelValues(metricTypeRoute).Inc()
+	}
+	if err != nil {
+		klog.Errorf("Route could not be replaced due to : " + err.Error())
+	}
+}
+
+// handleLinkUpdate sets up the routes going through a tunnel or overlay interface again as soon as it is deleted,
+// which also creates the interface again
+func (rs *routeSyncer) handleLinkUpdate(update netlink.LinkUpdate) {
+	if update.Header.Type != syscall.RTM_DELLINK {
+		return
+	}
+	index := int(update.Index)
+	rs.mutex.Lock()
+	var dsts []*net.IPNet
+	for _, route := range rs.routeTableStateMap {
+		if route.LinkIndex == index {
+			dsts = append(dsts, route.Dst)
+		}
+	}
+	ownDeletion := rs.ownLinkDeletions[index]
+	delete(rs.ownLinkDeletions, index)
+	rs.mutex.Unlock()
+	if len(dsts) == 0 || rs.routeReinjector == nil {
+		return
+	}
+
+	name := ""
+	if update.Link != nil {
+		name = update.Attrs().Name
+	}
+	if ownDeletion {
+		klog.Infof("Setting up the routes through the recreated interface %s again", name)
+	} else {
+		klog.Warningf("Interface %s carrying injected routes was deleted outside of kube-router, setting up its "+
+			"routes again", name)
+		if rs.metricsEnabled {
+			metrics.ControllerRoutesTampered.WithLabelValues(metricTypeTunnel).Inc()
+		}
+	}
+	sort.Slice(dsts, func(i, j int) bool { return dsts[i].String() < dsts[j].String() })
+	rs.routeReinjector(dsts)
+}
+
+// subscribeRouteUpdates returns a channel receiving the route updates of the kernel until stopCh is closed, or nil if
+// the subscription failed
+func (rs *routeSyncer) subscribeRouteUpdates(stopCh <-chan struct{}) chan netlink.RouteUpdate {
+	updates := make(chan netlink.RouteUpdate, netlinkUpdatesBufferSize)
+	err := rs.ln.routeSubscribe(updates, stopCh, netlink.RouteSubscribeOptions{
+		ErrorCallback: func(err error) {
+			klog.Errorf("Error while receiving route updates: %v", err)
+		},
+	})
+	if err != nil {
+		klog.Errorf("Failed to subscribe to route updates, deleted routes are installed again on the next "+
+			"synchronization only: %v", err)
+		return nil
+	}
+	return updates
+}
+
+// subscribeLinkUpdates returns a channel receiving the link updates of the kernel until stopCh is closed, or nil if
+// the subscription failed
+func (rs *routeSyncer) subscribeLinkUpdates(stopCh <-chan struct{}) chan netlink.LinkUpdate {
+	updates := make(chan netlink.LinkUpdate, netlinkUpdatesBufferSize)
+	err := rs.ln.linkSubscribe(updates, stopCh, netlink.LinkSubscribeOptions{
+		ErrorCallback: func(err error) {
+			klog.Errorf("Error while receiving link updates: %v", err)
+		},
+	})
+	if err != nil {
+		klog.Errorf("Failed to subscribe to link updates, deleted tunnels are set up again when their routes are "+
+			"advertised again only: %v", err)
+		return nil
+	}
+	return updates
+}
+
+// run starts a goroutine that calls syncLocalRouteTable on interval injectedRoutesSyncPeriod, and handles the route and
+// link updates of the kernel in between
 func (rs *routeSyncer) run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
 	// Start route synchronization routine
 	wg.Add(1)
@@ -56,10 +335,31 @@ func (rs *routeSyncer) run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
 		defer wg.Done()
 		t := time.NewTicker(rs.injectedRoutesSyncPeriod)
 		defer t.Stop()
+		routeUpdates, linkUpdates := rs.subscribeRouteUpdates(stopCh), rs.subscribeLinkUpdates(stopCh)
 		for {
 			select {
 			case <-t.C:
 				rs.syncLocalRouteTable()
+				rs.cleanupStaleRoutes()
+				// the subscriptions end on errors, such as the kernel dropping updates, so they are renewed here
+				if routeUpdates == nil {
+					routeUpdates = rs.subscribeRouteUpdates(stopCh)
+				}
+				if linkUpdates == nil {
+					linkUpdates = rs.subscribeLinkUpdates(stopCh)
+				}
+			case update, ok := <-routeUpdates:
+				if !ok {
+					routeUpdates = nil
+					continue
+				}
+				rs.handleRouteUpdate(update)
+			case update, ok := <-linkUpdates:
+				if !ok {
+					linkUpdates = nil
+					continue
+				}
+				rs.handleLinkUpdate(update)
 			case <-stopCh:
 				klog.Infof("Shutting down local route synchronization")
 				return
@@ -69,13 +369,16 @@ func (rs *routeSyncer) run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
 }
 
 // newRouteSyncer creates a new routeSyncer that, when run, will sync routes kept in its local state table every
-// syncPeriod
-func newRouteSyncer(syncPeriod time.Duration) *routeSyncer {
+// syncPeriod and remove the stale ones according to cleanupMode
+func newRouteSyncer(syncPeriod time.Duration, cleanupMode string) *routeSyncer {
 	rs := routeSyncer{}
 	rs.routeTableStateMap = make(map[string]*netlink.Route)
 	rs.injectedRoutesSyncPeriod = syncPeriod
 	rs.mutex = sync.Mutex{}
 	// We substitute the RouteReplace function here so that we can easily monkey patch it in our unit tests
 	rs.routeReplacer = netlink.RouteReplace
+	rs.ln = &linuxNetworking{}
+	rs.cleanupMode = cleanupMode
+	rs.ownLinkDeletions = make(map[int]bool)
 	return &rs
 }
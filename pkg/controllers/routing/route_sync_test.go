@@ -3,6 +3,7 @@ package routing
 import (
 	"net"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -61,7 +62,7 @@ func Test_syncLocalRouteTable(t *testing.T) {
 		myNetlink.pause = time.Millisecond * 200
 
 		// Create a route replacer and seed it with some routes to iterate over
-		syncer := newRouteSyncer(15 * time.Second)
+		syncer := newRouteSyncer(15*time.Second, injectedRoutesCleanupDisabled)
 		syncer.routeTableStateMap = generateTestRouteMap(testRoutes)
 
 		// Replace the netlink.RouteReplace function with our own mock function that includes a WaitGroup for syncing
@@ -141,7 +142,7 @@ func Test_routeSyncer_run(t *testing.T) {
 
 	t.Run("Ensure that run goroutine shuts down correctly on stop", func(t *testing.T) {
 		// Setup routeSyncer to run 10 times a second
-		syncer := newRouteSyncer(100 * time.Millisecond)
+		syncer := newRouteSyncer(100*time.Millisecond, injectedRoutesCleanupDisabled)
 		myNetLink := mockNetlink{}
 		syncer.routeReplacer = myNetLink.mockRouteReplace
 		syncer.routeTableStateMap = generateTestRouteMap(testRoutes)
@@ -166,3 +167,270 @@ func Test_routeSyncer_run(t *testing.T) {
 		assert.False(t, timedOut, "WaitGroup should have marked itself as done instead of timing out")
 	})
 }
+
+func Test_routeSyncer_cleanupStaleRoutes(t *testing.T) {
+	newTunnel := func(name string) netlink.Link {
+		linkAttrs := netlink.NewLinkAttrs()
+		linkAttrs.Name = name
+		return &netlink.Iptun{LinkAttrs: linkAttrs}
+	}
+	newTuntap := func(name string) netlink.Link {
+		linkAttrs := netlink.NewLinkAttrs()
+		linkAttrs.Name = name
+		return &netlink.Tuntap{LinkAttrs: linkAttrs}
+	}
+	newRoute := func(dst string, linkIndex int, protocol netlink.RouteProtocol) netlink.Route {
+		_, dstNet, _ := net.ParseCIDR(dst)
+		return netlink.Route{Dst: dstNet, LinkIndex: linkIndex, Table: syscall.RT_TABLE_MAIN, Protocol: protocol}
+	}
+
+	testcases := []struct {
+		name            string
+		cleanupMode     string
+		startCleanup    bool
+		cleanupDelay    time.Duration
+		expectedDeleted []string
+		expectedRoutes  []string
+	}{
+		{
+			"stale routes and tunnels are removed after being found twice",
+			injectedRoutesCleanupEnabled,
+			true,
+			0,
+			[]string{"tun-10012"},
+			[]string{"10.244.1.0/24", "10.244.3.0/24"},
+		},
+		{
+			"nothing is removed in dry run mode",
+			injectedRoutesCleanupDryRun,
+			true,
+			0,
+			nil,
+			[]string{"10.244.1.0/24", "10.244.2.0/24", "10.244.3.0/24"},
+		},
+		{
+			"nothing is removed before the cleanup is started",
+			injectedRoutesCleanupEnabled,
+			false,
+			0,
+			nil,
+			[]string{"10.244.1.0/24", "10.244.2.0/24", "10.244.3.0/24"},
+		},
+		{
+			"nothing is removed before the graceful restart deferral time is over",
+			injectedRoutesCleanupEnabled,
+			true,
+			time.Hour,
+			nil,
+			[]string{"10.244.1.0/24", "10.244.2.0/24", "10.244.3.0/24"},
+		},
+		{
+			"nothing is removed when the cleanup is disabled",
+			injectedRoutesCleanupDisabled,
+			true,
+			0,
+			nil,
+			[]string{"10.244.1.0/24", "10.244.2.0/24", "10.244.3.0/24"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			// tun-10011 carries the wanted route, tun-10012 the stale one, while neither tun0 nor tunl0, the fallback
+			// IPIP device of the kernel, are tunnels of kube-router
+			fn := newFakeNetlink(newTunnel("tun-10011"), newTunnel("tun-10012"), newTuntap("tun0"),
+				newTunnel("tunl0"))
+			wantedTunnel, _ := fn.linkByName("tun-10011")
+			staleTunnel, _ := fn.linkByName("tun-10012")
+			wantedRoute := newRoute("10.244.1.0/24", wantedTunnel.Attrs().Index, zebraRouteOriginator)
+			fn.routes = []netlink.Route{
+				wantedRoute,
+				newRoute("10.244.2.0/24", staleTunnel.Attrs().Index, zebraRouteOriginator),
+				newRoute("10.244.3.0/24", 0, syscall.RTPROT_BOOT),
+			}
+
+			syncer := newRouteSyncer(time.Minute, testcase.cleanupMode)
+			syncer.ln = fn
+			syncer.routeTableStateMap[wantedRoute.Dst.String()] = &wantedRoute
+			if testcase.startCleanup {
+				syncer.startCleanup(testcase.cleanupDelay)
+			}
+
+			syncer.cleanupStaleRoutes()
+			assert.Nil(t, fn.deleted, "nothing should be removed by the first cleanup")
+			assert.Len(t, fn.routes, 3, "no route should be removed by the first cleanup")
+
+			syncer.cleanupStaleRoutes()
+			assert.Equal(t, testcase.expectedDeleted, fn.deleted)
+			var routes []string
+			for _, route := range fn.routes {
+				routes = append(routes, route.Dst.String())
+			}
+			assert.Equal(t, testcase.expectedRoutes, routes)
+		})
+	}
+}
+
+func Test_routeSyncer_cleanupStaleDefaultRoutes(t *testing.T) {
+	// the kernel reports the default routes without destination
+	fn := newFakeNetlink()
+	fn.routes = []netlink.Route{
+		{Family: netlink.FAMILY_V4, Gw: net.ParseIP("10.0.0.254"), Table: syscall.RT_TABLE_MAIN,
+			Protocol: zebraRouteOriginator},
+		{Family: netlink.FAMILY_V6, Gw: net.ParseIP("2001:db8::254"), LinkIndex: 1, Table: syscall.RT_TABLE_MAIN,
+			Protocol: zebraRouteOriginator},
+	}
+
+	syncer := newRouteSyncer(time.Minute, injectedRoutesCleanupEnabled)
+	syncer.ln = fn
+	_, defaultNet, _ := net.ParseCIDR("0.0.0.0/0")
+	syncer.routeTableStateMap[defaultNet.String()] = &netlink.Route{Dst: defaultNet, Gw: net.ParseIP("10.0.0.254"),
+		Protocol: zebraRouteOriginator}
+	syncer.startCleanup(0)
+
+	syncer.cleanupStaleRoutes()
+	syncer.cleanupStaleRoutes()
+	assert.Len(t, fn.routes, 1, "the stale IPv6 default route should be removed")
+	assert.Equal(t, netlink.FAMILY_V4, fn.routes[0].Family, "the wanted IPv4 default route should be kept")
+	assert.Equal(t, "::/0", getRouteDst(&netlink.Route{Family: netlink.FAMILY_V6}).String())
+}
+
+func Test_routeSyncer_handleRouteUpdate(t *testing.T) {
+	newRoute := func(dst string, linkIndex int, protocol netlink.RouteProtocol) netlink.Route {
+		_, dstNet, _ := net.ParseCIDR(dst)
+		return netlink.Route{Dst: dstNet, LinkIndex: linkIndex, Table: syscall.RT_TABLE_MAIN, Protocol: protocol}
+	}
+	managedRoute := newRoute("10.244.1.0/24", 5, zebraRouteOriginator)
+
+	testcases := []struct {
+		name           string
+		update         netlink.RouteUpdate
+		kernelRoutes   []netlink.Route
+		expectReplaced bool
+	}{
+		{
+			"a deleted injected route is installed again",
+			netlink.RouteUpdate{Type: syscall.RTM_DELROUTE, Route: managedRoute},
+			nil,
+			true,
+		},
+		{
+			"a deleted injected route installed again by kube-router is left alone",
+			netlink.RouteUpdate{Type: syscall.RTM_DELROUTE, Route: managedRoute},
+			[]netlink.Route{managedRoute},
+			false,
+		},
+		{
+			"a deleted route through another interface is ignored",
+			netlink.RouteUpdate{Type: syscall.RTM_DELROUTE, Route: newRoute("10.244.1.0/24", 6, zebraRouteOriginator)},
+			nil,
+			false,
+		},
+		{
+			"a deleted route that wasn't injected is ignored",
+			netlink.RouteUpdate{Type: syscall.RTM_DELROUTE, Route: newRoute("10.244.2.0/24", 5, zebraRouteOriginator)},
+			nil,
+			false,
+		},
+		{
+			"a deleted route of another protocol is ignored",
+			netlink.RouteUpdate{Type: syscall.RTM_DELROUTE, Route: newRoute("10.244.1.0/24", 5, syscall.RTPROT_BOOT)},
+			nil,
+			false,
+		},
+		{
+			"an added route is ignored",
+			netlink.RouteUpdate{Type: syscall.RTM_NEWROUTE, Route: managedRoute},
+			nil,
+			false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			myNetlink := mockNetlink{}
+			fn := newFakeNetlink()
+			fn.routes = testcase.kernelRoutes
+			syncer := newRouteSyncer(time.Minute, injectedRoutesCleanupDisabled)
+			syncer.routeReplacer = myNetlink.mockRouteReplace
+			syncer.ln = fn
+			route := managedRoute
+			syncer.routeTableStateMap[route.Dst.String()] = &route
+
+			syncer.handleRouteUpdate(testcase.update)
+
+			if testcase.expectReplaced {
+				assert.Equal(t, &route, myNetlink.currentRoute, "the injected route should be installed again")
+			} else {
+				assert.Nil(t, myNetlink.currentRoute, "no route should be installed")
+			}
+		})
+	}
+}
+
+func Test_routeSyncer_handleLinkUpdate(t *testing.T) {
+	newLinkUpdate := func(msgType uint16, index int32) netlink.LinkUpdate {
+		update := netlink.LinkUpdate{Link: &netlink.Iptun{LinkAttrs: netlink.LinkAttrs{Name: "tun-10012"}}}
+		update.Header.Type = msgType
+		update.Index = index
+		return update
+	}
+
+	testcases := []struct {
+		name             string
+		update           netlink.LinkUpdate
+		ownDeletion      bool
+		expectedReinject []string
+	}{
+		{
+			"the routes through a deleted interface are set up again",
+			newLinkUpdate(syscall.RTM_DELLINK, 5),
+			false,
+			[]string{"10.244.1.0/24", "fd00:10:244:1::/64"},
+		},
+		{
+			"the routes through an interface recreated by kube-router are set up again",
+			newLinkUpdate(syscall.RTM_DELLINK, 5),
+			true,
+			[]string{"10.244.1.0/24", "fd00:10:244:1::/64"},
+		},
+		{
+			"a deleted interface without injected routes is ignored",
+			newLinkUpdate(syscall.RTM_DELLINK, 7),
+			false,
+			nil,
+		},
+		{
+			"an added interface is ignored",
+			newLinkUpdate(syscall.RTM_NEWLINK, 5),
+			false,
+			nil,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			syncer := newRouteSyncer(time.Minute, injectedRoutesCleanupDisabled)
+			for _, dst := range []string{"fd00:10:244:1::/64", "10.244.1.0/24"} {
+				_, dstNet, _ := net.ParseCIDR(dst)
+				syncer.routeTableStateMap[dstNet.String()] = &netlink.Route{Dst: dstNet, LinkIndex: 5}
+			}
+			_, otherNet, _ := net.ParseCIDR("10.244.2.0/24")
+			syncer.routeTableStateMap[otherNet.String()] = &netlink.Route{Dst: otherNet, LinkIndex: 6}
+			var reinjected []string
+			syncer.routeReinjector = func(dsts []*net.IPNet) {
+				for _, dst := range dsts {
+					reinjected = append(reinjected, dst.String())
+				}
+			}
+			if testcase.ownDeletion {
+				syncer.expectLinkDeletion(5)
+			}
+
+			syncer.handleLinkUpdate(testcase.update)
+
+			assert.Equal(t, testcase.expectedReinject, reinjected)
+			assert.Empty(t, syncer.ownLinkDeletions, "the expected link deletion should be consumed")
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"k8s.io/klog/v2"
+)
+
+const (
+	singleTunnelInterfaceName  = "kube-tunl"
+	singleTunnel6InterfaceName = "kube-tunl6"
+
+	// from https://github.com/torvalds/linux/blob/master/include/uapi/linux/lwtunnel.h, the attribute holding the
+	// destination of the tunnel is the same for both IP families
+	lwtunnelIPDst = 2
+)
+
+// ipTunnelEncap is the lightweight tunnel encapsulation of a route through a collect metadata tunnel device, that is
+// the `encap ip dst <address>` of `ip route`. The netlink library doesn't provide it.
+type ipTunnelEncap struct {
+	Dst net.IP
+}
+
+func (e *ipTunnelEncap) Type() int {
+	if e.Dst.To4() == nil {
+		return nl.LWTUNNEL_ENCAP_IP6
+	}
+	return nl.LWTUNNEL_ENCAP_IP
+}
+
+func (e *ipTunnelEncap) Decode(buf []byte) error {
+	attrs, err := nl.ParseRouteAttr(buf)
+	if err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		if attr.Attr.Type == lwtunnelIPDst {
+			e.Dst = net.IP(attr.Value)
+			return nil
+		}
+	}
+	return errors.New("no tunnel destination in the IP encapsulation")
+}
+
+func (e *ipTunnelEncap) Encode() ([]byte, error) {
+	dst := e.Dst.To4()
+	if dst == nil {
+		dst = e.Dst.To16()
+	}
+	if dst == nil {
+		return nil, fmt.Errorf("invalid tunnel destination %s", e.Dst)
+	}
+	return nl.NewRtAttr(lwtunnelIPDst, dst).Serialize(), nil
+}
+
+func (e *ipTunnelEncap) String() string {
+	return "ip dst " + e.Dst.String()
+}
+
+func (e *ipTunnelEncap) Equal(x netlink.Encap) bool {
+	o, ok := x.(*ipTunnelEncap)
+	if !ok {
+		return false
+	}
+	if e == nil || o == nil {
+		return e == o
+	}
+	return e.Dst.Equal(o.Dst)
+}
+
+// getSingleTunnelInterfaceName returns the name of the collect metadata tunnel device of the given IP family
+func getSingleTunnelInterfaceName(isIpv6 bool) string {
+	if isIpv6 {
+		return singleTunnel6InterfaceName
+	}
+	return singleTunnelInterfaceName
+}
+
+// setupSingleTunnel makes sure the collect metadata tunnel device of the IP family of the next hop exists, routes the
+// traffic from the pods to the node through it and removes the per node tunnel left from before the single tunnel was
+// enabled. The destination of the tunnel is set on each route through the device.
+func (nrc *NetworkRoutingController) setupSingleTunnel(tunnelName string, nextHop net.IP) (netlink.Link, error) {
+	if link, err := netlink.LinkByName(tunnelName); err == nil {
+		klog.Infof("Migrating the routes through tunnel %s to the node %s to the single tunnel device", tunnelName,
+			nextHop)
+		if err = netlink.LinkDel(link); err != nil {
+			klog.Errorf("Failed to delete tunnel link %s: %s", tunnelName, err)
+		}
+	}
+
+	isIpv6 := nextHop.To4() == nil
+	link, err := nrc.ensureSingleTunnelInterface(isIpv6)
+	if err != nil {
+		return nil, fmt.Errorf("route not injected for the route advertised by the node %s: %s", nextHop, err)
+	}
+
+	// like for the per node tunnels, traffic from the pods to the node goes through the overlay
+	if err = nrc.addCustomTableHostRoute(link, nextHop, &ipTunnelEncap{Dst: nextHop}); err != nil {
+		return nil, fmt.Errorf("failed to add route in custom route table, err: %s", err)
+	}
+	return link, nil
+}
+
+// ensureSingleTunnelInterface creates the collect metadata tunnel device of the IP family and brings it up. IPv4 uses
+// an ipip device while IPv6 uses an ip6tnl device in ip6ip6 mode, which the netlink library can't create in collect
+// metadata mode, so `ip` is used for it.
+func (nrc *NetworkRoutingController) ensureSingleTunnelInterface(isIpv6 bool) (netlink.Link, error) {
+	name := getSingleTunnelInterfaceName(isIpv6)
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		localIP := nrc.getNodeIPForFamily(isIpv6)
+		if localIP == nil {
+			return nil, errors.New("the node has no address of the same IP family to create a tunnel from")
+		}
+		mtu, err := utils.GetMTUFromNodeIP(localIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find MTU for node IP %s: %s", localIP, err)
+		}
+		mtu -= getOverlayHeaderLength(overlayEncapIPIP, isIpv6)
+
+		klog.Infof("Creating collect metadata tunnel device %s", name)
+		if isIpv6 {
+			//nolint:gosec // this exec should be safe from command injection given the parameter's context
+			out, err := exec.Command("ip", "-6", "link", "add", "name", name, "mtu", strconv.Itoa(mtu),
+				"type", "ip6tnl", "external", "mode", "ip6ip6").CombinedOutput()
+			if err != nil {
+				return nil, fmt.Errorf("failed to create tunnel device %s, error: %s, output: %s", name, err,
+					strings.TrimSpace(string(out)))
+			}
+		} else {
+			linkAttrs := netlink.NewLinkAttrs()
+			linkAttrs.Name = name
+			linkAttrs.MTU = mtu
+			if err = netlink.LinkAdd(&netlink.Iptun{LinkAttrs: linkAttrs, FlowBased: true}); err != nil {
+				return nil, fmt.Errorf("failed to create tunnel device %s: %s", name, err)
+			}
+		}
+		if link, err = netlink.LinkByName(name); err != nil {
+			return nil, fmt.Errorf("failed to get tunnel device %s: %s", name, err)
+		}
+	}
+
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		if err = netlink.LinkSetUp(link); err != nil {
+			return nil, fmt.Errorf("failed to bring tunnel device %s up: %s", name, err)
+		}
+	}
+	return link, nil
+}
+
+// cleanupSingleTunnelPeer removes the route to the node from the custom route table, the routes to its pod CIDRs are
+// removed along with the other routes of the destination
+func (nrc *NetworkRoutingController) cleanupSingleTunnelPeer(nextHop net.IP) {
+	link, err := nrc.ln.linkByName(getSingleTunnelInterfaceName(nextHop.To4() == nil))
+	if err != nil {
+		return
+	}
+	if err = nrc.delCustomTableHostRoute(link, nextHop); err != nil {
+		klog.Errorf("Failed to delete route to the node %s from the custom route table: %s", nextHop, err)
+	}
+}
+
+// deleteSingleTunnelInterfaces removes the collect metadata tunnel devices, which takes their routes with them
+func deleteSingleTunnelInterfaces() {
+	for _, name := range []string{singleTunnelInterfaceName, singleTunnel6InterfaceName} {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			continue
+		}
+		klog.Infof("Removing tunnel device %s as the overlay doesn't use a single tunnel", name)
+		if err = netlink.LinkDel(link); err != nil {
+			klog.Errorf("Failed to delete tunnel device %s: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package routing
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+func Test_ipTunnelEncap(t *testing.T) {
+	testcases := []struct {
+		name          string
+		dst           string
+		expectedType  int
+		expectedBytes []byte
+	}{
+		{
+			"IPv4 tunnel destination",
+			"10.0.0.2",
+			nl.LWTUNNEL_ENCAP_IP,
+			[]byte{8, 0, 2, 0, 10, 0, 0, 2},
+		},
+		{
+			"IPv6 tunnel destination",
+			"2001:db8::2",
+			nl.LWTUNNEL_ENCAP_IP6,
+			[]byte{20, 0, 2, 0, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x02},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			encap := &ipTunnelEncap{Dst: net.ParseIP(testcase.dst)}
+			if encap.Type() != testcase.expectedType {
+				t.Errorf("expected encapsulation type %d, got %d", testcase.expectedType, encap.Type())
+			}
+			b, err := encap.Encode()
+			if err != nil {
+				t.Fatalf("failed to encode encapsulation: %v", err)
+			}
+			if !bytes.Equal(b, testcase.expectedBytes) {
+				t.Errorf("expected encoded encapsulation %v, got %v", testcase.expectedBytes, b)
+			}
+
+			decoded := &ipTunnelEncap{}
+			if err = decoded.Decode(b); err != nil {
+				t.Fatalf("failed to decode encapsulation: %v", err)
+			}
+			if !decoded.Equal(encap) {
+				t.Errorf("expected decoded encapsulation %s, got %s", encap, decoded)
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package routing
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
@@ -11,7 +13,6 @@ import (
 
 	gobgpapi "github.com/osrg/gobgp/v3/api"
 	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
-	"github.com/vishvananda/netlink/nl"
 	"k8s.io/klog/v2"
 
 	"github.com/vishvananda/netlink"
@@ -129,12 +130,31 @@ func getNodeSubnet(nodeIP net.IP) (net.IPNet, string, error) {
 	return net.IPNet{}, "", errors.New("failed to find interface with specified node ip")
 }
 
+// getPodCIDRForFamily returns the first pod CIDR of the IP family of the node IP, or the first pod CIDR when the
+// node has none of that family
+func getPodCIDRForFamily(cidrs []string, isIpv6 bool) string {
+	for _, cidr := range cidrs {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err == nil && (ip.To4() == nil) == isIpv6 {
+			return cidr
+		}
+	}
+	return cidrs[0]
+}
+
 // generateTunnelName will generate a name for a tunnel interface given a node IP
 // for example, if the node IP is 10.0.0.1 the tunnel interface will be named tun-10001
 // Since linux restricts interface names to 15 characters, if length of a node IP
 // is greater than 12 (after removing "."), then the interface name is tunXYZ
-// as opposed to tun-XYZ
+// as opposed to tun-XYZ. IPv6 addresses neither fit nor are allowed (because of the ":")
+// in an interface name, so for those the interface name is tun6 followed by a hash of the IP
 func generateTunnelName(nodeIP string) string {
+	if strings.Contains(nodeIP, ":") {
+		sum := sha256.Sum256([]byte(nodeIP))
+		//nolint:gomnd // this number becomes less obvious when made a constant
+		return "tun6" + hex.EncodeToString(sum[:])[:11]
+	}
+
 	hash := strings.ReplaceAll(nodeIP, ".", "")
 
 	//nolint:gomnd // this number becomes less obvious when made a constant
@@ -154,7 +174,7 @@ func validateCommunity(arg string) error {
 		return nil
 	}
 
-	_regexpCommunity := regexp.MustCompile(`(\d+):(\d+)`)
+	_regexpCommunity := regexp.MustCompile(`^(\d+):(\d+)$`)
 	elems := _regexpCommunity.FindStringSubmatch(arg)
 	if len(elems) == 3 {
 		if _, err := strconv.ParseUint(elems[1], 10, bgpCommunityMaxPartSize); err == nil {
@@ -171,29 +191,54 @@ func validateCommunity(arg string) error {
 	return fmt.Errorf("failed to parse %s as community", arg)
 }
 
-// parseBGPNextHop takes in a GoBGP Path and parses out the destination's next hop from its attributes. If it
-// can't parse a next hop IP from the GoBGP Path, it returns an error.
+// validateLargeCommunity takes in a string and attempts to parse a BGP large community (RFC 8092) out of it, in the
+// ASN:LocalData1:LocalData2 format gobgp expects. If it is not able to parse the large community it returns an error.
+func validateLargeCommunity(arg string) error {
+	elems := strings.Split(arg, ":")
+	if len(elems) != 3 {
+		return fmt.Errorf("failed to parse %s as large community", arg)
+	}
+	for _, elem := range elems {
+		if _, err := strconv.ParseUint(elem, 10, bgpCommunityMaxSize); err != nil {
+			return fmt.Errorf("failed to parse %s as large community", arg)
+		}
+	}
+	return nil
+}
+
+// parseBGPNextHop takes in a GoBGP Path and parses out the destination's next hop from its attributes, GoBGP holds
+// the next hop of the IPv4 paths in the NEXT_HOP attribute and the one of the IPv6 paths in the MP_REACH_NLRI
+// attribute. If it can't parse a next hop IP from the GoBGP Path, it returns an error.
 func parseBGPNextHop(path *gobgpapi.Path) (net.IP, error) {
 	for _, pAttr := range path.GetPattrs() {
 		unmarshalNew, err := pAttr.UnmarshalNew()
 		if err != nil {
 			return nil, fmt.Errorf("failed to unmarshal path attribute: %s", err)
 		}
-		//nolint:gocritic // this is the correct way to use a switch statement regardless of how many case statements
 		switch t := unmarshalNew.(type) {
 		case *gobgpapi.NextHopAttribute:
-			nextHop := net.ParseIP(t.NextHop).To4()
-			if nextHop == nil {
-				if nextHop = net.ParseIP(t.NextHop).To16(); nextHop == nil {
-					return nil, fmt.Errorf("invalid nextHop address: %s", t.NextHop)
-				}
+			return parseNextHopIP(t.NextHop)
+		case *gobgpapi.MpReachNLRIAttribute:
+			if len(t.NextHops) == 0 {
+				return nil, fmt.Errorf("no next hop in the MP_REACH_NLRI attribute of path: %s", path)
 			}
-			return nextHop, nil
+			return parseNextHopIP(t.NextHops[0])
 		}
 	}
 	return nil, fmt.Errorf("could not parse next hop received from GoBGP for path: %s", path)
 }
 
+// parseNextHopIP parses a next hop received from GoBGP, returning IPv4 addresses in their 4 bytes form
+func parseNextHopIP(address string) (net.IP, error) {
+	nextHop := net.ParseIP(address).To4()
+	if nextHop == nil {
+		if nextHop = net.ParseIP(address).To16(); nextHop == nil {
+			return nil, fmt.Errorf("invalid nextHop address: %s", address)
+		}
+	}
+	return nextHop, nil
+}
+
 // parseBGPPath takes in a GoBGP Path and parses out the destination subnet and the next hop from its attributes.
 // If successful, it will return the destination of the BGP path as a subnet form and the next hop. If it
 // can't parse the destination or the next hop IP, it returns an error.
@@ -217,16 +262,18 @@ func parseBGPPath(path *gobgpapi.Path) (*net.IPNet, net.IP, error) {
 }
 
 // deleteRoutesByDestination attempts to safely find all routes based upon its destination subnet and delete them
-func deleteRoutesByDestination(destinationSubnet *net.IPNet) error {
-	routes, err := netlink.RouteListFiltered(nl.FAMILY_ALL, &netlink.Route{
-		Dst: destinationSubnet, Protocol: zebraRouteOriginator,
+func deleteRoutesByDestination(ln netlinkCalls, destinationSubnet *net.IPNet) error {
+	routes, err := ln.routeListFiltered(getIPFamily(destinationSubnet.IP), &netlink.Route{
+		Dst: routeFilterDst(destinationSubnet), Protocol: zebraRouteOriginator,
 	}, netlink.RT_FILTER_DST|netlink.RT_FILTER_PROTOCOL)
 	if err != nil {
 		return fmt.Errorf("failed to get routes from netlink: %v", err)
 	}
 	for i, r := range routes {
 		klog.V(2).Infof("Found route to remove: %s", r.String())
-		if err = netlink.RouteDel(&routes[i]); err != nil {
+		// netlink refuses to delete the multipath default routes as listed from the kernel, without destination
+		routes[i].Dst = getRouteDst(&routes[i])
+		if err = ln.routeDel(&routes[i]); err != nil {
 			return fmt.Errorf("failed to remove route due to %v", err)
 		}
 	}
@@ -51,6 +51,15 @@ func Test_stringSliceToIPNets(t *testing.T) {
 	})
 }
 
+func Test_getPodCIDRForFamily(t *testing.T) {
+	dualStack := []string{"10.244.0.0/24", "2001:db8:42::/64"}
+	assert.Equal(t, "10.244.0.0/24", getPodCIDRForFamily(dualStack, false))
+	assert.Equal(t, "2001:db8:42::/64", getPodCIDRForFamily(dualStack, true),
+		"the pod CIDR of the family of an IPv6 node IP should be picked even when it is not the first one")
+	assert.Equal(t, "10.244.0.0/24", getPodCIDRForFamily([]string{"10.244.0.0/24"}, true),
+		"the first pod CIDR should be picked when none is of the family of the node IP")
+}
+
 func Test_validateCommunity(t *testing.T) {
 	t.Run("BGP community specified as a 32-bit integer should pass validation", func(t *testing.T) {
 		assert.Nil(t, validateCommunity("4294967041"))
@@ -81,4 +90,24 @@ func Test_validateCommunity(t *testing.T) {
 		assert.Error(t, validateCommunity("0xFFFFFFFF"))
 		assert.Error(t, validateCommunity("community"))
 	})
+	t.Run("BGP large community should fail validation", func(t *testing.T) {
+		assert.Error(t, validateCommunity("65535:65535:65535"))
+	})
+}
+
+func Test_validateLargeCommunity(t *testing.T) {
+	t.Run("BGP large community specified as 3 32-bit integers should pass validation", func(t *testing.T) {
+		assert.Nil(t, validateLargeCommunity("65000:1:2"))
+		assert.Nil(t, validateLargeCommunity("4294967295:4294967295:4294967295"))
+	})
+	t.Run("BGP large community that is greater than 3 32-bit integers should fail validation", func(t *testing.T) {
+		assert.Error(t, validateLargeCommunity("4294967296:1:2"))
+		assert.Error(t, validateLargeCommunity("65000:4294967296:2"))
+		assert.Error(t, validateLargeCommunity("65000:1:4294967296"))
+	})
+	t.Run("BGP large community without 3 parts should fail validation", func(t *testing.T) {
+		assert.Error(t, validateLargeCommunity("65000:1"))
+		assert.Error(t, validateLargeCommunity("65000:1:2:3"))
+		assert.Error(t, validateLargeCommunity("no-export"))
+	})
 }
@@ -0,0 +1,240 @@
+package routing
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+const (
+	overlayEncapIPIP      = "ipip"
+	overlayEncapVXLAN     = "vxlan"
+	overlayEncapWireGuard = "wireguard"
+
+	vxlanInterfaceName  = "kube-vxlan"
+	vxlan6InterfaceName = "kube-vxlan6"
+	vxlanID             = 1
+
+	// outer IP, UDP, VXLAN and inner ethernet headers
+	vxlanHeaderLength  = 50
+	vxlan6HeaderLength = 70
+	ip6ip6HeaderLength = 40
+)
+
+// getVXLANInterfaceName returns the name of the VXLAN interface carrying the overlay traffic of the given IP family
+func getVXLANInterfaceName(isIpv6 bool) string {
+	if isIpv6 {
+		return vxlan6InterfaceName
+	}
+	return vxlanInterfaceName
+}
+
+// generateVXLANMAC returns the MAC address of the VXLAN interface of the node with the given IP. It is derived from the
+// node IP so that every node can compute the address of its peers without it having to be published. The address is
+// locally administered and unicast, IPv4 addresses are mapped directly while IPv6 addresses are hashed.
+func generateVXLANMAC(nodeIP net.IP) net.HardwareAddr {
+	if ip4 := nodeIP.To4(); ip4 != nil {
+		return net.HardwareAddr{0x0a, 0x58, ip4[0], ip4[1], ip4[2], ip4[3]}
+	}
+	sum := sha256.Sum256(nodeIP.To16())
+	return net.HardwareAddr{0x0a, sum[0], sum[1], sum[2], sum[3], sum[4]}
+}
+
+// getOverlayHeaderLength returns the number of bytes the overlay encapsulation adds to packets sent over an underlay of
+// the given IP family
+func getOverlayHeaderLength(overlayEncap string, isIpv6 bool) int {
+	switch {
+	case overlayEncap == overlayEncapVXLAN && isIpv6:
+		return vxlan6HeaderLength
+	case overlayEncap == overlayEncapVXLAN:
+		return vxlanHeaderLength
+	case overlayEncap == overlayEncapWireGuard && isIpv6:
+		return wireGuard6HeaderLength
+	case overlayEncap == overlayEncapWireGuard:
+		return wireGuardHeaderLength
+	case isIpv6:
+		return ip6ip6HeaderLength
+	default:
+		return utils.IPInIPHeaderLength
+	}
+}
+
+// getPodMTU returns the largest MTU pods can use, that is the MTU of the node interface minus the overhead of the
+// overlay encapsulation when overlays are enabled. On dual-stack nodes the overhead of the largest underlay is used.
+func (nrc *NetworkRoutingController) getPodMTU() (int, error) {
+	mtu, err := utils.GetMTUFromNodeIP(nrc.nodeIP)
+	if err != nil {
+		return 0, err
+	}
+	if !nrc.enableOverlays {
+		return mtu, nil
+	}
+	overhead := getOverlayHeaderLength(nrc.overlayEncap, nrc.isIpv6)
+	if nrc.nodeSecondaryIP != nil {
+		if secondaryOverhead := getOverlayHeaderLength(nrc.overlayEncap, !nrc.isIpv6); secondaryOverhead > overhead {
+			overhead = secondaryOverhead
+		}
+	}
+	return mtu - overhead, nil
+}
+
+// setupVXLANOverlay makes sure the VXLAN interface of the IP family of the next hop exists and holds the forwarding
+// database and neighbor entries sending the traffic routed through the next hop to its node. The per node IPIP tunnel
+// is removed if there is one left from a previous IPIP overlay.
+func (nrc *NetworkRoutingController) setupVXLANOverlay(tunnelName string, nextHop net.IP) (netlink.Link, error) {
+	if link, err := netlink.LinkByName(tunnelName); err == nil {
+		klog.Infof("Removing IPIP tunnel %s to the node %s as the overlay uses VXLAN", tunnelName, nextHop)
+		if err = netlink.LinkDel(link); err != nil {
+			klog.Errorf("Failed to delete tunnel link %s: %s", tunnelName, err)
+		}
+	}
+
+	isIpv6 := nextHop.To4() == nil
+	link, err := nrc.ensureVXLANInterface(isIpv6)
+	if err != nil {
+		return nil, fmt.Errorf("route not injected for the route advertised by the node %s: %s", nextHop, err)
+	}
+
+	family := getIPFamily(nextHop)
+	mac := generateVXLANMAC(nextHop)
+	// the forwarding database entry sends the frames for the node to its underlay address
+	err = netlink.NeighSet(&netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       syscall.AF_BRIDGE,
+		Flags:        netlink.NTF_SELF,
+		State:        netlink.NUD_PERMANENT,
+		IP:           nextHop,
+		HardwareAddr: mac,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add forwarding database entry for the node %s: %s", nextHop, err)
+	}
+	// the neighbor entry resolves the next hop of the routes over the VXLAN interface to the MAC of the node
+	err = netlink.NeighSet(&netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       family,
+		State:        netlink.NUD_PERMANENT,
+		IP:           nextHop,
+		HardwareAddr: mac,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add neighbor entry for the node %s: %s", nextHop, err)
+	}
+
+	// like for the IPIP tunnels, traffic from the pods to the node goes through the overlay
+	if err = nrc.addCustomTableHostRoute(link, nextHop, nil); err != nil {
+		return nil, fmt.Errorf("failed to add route in custom route table, err: %s", err)
+	}
+
+	return link, nil
+}
+
+// ensureVXLANInterface creates the VXLAN interface of the IP family and brings it up. An existing interface with
+// different settings, for instance after the node address changed, is recreated.
+func (nrc *NetworkRoutingController) ensureVXLANInterface(isIpv6 bool) (netlink.Link, error) {
+	name := getVXLANInterfaceName(isIpv6)
+	localIP := nrc.getNodeIPForFamily(isIpv6)
+	if localIP == nil {
+		return nil, fmt.Errorf("the node has no address of the same IP family to send VXLAN traffic from")
+	}
+	nodeInterface := nrc.nodeInterface
+	if nrc.nodeSecondaryIP != nil && isIpv6 != nrc.isIpv6 {
+		nodeInterface = nrc.nodeSecondaryInterface
+	}
+
+	vxlan := &netlink.Vxlan{
+		LinkAttrs: netlink.LinkAttrs{Name: name, HardwareAddr: generateVXLANMAC(localIP)},
+		VxlanId:   vxlanID,
+		SrcAddr:   localIP,
+		Port:      int(nrc.vxlanPort),
+	}
+	if mtu, err := utils.GetMTUFromNodeIP(localIP); err == nil {
+		vxlan.MTU = mtu - getOverlayHeaderLength(overlayEncapVXLAN, isIpv6)
+	}
+	// need to skip binding device if the node interface is loopback, otherwise packets never leave from egress
+	// interface to the peer
+	if nodeInterface != "lo" {
+		if nodeLink, err := netlink.LinkByName(nodeInterface); err == nil {
+			vxlan.VtepDevIndex = nodeLink.Attrs().Index
+		}
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		existing, ok := link.(*netlink.Vxlan)
+		if ok && existing.VxlanId == vxlan.VxlanId && existing.SrcAddr.Equal(vxlan.SrcAddr) &&
+			existing.Port == vxlan.Port && existing.VtepDevIndex == vxlan.VtepDevIndex &&
+			existing.HardwareAddr.String() == vxlan.HardwareAddr.String() {
+			if existing.Flags&net.FlagUp == 0 {
+				if err = netlink.LinkSetUp(link); err != nil {
+					return nil, fmt.Errorf("failed to bring VXLAN interface %s up: %s", name, err)
+				}
+			}
+			return link, nil
+		}
+		klog.Infof("Recreating VXLAN interface %s as its settings changed", name)
+		nrc.routeSyncer.expectLinkDeletion(link.Attrs().Index)
+		if err = netlink.LinkDel(link); err != nil {
+			return nil, fmt.Errorf("failed to delete VXLAN interface %s: %s", name, err)
+		}
+	}
+
+	klog.Infof("Creating VXLAN interface %s from %s on port %d", name, localIP, nrc.vxlanPort)
+	if err = netlink.LinkAdd(vxlan); err != nil {
+		return nil, fmt.Errorf("failed to create VXLAN interface %s: %s", name, err)
+	}
+	link, err = netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VXLAN interface %s: %s", name, err)
+	}
+	if err = netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to bring VXLAN interface %s up: %s", name, err)
+	}
+	return link, nil
+}
+
+// cleanupVXLANPeer removes the forwarding database and neighbor entries of the node from the VXLAN interface, along
+// with its route in the custom route table
+func (nrc *NetworkRoutingController) cleanupVXLANPeer(nextHop net.IP) {
+	isIpv6 := nextHop.To4() == nil
+	link, err := nrc.ln.linkByName(getVXLANInterfaceName(isIpv6))
+	if err != nil {
+		return
+	}
+	klog.V(1).Infof("Cleaning up any lingering VXLAN entries for the node %s", nextHop)
+
+	family := getIPFamily(nextHop)
+	mac := generateVXLANMAC(nextHop)
+	for _, neigh := range []*netlink.Neigh{
+		{LinkIndex: link.Attrs().Index, Family: syscall.AF_BRIDGE, Flags: netlink.NTF_SELF, IP: nextHop,
+			HardwareAddr: mac},
+		{LinkIndex: link.Attrs().Index, Family: family, IP: nextHop, HardwareAddr: mac},
+	} {
+		if err = netlink.NeighDel(neigh); err != nil && !isNotExistError(err) {
+			klog.Errorf("Failed to delete VXLAN entry for the node %s: %s", nextHop, err)
+		}
+	}
+
+	if err = nrc.delCustomTableHostRoute(link, nextHop); err != nil {
+		klog.Errorf("Failed to delete route to the node %s from the custom route table: %s", nextHop, err)
+	}
+}
+
+// deleteVXLANInterfaces removes the VXLAN interfaces, which takes all their entries and routes with them
+func deleteVXLANInterfaces() {
+	for _, name := range []string{vxlanInterfaceName, vxlan6InterfaceName} {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			continue
+		}
+		klog.Infof("Removing VXLAN interface %s as the overlay doesn't use VXLAN", name)
+		if err = netlink.LinkDel(link); err != nil {
+			klog.Errorf("Failed to delete VXLAN interface %s: %s", name, err)
+		}
+	}
+}
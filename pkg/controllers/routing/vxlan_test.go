@@ -0,0 +1,75 @@
+package routing
+
+import (
+	"net"
+	"testing"
+)
+
+func Test_generateVXLANMAC(t *testing.T) {
+	testcases := []struct {
+		name        string
+		nodeIP      string
+		expectedMAC string
+	}{
+		{
+			"IPv4 node IP is mapped into the MAC",
+			"10.0.0.1",
+			"0a:58:0a:00:00:01",
+		},
+		{
+			"IPv4-mapped IPv6 node IP is treated as IPv4",
+			"::ffff:192.168.1.20",
+			"0a:58:c0:a8:01:14",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			mac := generateVXLANMAC(net.ParseIP(testcase.nodeIP))
+			if mac.String() != testcase.expectedMAC {
+				t.Errorf("expected MAC %s for node IP %s, got %s", testcase.expectedMAC, testcase.nodeIP, mac)
+			}
+		})
+	}
+
+	t.Run("IPv6 node IPs get distinct locally administered unicast MACs", func(t *testing.T) {
+		macA := generateVXLANMAC(net.ParseIP("2001:db8::1"))
+		macB := generateVXLANMAC(net.ParseIP("2001:db8::2"))
+		if macA.String() == macB.String() {
+			t.Errorf("expected different MACs for different node IPs, got %s", macA)
+		}
+		for _, mac := range []net.HardwareAddr{macA, macB} {
+			if mac[0]&0x02 == 0 || mac[0]&0x01 != 0 {
+				t.Errorf("expected a locally administered unicast MAC, got %s", mac)
+			}
+		}
+		if again := generateVXLANMAC(net.ParseIP("2001:db8::1")); again.String() != macA.String() {
+			t.Errorf("expected the MAC to be stable, got %s and %s", macA, again)
+		}
+	})
+}
+
+func Test_getOverlayHeaderLength(t *testing.T) {
+	testcases := []struct {
+		name           string
+		overlayEncap   string
+		isIpv6         bool
+		expectedLength int
+	}{
+		{"IPIP over IPv4", overlayEncapIPIP, false, 20},
+		{"ip6ip6 over IPv6", overlayEncapIPIP, true, 40},
+		{"VXLAN over IPv4", overlayEncapVXLAN, false, 50},
+		{"VXLAN over IPv6", overlayEncapVXLAN, true, 70},
+		{"WireGuard over IPv4", overlayEncapWireGuard, false, 60},
+		{"WireGuard over IPv6", overlayEncapWireGuard, true, 80},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			length := getOverlayHeaderLength(testcase.overlayEncap, testcase.isIpv6)
+			if length != testcase.expectedLength {
+				t.Errorf("expected header length %d, got %d", testcase.expectedLength, length)
+			}
+		})
+	}
+}
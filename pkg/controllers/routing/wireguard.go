@@ -0,0 +1,331 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	wireGuardInterfaceName = "kube-wg"
+
+	// outer IP, UDP and WireGuard headers
+	wireGuardHeaderLength  = 60
+	wireGuard6HeaderLength = 80
+)
+
+// initWireGuard creates the WireGuard interface, publishes the public key of the node and configures the peers. The
+// private key is kept on the interface so that it survives restarts of kube-router, a new one is only generated when
+// the interface doesn't have one yet.
+func (nrc *NetworkRoutingController) initWireGuard() error {
+	nrc.wireGuardMu.Lock()
+	link, err := nrc.ensureWireGuardInterface()
+	if err != nil {
+		nrc.wireGuardMu.Unlock()
+		return err
+	}
+
+	client, err := wgctrl.New()
+	if err != nil {
+		nrc.wireGuardMu.Unlock()
+		return fmt.Errorf("failed to open WireGuard control client: %s", err)
+	}
+	device, err := client.Device(link.Attrs().Name)
+	_ = client.Close()
+	if err != nil {
+		nrc.wireGuardMu.Unlock()
+		return fmt.Errorf("failed to get WireGuard interface %s: %s", wireGuardInterfaceName, err)
+	}
+	if device.PrivateKey == (wgtypes.Key{}) {
+		klog.Infof("Generating the WireGuard key of the node")
+		if device.PrivateKey, err = wgtypes.GeneratePrivateKey(); err != nil {
+			nrc.wireGuardMu.Unlock()
+			return fmt.Errorf("failed to generate WireGuard key: %s", err)
+		}
+	}
+	nrc.wireGuardKey = device.PrivateKey
+	nrc.wireGuardKeyTime = time.Now()
+	nrc.wireGuardMu.Unlock()
+
+	nrc.syncWireGuardPeers()
+	return nrc.publishWireGuardPublicKey()
+}
+
+// rotateWireGuardKey replaces the WireGuard key of the node once it is older than the rotation period. The peers pick
+// up the new public key from the node annotation, the traffic is dropped until they do.
+func (nrc *NetworkRoutingController) rotateWireGuardKey() error {
+	nrc.wireGuardMu.Lock()
+	if nrc.wireGuardKeyRotationPeriod == 0 || time.Since(nrc.wireGuardKeyTime) < nrc.wireGuardKeyRotationPeriod {
+		nrc.wireGuardMu.Unlock()
+		return nil
+	}
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		nrc.wireGuardMu.Unlock()
+		return fmt.Errorf("failed to generate WireGuard key: %s", err)
+	}
+	klog.Infof("Rotating the WireGuard key of the node")
+	nrc.wireGuardKey = key
+	nrc.wireGuardKeyTime = time.Now()
+	nrc.wireGuardMu.Unlock()
+
+	nrc.syncWireGuardPeers()
+	return nrc.publishWireGuardPublicKey()
+}
+
+// publishWireGuardPublicKey sets the public key of the node in its annotation, for the other nodes to peer with it
+func (nrc *NetworkRoutingController) publishWireGuardPublicKey() error {
+	nrc.wireGuardMu.Lock()
+	publicKey := nrc.wireGuardKey.PublicKey().String()
+	nrc.wireGuardMu.Unlock()
+
+	node, err := nrc.clientset.CoreV1().Nodes().Get(context.Background(), nrc.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s to publish its WireGuard public key: %s", nrc.nodeName, err)
+	}
+	if node.Annotations[wireGuardPublicKeyAnnotation] == publicKey {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{wireGuardPublicKeyAnnotation: publicKey},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = nrc.clientset.CoreV1().Nodes().Patch(context.Background(), nrc.nodeName, types.MergePatchType, patch,
+		metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to publish the WireGuard public key of node %s: %s", nrc.nodeName, err)
+	}
+	klog.Infof("Published WireGuard public key %s of the node", publicKey)
+	return nil
+}
+
+// ensureWireGuardInterface creates the WireGuard interface if it doesn't exist yet and brings it up. It must be called
+// with the wireGuardMu lock held.
+func (nrc *NetworkRoutingController) ensureWireGuardInterface() (netlink.Link, error) {
+	link, err := netlink.LinkByName(wireGuardInterfaceName)
+	if err != nil {
+		klog.Infof("Creating WireGuard interface %s listening on port %d", wireGuardInterfaceName, nrc.wireGuardPort)
+		wg := &netlink.Wireguard{LinkAttrs: netlink.LinkAttrs{Name: wireGuardInterfaceName}}
+		if err = netlink.LinkAdd(wg); err != nil {
+			return nil, fmt.Errorf("failed to create WireGuard interface %s: %s", wireGuardInterfaceName, err)
+		}
+		if link, err = netlink.LinkByName(wireGuardInterfaceName); err != nil {
+			return nil, fmt.Errorf("failed to get WireGuard interface %s: %s", wireGuardInterfaceName, err)
+		}
+	}
+
+	if mtu, err := nrc.getPodMTU(); err == nil && link.Attrs().MTU != mtu {
+		if err = netlink.LinkSetMTU(link, mtu); err != nil {
+			klog.Errorf("Failed to set MTU of WireGuard interface %s: %s", wireGuardInterfaceName, err)
+		}
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		if err = netlink.LinkSetUp(link); err != nil {
+			return nil, fmt.Errorf("failed to bring WireGuard interface %s up: %s", wireGuardInterfaceName, err)
+		}
+	}
+	return link, nil
+}
+
+// getWireGuardPeerConfigs returns a WireGuard peer for every node that published its public key and that the overlay
+// is used with according to the overlay type. The traffic to the pod CIDRs and the node IPs of the node goes through
+// its peer.
+func (nrc *NetworkRoutingController) getWireGuardPeerConfigs(nodes []*v1core.Node) []wgtypes.PeerConfig {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+
+	peers := make([]wgtypes.PeerConfig, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Name == nrc.nodeName {
+			continue
+		}
+		publicKey, ok := node.Annotations[wireGuardPublicKeyAnnotation]
+		if !ok {
+			klog.V(2).Infof("Node %s has not published a WireGuard public key yet", node.Name)
+			continue
+		}
+		key, err := wgtypes.ParseKey(publicKey)
+		if err != nil {
+			klog.Warningf("Invalid WireGuard public key in the %s annotation of node %s: %s",
+				wireGuardPublicKeyAnnotation, node.Name, err)
+			continue
+		}
+
+		// the endpoint is the node IP of the primary IP family of this node, or of the other one if it has none
+		endpointIP, err := utils.GetNodeIPByFamily(node, nrc.isIpv6)
+		if err != nil && nrc.nodeSecondaryIP != nil {
+			endpointIP, err = utils.GetNodeIPByFamily(node, !nrc.isIpv6)
+		}
+		if err != nil {
+			klog.Warningf("Couldn't determine a node IP of node %s to send WireGuard traffic to", node.Name)
+			continue
+		}
+		nodeSubnet := nrc.getNodeSubnetForFamily(endpointIP.To4() == nil)
+		if !nrc.isOverlayRequired(nodeSubnet.Contains(endpointIP)) {
+			continue
+		}
+
+		var allowedIPs []net.IPNet
+		podCIDRs, err := utils.GetPodCIDRsFromNodeSpec(node)
+		if err != nil {
+			klog.Warningf("Couldn't determine PodCIDR of the %v node: %v", node.Name, err)
+		}
+		for _, podCIDR := range podCIDRs {
+			if _, cidr, err := net.ParseCIDR(podCIDR); err == nil {
+				allowedIPs = append(allowedIPs, *cidr)
+			}
+		}
+		for _, isIpv6 := range []bool{false, true} {
+			if nodeIP, err := utils.GetNodeIPByFamily(node, isIpv6); err == nil {
+				allowedIPs = append(allowedIPs, *getHostIPNet(nodeIP))
+			}
+		}
+
+		peers = append(peers, wgtypes.PeerConfig{
+			PublicKey:         key,
+			Endpoint:          &net.UDPAddr{IP: endpointIP, Port: int(nrc.wireGuardPort)},
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        allowedIPs,
+		})
+	}
+	return peers
+}
+
+// syncWireGuardPeers configures the key of the node on the WireGuard interface along with a peer for every node. Peers
+// are updated in place so that their sessions are kept, the ones of nodes that are gone are removed.
+func (nrc *NetworkRoutingController) syncWireGuardPeers() {
+	if !nrc.enableOverlays || nrc.overlayEncap != overlayEncapWireGuard {
+		return
+	}
+
+	nodes := make([]*v1core.Node, 0)
+	for _, obj := range nrc.nodeLister.List() {
+		nodes = append(nodes, obj.(*v1core.Node))
+	}
+	peers := nrc.getWireGuardPeerConfigs(nodes)
+
+	nrc.wireGuardMu.Lock()
+	defer nrc.wireGuardMu.Unlock()
+	if nrc.wireGuardKey == (wgtypes.Key{}) {
+		return
+	}
+	if _, err := nrc.ensureWireGuardInterface(); err != nil {
+		klog.Errorf("Failed to sync WireGuard peers: %s", err)
+		return
+	}
+
+	client, err := wgctrl.New()
+	if err != nil {
+		klog.Errorf("Failed to open WireGuard control client: %s", err)
+		return
+	}
+	defer func() { _ = client.Close() }()
+	device, err := client.Device(wireGuardInterfaceName)
+	if err != nil {
+		klog.Errorf("Failed to get WireGuard interface %s: %s", wireGuardInterfaceName, err)
+		return
+	}
+
+	desired := make(map[wgtypes.Key]bool, len(peers))
+	peerIPs := make(map[string]bool)
+	for _, peer := range peers {
+		desired[peer.PublicKey] = true
+		for _, allowedIP := range peer.AllowedIPs {
+			peerIPs[allowedIP.String()] = true
+		}
+	}
+	for _, peer := range device.Peers {
+		if !desired[peer.PublicKey] {
+			klog.V(1).Infof("Removing WireGuard peer %s", peer.PublicKey)
+			peers = append(peers, wgtypes.PeerConfig{PublicKey: peer.PublicKey, Remove: true})
+		}
+	}
+
+	port := int(nrc.wireGuardPort)
+	err = client.ConfigureDevice(wireGuardInterfaceName, wgtypes.Config{
+		PrivateKey: &nrc.wireGuardKey,
+		ListenPort: &port,
+		Peers:      peers,
+	})
+	if err != nil {
+		klog.Errorf("Failed to configure WireGuard interface %s: %s", wireGuardInterfaceName, err)
+		return
+	}
+	nrc.wireGuardPeerIPs = peerIPs
+}
+
+// setupWireGuardOverlay makes sure the traffic routed to the next hop goes through the WireGuard interface, the per
+// node IPIP tunnel is removed if there is one left from a previous IPIP overlay. The route is set up even when the
+// node has no peer yet, so that the traffic is dropped rather than sent in the clear until it publishes its key.
+func (nrc *NetworkRoutingController) setupWireGuardOverlay(tunnelName string, nextHop net.IP) (netlink.Link, error) {
+	if link, err := netlink.LinkByName(tunnelName); err == nil {
+		klog.Infof("Removing IPIP tunnel %s to the node %s as the overlay uses WireGuard", tunnelName, nextHop)
+		if err = netlink.LinkDel(link); err != nil {
+			klog.Errorf("Failed to delete tunnel link %s: %s", tunnelName, err)
+		}
+	}
+
+	nrc.wireGuardMu.Lock()
+	link, err := nrc.ensureWireGuardInterface()
+	hasPeer := nrc.wireGuardPeerIPs[getHostIPNet(nextHop).String()]
+	nrc.wireGuardMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("route not injected for the route advertised by the node %s: %s", nextHop, err)
+	}
+	if !hasPeer {
+		nrc.syncWireGuardPeers()
+		nrc.wireGuardMu.Lock()
+		hasPeer = nrc.wireGuardPeerIPs[getHostIPNet(nextHop).String()]
+		nrc.wireGuardMu.Unlock()
+		if !hasPeer {
+			klog.Warningf("No WireGuard peer for the node %s, its traffic is dropped until it publishes its key",
+				nextHop)
+		}
+	}
+
+	// like for the IPIP tunnels, traffic from the pods to the node goes through the overlay
+	if err = nrc.addCustomTableHostRoute(link, nextHop, nil); err != nil {
+		return nil, fmt.Errorf("failed to add route in custom route table, err: %s", err)
+	}
+	return link, nil
+}
+
+// cleanupWireGuardPeer removes the route to the node from the custom route table, its peer is removed along with the
+// node
+func (nrc *NetworkRoutingController) cleanupWireGuardPeer(nextHop net.IP) {
+	link, err := nrc.ln.linkByName(wireGuardInterfaceName)
+	if err != nil {
+		return
+	}
+	if err = nrc.delCustomTableHostRoute(link, nextHop); err != nil {
+		klog.Errorf("Failed to delete route to the node %s from the custom route table: %s", nextHop, err)
+	}
+}
+
+// deleteWireGuardInterface removes the WireGuard interface, which takes its peers and routes with it
+func deleteWireGuardInterface() {
+	link, err := netlink.LinkByName(wireGuardInterfaceName)
+	if err != nil {
+		return
+	}
+	klog.Infof("Removing WireGuard interface %s as the overlay doesn't use WireGuard", wireGuardInterfaceName)
+	if err = netlink.LinkDel(link); err != nil {
+		klog.Errorf("Failed to delete WireGuard interface %s: %s", wireGuardInterfaceName, err)
+	}
+}
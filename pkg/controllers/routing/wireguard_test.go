@@ -0,0 +1,129 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newWireGuardTestNode(name, nodeIP string, podCIDRs []string, publicKey string) *v1core.Node {
+	node := &v1core.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}},
+		Spec:       v1core.NodeSpec{PodCIDRs: podCIDRs},
+		Status: v1core.NodeStatus{Addresses: []v1core.NodeAddress{
+			{Type: v1core.NodeInternalIP, Address: nodeIP},
+		}},
+	}
+	if len(podCIDRs) > 0 {
+		node.Spec.PodCIDR = podCIDRs[0]
+	}
+	if publicKey != "" {
+		node.Annotations[wireGuardPublicKeyAnnotation] = publicKey
+	}
+	return node
+}
+
+func newWireGuardTestKey(t *testing.T) wgtypes.Key {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate WireGuard key: %v", err)
+	}
+	return key.PublicKey()
+}
+
+func Test_getWireGuardPeerConfigs(t *testing.T) {
+	keyA, keyB, keyC := newWireGuardTestKey(t), newWireGuardTestKey(t), newWireGuardTestKey(t)
+	nodes := func() []*v1core.Node {
+		return []*v1core.Node{
+			newWireGuardTestNode("node-1", "10.0.0.1", []string{"172.20.1.0/24"}, keyA.String()),
+			newWireGuardTestNode("node-2", "10.0.0.2", []string{"172.20.2.0/24"}, keyB.String()),
+			newWireGuardTestNode("node-3", "10.0.1.3", []string{"172.20.3.0/24"}, keyC.String()),
+			newWireGuardTestNode("node-4", "10.0.1.4", []string{"172.20.4.0/24"}, ""),
+			newWireGuardTestNode("node-5", "10.0.1.5", []string{"172.20.5.0/24"}, "not-a-key"),
+		}
+	}
+	peer := func(key wgtypes.Key, nodeIP, podCIDR string) wgtypes.PeerConfig {
+		_, cidr, _ := net.ParseCIDR(podCIDR)
+		return wgtypes.PeerConfig{
+			PublicKey:         key,
+			Endpoint:          &net.UDPAddr{IP: net.ParseIP(nodeIP), Port: 51820},
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        []net.IPNet{*cidr, *getHostIPNet(net.ParseIP(nodeIP))},
+		}
+	}
+
+	testcases := []struct {
+		name          string
+		overlayType   string
+		expectedPeers []wgtypes.PeerConfig
+	}{
+		{
+			"only nodes in other subnets are peers with the subnet overlay type",
+			"subnet",
+			[]wgtypes.PeerConfig{peer(keyC, "10.0.1.3", "172.20.3.0/24")},
+		},
+		{
+			"all the nodes are peers with the full overlay type",
+			"full",
+			[]wgtypes.PeerConfig{
+				peer(keyB, "10.0.0.2", "172.20.2.0/24"),
+				peer(keyC, "10.0.1.3", "172.20.3.0/24"),
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			nrc := &NetworkRoutingController{
+				nodeName:       "node-1",
+				nodeIP:         net.ParseIP("10.0.0.1"),
+				nodeSubnet:     net.IPNet{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(24, 32)},
+				enableOverlays: true,
+				overlayType:    testcase.overlayType,
+				overlayEncap:   overlayEncapWireGuard,
+				wireGuardPort:  51820,
+			}
+			peers := nrc.getWireGuardPeerConfigs(nodes())
+			if !reflect.DeepEqual(peers, testcase.expectedPeers) {
+				t.Logf("expected peers: %+v", testcase.expectedPeers)
+				t.Logf("actual peers: %+v", peers)
+				t.Error("did not get expected WireGuard peers")
+			}
+		})
+	}
+}
+
+func Test_publishWireGuardPublicKey(t *testing.T) {
+	privateKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate WireGuard key: %v", err)
+	}
+	node := newWireGuardTestNode("node-1", "10.0.0.1", []string{"172.20.1.0/24"}, "")
+	node.Annotations[nodeASNAnnotation] = "65000"
+	nrc := &NetworkRoutingController{
+		nodeName:     "node-1",
+		clientset:    fake.NewSimpleClientset(node),
+		wireGuardKey: privateKey,
+	}
+
+	if err = nrc.publishWireGuardPublicKey(); err != nil {
+		t.Fatalf("failed to publish WireGuard public key: %v", err)
+	}
+	node, err = nrc.clientset.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+	expectedAnnotations := map[string]string{
+		nodeASNAnnotation:            "65000",
+		wireGuardPublicKeyAnnotation: privateKey.PublicKey().String(),
+	}
+	if !reflect.DeepEqual(node.Annotations, expectedAnnotations) {
+		t.Errorf("expected node annotations %v, got %v", expectedAnnotations, node.Annotations)
+	}
+}
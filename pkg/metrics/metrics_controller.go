@@ -88,6 +88,18 @@ var (
 		Name:      "service_bps_out",
 		Help:      "Outgoing bytes per second",
 	}, []string{"svc_namespace", "service_name", "service_vip", "protocol", "port"})
+	// ServiceEndpointHealthy Result of the active health check of a service endpoint
+	ServiceEndpointHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_endpoint_healthy",
+		Help:      "Whether the service endpoint passes its active health check (1) or not (0)",
+	}, []string{"svc_namespace", "service_name", "endpoint_ip", "port", "check"})
+	// ServiceEndpointHealthCheckFailures Total failed active health checks of a service endpoint
+	ServiceEndpointHealthCheckFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "service_endpoint_health_check_failures",
+		Help:      "Total failed active health checks of the service endpoint",
+	}, []string{"svc_namespace", "service_name", "endpoint_ip", "port", "check"})
 	// ControllerIpvsServices Number of ipvs services in the instance
 	ControllerIpvsServices = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: namespace,
@@ -112,12 +124,82 @@ var (
 		Name:      "controller_routes_sync_time",
 		Help:      "Time it took for controller to sync routes",
 	})
+	// ControllerRoutesStale Stale injected routes and tunnels found by the last route table synchronization
+	ControllerRoutesStale = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_routes_stale",
+		Help:      "Stale injected routes and tunnels found by the last route table synchronization",
+	}, []string{"type"})
+	// ControllerRoutesStaleRemoved Stale injected routes and tunnels removed
+	ControllerRoutesStaleRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_routes_stale_removed",
+		Help:      "Stale injected routes and tunnels removed",
+	}, []string{"type"})
+	// ControllerRoutesTampered Injected routes and tunnels deleted outside of kube-router
+	ControllerRoutesTampered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_routes_tampered",
+		Help:      "Injected routes and tunnels deleted outside of kube-router",
+	}, []string{"type"})
 	// ControllerBPGpeers BGP peers in the runtime configuration
 	ControllerBPGpeers = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: namespace,
 		Name:      "controller_bgp_peers",
 		Help:      "BGP peers in the runtime configuration",
 	})
+	// ControllerBGPPeerSessionState BGP session state of each peer
+	ControllerBGPPeerSessionState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_session_state",
+		Help: "BGP session state of the peer: 1 idle, 2 connect, 3 active, 4 opensent, 5 openconfirm, " +
+			"6 established",
+	}, []string{"peer"})
+	// ControllerBGPPeerUptime Time since the BGP session with each peer was established
+	ControllerBGPPeerUptime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_uptime_seconds",
+		Help:      "Time since the BGP session with the peer was established, 0 when it is not established",
+	}, []string{"peer"})
+	// ControllerBGPPeerFlaps Times the BGP session with each peer went down after being established
+	ControllerBGPPeerFlaps = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_flaps",
+		Help:      "Times the BGP session with the peer went down after being established",
+	}, []string{"peer"})
+	// ControllerBGPPeerNotifications BGP NOTIFICATION messages, which report the errors closing the session, received
+	// from and sent to each peer
+	ControllerBGPPeerNotifications = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_notifications",
+		Help:      "BGP NOTIFICATION messages, reporting the errors closing the session, received from or sent to the peer",
+	}, []string{"peer", "direction"})
+	// ControllerBGPPeerLastNotification Time of the last BGP NOTIFICATION message received from and sent to each peer,
+	// with the error it reported
+	ControllerBGPPeerLastNotification = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_last_notification_timestamp_seconds",
+		Help: "Time of the last BGP NOTIFICATION message received from or sent to the peer, the error code, subcode " +
+			"and description of the message are in the labels",
+	}, []string{"peer", "direction", "code", "subcode", "error"})
+	// ControllerBGPPeerPrefixes Prefixes received from, accepted from and advertised to each peer
+	ControllerBGPPeerPrefixes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_prefixes",
+		Help:      "Prefixes received from, accepted from or advertised to the peer",
+	}, []string{"peer", "type"})
+	// ControllerBGPPeerMaxPrefixes Maximum number of prefixes per address family accepted from each peer
+	ControllerBGPPeerMaxPrefixes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_max_prefixes",
+		Help:      "Maximum number of prefixes per address family accepted from the peer, 0 when it is not limited",
+	}, []string{"peer"})
+	// ControllerBGPPeerMaxPrefixesReached Times a peer sent more prefixes than its warning threshold or its maximum
+	ControllerBGPPeerMaxPrefixesReached = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_max_prefixes_reached",
+		Help:      "Times the peer sent more prefixes than the warning threshold or the maximum of an address family",
+	}, []string{"peer", "threshold"})
 	// ControllerBGPInternalPeersSyncTime Time it took to sync internal bgp peers
 	ControllerBGPInternalPeersSyncTime = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Namespace: namespace,
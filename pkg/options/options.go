@@ -20,21 +20,39 @@ type KubeRouterConfig struct {
 	AdvertiseLoadBalancerIP        bool
 	AdvertiseNodePodCidr           bool
 	AutoMTU                        bool
+	BFDDetectMultiplier            uint8
+	BFDMinRxInterval               time.Duration
+	BFDMinTxInterval               time.Duration
+	BGPAPIListen                   string
+	BGPAPISocket                   string
+	BGPAPITLSCertFile              string
+	BGPAPITLSClientCAFile          string
+	BGPAPITLSKeyFile               string
 	BGPGracefulRestart             bool
 	BGPGracefulRestartDeferralTime time.Duration
 	BGPGracefulRestartTime         time.Duration
 	BGPHoldTime                    time.Duration
+	BGPMaxPaths                    int
+	BGPPeersNodeCondition          bool
 	BGPPort                        uint32
 	CacheSyncTimeout               time.Duration
 	CleanupConfig                  bool
+	CleanupControllers             []string
+	CleanupDryRun                  bool
 	ClusterAsn                     uint
 	ClusterIPCIDR                  string
 	DisableSrcDstCheck             bool
+	EBPFCgroupPath                 string
+	EnableBFD                      bool
+	EnableBGPCRDs                  bool
 	EnableCNI                      bool
+	EnableHostPort                 bool
 	EnableiBGP                     bool
 	EnableOverlay                  bool
+	EnablePeerPasswordSecrets      bool
 	EnablePodEgress                bool
 	EnablePprof                    bool
+	EnableRoutingIntrospection     bool
 	ExcludedCidrs                  []string
 	ExternalIPCIDRs                []string
 	FullMeshMode                   bool
@@ -42,10 +60,15 @@ type KubeRouterConfig struct {
 	HealthPort                     uint16
 	HelpRequested                  bool
 	HostnameOverride               string
+	InjectedRoutesCleanup          string
 	InjectedRoutesSyncPeriod       time.Duration
+	InstallExternalRoutes          bool
+	InstallExternalRoutesMetric    int
 	IPTablesSyncPeriod             time.Duration
 	IpvsGracefulPeriod             time.Duration
 	IpvsGracefulTermination        bool
+	IpvsHealthCheckInterval        time.Duration
+	IpvsHealthCheckTimeout         time.Duration
 	IpvsPermitAll                  bool
 	IpvsSyncPeriod                 time.Duration
 	Kubeconfig                     string
@@ -56,10 +79,17 @@ type KubeRouterConfig struct {
 	MetricsPort                    uint16
 	NodePortBindOnAllIP            bool
 	NodePortRange                  string
+	OverlayEncap                   string
+	OverlaySingleTunnel            bool
 	OverlayType                    string
 	OverrideNextHop                bool
 	PeerASNs                       []uint
+	PeerMaxPrefixes                []uint
+	PeerMaxPrefixesRestartTime     time.Duration
+	PeerMaxPrefixesThreshold       uint8
 	PeerMultihopTTL                uint8
+	PeerPasswordSecrets            []string
+	PeerPasswordSecretsNamespace   string
 	PeerPasswords                  []string
 	PeerPasswordsFile              string
 	PeerPorts                      []uint
@@ -70,27 +100,49 @@ type KubeRouterConfig struct {
 	RunRouter                      bool
 	RunServiceProxy                bool
 	RuntimeEndpoint                string
+	ServiceProxyDataplane          string
 	Version                        bool
 	VLevel                         string
+	VXLANPort                      uint16
+	WireGuardKeyRotationPeriod     time.Duration
+	WireGuardPort                  uint16
 	// FullMeshPassword    string
 }
 
 func NewKubeRouterConfig() *KubeRouterConfig {
 	//nolint:gomnd // Here we are specifying the names of the literals which is very similar to constant behavior
 	return &KubeRouterConfig{
+		BFDDetectMultiplier:            3,
+		BFDMinRxInterval:               300 * time.Millisecond,
+		BFDMinTxInterval:               300 * time.Millisecond,
+		BGPAPIListen:                   "node-ip",
+		BGPAPISocket:                   "/var/run/kube-router/gobgp.sock",
 		BGPGracefulRestartDeferralTime: 360 * time.Second,
 		BGPGracefulRestartTime:         90 * time.Second,
 		BGPHoldTime:                    90 * time.Second,
+		BGPMaxPaths:                    1,
 		CacheSyncTimeout:               1 * time.Minute,
+		CleanupControllers:             []string{"firewall", "router", "service-proxy"},
 		ClusterIPCIDR:                  "10.96.0.0/12",
+		EBPFCgroupPath:                 "/sys/fs/cgroup",
 		EnableOverlay:                  true,
 		IPTablesSyncPeriod:             5 * time.Minute,
 		IpvsGracefulPeriod:             30 * time.Second,
+		IpvsHealthCheckInterval:        5 * time.Second,
+		IpvsHealthCheckTimeout:         1 * time.Second,
 		IpvsSyncPeriod:                 5 * time.Minute,
 		NodePortRange:                  "30000-32767",
+		OverlayEncap:                   "ipip",
 		OverlayType:                    "subnet",
+		PeerMaxPrefixesRestartTime:     5 * time.Minute,
+		PeerMaxPrefixesThreshold:       75,
+		PeerPasswordSecretsNamespace:   "kube-system",
 		RoutesSyncPeriod:               5 * time.Minute,
+		ServiceProxyDataplane:          "ipvs",
+		InjectedRoutesCleanup:          "enabled",
 		InjectedRoutesSyncPeriod:       60 * time.Second,
+		VXLANPort:                      4789,
+		WireGuardPort:                  51820,
 	}
 }
 
@@ -106,7 +158,25 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"Add Node's POD cidr to the RIB so that it gets advertised to the BGP peers.")
 	fs.BoolVar(&s.AutoMTU, "auto-mtu", true,
 		"Auto detect and set the largest possible MTU for kube-bridge and pod interfaces (also accounts for "+
-			"IPIP overlay network when enabled).")
+			"the overlay encapsulation when enabled).")
+	fs.Uint8Var(&s.BFDDetectMultiplier, "bfd-detect-multiplier", s.BFDDetectMultiplier,
+		"Number of BFD control packets that can be missed before a BGP peer is declared down.")
+	fs.DurationVar(&s.BFDMinRxInterval, "bfd-min-rx-interval", s.BFDMinRxInterval,
+		"Required minimum interval between the BFD control packets received from BGP peers.")
+	fs.DurationVar(&s.BFDMinTxInterval, "bfd-min-tx-interval", s.BFDMinTxInterval,
+		"Desired minimum interval between the BFD control packets sent to BGP peers.")
+	fs.StringVar(&s.BGPAPIListen, "bgp-api-listen", s.BGPAPIListen,
+		"Where the GoBGP gRPC API listens (node-ip, loopback, unix, none). node-ip listens on the node IP and "+
+			"loopback, unix listens on the socket of \"--bgp-api-socket\" and none disables the API.")
+	fs.StringVar(&s.BGPAPISocket, "bgp-api-socket", s.BGPAPISocket,
+		"Path of the Unix socket the GoBGP gRPC API listens on when \"--bgp-api-listen\" is unix.")
+	fs.StringVar(&s.BGPAPITLSCertFile, "bgp-api-tls-cert-file", "",
+		"Path to the certificate the GoBGP gRPC API presents to the clients connecting through the node IP.")
+	fs.StringVar(&s.BGPAPITLSClientCAFile, "bgp-api-tls-client-ca-file", "",
+		"Path to the CA certificates verifying the client certificates of the GoBGP gRPC API, the clients "+
+			"connecting through the node IP must present one signed by them. Loopback clients are not verified.")
+	fs.StringVar(&s.BGPAPITLSKeyFile, "bgp-api-tls-key-file", "",
+		"Path to the private key of \"--bgp-api-tls-cert-file\".")
 	fs.BoolVar(&s.BGPGracefulRestart, "bgp-graceful-restart", false,
 		"Enables the BGP Graceful Restart capability so that routes are preserved on unexpected restarts")
 	fs.DurationVar(&s.BGPGracefulRestartDeferralTime, "bgp-graceful-restart-deferral-time",
@@ -118,29 +188,59 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"This parameter is mainly used to modify the holdtime declared to BGP peer. When Kube-router goes down "+
 			"abnormally, the local saving time of BGP route will be affected. "+
 			"Holdtime must be in the range 3s to 18h12m16s.")
+	fs.IntVar(&s.BGPMaxPaths, "bgp-max-paths", s.BGPMaxPaths,
+		"The maximum number of next hops of the multipath routes installed for the prefixes learned from several "+
+			"external peers with --install-external-routes, values over 1 require --install-external-routes. "+
+			"The other routes, including the VIPs other clusters advertise with ECMP when they are not imported, keep "+
+			"a single next hop. 1 disables multipath.")
+	fs.BoolVar(&s.BGPPeersNodeCondition, "bgp-peers-node-condition", false,
+		"Reports whether the BGP sessions with all the peers of the node are established in its BGPPeersEstablished "+
+			"condition.")
 	fs.Uint32Var(&s.BGPPort, "bgp-port", DefaultBgpPort,
 		"The port open for incoming BGP connections and to use for connecting with other BGP peers.")
 	fs.DurationVar(&s.CacheSyncTimeout, "cache-sync-timeout", s.CacheSyncTimeout,
 		"The timeout for cache synchronization (e.g. '5s', '1m'). Must be greater than 0.")
 	fs.BoolVar(&s.CleanupConfig, "cleanup-config", false,
 		"Cleanup iptables rules, ipvs, ipset configuration and exit.")
+	fs.StringSliceVar(&s.CleanupControllers, "cleanup-controllers", s.CleanupControllers,
+		"Controllers whose configuration is removed by --cleanup-config (firewall, router, service-proxy).")
+	fs.BoolVar(&s.CleanupDryRun, "cleanup-dry-run", false,
+		"Only log the configuration that --cleanup-config would remove, without removing it.")
 	fs.UintVar(&s.ClusterAsn, "cluster-asn", s.ClusterAsn,
 		"ASN number under which cluster nodes will run iBGP.")
 	fs.BoolVar(&s.DisableSrcDstCheck, "disable-source-dest-check", true,
 		"Disable the source-dest-check attribute for AWS EC2 instances. When this option is false, it must be "+
 			"set some other way.")
+	fs.StringVar(&s.EBPFCgroupPath, "ebpf-cgroup-path", s.EBPFCgroupPath,
+		"Path to the root of the cgroup v2 hierarchy of the host, the socket load balancing BPF programs of the "+
+			"ebpf service proxy dataplane are attached to it.")
+	fs.BoolVar(&s.EnableBFD, "enable-bfd", false,
+		"Enables BFD sessions with the BGP peers of the node, a peer is shut down as soon as its BFD session "+
+			"goes down. Only directly connected peers are supported.")
+	fs.BoolVar(&s.EnableBGPCRDs, "enable-bgp-crds", false,
+		"Enables configuring BGP peers and cluster-wide BGP settings through the BGPPeer and BGPConfiguration "+
+			"custom resources, their definitions must be installed in the cluster.")
 	fs.BoolVar(&s.EnableCNI, "enable-cni", true,
 		"Enable CNI plugin. Disable if you want to use kube-router features alongside another CNI plugin.")
+	fs.BoolVar(&s.EnableHostPort, "enable-hostport", false,
+		"Enables native support for the hostPort of pod containers in the service proxy, this replaces the CNI "+
+			"portmap plugin which should not be configured when enabled.")
 	fs.BoolVar(&s.EnableiBGP, "enable-ibgp", true,
 		"Enables peering with nodes with the same ASN, if disabled will only peer with external BGP peers")
 	fs.BoolVar(&s.EnableOverlay, "enable-overlay", true,
 		"When enable-overlay is set to true, IP-in-IP tunneling is used for pod-to-pod networking across "+
 			"nodes in different subnets. When set to false no tunneling is used and routing infrastructure is "+
 			"expected to route traffic for pod-to-pod networking across nodes in different subnets")
+	fs.BoolVar(&s.EnablePeerPasswordSecrets, "enable-peer-password-secrets", false,
+		"Watches the Secrets holding the BGP peer passwords so that the sessions are updated as soon as a password "+
+			"is rotated, kube-router must be allowed to list and watch Secrets in --peer-password-secrets-namespace.")
 	fs.BoolVar(&s.EnablePodEgress, "enable-pod-egress", true,
 		"SNAT traffic from Pods to destinations outside the cluster.")
 	fs.BoolVar(&s.EnablePprof, "enable-pprof", false,
 		"Enables pprof for debugging performance and memory leak issues.")
+	fs.BoolVar(&s.EnableRoutingIntrospection, "enable-routing-introspection", false,
+		"Serves the BGP RIBs, neighbors, defined sets and policies and the routes injected by kube-router as JSON "+
+			"on /debug/routing of the health and metrics ports.")
 	fs.StringSliceVar(&s.ExcludedCidrs, "excluded-cidrs", s.ExcludedCidrs,
 		"Excluded CIDRs are used to exclude IPVS rules from deletion.")
 	fs.BoolVar(&s.GlobalHairpinMode, "hairpin-mode", false,
@@ -151,8 +251,17 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&s.HostnameOverride, "hostname-override", s.HostnameOverride,
 		"Overrides the NodeName of the node. Set this if kube-router is unable to determine your NodeName "+
 			"automatically.")
+	fs.StringVar(&s.InjectedRoutesCleanup, "injected-routes-cleanup", s.InjectedRoutesCleanup,
+		"Whether the route table synchronizations remove the routes and tunnels to nodes that kube-router no longer "+
+			"learns from BGP (enabled, dry-run, disabled). In dry-run mode they are only logged.")
 	fs.DurationVar(&s.InjectedRoutesSyncPeriod, "injected-routes-sync-period", s.InjectedRoutesSyncPeriod,
 		"The delay between route table synchronizations  (e.g. '5s', '1m', '2h22m'). Must be greater than 0.")
+	fs.BoolVar(&s.InstallExternalRoutes, "install-external-routes", false,
+		"Install in the routing table of the node the routes learned from the external peers that are selected by "+
+			"the import filters of their BGPPeer resources, including default routes.")
+	fs.IntVar(&s.InstallExternalRoutesMetric, "install-external-routes-metric", 0,
+		"The metric of the routes installed with --install-external-routes. A route is not installed when the node "+
+			"already has a route to the same destination with that metric which kube-router did not set up.")
 	fs.DurationVar(&s.IPTablesSyncPeriod, "iptables-sync-period", s.IPTablesSyncPeriod,
 		"The delay between iptables rule synchronizations (e.g. '5s', '1m'). Must be greater than 0.")
 	fs.DurationVar(&s.IpvsGracefulPeriod, "ipvs-graceful-period", s.IpvsGracefulPeriod,
@@ -160,6 +269,12 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 			"be greater than 0.")
 	fs.BoolVar(&s.IpvsGracefulTermination, "ipvs-graceful-termination", false,
 		"Enables the experimental IPVS graceful terminaton capability")
+	fs.DurationVar(&s.IpvsHealthCheckInterval, "ipvs-healthcheck-interval", s.IpvsHealthCheckInterval,
+		"The delay between active health checks of IPVS destinations for services that enable them through the "+
+			"kube-router.io/service.healthcheck annotation (e.g. '5s', '1m'). Must be greater than 0.")
+	fs.DurationVar(&s.IpvsHealthCheckTimeout, "ipvs-healthcheck-timeout", s.IpvsHealthCheckTimeout,
+		"The timeout of a single active health check of an IPVS destination (e.g. '1s', '500ms'). Must be "+
+			"greater than 0.")
 	fs.BoolVar(&s.IpvsPermitAll, "ipvs-permit-all", true,
 		"Enables rule to accept all incoming traffic to service VIP's on the node.")
 	fs.DurationVar(&s.IpvsSyncPeriod, "ipvs-sync-period", s.IpvsSyncPeriod,
@@ -176,6 +291,17 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"For service of NodePort type create IPVS service that listens on all IP's of the node.")
 	fs.BoolVar(&s.FullMeshMode, "nodes-full-mesh", true,
 		"Each node in the cluster will setup BGP peering with rest of the nodes.")
+	fs.StringVar(&s.OverlayEncap, "overlay-encap", s.OverlayEncap,
+		"Possible values: ipip,vxlan,wireguard - "+
+			"Encapsulation used for the overlay network when \"--enable-overlay=true\". When set to \"ipip\", the "+
+			"default, an IP-in-IP tunnel is created per node. When set to \"vxlan\", a single VXLAN interface per IP "+
+			"family is used, which works on networks filtering IP-in-IP. When set to \"wireguard\", the traffic is "+
+			"encrypted with WireGuard, the public key of each node is published in its "+
+			"kube-router.io/wireguard.public-key annotation.")
+	fs.BoolVar(&s.OverlaySingleTunnel, "overlay-single-tunnel", false,
+		"When \"--overlay-encap=ipip\", use a single collect metadata tunnel device per IP family with the tunnel "+
+			"destination set on each route, instead of a tunnel device per node. The per node tunnels are migrated "+
+			"as their routes are injected again.")
 	fs.StringVar(&s.OverlayType, "overlay-type", s.OverlayType,
 		"Possible values: subnet,full - "+
 			"When set to \"subnet\", the default, default \"--enable-overlay=true\" behavior is used. "+
@@ -188,8 +314,24 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 	fs.IPSliceVar(&s.PeerRouters, "peer-router-ips", s.PeerRouters,
 		"The ip address of the external router to which all nodes will peer and advertise the cluster ip and "+
 			"pod cidr's.")
+	fs.UintSliceVar(&s.PeerMaxPrefixes, "peer-router-max-prefixes", s.PeerMaxPrefixes,
+		"Maximum number of prefixes per address family accepted from the BGP peer defined with "+
+			"\"--peer-router-ips\", the session is torn down when the peer sends more. 0 disables the limit.")
+	fs.DurationVar(&s.PeerMaxPrefixesRestartTime, "peer-router-max-prefixes-restart",
+		s.PeerMaxPrefixesRestartTime,
+		"Time a BGP session torn down for exceeding its maximum number of prefixes is kept down before it is "+
+			"started again. 0 restarts it right away.")
+	fs.Uint8Var(&s.PeerMaxPrefixesThreshold, "peer-router-max-prefixes-threshold", s.PeerMaxPrefixesThreshold,
+		"Percentage of the maximum number of prefixes of a BGP peer above which a warning is logged. 0 disables "+
+			"the warning.")
 	fs.Uint8Var(&s.PeerMultihopTTL, "peer-router-multihop-ttl", s.PeerMultihopTTL,
 		"Enable eBGP multihop supports -- sets multihop-ttl. (Relevant only if ttl >= 2)")
+	fs.StringSliceVar(&s.PeerPasswordSecrets, "peer-router-password-secrets", s.PeerPasswordSecrets,
+		"Secret keys holding the password for authenticating against the BGP peer defined with "+
+			"\"--peer-router-ips\", as namespace/name/key. They are preferred over --peer-router-passwords.")
+	fs.StringVar(&s.PeerPasswordSecretsNamespace, "peer-password-secrets-namespace", s.PeerPasswordSecretsNamespace,
+		"Namespace of the Secrets holding the BGP peer passwords, watched with --enable-peer-password-secrets. "+
+			"kube-router is only allowed to read the Secrets of this namespace.")
 	fs.StringSliceVar(&s.PeerPasswords, "peer-router-passwords", s.PeerPasswords,
 		"Password for authenticating against the BGP peer defined with \"--peer-router-ips\".")
 	fs.StringVar(&s.PeerPasswordsFile, "peer-router-passwords-file", s.PeerPasswordsFile,
@@ -218,7 +360,18 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 			"(can be specified multiple times)")
 	fs.StringVar(&s.NodePortRange, "service-node-port-range", s.NodePortRange,
 		"NodePort range specified with either a hyphen or colon")
+	fs.StringVar(&s.ServiceProxyDataplane, "service-proxy-dataplane", s.ServiceProxyDataplane,
+		"Possible values: ipvs,ebpf - When set to \"ebpf\", connections to services that originate on the node, "+
+			"from its pods or host processes, are load balanced at the socket level by BPF programs. Traffic received "+
+			"from outside of the node, e.g. to node ports or LoadBalancer IPs, is still load balanced by IPVS.")
 	fs.StringVarP(&s.VLevel, "v", "v", "0", "log level for V logs")
 	fs.BoolVarP(&s.Version, "version", "V", false,
 		"Print version information.")
+	fs.Uint16Var(&s.VXLANPort, "vxlan-port", s.VXLANPort,
+		"Destination UDP port of the VXLAN traffic when \"--overlay-encap=vxlan\".")
+	fs.DurationVar(&s.WireGuardKeyRotationPeriod, "wireguard-key-rotation-period", s.WireGuardKeyRotationPeriod,
+		"The period after which the WireGuard key of the node is replaced when \"--overlay-encap=wireguard\" "+
+			"(e.g. '24h', '720h'). The key is never rotated when set to 0, the default.")
+	fs.Uint16Var(&s.WireGuardPort, "wireguard-port", s.WireGuardPort,
+		"UDP port the WireGuard interface listens on when \"--overlay-encap=wireguard\".")
 }
@@ -62,6 +62,24 @@ func GetNodeIP(node *apiv1.Node) (net.IP, error) {
 	return nil, errors.New("host IP unknown")
 }
 
+// GetNodeIPByFamily returns the most valid external facing IP address of the given IP family for a node. It follows
+// the same order of preference as GetNodeIP and is mostly useful on dual-stack nodes to find the address of the
+// secondary IP family.
+func GetNodeIPByFamily(node *apiv1.Node, isIpv6 bool) (net.IP, error) {
+	for _, addressType := range []apiv1.NodeAddressType{apiv1.NodeInternalIP, apiv1.NodeExternalIP} {
+		for _, address := range node.Status.Addresses {
+			if address.Type != addressType {
+				continue
+			}
+			ip := net.ParseIP(address.Address)
+			if ip != nil && (ip.To4() == nil) == isIpv6 {
+				return ip, nil
+			}
+		}
+	}
+	return nil, errors.New("host IP of the requested family unknown")
+}
+
 // GetMTUFromNodeIP returns the MTU by detecting it from the IP on the node and figuring in tunneling configurations
 func GetMTUFromNodeIP(nodeIP net.IP) (int, error) {
 	links, err := netlink.LinkList()
@@ -190,3 +190,85 @@ func Test_GetNodeIP(t *testing.T) {
 		})
 	}
 }
+
+func Test_GetNodeIPByFamily(t *testing.T) {
+	node := &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-node",
+		},
+		Status: apiv1.NodeStatus{
+			Addresses: []apiv1.NodeAddress{
+				{
+					Type:    apiv1.NodeExternalIP,
+					Address: "2001:db8::1",
+				},
+				{
+					Type:    apiv1.NodeInternalIP,
+					Address: "10.0.0.1",
+				},
+				{
+					Type:    apiv1.NodeInternalIP,
+					Address: "fd00::1",
+				},
+			},
+		},
+	}
+	testcases := []struct {
+		name   string
+		node   *apiv1.Node
+		isIpv6 bool
+		ip     net.IP
+		err    error
+	}{
+		{
+			"internal IPv4 address",
+			node,
+			false,
+			net.ParseIP("10.0.0.1"),
+			nil,
+		},
+		{
+			"internal IPv6 address is preferred over external",
+			node,
+			true,
+			net.ParseIP("fd00::1"),
+			nil,
+		},
+		{
+			"no address of the requested family",
+			&apiv1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-node",
+				},
+				Status: apiv1.NodeStatus{
+					Addresses: []apiv1.NodeAddress{
+						{
+							Type:    apiv1.NodeInternalIP,
+							Address: "10.0.0.1",
+						},
+					},
+				},
+			},
+			true,
+			nil,
+			errors.New("host IP of the requested family unknown"),
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			ip, err := GetNodeIPByFamily(testcase.node, testcase.isIpv6)
+			if !reflect.DeepEqual(err, testcase.err) {
+				t.Logf("actual error: %v", err)
+				t.Logf("expected error: %v", testcase.err)
+				t.Error("did not get expected error")
+			}
+
+			if !reflect.DeepEqual(ip, testcase.ip) {
+				t.Logf("actual ip: %v", ip)
+				t.Logf("expected ip: %v", testcase.ip)
+				t.Error("did not get expected node ip")
+			}
+		})
+	}
+}
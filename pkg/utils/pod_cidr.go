@@ -9,11 +9,13 @@ import (
 
 	"github.com/containernetworking/cni/libcni"
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
+	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 const (
-	podCIDRAnnotation = "kube-router.io/pod-cidr"
+	podCIDRAnnotation  = "kube-router.io/pod-cidr"
+	podCIDRsAnnotation = "kube-router.io/pod-cidrs"
 )
 
 // GetPodCidrFromCniSpec gets pod CIDR allocated to the node from CNI spec file and returns it
@@ -142,3 +144,44 @@ func GetPodCidrFromNodeSpec(clientset kubernetes.Interface, hostnameOverride str
 
 	return node.Spec.PodCIDR, nil
 }
+
+// GetPodCIDRsFromNodeSpec reads all the pod CIDRs allocated to the given node object and returns them with the primary
+// pod CIDR first. On dual-stack clusters node.Spec.PodCIDRs holds one CIDR for each IP family. The deprecated
+// `kube-router.io/pod-cidr` annotation takes precedence over the comma-separated `kube-router.io/pod-cidrs` annotation,
+// which in turn takes precedence over the node spec.
+func GetPodCIDRsFromNodeSpec(node *apiv1.Node) ([]string, error) {
+	if cidr, ok := node.Annotations[podCIDRAnnotation]; ok {
+		_, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing pod CIDR in node annotation: %v", err)
+		}
+
+		return []string{cidr}, nil
+	}
+
+	if cidrsAnnotation, ok := node.Annotations[podCIDRsAnnotation]; ok {
+		cidrs := strings.Split(cidrsAnnotation, ",")
+		for i, cidr := range cidrs {
+			cidrs[i] = strings.TrimSpace(cidr)
+			if _, _, err := net.ParseCIDR(cidrs[i]); err != nil {
+				return nil, fmt.Errorf("error parsing pod CIDRs in node annotation: %v", err)
+			}
+		}
+
+		return cidrs, nil
+	}
+
+	if len(node.Spec.PodCIDRs) == 0 {
+		if node.Spec.PodCIDR == "" {
+			return nil, fmt.Errorf("node.Spec.PodCIDR not set for node: %v", node.Name)
+		}
+		return []string{node.Spec.PodCIDR}, nil
+	}
+
+	for _, cidr := range node.Spec.PodCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("error parsing pod CIDR in node.Spec.PodCIDRs: %v", err)
+		}
+	}
+	return node.Spec.PodCIDRs, nil
+}
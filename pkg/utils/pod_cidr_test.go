@@ -198,6 +198,117 @@ func Test_GetPodCidrFromNodeSpec(t *testing.T) {
 	}
 }
 
+func Test_GetPodCIDRsFromNodeSpec(t *testing.T) {
+	testcases := []struct {
+		name     string
+		node     *apiv1.Node
+		podCIDRs []string
+		err      error
+	}{
+		{
+			"node with only node.Spec.PodCIDR",
+			&apiv1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-node",
+				},
+				Spec: apiv1.NodeSpec{
+					PodCIDR: "172.17.0.0/24",
+				},
+			},
+			[]string{"172.17.0.0/24"},
+			nil,
+		},
+		{
+			"dual-stack node with node.Spec.PodCIDRs",
+			&apiv1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-node",
+				},
+				Spec: apiv1.NodeSpec{
+					PodCIDR:  "172.17.0.0/24",
+					PodCIDRs: []string{"172.17.0.0/24", "fd00:17::/64"},
+				},
+			},
+			[]string{"172.17.0.0/24", "fd00:17::/64"},
+			nil,
+		},
+		{
+			"node with node.Annotations['kube-router.io/pod-cidr']",
+			&apiv1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-node",
+					Annotations: map[string]string{
+						podCIDRAnnotation: "172.18.0.0/24",
+					},
+				},
+				Spec: apiv1.NodeSpec{
+					PodCIDR:  "172.17.0.0/24",
+					PodCIDRs: []string{"172.17.0.0/24", "fd00:17::/64"},
+				},
+			},
+			[]string{"172.18.0.0/24"},
+			nil,
+		},
+		{
+			"node with node.Annotations['kube-router.io/pod-cidrs']",
+			&apiv1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-node",
+					Annotations: map[string]string{
+						podCIDRsAnnotation: "172.18.0.0/24, fd00:18::/64",
+					},
+				},
+				Spec: apiv1.NodeSpec{
+					PodCIDR:  "172.17.0.0/24",
+					PodCIDRs: []string{"172.17.0.0/24", "fd00:17::/64"},
+				},
+			},
+			[]string{"172.18.0.0/24", "fd00:18::/64"},
+			nil,
+		},
+		{
+			"node with invalid pod cidr in node.Annotations['kube-router.io/pod-cidrs']",
+			&apiv1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-node",
+					Annotations: map[string]string{
+						podCIDRsAnnotation: "172.18.0.0/24,fd00:18::",
+					},
+				},
+			},
+			nil,
+			errors.New("error parsing pod CIDRs in node annotation: invalid CIDR address: fd00:18::"),
+		},
+		{
+			"node without pod CIDR",
+			&apiv1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-node",
+				},
+			},
+			nil,
+			errors.New("node.Spec.PodCIDR not set for node: test-node"),
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			podCIDRs, err := GetPodCIDRsFromNodeSpec(testcase.node)
+			if !reflect.DeepEqual(err, testcase.err) {
+				t.Logf("actual error: %v", err)
+				t.Logf("expected error: %v", testcase.err)
+				t.Error("did not get expected error")
+			}
+
+			if !reflect.DeepEqual(podCIDRs, testcase.podCIDRs) {
+				t.Logf("actual podCIDRs: %q", podCIDRs)
+				t.Logf("expected podCIDRs: %q", testcase.podCIDRs)
+				t.Error("did not get expected podCIDRs")
+			}
+		})
+	}
+}
+
 func createFile(content, filename string) (*os.File, error) {
 	file, err := os.Create(filename)
 	if err != nil {